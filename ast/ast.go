@@ -55,6 +55,7 @@ func (p *Program) String() string {
 type LetStatement struct { // the full statement
 	Token token.Token
 	Name  *Identifier // variable name
+	Type  Type        // the annotated type, nil if there is none
 	Value Expression  // what the value is
 }
 
@@ -67,6 +68,9 @@ func (ls *LetStatement) String() string {
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String()) // the identifier
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -117,6 +121,18 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement represents the line `throw <expression>`
+type ThrowStatement struct {
+	Token token.Token // throw token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
 // ----------- expression statement -----------
 
 // ExpressionStatement represents the line `x + 10`
@@ -240,9 +256,44 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// TryExpression represents `try { } catch (e) { } finally { }`. Either the catch or the finally
+// block may be left out, and the catch block doesn't have to bind the error: `catch { }`.
+type TryExpression struct {
+	Token     token.Token // the 'try' token
+	Block     *BlockStatement
+	CatchName *Identifier // nil if the error isn't bound
+	Catch     *BlockStatement
+	Finally   *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.CatchName != nil {
+			out.WriteString("(" + te.CatchName.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement // statements composing of this block
+	End        token.Token // the } token (EOF if the block isn't closed)
 }
 
 func (bs *BlockStatement) statementNode()       {}
@@ -264,6 +315,11 @@ type FunctionLiteral struct {
 	Parameters []*Identifier   // the list of function parameters (simple identifiers)
 	Body       *BlockStatement // the body of a function is just a block
 	Name       string
+
+	// the annotated types of the parameters (nil, or one per parameter with nil for those
+	// without one) and of the result, nil if there is none
+	ParameterTypes []Type
+	ReturnType     Type
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -272,8 +328,12 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			params = append(params, p.String()+": "+fl.ParameterTypes[i].String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString(fl.TokenLiteral())
@@ -283,6 +343,9 @@ func (fl *FunctionLiteral) String() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
@@ -357,11 +420,65 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression takes the part of an array or string from Start up to but excluding End:
+// left[start:end]. Either bound may be left out (nil).
+type SliceExpression struct {
+	Token token.Token // the [ token
+	Left  Expression
+	Start Expression // nil for left[:end]
+	End   Expression // nil for left[start:]
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+// -------- assignment --------
+
+// AssignExpression changes the element of an array: target = value. Its value is the
+// assigned value.
+type AssignExpression struct {
+	Token  token.Token      // the = token
+	Target *IndexExpression // the element being assigned
+	Value  Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" = ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // -------- hash --------
 
 type HashLiteral struct {
 	Token token.Token // the '{' token
 	Pairs map[Expression]Expression
+	Keys  []Expression // the keys of Pairs in source order
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -370,8 +487,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -1,9 +1,13 @@
 package ast_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
 	"github.com/andy9775/monkey/token"
 )
 
@@ -28,3 +32,55 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = -1 + b;", "let a + - 1 b"},
+		{"if (a) { b } else { return c; }", "if a { b { return c"},
+		{"try { throw a; } catch (e) { e } finally { f(1, [2][0]) }", "try { throw a e { e { ( f 1 [ [ 2 0"},
+		{"fn(x) { x }({a: b}, c[1:])", "( fn x { x { a b [ c 1"},
+		{"a[0] = 1", "= [ a 0 1"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		visited := []string{}
+		ast.Inspect(program, func(node ast.Node) bool {
+			switch node.(type) {
+			case *ast.Program, *ast.ExpressionStatement:
+			default:
+				visited = append(visited, node.TokenLiteral())
+			}
+			return true
+		})
+		if got := strings.Join(visited, " "); got != tt.expected {
+			t.Errorf("wrong nodes visited for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(a) { a + b }; f(c)")).ParseProgram()
+
+	identifiers := []string{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			identifiers = append(identifiers, ident.Value)
+		}
+		_, isFunction := node.(*ast.FunctionLiteral)
+		return !isFunction
+	})
+	if got := fmt.Sprint(identifiers); got != "[f f c]" {
+		t.Errorf("wrong identifiers. want=[f f c], got=%s", got)
+	}
+}
+
+func TestInspectIncompleteProgram(t *testing.T) {
+	// the parser leaves the value of the let and the block of the if out
+	program := parser.New(lexer.New("let a = ; if (a) ")).ParseProgram()
+	ast.Inspect(program, func(node ast.Node) bool { return true })
+}
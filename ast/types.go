@@ -0,0 +1,71 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/andy9775/monkey/token"
+)
+
+// Type is a type annotation: `int`, `[int]`, `{string: int}` or `fn(int, string) -> bool`.
+// Annotations are optional and the engines ignore them, see package types.
+type Type interface {
+	Node
+	typeNode()
+}
+
+// NamedType is a type named by an identifier, such as int or any
+type NamedType struct {
+	Token token.Token // the identifier
+	Name  string
+}
+
+func (nt *NamedType) typeNode()            {}
+func (nt *NamedType) TokenLiteral() string { return nt.Token.Literal }
+func (nt *NamedType) String() string       { return nt.Name }
+
+// ArrayType is the type of arrays whose elements are of the type Element: [element]
+type ArrayType struct {
+	Token   token.Token // the [ token
+	Element Type
+}
+
+func (at *ArrayType) typeNode()            {}
+func (at *ArrayType) TokenLiteral() string { return at.Token.Literal }
+func (at *ArrayType) String() string       { return "[" + at.Element.String() + "]" }
+
+// HashType is the type of hashes with keys of the type Key and values of the type Value:
+// {key: value}
+type HashType struct {
+	Token token.Token // the { token
+	Key   Type
+	Value Type
+}
+
+func (ht *HashType) typeNode()            {}
+func (ht *HashType) TokenLiteral() string { return ht.Token.Literal }
+func (ht *HashType) String() string {
+	return "{" + ht.Key.String() + ": " + ht.Value.String() + "}"
+}
+
+// FunctionType is the type of functions: fn(parameters) -> return. The return type may be
+// left out (nil).
+type FunctionType struct {
+	Token      token.Token // the fn token
+	Parameters []Type
+	Return     Type
+}
+
+func (ft *FunctionType) typeNode()            {}
+func (ft *FunctionType) TokenLiteral() string { return ft.Token.Literal }
+func (ft *FunctionType) String() string {
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, p.String())
+	}
+
+	s := "fn(" + strings.Join(params, ", ") + ")"
+	if ft.Return != nil {
+		s += " -> " + ft.Return.String()
+	}
+	return s
+}
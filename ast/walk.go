@@ -0,0 +1,91 @@
+package ast
+
+import "reflect"
+
+// Inspect traverses the tree of node depth first and in source order, calling f for node and
+// then for each of its children. The children of a node are skipped when f returns false for
+// it. Missing (nil) children, which the trees of programs with parse errors can have, aren't
+// visited.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || reflect.ValueOf(node).IsNil() || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Type, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ThrowStatement:
+		Inspect(n.Value, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *TryExpression:
+		Inspect(n.Block, f)
+		Inspect(n.CatchName, f)
+		Inspect(n.Catch, f)
+		Inspect(n.Finally, f)
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			Inspect(p, f)
+			if i < len(n.ParameterTypes) {
+				Inspect(n.ParameterTypes[i], f)
+			}
+		}
+		Inspect(n.ReturnType, f)
+		Inspect(n.Body, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			Inspect(el, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *SliceExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Start, f)
+		Inspect(n.End, f)
+	case *AssignExpression:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *HashLiteral:
+		for _, key := range n.Keys {
+			Inspect(key, f)
+			Inspect(n.Pairs[key], f)
+		}
+	case *ArrayType:
+		Inspect(n.Element, f)
+	case *HashType:
+		Inspect(n.Key, f)
+		Inspect(n.Value, f)
+	case *FunctionType:
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(n.Return, f)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 // Instructions is a list of operations
@@ -107,6 +108,8 @@ const (
 
 	// OpCall is used to execute a function call
 	OpCall
+	// OpTailCall is an OpCall in tail position; the vm reuses the current frame for the callee
+	OpTailCall
 
 	// OpCurrentClosure instructs the compiler/vm to correctly treat nested closures (not as free variables)
 	OpCurrentClosure
@@ -123,6 +126,37 @@ const (
 
 	// OpClosure tells the vm to wrap the object.CompiledFunction in an object.Closure
 	OpClosure
+
+	// superinstructions, emitted by the compiler's peephole pass in place of common sequences
+
+	// OpAddLocals is OpGetLocal a; OpGetLocal b; OpAdd
+	OpAddLocals
+	// OpAddConst is OpConstant k; OpAdd
+	OpAddConst
+	// OpSubConst is OpConstant k; OpSub
+	OpSubConst
+	// OpJumpIfNotGreater is OpGreaterThan; OpJumpNotTruthy
+	OpJumpIfNotGreater
+	// OpJumpIfNotEqual is OpEqual; OpJumpNotTruthy
+	OpJumpIfNotEqual
+
+	// OpMove copies a value into a register; it only exists in register code (see register.go)
+	OpMove
+
+	// OpThrow pops the value on top of the stack and throws it (see Handler)
+	OpThrow
+
+	// OpLessThan compares the operands in source order; the compiler prefers swapping them for
+	// OpGreaterThan when that isn't observable (see compiler.go)
+	OpLessThan
+
+	// OpSetIndex pops a value, an index and an array, sets the array's element and pushes the
+	// value back
+	OpSetIndex
+
+	// OpSlice pops the end, the start and an array or string and pushes the slice between them;
+	// a bound which was left out is null
+	OpSlice
 )
 
 // Definition provides human readable debugging information for a specific OpCode
@@ -169,6 +203,7 @@ var definitions = map[Opcode]*Definition{
 		"OpCall",
 		[]int{1}, /*operand is number of arguments; previous item on stack is the identifier for the call*/
 	},
+	OpTailCall: {"OpTailCall", []int{1} /*operand is number of arguments, same as OpCall*/},
 
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
 
@@ -182,6 +217,83 @@ var definitions = map[Opcode]*Definition{
 	OpPop: {"OpPop", []int{} /*takes no operands*/},
 
 	OpClosure: {"OpClosure", []int{2, 1} /*first operand is constant index, second is num free variables*/},
+
+	OpAddLocals:        {"OpAddLocals", []int{1, 1} /*operands are the two local reference locations*/},
+	OpAddConst:         {"OpAddConst", []int{2} /*operand is the constant index of the right side*/},
+	OpSubConst:         {"OpSubConst", []int{2} /*operand is the constant index of the right side*/},
+	OpJumpIfNotGreater: {"OpJumpIfNotGreater", []int{2} /*operand is the offset instruction*/},
+	OpJumpIfNotEqual:   {"OpJumpIfNotEqual", []int{2} /*operand is the offset instruction*/},
+
+	OpThrow: {"OpThrow", []int{} /*no operands; the thrown value sits at the top of the stack*/},
+
+	OpLessThan: {"OpLessThan", []int{} /*takes no operands*/},
+
+	OpSetIndex: {"OpSetIndex", []int{} /*no operands; requires 3 items on stack: the array, index and value*/},
+
+	OpSlice: {"OpSlice", []int{} /*no operands; requires 3 items on stack: the array or string, start and end*/},
+}
+
+// StackEffect returns by how much the instruction changes the height of the stack
+func StackEffect(op Opcode, operands ...int) int {
+	switch op {
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetLocal, OpGetBuiltin, OpGetFree,
+		OpCurrentClosure, OpAddLocals:
+		return 1
+	case OpAdd, OpSub, OpMul, OpDiv, OpEqual, OpNotEqual, OpGreaterThan, OpLessThan,
+		OpJumpNotTruthy, OpSetGlobal, OpSetLocal, OpIndex, OpReturnValue, OpPop, OpThrow:
+		return -1
+	case OpJumpIfNotGreater, OpJumpIfNotEqual, OpSetIndex, OpSlice:
+		return -2
+	case OpArray, OpHash, OpClosure:
+		// the last operand is the number of values replaced by the result
+		return 1 - operands[len(operands)-1]
+	case OpCall, OpTailCall:
+		// the callee and its arguments are replaced by the result
+		return -operands[0]
+	}
+
+	return 0
+}
+
+// Handler is an entry of a function's exception handler table. An error thrown by the
+// instructions in [Start, End) is handled at Target: the stack is cut back to Depth values above
+// the function's locals and the error is pushed. Handlers are ordered innermost first.
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
+// LineTable maps a function's instructions to the source lines they were compiled from. Its
+// entries are ordered by Offset, each covering the instructions from its Offset up to the next
+// entry's.
+type LineTable []LineEntry
+
+// LineEntry is an entry of a LineTable. Statement reports whether a statement starts at Offset,
+// rather than the instructions continuing a statement after the statements nested in it.
+type LineEntry struct {
+	Offset    int
+	Line      int
+	Statement bool
+}
+
+// LineAt returns the line of the instruction at offset, 0 if the table doesn't cover it
+func (t LineTable) LineAt(offset int) int {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return t[i-1].Line
+}
+
+// StatementAt reports whether a statement starts at offset, and on which line
+func (t LineTable) StatementAt(offset int) (int, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset >= offset })
+	if i < len(t) && t[i].Offset == offset && t[i].Statement {
+		return t[i].Line, true
+	}
+	return 0, false
 }
 
 // Lookup returns the Definition for the specific op and an error if none found
@@ -202,6 +314,26 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
+	return makeInstruction(def, op, operands)
+}
+
+// Fits reports whether the operands can be encoded in the operand widths of op. Make truncates
+// operands which don't fit.
+func Fits(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+
+	for i, o := range operands {
+		if o < 0 || o >= 1<<(8*def.OperandWidths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func makeInstruction(def *Definition, op Opcode, operands []int) []byte {
 	instructionLen := 1
 	for _, w := range def.OperandWidths {
 		instructionLen += w
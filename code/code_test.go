@@ -102,3 +102,59 @@ func TestReadOperands(t *testing.T) {
 
 	}
 }
+
+func TestRegisterInstructionsString(t *testing.T) {
+	instructions := []code.Instructions{
+		code.MakeRegister(code.OpAdd, 2, 0, 1|code.ConstantBit),
+		code.MakeRegister(code.OpCall, 1, 255),
+		code.MakeRegister(code.OpReturn),
+	}
+
+	expected := "0000 OpAdd 2 0 32769\n0007 OpCall 1 255\n0011 OpReturn\n"
+
+	concatted := code.Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.RegisterString() != expected {
+		t.Errorf("register instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.RegisterString())
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	lines := code.LineTable{
+		{Offset: 0, Line: 1, Statement: true},
+		{Offset: 6, Line: 2, Statement: true},
+		{Offset: 10, Line: 1},
+	}
+
+	tests := []struct {
+		offset        int
+		expectedLine  int
+		expectedStart bool
+	}{
+		{0, 1, true},
+		{3, 1, false},
+		{6, 2, true},
+		{9, 2, false},
+		{10, 1, false},
+		{100, 1, false},
+		{-1, 0, false},
+	}
+
+	for _, tt := range tests {
+		if line := lines.LineAt(tt.offset); line != tt.expectedLine {
+			t.Errorf("LineAt(%d) wrong. want=%d, got=%d", tt.offset, tt.expectedLine, line)
+		}
+
+		line, ok := lines.StatementAt(tt.offset)
+		if ok != tt.expectedStart {
+			t.Errorf("StatementAt(%d) wrong. want=%t, got=%t", tt.offset, tt.expectedStart, ok)
+		}
+		if ok && line != tt.expectedLine {
+			t.Errorf("StatementAt(%d) line wrong. want=%d, got=%d", tt.offset, tt.expectedLine, line)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package code
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+/*
+	Register code is the instruction set of the register based vm. It reuses the opcodes of the
+	stack instruction set, but rather than working on the top of the stack the instructions
+	address the slots of the current frame (the registers) directly. A frame's registers are its
+	local bindings (the arguments first) followed by the temporaries the compiler allocated.
+
+	Operands which read a value are RK operands: without ConstantBit they name a register,
+	with ConstantBit set the remaining bits are an index into the constant pool.
+	Instructions which produce a value write it to their first (destination) operand.
+*/
+
+// ConstantBit marks an RK operand as a constant index rather than a register
+const ConstantBit = 1 << 15
+
+// registerDefinitions are the operands of the register instructions
+var registerDefinitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2, 2} /*destination, constant index (for those too big for RK)*/},
+	OpMove:     {"OpMove", []int{2, 2} /*destination, RK source*/},
+
+	OpTrue:  {"OpTrue", []int{2} /*destination*/},
+	OpFalse: {"OpFalse", []int{2} /*destination*/},
+	OpNull:  {"OpNull", []int{2} /*destination*/},
+
+	OpAdd:         {"OpAdd", []int{2, 2, 2} /*destination, RK left, RK right*/},
+	OpSub:         {"OpSub", []int{2, 2, 2} /*destination, RK left, RK right*/},
+	OpMul:         {"OpMul", []int{2, 2, 2} /*destination, RK left, RK right*/},
+	OpDiv:         {"OpDiv", []int{2, 2, 2} /*destination, RK left, RK right*/},
+	OpEqual:       {"OpEqual", []int{2, 2, 2} /*destination, RK left, RK right*/},
+	OpNotEqual:    {"OpNotEqual", []int{2, 2, 2} /*destination, RK left, RK right*/},
+	OpGreaterThan: {"OpGreaterThan", []int{2, 2, 2} /*destination, RK left, RK right*/},
+
+	OpMinus: {"OpMinus", []int{2, 2} /*destination, RK operand*/},
+	OpBang:  {"OpBang", []int{2, 2} /*destination, RK operand*/},
+
+	OpJump:             {"OpJump", []int{2} /*offset instruction*/},
+	OpJumpNotTruthy:    {"OpJumpNotTruthy", []int{2, 2} /*RK condition, offset instruction*/},
+	OpJumpIfNotGreater: {"OpJumpIfNotGreater", []int{2, 2, 2} /*RK left, RK right, offset instruction*/},
+	OpJumpIfNotEqual:   {"OpJumpIfNotEqual", []int{2, 2, 2} /*RK left, RK right, offset instruction*/},
+
+	OpGetGlobal: {"OpGetGlobal", []int{2, 2} /*destination, global reference location*/},
+	OpSetGlobal: {"OpSetGlobal", []int{2, 2} /*global reference location, RK source*/},
+
+	OpGetFree:        {"OpGetFree", []int{2, 1} /*destination, free variable location*/},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{2, 1} /*destination, index of the builtin*/},
+	OpCurrentClosure: {"OpCurrentClosure", []int{2} /*destination*/},
+
+	// the elements sit in consecutive registers starting at the first operand, which also
+	// receives the result
+	OpArray: {"OpArray", []int{2, 2} /*first register, number of elements*/},
+	OpHash:  {"OpHash", []int{2, 2} /*first register, number of keys and values*/},
+	OpIndex: {"OpIndex", []int{2, 2, 2} /*destination, RK data structure, RK index*/},
+	// the destination receives the value
+	OpSetIndex: {"OpSetIndex", []int{2, 2, 2, 2} /*destination, RK array, RK index, RK value*/},
+	OpSlice:    {"OpSlice", []int{2, 2, 2, 2} /*destination, RK array or string, RK start, RK end*/},
+
+	// the callee sits in the base register followed by its arguments; the callee's frame starts
+	// right after the base register (so the arguments become its first locals) and its result
+	// is written back to the base register
+	OpCall:     {"OpCall", []int{2, 1} /*base register, number of arguments*/},
+	OpTailCall: {"OpTailCall", []int{2, 1} /*base register, number of arguments*/},
+
+	OpReturnValue: {"OpReturnValue", []int{2} /*RK returned value*/},
+	OpReturn:      {"OpReturn", []int{}},
+
+	OpPop: {"OpPop", []int{2} /*RK value; only emitted for the expression statements of the main program*/},
+
+	OpThrow: {"OpThrow", []int{2} /*RK thrown value*/},
+
+	OpLessThan: {"OpLessThan", []int{2, 2, 2} /*destination, RK left, RK right*/},
+
+	OpClosure: {"OpClosure", []int{2, 2, 1} /*first register (free variables), constant index, num free variables*/},
+}
+
+// LookupRegister returns the register instruction Definition for op and an error if none found
+func LookupRegister(op byte) (*Definition, error) {
+	def, ok := registerDefinitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("register opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// MakeRegister returns the register code for the specific Opcode and operands
+func MakeRegister(op Opcode, operands ...int) []byte {
+	def, ok := registerDefinitions[op]
+	if !ok { // opcode not found
+		return []byte{}
+	}
+
+	return makeInstruction(def, op, operands)
+}
+
+// RegisterString disassembles ins as register code
+func (ins Instructions) RegisterString() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := LookupRegister(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			return out.String()
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		parts := []string{def.Name}
+		for _, o := range operands {
+			parts = append(parts, fmt.Sprintf("%d", o))
+		}
+		fmt.Fprintf(&out, "%04d %s\n", i, strings.Join(parts, " "))
+		i += 1 + read
+	}
+
+	return out.String()
+}
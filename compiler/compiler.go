@@ -2,7 +2,6 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/andy9775/monkey/ast"
 	"github.com/andy9775/monkey/code"
@@ -13,10 +12,24 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	// depth is the height of the stack (above the locals) after the instructions emitted so far
+	depth int
+
+	// handlers is the exception handler table of the scope, tries the try expressions being
+	// compiled (see exceptions.go)
+	handlers []code.Handler
+	tries    []*activeTry
+
+	// lines is the line table of the scope (see markLine)
+	lines code.LineTable
 }
 
 type Compiler struct {
 	constants []object.Object
+	// constantIndexes maps integer and string constants to their position in constants so
+	// each distinct literal is only stored once
+	constantIndexes map[constantKey]int
 
 	// scopes are used to track instructions for the currrent call depth.
 	// functions have their own scope
@@ -24,6 +37,11 @@ type Compiler struct {
 	scopeIndex int
 
 	symbolTable *SymbolTable
+
+	optimize bool // run the optimization passes, see optimize.go
+
+	// err is the first operand which didn't fit its instruction (see checkOperands)
+	err error
 }
 
 type EmittedInstruction struct {
@@ -62,15 +80,46 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	return compiler
 }
 
+// EnableOptimizations turns on constant folding and dead branch elimination
+func (c *Compiler) EnableOptimizations() {
+	c.optimize = true
+}
+
 // Compile compiles the program and generates the bytecode
 func (c *Compiler) Compile(node ast.Node) error {
+	if line := statementLine(node); line > 0 {
+		outer := c.line()
+		c.markLine(line, true)
+		if outer > 0 {
+			// the rest of the enclosing statement is back on its line
+			defer c.markLine(outer, false)
+		}
+	} else if line := expressionLine(node); line > 0 && line != c.line() {
+		outer := c.line()
+		c.markLine(line, false)
+		if outer > 0 {
+			defer c.markLine(outer, false)
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
+		if c.optimize {
+			foldConstants(node)
+		}
+
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
+			if c.err != nil {
+				return c.err
+			}
+		}
+
+		if c.optimize {
+			c.peephole()
 		}
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
@@ -94,7 +143,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.InfixExpression:
-		if node.Operator == "<" { // swap order of operands
+		if node.Operator == "<=" || node.Operator == ">=" {
+			// a <= b is !(a > b) and a >= b is !(a < b)
+			operator := ">"
+			if node.Operator == ">=" {
+				operator = "<"
+			}
+
+			err := c.Compile(&ast.InfixExpression{Token: node.Token, Left: node.Left, Operator: operator, Right: node.Right})
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpBang)
+			return nil
+		}
+
+		// swap order of operands, unless that changes the order of observable effects
+		if node.Operator == "<" && (isPure(node.Left) || isPure(node.Right)) {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -134,6 +199,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpDiv)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
@@ -148,9 +215,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		integer := object.NewInteger(node.Value)
 		c.emit(code.OpConstant, c.addConstant(integer))
 	case *ast.IfExpression:
+		if truthy, ok := isConstant(node.Condition); ok && c.optimize {
+			return c.compileConstantIf(node, truthy)
+		}
+
+		depth := c.depth()
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -159,24 +232,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// emit `OpJumpNotTruthy` with a bogus value for now
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
-		err = c.Compile(node.Consequence)
+		err = c.compileBranch(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		/*
-			if the censequence emmits a OpPop we need to drop it else we
-			risk getting rid of the evaluated result
-		*/
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
-
 		// emit `OpJumpNotTruthy` with a bogus value for now
 		//
 		// we have an alternative so the consequence should end with
 		// an unconditional jump past the alternative
 		jumpPos := c.emit(code.OpJump, 9999)
+		c.setDepth(depth) // the alternative starts out like the consequence
 
 		// fix the truthy jump position
 		afterConsequencePos := len(c.scopes[c.scopeIndex].instructions) // where to jump to
@@ -187,40 +253,42 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpNull)
 		} else {
 			// compile the alternative branch
-			err := c.Compile(node.Alternative)
+			err := c.compileBranch(node.Alternative)
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
 		}
 
 		// change the unconditional jump position
 		afterAlternativePos := len(c.scopes[c.scopeIndex].instructions)
 		c.changeOperand(jumpPos, afterAlternativePos)
+		c.setDepth(depth + 1)
+	case *ast.TryExpression:
+		return c.compileTry(node)
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
+			if c.err != nil {
+				return c.err
+			}
 		}
 	case *ast.LetStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		// only functions can refer to themselves, any other value would read its name before
+		// it's set
+		_, function := node.Value.(*ast.FunctionLiteral)
+		if function {
+			c.symbolTable.Define(node.Name.Value)
+		}
+
 		err := c.Compile(node.Value) // compile the expression (lhs of assignment)
 		if err != nil {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			// emit the correctly scoped instruction based on the symbol tables scope
-			// this correctly binds locals
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(c.symbolTable.Define(node.Name.Value))
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
@@ -247,15 +315,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
+		// pairs are compiled in source order, which is the order of the resulting hash
+		for _, k := range node.Keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
@@ -282,6 +343,42 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpIndex)
 
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		// a bound which is left out is null
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			err = c.Compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSlice)
+
+	case *ast.AssignExpression:
+		err := c.Compile(node.Target.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Target.Index)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpSetIndex)
+
 	case *ast.FunctionLiteral:
 		// the functionliteral has it's own scope in which it compiles in
 		c.enterScope()
@@ -307,18 +404,36 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpReturn)
 		}
 
+		if c.optimize {
+			c.peephole()
+		}
+		c.markTailCalls()
+
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions // number of local bindings used by the function
+		localNames := c.symbolTable.Names()
+		handlers := c.scopes[c.scopeIndex].handlers
+		lines := c.scopes[c.scopeIndex].lines
 		instructions := c.leaveScope()
 
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			freeNames[i] = s.Name
+		}
+
 		for _, s := range freeSymbols {
 			c.loadSymbol(s)
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
+			Name:          node.Name,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Handlers:      handlers,
+			Lines:         trimLines(lines, len(instructions)),
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
@@ -331,8 +446,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		// the finally blocks of the try expressions being returned out of run first
+		err = c.compileFinallyBlocks()
+		if err != nil {
+			return err
+		}
+
 		c.emit(code.OpReturnValue)
 
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpThrow)
+
 	case *ast.CallExpression:
 		err := c.Compile(node.Function) // identifier
 		if err != nil {
@@ -352,6 +481,60 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileConstantIf compiles an if expression whose condition is known at compile time. Only
+// the branch which is taken is compiled, without any jumps.
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, truthy bool) error {
+	branch := node.Alternative
+	if truthy {
+		branch = node.Consequence
+	}
+
+	if branch == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	return c.compileBranch(branch)
+}
+
+// compileBranch compiles a branch of an if or try expression. Like any other expression, the
+// branch leaves exactly one value on the stack: the value of its last statement if that is an
+// expression, null otherwise.
+func (c *Compiler) compileBranch(block *ast.BlockStatement) error {
+	depth := c.depth()
+
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	/*
+		if the branch ends in an expression it emitted an OpPop which we need to drop, else we
+		risk getting rid of the evaluated result
+	*/
+	if last := len(block.Statements) - 1; last >= 0 {
+		if _, ok := block.Statements[last].(*ast.ExpressionStatement); ok {
+			c.removeLastPop()
+			c.setDepth(depth + 1)
+			return nil
+		}
+	}
+
+	c.emit(code.OpNull)
+	c.setDepth(depth + 1) // after a return or throw the depth is meaningless
+	return nil
+}
+
+// isPure reports whether evaluating exp can neither have effects nor fail, so it doesn't matter
+// when exp is evaluated
+func isPure(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.Boolean, *ast.StringLiteral:
+		return true
+	}
+	return false
+}
+
 // loadSymbol emits a bytecode instruction telling the vm to either load a symbol (by identifier)
 // from global or local scope, or from the given builtins. This allows for locally scoped variables
 // to overwrite global ones, and for global function calls to work in locally scoped functions.
@@ -370,6 +553,17 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
+// storeSymbol emits the instruction which binds the value on top of the stack to s
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		// emit the correctly scoped instruction based on the symbol tables scope
+		// this correctly binds locals
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions:        code.Instructions{},
@@ -393,19 +587,65 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
-// addConstant adds a constant value to the constants array and returns it's position
+type constantKey struct {
+	Type    object.ObjectType
+	Integer int64
+	String  string
+}
+
+// keyFor returns the key used to deduplicate obj in the constants pool. Only integers and
+// strings are deduplicated, compiled functions are always added.
+func keyFor(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{Type: obj.Type(), Integer: obj.Value}, true
+	case *object.String:
+		return constantKey{Type: obj.Type(), String: obj.Value}, true
+	}
+
+	return constantKey{}, false
+}
+
+// addConstant adds a constant value to the constants array and returns it's position. If an
+// equal integer or string is already in the array, its position is returned instead.
 func (c *Compiler) addConstant(obj object.Object) int {
+	if c.constantIndexes == nil {
+		// the constants may have been handed over by NewWithState (e.g. from a previous
+		// line in the repl)
+		c.constantIndexes = make(map[constantKey]int)
+		for i, existing := range c.constants {
+			if key, ok := keyFor(existing); ok {
+				if _, found := c.constantIndexes[key]; !found {
+					c.constantIndexes[key] = i
+				}
+			}
+		}
+	}
+
+	key, ok := keyFor(obj)
+	if ok {
+		if i, found := c.constantIndexes[key]; found {
+			return i
+		}
+	}
+
 	c.constants = append(c.constants, obj)
+	if ok {
+		c.constantIndexes[key] = len(c.constants) - 1
+	}
+
 	return len(c.constants) - 1
 }
 
 // emit creates and adds code instructions to the instructions array
 // and returns the starting position of the instruction
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
+	c.scopes[c.scopeIndex].depth += code.StackEffect(op, operands...)
 	return pos
 }
 
@@ -435,6 +675,24 @@ func (c *Compiler) removeLastPop() {
 		scopes[c.scopeIndex].
 		instructions[:c.scopes[c.scopeIndex].lastInstruction.Position]
 	c.scopes[c.scopeIndex].lastInstruction = c.scopes[c.scopeIndex].previousInstruction
+	c.scopes[c.scopeIndex].depth++
+	c.truncateLines()
+}
+
+// position returns the position of the next instruction of the current scope
+func (c *Compiler) position() int {
+	return len(c.scopes[c.scopeIndex].instructions)
+}
+
+// depth returns the height of the stack at the current position of the current scope
+func (c *Compiler) depth() int {
+	return c.scopes[c.scopeIndex].depth
+}
+
+// setDepth sets the height of the stack at the current position, e.g. where control flow
+// merges
+func (c *Compiler) setDepth(depth int) {
+	c.scopes[c.scopeIndex].depth = depth
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -445,11 +703,65 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := code.Opcode(c.scopes[c.scopeIndex].instructions[opPos])
+	c.checkOperands(op, []int{operand})
 	newInstruction := code.Make(op, operand)
 
 	c.replaceInstruction(opPos, newInstruction)
 }
 
+// checkOperands records an error if the operands don't fit op, e.g. when a function has more
+// than 256 locals, or when its instructions outgrow the jump operands. Compile checks for it
+// after every statement, which also keeps the copies of nested finally blocks from growing a
+// program without bounds.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	if c.err != nil || code.Fits(op, operands...) {
+		return
+	}
+
+	def, _ := code.Lookup(byte(op))
+	c.err = fmt.Errorf("program too large: operands %v don't fit %s", operands, def.Name)
+}
+
+// markTailCalls rewrites every OpCall of the current scope which sits in tail position into an
+// OpTailCall. A call is in tail position when it's directly followed by an OpReturnValue, or by
+// a chain of jumps which ends in one (e.g. the consequence of an if at the end of a function).
+// Calls protected by an exception handler are never tail calls, the frame has to stay around.
+func (c *Compiler) markTailCalls() {
+	ins := c.scopes[c.scopeIndex].instructions
+	handlers := c.scopes[c.scopeIndex].handlers
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return
+		}
+		_, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+
+		if code.Opcode(ins[i]) == code.OpCall && returnsAt(ins, next) && !protected(handlers, i) {
+			ins[i] = byte(code.OpTailCall)
+		}
+		i = next
+	}
+}
+
+// returnsAt reports whether execution starting at pos reaches an OpReturnValue without
+// executing anything but unconditional jumps
+func returnsAt(ins code.Instructions, pos int) bool {
+	// bound the walk by the number of instructions so a jump cycle can't loop forever
+	for steps := 0; pos < len(ins) && steps < len(ins); steps++ {
+		switch code.Opcode(ins[pos]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			pos = int(code.ReadUint16(ins[pos+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
@@ -462,6 +774,17 @@ func (c *Compiler) replaceLastPopWithReturn() {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+
+	// Handlers is the exception handler table of the main program
+	Handlers []code.Handler
+
+	// NumRegisters is the size of the main program's frame for register code (see registers.go)
+	NumRegisters int
+
+	// Lines is the line table of the main program and GlobalNames the names of the globals by
+	// index, for debuggers
+	Lines       code.LineTable
+	GlobalNames []string
 }
 
 // Bytecode returns the bytecode for the application
@@ -469,5 +792,87 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.scopes[c.scopeIndex].instructions,
 		Constants:    c.constants,
+		Handlers:     c.scopes[c.scopeIndex].handlers,
+		Lines:        trimLines(c.scopes[c.scopeIndex].lines, len(c.scopes[c.scopeIndex].instructions)),
+		GlobalNames:  c.symbolTable.Names(),
+	}
+}
+
+// statementLine returns the line the statement node starts on, 0 for other nodes and for
+// blocks (their statements have lines of their own)
+func statementLine(node ast.Node) int {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		return node.Token.Line
+	case *ast.ReturnStatement:
+		return node.Token.Line
+	case *ast.ThrowStatement:
+		return node.Token.Line
+	case *ast.ExpressionStatement:
+		return node.Token.Line
+	}
+	return 0
+}
+
+// expressionLine returns the line of the expressions which have a line of their own when they
+// start on another line than the statement they're in, 0 for other nodes: ifs, so that their
+// conditional jumps map to the if (coverage reports the outcomes of the jumps by line)
+func expressionLine(node ast.Node) int {
+	if node, ok := node.(*ast.IfExpression); ok {
+		return node.Token.Line
+	}
+	return 0
+}
+
+// line returns the line the instructions of the current scope are being compiled from, 0 if
+// there is none yet
+func (c *Compiler) line() int {
+	lines := c.scopes[c.scopeIndex].lines
+	if len(lines) == 0 {
+		return 0
+	}
+	return lines[len(lines)-1].Line
+}
+
+// markLine records that the instructions emitted from here on are compiled from line, and
+// whether a statement starts here. Entries no instruction was emitted for are dropped, as are
+// those past the end of instructions which were removed again.
+func (c *Compiler) markLine(line int, statement bool) {
+	scope := &c.scopes[c.scopeIndex]
+	pos := len(scope.instructions)
+
+	lines := scope.lines
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= pos {
+		lines = lines[:len(lines)-1]
+	}
+	if !statement && len(lines) > 0 && lines[len(lines)-1].Line == line {
+		scope.lines = lines
+		return
+	}
+	scope.lines = append(lines, code.LineEntry{Offset: pos, Line: line, Statement: statement})
+}
+
+// truncateLines moves the line entries past the end of the instructions, which were removed,
+// to the end
+func (c *Compiler) truncateLines() {
+	scope := &c.scopes[c.scopeIndex]
+	pos := len(scope.instructions)
+
+	n := len(scope.lines)
+	if n == 0 || scope.lines[n-1].Offset <= pos {
+		return
+	}
+	last := scope.lines[n-1]
+	for len(scope.lines) > 0 && scope.lines[len(scope.lines)-1].Offset > pos {
+		scope.lines = scope.lines[:len(scope.lines)-1]
+	}
+	c.markLine(last.Line, last.Statement)
+}
+
+// trimLines drops the entries of lines which don't cover any of size bytes of instructions
+func trimLines(lines code.LineTable, size int) code.LineTable {
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= size {
+		lines = lines[:len(lines)-1]
 	}
+	return lines
 }
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/andy9775/monkey/ast"
@@ -31,16 +32,15 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -60,21 +60,20 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
 					code.Make(code.OpClosure, 1, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -86,6 +85,221 @@ func TestRecursiveFunctions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let f = fn(x) { if (x) { f(x) } else { 1 } };`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 13),
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 1), // jumps straight to the OpReturnValue
+					code.Make(code.OpJump, 16),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `let f = fn(x) { f(x) + 1 };`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1), // not in tail position
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `let f = fn(x) { try { f(x) } catch { 1 } };`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1), // protected by the catch
+					code.Make(code.OpJump, 12),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `let f = fn(x) { return f(x); };`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input                string
+		expectedInstructions []code.Instructions
+		expectedHandlers     []code.Handler
+	}{
+		{
+			input: `try { 1 } catch (e) { e }`,
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJump, 12),
+				// 0006
+				code.Make(code.OpSetGlobal, 0),
+				// 0009
+				code.Make(code.OpGetGlobal, 0),
+				// 0012
+				code.Make(code.OpPop),
+			},
+			expectedHandlers: []code.Handler{{Start: 0, End: 3, Target: 6, Depth: 0}},
+		},
+		{
+			input: `try { 1 } catch (e) { 2 } finally { 3 }`,
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003 finally
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				// 0007
+				code.Make(code.OpJump, 28),
+				// 0010 catch
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpConstant, 2),
+				// 0016 finally
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpJump, 28),
+				// 0023 finally handler
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpThrow),
+				// 0028
+				code.Make(code.OpPop),
+			},
+			expectedHandlers: []code.Handler{
+				{Start: 0, End: 3, Target: 10, Depth: 0},
+				{Start: 0, End: 3, Target: 23, Depth: 0},
+				{Start: 13, End: 16, Target: 23, Depth: 0},
+			},
+		},
+		{
+			// the finally block runs before the return, outside of the handlers
+			input: `try { return 1 } finally { 2 }`,
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				// 0007
+				code.Make(code.OpReturnValue),
+				// 0008
+				code.Make(code.OpNull),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				// 0013
+				code.Make(code.OpJump, 21),
+				// 0016
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpThrow),
+				// 0021
+				code.Make(code.OpPop),
+			},
+			expectedHandlers: []code.Handler{
+				{Start: 0, End: 3, Target: 16, Depth: 0},
+				{Start: 7, End: 9, Target: 16, Depth: 0},
+			},
+		},
+		{
+			// inner handlers come first, handlers record the stack depth
+			input: `1 + try { try { 2 } catch { 3 } } catch { 4 }`,
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpJump, 13),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpConstant, 2),
+				// 0013
+				code.Make(code.OpJump, 20),
+				// 0016
+				code.Make(code.OpPop),
+				// 0017
+				code.Make(code.OpConstant, 3),
+				// 0020
+				code.Make(code.OpAdd),
+				// 0021
+				code.Make(code.OpPop),
+			},
+			expectedHandlers: []code.Handler{
+				{Start: 3, End: 6, Target: 9, Depth: 1},
+				{Start: 3, End: 13, Target: 16, Depth: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed: %s", err)
+		}
+
+		if fmt.Sprint(bytecode.Handlers) != fmt.Sprint(tt.expectedHandlers) {
+			t.Errorf("wrong handlers for %q.\nwant=%v\ngot =%v", tt.input, tt.expectedHandlers, bytecode.Handlers)
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -219,7 +433,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -233,6 +447,36 @@ func TestBuiltins(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestCompilerErrors(t *testing.T) {
+	locals := ""
+	for i := 0; i < 300; i++ {
+		locals += fmt.Sprintf("let %c%c = %d; ", 'a'+i/26, 'a'+i%26, i)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"foobar", "undefined variable foobar"},
+		{"let a = [a];", "undefined variable a"},
+		{
+			"fn() { " + locals + "}",
+			"program too large: operands [256] don't fit OpSetLocal",
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
 func TestLetStatementScopes(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -534,14 +778,14 @@ func TestIndexExpression(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1,2,3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -549,18 +793,43 @@ func TestIndexExpression(t *testing.T) {
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "[1][0] = 2",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// a bound which is left out is null
+			input:             "[1][:1]",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -593,6 +862,19 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// pairs are compiled in source order
+			input:             "{5: 6, 1: 2}",
+			expectedConstants: []interface{}{5, 6, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "{1: 2 + 3, 4: 5 * 6}",
 			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
@@ -893,6 +1175,43 @@ func TestBooleanExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// swapping operands which aren't pure would change the order they're evaluated in
+			input:             "[1] < [2]",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 1 <= 2 is !(1 > 2)
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 1 >= 2 is !(1 < 2)
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "1 == 2",
 			expectedConstants: []interface{}{1, 2},
@@ -947,13 +1266,144 @@ func TestBooleanExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestLines(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  let y = x +
+    2;
+  y - 1
+};
+let c = if (a) {
+  2
+} else { 3 } + f(a);`
+
+	tests := []struct {
+		optimize      bool
+		expectedMain  code.LineTable
+		expectedFn    code.LineTable
+		expectedNames []string
+	}{
+		{
+			optimize: false,
+			expectedMain: code.LineTable{
+				{Offset: 0, Line: 1, Statement: true},
+				{Offset: 6, Line: 2, Statement: true},
+				{Offset: 13, Line: 7, Statement: true},
+				{Offset: 19, Line: 8, Statement: true},
+				{Offset: 22, Line: 7}, // the jump over the alternative
+				{Offset: 25, Line: 9, Statement: true},
+				{Offset: 28, Line: 7}, // the rest of the let statement
+			},
+			expectedFn: code.LineTable{
+				{Offset: 0, Line: 3, Statement: true},
+				{Offset: 8, Line: 5, Statement: true},
+			},
+		},
+		{
+			// the fused instructions are shorter
+			optimize: true,
+			expectedMain: code.LineTable{
+				{Offset: 0, Line: 1, Statement: true},
+				{Offset: 6, Line: 2, Statement: true},
+				{Offset: 13, Line: 7, Statement: true},
+				{Offset: 19, Line: 8, Statement: true},
+				{Offset: 22, Line: 7},
+				{Offset: 25, Line: 9, Statement: true},
+				{Offset: 28, Line: 7},
+			},
+			expectedFn: code.LineTable{
+				{Offset: 0, Line: 3, Statement: true},
+				{Offset: 7, Line: 5, Statement: true}, // after OpAddConst rather than OpConstant, OpAdd
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if tt.optimize {
+			compiler.EnableOptimizations()
+		}
+		if err := compiler.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+
+		if !reflect.DeepEqual(bytecode.Lines, tt.expectedMain) {
+			t.Errorf("main lines wrong (optimize=%t).\nwant=%v\ngot =%v", tt.optimize, tt.expectedMain, bytecode.Lines)
+		}
+		if !reflect.DeepEqual(bytecode.GlobalNames, []string{"a", "f", "c"}) {
+			t.Errorf("global names wrong. got=%v", bytecode.GlobalNames)
+		}
+
+		fn, ok := bytecode.Constants[2].(*object.CompiledFunction)
+		if !ok {
+			t.Fatalf("constant 2 is not a function. got=%T", bytecode.Constants[2])
+		}
+		if !reflect.DeepEqual(fn.Lines, tt.expectedFn) {
+			t.Errorf("function lines wrong (optimize=%t).\nwant=%v\ngot =%v", tt.optimize, tt.expectedFn, fn.Lines)
+		}
+		if !reflect.DeepEqual(fn.LocalNames, []string{"x", "y"}) {
+			t.Errorf("local names wrong. got=%v", fn.LocalNames)
+		}
+	}
+}
+
+// An if starting on a line of its own has the line, so that its condition does
+func TestIfLines(t *testing.T) {
+	input := `let c = 1 +
+  if (true) { 2 } else { 3 };`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := code.LineTable{
+		{Offset: 0, Line: 1, Statement: true},
+		{Offset: 3, Line: 2}, // the condition and the jump
+		{Offset: 7, Line: 2, Statement: true},
+		{Offset: 13, Line: 2, Statement: true},
+		{Offset: 16, Line: 1}, // the addition and the rest of the let statement
+	}
+	if lines := compiler.Bytecode().Lines; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("lines wrong.\nwant=%v\ngot =%v", expected, lines)
+	}
+}
+
+func TestFreeNames(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse("fn(a) { fn(b) { fn(c) { a + b + c } } }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// the innermost function is compiled first
+	fn, ok := compiler.Bytecode().Constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 0 is not a function. got=%T", compiler.Bytecode().Constants[0])
+	}
+	if !reflect.DeepEqual(fn.FreeNames, []string{"a", "b"}) {
+		t.Errorf("free names wrong. want=[a b], got=%v", fn.FreeNames)
+	}
+	if !reflect.DeepEqual(fn.LocalNames, []string{"c"}) {
+		t.Errorf("local names wrong. want=[c], got=%v", fn.LocalNames)
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
+	runCompilerTestsWith(t, tests, false)
+}
+
+func runCompilerTestsWith(t *testing.T, tests []compilerTestCase, optimize bool) {
+	t.Helper()
 
 	for _, tt := range tests {
 		program := parse(tt.input)
 
 		compiler := New()
+		if optimize {
+			compiler.EnableOptimizations()
+		}
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
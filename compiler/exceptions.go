@@ -0,0 +1,211 @@
+package compiler
+
+import (
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/code"
+)
+
+/*
+	A try expression compiles to
+
+	           <try block>                  leaves the value of the try block
+	           <finally block>
+	           OpJump end
+	    catch: OpSetGlobal/OpSetLocal e     the error sits on top of the stack (OpPop if the
+	           <catch block>                catch doesn't bind it)
+	           <finally block>
+	           OpJump end                   (the catch block falls through without a finally block)
+	  finally: <finally block>              the error sits on top of the stack
+	           OpThrow                      and is thrown again
+	      end:
+
+	leaving out the parts of a missing catch or finally block. The handler table of the scope
+	sends errors thrown in the try block to the catch handler, and errors thrown in the try and
+	catch blocks to the finally handler.
+
+	The finally block is copied into every path out of the try expression, including returns out
+	of the try and catch blocks (see compileFinallyBlocks). The copies for returns are holes in
+	the ranges of the handlers: an error thrown by a finally block must not be caught by its own
+	try expression, or any try expression nested in it.
+*/
+
+// activeTry is a try expression whose try or catch block is being compiled
+type activeTry struct {
+	finally *ast.BlockStatement
+	holes   []span // the finally blocks copied for returns
+}
+
+// span is the range [start, end) of instruction positions
+type span struct {
+	start, end int
+}
+
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	depth := c.depth()
+	try := &activeTry{finally: node.Finally}
+
+	tryStart := c.position()
+	err := c.compileProtected(try, node.Block)
+	if err != nil {
+		return err
+	}
+	tryRange := span{tryStart, c.position()}
+
+	err = c.compileFinally(node.Finally)
+	if err != nil {
+		return err
+	}
+	jumps := []int{c.emit(code.OpJump, 9999)}
+
+	var handlers []code.Handler
+	finallyRanges := []span{tryRange}
+
+	if node.Catch != nil {
+		target := c.position()
+		c.setDepth(depth + 1) // the error
+
+		if node.CatchName != nil {
+			c.storeSymbol(c.symbolTable.Define(node.CatchName.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+
+		catchStart := c.position()
+		err := c.compileProtected(try, node.Catch)
+		if err != nil {
+			return err
+		}
+		finallyRanges = append(finallyRanges, span{catchStart, c.position()})
+
+		if node.Finally != nil {
+			err = c.compileFinally(node.Finally)
+			if err != nil {
+				return err
+			}
+			jumps = append(jumps, c.emit(code.OpJump, 9999))
+		}
+
+		handlers = append(handlers, newHandlers([]span{tryRange}, try.holes, target, depth)...)
+	}
+
+	if node.Finally != nil {
+		target := c.position()
+		c.setDepth(depth + 1) // the error
+
+		err := c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+		handlers = append(handlers, newHandlers(finallyRanges, try.holes, target, depth)...)
+	}
+
+	end := c.position()
+	for _, jump := range jumps {
+		c.changeOperand(jump, end)
+	}
+	c.setDepth(depth + 1)
+
+	// try expressions nested in this one have added their handlers already, which keeps the
+	// table ordered innermost first
+	scope := &c.scopes[c.scopeIndex]
+	scope.handlers = append(scope.handlers, handlers...)
+
+	return nil
+}
+
+// compileProtected compiles the try or catch block of try, which leaves its value on the stack
+// like the branches of an if expression.
+func (c *Compiler) compileProtected(try *activeTry, block *ast.BlockStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	tries := scope.tries
+	scope.tries = append(tries[:len(tries):len(tries)], try)
+
+	err := c.compileBranch(block)
+
+	// compiling the block may have grown c.scopes
+	c.scopes[c.scopeIndex].tries = tries
+	return err
+}
+
+// compileFinally compiles a copy of the finally block, leaving the stack as it was
+func (c *Compiler) compileFinally(block *ast.BlockStatement) error {
+	if block == nil {
+		return nil
+	}
+
+	return c.Compile(block)
+}
+
+// compileFinallyBlocks compiles the finally blocks of the try expressions a return leaves,
+// innermost first. Each copy runs outside of its try expression (and of those nested in it).
+func (c *Compiler) compileFinallyBlocks() error {
+	tries := c.scopes[c.scopeIndex].tries
+
+	for i := len(tries) - 1; i >= 0; i-- {
+		if tries[i].finally == nil {
+			continue
+		}
+
+		start := c.position()
+
+		c.scopes[c.scopeIndex].tries = tries[:i:i]
+		err := c.compileFinally(tries[i].finally)
+		c.scopes[c.scopeIndex].tries = tries
+		if err != nil {
+			return err
+		}
+
+		for _, try := range tries[i:] {
+			try.holes = append(try.holes, span{start, c.position()})
+		}
+	}
+
+	return nil
+}
+
+// newHandlers returns the handlers sending the errors thrown in ranges, apart from the holes,
+// to target
+func newHandlers(ranges []span, holes []span, target, depth int) []code.Handler {
+	handlers := []code.Handler{}
+
+	for _, r := range ranges {
+		for _, s := range r.subtract(holes) {
+			handlers = append(handlers, code.Handler{Start: s.start, End: s.end, Target: target, Depth: depth})
+		}
+	}
+
+	return handlers
+}
+
+// subtract returns the parts of s which aren't covered by holes; holes are ordered by position
+func (s span) subtract(holes []span) []span {
+	parts := []span{}
+
+	start := s.start
+	for _, hole := range holes {
+		if hole.end <= start || hole.start >= s.end {
+			continue
+		}
+		if hole.start > start {
+			parts = append(parts, span{start, hole.start})
+		}
+		start = hole.end
+	}
+	if start < s.end {
+		parts = append(parts, span{start, s.end})
+	}
+
+	return parts
+}
+
+// protected reports whether an instruction at pos is covered by one of handlers
+func protected(handlers []code.Handler, pos int) bool {
+	for _, h := range handlers {
+		if h.Start <= pos && pos < h.End {
+			return true
+		}
+	}
+	return false
+}
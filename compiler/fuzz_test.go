@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/andy9775/monkey/internal/corpus"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
+)
+
+// FuzzCompile checks that compiling any program, with and without the optimization passes,
+// returns bytecode or an error, and that the register allocator accepts all bytecode
+func FuzzCompile(f *testing.F) {
+	// inputs of the compiler tests
+	f.Add("1 + 2; 1 - 2; 1 * 2; 2 / 1; -1; !true; 1 > 2; 1 < 2; 1 <= 2; 1 >= 2; 1 == 2; true != false")
+	f.Add("if (true) { 10 }; 3333; if (true) { 10 } else { 20 }; 3333;")
+	f.Add(`let one = 1; let two = one; two; "mon" + "key"; [1 + 2, 3 - 4, 5 * 6]; {1: 2 + 3, 4: 5 * 6}[2 - 1]`)
+	f.Add("let oneArg = fn(a) { a }; let manyArg = fn(a, b, c) { a; b; c }; oneArg(24); manyArg(24, 25, 26);")
+	f.Add("fn(a) { fn(b) { fn(c) { a + b + c } } }; let countDown = fn(x) { countDown(x - 1); }; countDown(1);")
+	f.Add("let f = fn(x) { try { f(x) } catch { 1 } }; try { 1 } catch (e) { 2 } finally { 3 }; try { return 1 } finally { 2 }")
+	f.Add("1 + try { try { 2 } catch { 3 } } catch { 4 }; throw error(\"x\");")
+	corpus.AddSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		for _, optimize := range []bool{false, true} {
+			// the optimization passes change the program
+			program := parser.New(lexer.New(input)).ParseProgram()
+
+			compiler := New()
+			if optimize {
+				compiler.EnableOptimizations()
+			}
+			if err := compiler.Compile(program); err != nil {
+				continue
+			}
+
+			if _, err := AllocateRegisters(compiler.Bytecode()); err != nil {
+				t.Fatalf("register allocation error (optimize=%t): %s", optimize, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package compiler
+
+import (
+	"strconv"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/token"
+)
+
+/*
+	The optimization passes are opt-in (see Compiler.EnableOptimizations) and work on the AST
+	before it's compiled.
+
+	foldConstants replaces prefix and infix expressions whose operands are all literals with the
+	literal they evaluate to, e.g. `1 + 2 * 3` becomes `7`. Only operations which behave exactly
+	the same at compile time as in the vm are folded: division by zero is left for the vm to
+	report. Strings are concatenated and compared by value, as the vm does.
+
+	Dead branch elimination happens while compiling if expressions whose (folded) condition
+	is a literal, see Compiler.compileConstantIf.
+*/
+
+// foldConstants folds the constant expressions of node in place and returns the node
+func foldConstants(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			foldConstants(s)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			foldConstants(s)
+		}
+	case *ast.ExpressionStatement:
+		node.Expression = foldExpression(node.Expression)
+	case *ast.LetStatement:
+		node.Value = foldExpression(node.Value)
+	case *ast.ReturnStatement:
+		node.ReturnValue = foldExpression(node.ReturnValue)
+	case *ast.ThrowStatement:
+		node.Value = foldExpression(node.Value)
+	case ast.Expression:
+		return foldExpression(node)
+	}
+
+	return node
+}
+
+// foldExpression folds exp's children and then exp its self, returning the replacement node
+func foldExpression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		exp.Right = foldExpression(exp.Right)
+		if folded := foldPrefix(exp); folded != nil {
+			return folded
+		}
+	case *ast.InfixExpression:
+		exp.Left = foldExpression(exp.Left)
+		exp.Right = foldExpression(exp.Right)
+		if folded := foldInfix(exp); folded != nil {
+			return folded
+		}
+	case *ast.IfExpression:
+		exp.Condition = foldExpression(exp.Condition)
+		foldConstants(exp.Consequence)
+		if exp.Alternative != nil {
+			foldConstants(exp.Alternative)
+		}
+	case *ast.TryExpression:
+		foldConstants(exp.Block)
+		if exp.Catch != nil {
+			foldConstants(exp.Catch)
+		}
+		if exp.Finally != nil {
+			foldConstants(exp.Finally)
+		}
+	case *ast.FunctionLiteral:
+		foldConstants(exp.Body)
+	case *ast.CallExpression:
+		exp.Function = foldExpression(exp.Function)
+		for i, a := range exp.Arguments {
+			exp.Arguments[i] = foldExpression(a)
+		}
+	case *ast.ArrayLiteral:
+		for i, el := range exp.Elements {
+			exp.Elements[i] = foldExpression(el)
+		}
+	case *ast.IndexExpression:
+		exp.Left = foldExpression(exp.Left)
+		exp.Index = foldExpression(exp.Index)
+	case *ast.SliceExpression:
+		exp.Left = foldExpression(exp.Left)
+		if exp.Start != nil {
+			exp.Start = foldExpression(exp.Start)
+		}
+		if exp.End != nil {
+			exp.End = foldExpression(exp.End)
+		}
+	case *ast.AssignExpression:
+		exp.Target.Left = foldExpression(exp.Target.Left)
+		exp.Target.Index = foldExpression(exp.Target.Index)
+		exp.Value = foldExpression(exp.Value)
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(exp.Pairs))
+		for i, k := range exp.Keys {
+			folded := foldExpression(k)
+			pairs[folded] = foldExpression(exp.Pairs[k])
+			exp.Keys[i] = folded
+		}
+		exp.Pairs = pairs
+	}
+
+	return exp
+}
+
+func foldPrefix(exp *ast.PrefixExpression) ast.Expression {
+	switch right := exp.Right.(type) {
+	case *ast.IntegerLiteral:
+		switch exp.Operator {
+		case "-":
+			return newIntegerLiteral(exp.Token, -right.Value)
+		case "!": // integers are always truthy
+			return newBooleanLiteral(exp.Token, false)
+		}
+	case *ast.Boolean:
+		if exp.Operator == "!" {
+			return newBooleanLiteral(exp.Token, !right.Value)
+		}
+	case *ast.StringLiteral:
+		if exp.Operator == "!" {
+			return newBooleanLiteral(exp.Token, false)
+		}
+	}
+
+	return nil
+}
+
+func foldInfix(exp *ast.InfixExpression) ast.Expression {
+	switch left := exp.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := exp.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+
+		switch exp.Operator {
+		case "+":
+			return newIntegerLiteral(exp.Token, left.Value+right.Value)
+		case "-":
+			return newIntegerLiteral(exp.Token, left.Value-right.Value)
+		case "*":
+			return newIntegerLiteral(exp.Token, left.Value*right.Value)
+		case "/":
+			if right.Value == 0 { // leave the error to the vm
+				return nil
+			}
+			return newIntegerLiteral(exp.Token, left.Value/right.Value)
+		case ">":
+			return newBooleanLiteral(exp.Token, left.Value > right.Value)
+		case "<":
+			return newBooleanLiteral(exp.Token, left.Value < right.Value)
+		case ">=":
+			return newBooleanLiteral(exp.Token, left.Value >= right.Value)
+		case "<=":
+			return newBooleanLiteral(exp.Token, left.Value <= right.Value)
+		case "==":
+			return newBooleanLiteral(exp.Token, left.Value == right.Value)
+		case "!=":
+			return newBooleanLiteral(exp.Token, left.Value != right.Value)
+		}
+	case *ast.Boolean:
+		right, ok := exp.Right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+
+		switch exp.Operator {
+		case "==":
+			return newBooleanLiteral(exp.Token, left.Value == right.Value)
+		case "!=":
+			return newBooleanLiteral(exp.Token, left.Value != right.Value)
+		}
+	case *ast.StringLiteral:
+		right, ok := exp.Right.(*ast.StringLiteral)
+		if !ok {
+			return nil
+		}
+
+		switch exp.Operator {
+		case "+":
+			tok := exp.Token
+			tok.Type = token.STRING
+			tok.Literal = left.Value + right.Value
+			return &ast.StringLiteral{Token: tok, Value: tok.Literal}
+		case ">":
+			return newBooleanLiteral(exp.Token, left.Value > right.Value)
+		case "<":
+			return newBooleanLiteral(exp.Token, left.Value < right.Value)
+		case ">=":
+			return newBooleanLiteral(exp.Token, left.Value >= right.Value)
+		case "<=":
+			return newBooleanLiteral(exp.Token, left.Value <= right.Value)
+		case "==":
+			return newBooleanLiteral(exp.Token, left.Value == right.Value)
+		case "!=":
+			return newBooleanLiteral(exp.Token, left.Value != right.Value)
+		}
+	}
+
+	return nil
+}
+
+// isConstant reports whether exp is a literal and if so, whether the vm considers it truthy
+func isConstant(exp ast.Expression) (truthy bool, ok bool) {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	}
+
+	return false, false
+}
+
+// newIntegerLiteral creates a folded literal. The token of the original expression is kept
+// around (with its literal replaced) so the literal points back to where it came from.
+func newIntegerLiteral(tok token.Token, value int64) *ast.IntegerLiteral {
+	tok.Type = token.INT
+	tok.Literal = strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{Token: tok, Value: value}
+}
+
+func newBooleanLiteral(tok token.Token, value bool) *ast.Boolean {
+	if value {
+		tok.Type = token.TRUE
+	} else {
+		tok.Type = token.FALSE
+	}
+	tok.Literal = strconv.FormatBool(value)
+	return &ast.Boolean{Token: tok, Value: value}
+}
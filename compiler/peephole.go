@@ -0,0 +1,269 @@
+package compiler
+
+import (
+	"github.com/andy9775/monkey/code"
+)
+
+/*
+	peephole is the bytecode level optimization pass. It runs over the instructions of each scope
+	once they're complete (when optimizations are enabled) and
+
+	1. threads jumps: a jump to an OpJump jumps to its target directly, and an OpJump to an
+	   OpReturnValue/OpReturn becomes the return its self
+	2. removes jumps to the instruction which follows anyway
+	3. fuses hot instruction sequences into superinstructions:
+	     OpGetLocal a; OpGetLocal b; OpAdd    => OpAddLocals a b
+	     OpConstant k; OpAdd                  => OpAddConst k
+	     OpConstant k; OpSub                  => OpSubConst k
+	     OpGreaterThan; OpJumpNotTruthy p     => OpJumpIfNotGreater p
+	     OpEqual; OpJumpNotTruthy p           => OpJumpIfNotEqual p
+
+	Sequences are only fused when no jump (or exception handler) lands in their middle. Since
+	instructions change size, the result is re-encoded and all jump targets and the exception
+	handler table are relocated.
+*/
+
+// instruction is a decoded instruction
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	pos      int // position in the original instructions
+}
+
+// peephole optimizes the instructions of the current scope
+func (c *Compiler) peephole() {
+	scope := &c.scopes[c.scopeIndex]
+
+	labels := []int{}
+	for _, h := range scope.handlers {
+		labels = append(labels, h.Start, h.End, h.Target)
+	}
+	for _, entry := range scope.lines {
+		if entry.Statement {
+			labels = append(labels, entry.Offset)
+		}
+	}
+
+	size := len(scope.instructions)
+	var positions map[int]int
+	scope.instructions, positions = peephole(scope.instructions, labels...)
+
+	for i, h := range scope.handlers {
+		scope.handlers[i] = code.Handler{
+			Start:  resolve(positions, h.Start, size),
+			End:    resolve(positions, h.End, size),
+			Target: resolve(positions, h.Target, size),
+			Depth:  h.Depth,
+		}
+	}
+
+	// entries whose instructions were all removed give way to the next one
+	lines := code.LineTable{}
+	for _, entry := range scope.lines {
+		entry.Offset = resolve(positions, entry.Offset, size)
+		if len(lines) > 0 && lines[len(lines)-1].Offset == entry.Offset {
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, entry)
+	}
+	scope.lines = lines
+
+	// the positions of the emitted instructions have changed
+	scope.lastInstruction = EmittedInstruction{}
+	scope.previousInstruction = EmittedInstruction{}
+}
+
+// peephole returns the optimized instructions, and a mapping of every position in ins which
+// holds an instruction (and len(ins)) to where execution continues in the result. No sequence
+// is fused across labels, the positions the handler and line tables refer to.
+func peephole(ins code.Instructions, labels ...int) (code.Instructions, map[int]int) {
+	original := decode(ins)
+
+	list := make([]instruction, len(original))
+	copy(list, original)
+
+	list = threadJumps(list)
+	list = removeRedundantJumps(list, len(ins))
+	list = fuse(list, labels)
+
+	out, positions := encode(list, len(ins))
+
+	// positions of removed or fused instructions continue at the next kept instruction
+	for _, inst := range original {
+		positions[inst.pos] = resolve(positions, inst.pos, len(ins))
+	}
+
+	return out, positions
+}
+
+func decode(ins code.Instructions) []instruction {
+	list := []instruction{}
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil { // can't happen for compiled code; leave the rest untouched
+			return list
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		list = append(list, instruction{op: code.Opcode(ins[i]), operands: operands, pos: i})
+		i += 1 + read
+	}
+
+	return list
+}
+
+// encode re-encodes the instructions and relocates the jumps
+func encode(list []instruction, size int) (code.Instructions, map[int]int) {
+	positions := make(map[int]int, len(list)+1)
+
+	newPos := 0
+	for _, inst := range list {
+		positions[inst.pos] = newPos
+		newPos += len(code.Make(inst.op, inst.operands...))
+	}
+	positions[size] = newPos
+
+	out := make(code.Instructions, 0, newPos)
+	for _, inst := range list {
+		if isJump(inst.op) {
+			inst.operands = []int{resolve(positions, inst.operands[0], size)}
+		}
+		out = append(out, code.Make(inst.op, inst.operands...)...)
+	}
+
+	return out, positions
+}
+
+// resolve returns the new position of old. Positions which no longer hold an instruction of
+// their own (the instruction was removed or fused into its predecessor) resolve to the next
+// position which does.
+func resolve(positions map[int]int, old int, size int) int {
+	for p := old; p <= size; p++ {
+		if n, ok := positions[p]; ok {
+			return n
+		}
+	}
+	return positions[size]
+}
+
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpIfNotGreater, code.OpJumpIfNotEqual:
+		return true
+	}
+	return false
+}
+
+// threadJumps shortcuts jumps to unconditional jumps and turns jumps to a return into the return
+func threadJumps(list []instruction) []instruction {
+	at := make(map[int]int, len(list)) // position => index in list
+	for i, inst := range list {
+		at[inst.pos] = i
+	}
+
+	for i, inst := range list {
+		if !isJump(inst.op) {
+			continue
+		}
+
+		target := inst.operands[0]
+		// bound the walk so a cycle of jumps can't loop forever
+		for steps := 0; steps < len(list); steps++ {
+			j, ok := at[target]
+			if !ok || list[j].op != code.OpJump || j == i {
+				break
+			}
+			target = list[j].operands[0]
+		}
+		list[i].operands = []int{target}
+
+		if inst.op != code.OpJump {
+			continue
+		}
+		if j, ok := at[target]; ok {
+			switch list[j].op {
+			case code.OpReturnValue, code.OpReturn:
+				list[i] = instruction{op: list[j].op, operands: []int{}, pos: inst.pos}
+			}
+		}
+	}
+
+	return list
+}
+
+// removeRedundantJumps drops unconditional jumps to the next instruction
+func removeRedundantJumps(list []instruction, size int) []instruction {
+	out := make([]instruction, 0, len(list))
+
+	for i, inst := range list {
+		next := size
+		if i+1 < len(list) {
+			next = list[i+1].pos
+		}
+
+		if inst.op == code.OpJump && inst.operands[0] == next {
+			continue
+		}
+		out = append(out, inst)
+	}
+
+	return out
+}
+
+func fuse(list []instruction, labels []int) []instruction {
+	targets := map[int]bool{}
+	for _, label := range labels {
+		targets[label] = true
+	}
+	for _, inst := range list {
+		if isJump(inst.op) {
+			targets[inst.operands[0]] = true
+		}
+	}
+
+	// fusable reports whether list[i:i+n] exists and nothing jumps into it after its start
+	fusable := func(i, n int) bool {
+		if i+n > len(list) {
+			return false
+		}
+		for j := i + 1; j < i+n; j++ {
+			if targets[list[j].pos] {
+				return false
+			}
+		}
+		return true
+	}
+
+	out := make([]instruction, 0, len(list))
+	for i := 0; i < len(list); i++ {
+		inst := list[i]
+
+		switch {
+		case inst.op == code.OpGetLocal && fusable(i, 3) &&
+			list[i+1].op == code.OpGetLocal && list[i+2].op == code.OpAdd:
+			inst = instruction{
+				op:       code.OpAddLocals,
+				operands: []int{inst.operands[0], list[i+1].operands[0]},
+				pos:      inst.pos,
+			}
+			i += 2
+		case inst.op == code.OpConstant && fusable(i, 2) && list[i+1].op == code.OpAdd:
+			inst = instruction{op: code.OpAddConst, operands: inst.operands, pos: inst.pos}
+			i++
+		case inst.op == code.OpConstant && fusable(i, 2) && list[i+1].op == code.OpSub:
+			inst = instruction{op: code.OpSubConst, operands: inst.operands, pos: inst.pos}
+			i++
+		case inst.op == code.OpGreaterThan && fusable(i, 2) && list[i+1].op == code.OpJumpNotTruthy:
+			inst = instruction{op: code.OpJumpIfNotGreater, operands: list[i+1].operands, pos: inst.pos}
+			i++
+		case inst.op == code.OpEqual && fusable(i, 2) && list[i+1].op == code.OpJumpNotTruthy:
+			inst = instruction{op: code.OpJumpIfNotEqual, operands: list[i+1].operands, pos: inst.pos}
+			i++
+		}
+
+		out = append(out, inst)
+	}
+
+	return out
+}
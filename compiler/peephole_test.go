@@ -0,0 +1,109 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/andy9775/monkey/code"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let f = fn(x) { if (x > 1) { x - 1 } else { x + x } };",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpJumpIfNotGreater, 14),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSubConst, 0),
+					code.Make(code.OpReturnValue), // was a jump to the final OpReturnValue
+					code.Make(code.OpAddLocals, 0, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } };",
+			expectedConstants: []interface{}{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpJumpIfNotEqual, 12),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSubConst, 1),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			// jump targets are relocated in the main program too
+			input:             "let a = 1; if (a) { a + 2 } else { 2 }; 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 21),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAddConst, 1),
+				code.Make(code.OpJump, 24),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWith(t, tests, true)
+}
+
+func TestPeepholeDoesNotFuseJumpTargets(t *testing.T) {
+	// OpConstant; [target] OpAdd must not be fused since a jump lands on the OpAdd
+	ins := concatInstructions([]code.Instructions{
+		code.Make(code.OpTrue),              // 0000
+		code.Make(code.OpJumpNotTruthy, 10), // 0001
+		code.Make(code.OpConstant, 0),       // 0004
+		code.Make(code.OpConstant, 1),       // 0007
+		code.Make(code.OpAdd),               // 0010
+		code.Make(code.OpPop),               // 0011
+		code.Make(code.OpJump, 15),          // 0012 - redundant
+		code.Make(code.OpNull),              // 0015
+	})
+
+	expected := []code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthy, 10),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpAdd),
+		code.Make(code.OpPop),
+		code.Make(code.OpNull),
+	}
+
+	optimized, positions := peephole(ins)
+	if err := testInstructions(expected, optimized); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	// the removed jump continues at the OpNull
+	if positions[12] != 12 || positions[15] != 12 || positions[len(ins)] != 13 {
+		t.Errorf("wrong positions: %v", positions)
+	}
+}
@@ -0,0 +1,404 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/object"
+)
+
+/*
+	AllocateRegisters translates the bytecode of the compiler into register code (see
+	code/register.go) for the register based vm.
+
+	Every function is translated by simulating its operand stack. Rather than values, the
+	simulated stack holds the RK operand each value can be read from. The value at stack depth d
+	has register numLocals+d as its home, so a frame needs numLocals plus the maximum stack depth
+	registers. Constants and locals are read where they are and don't cost an instruction;
+	operations write their result to the home of the slot they leave it in.
+
+	Values are moved to their homes (flushed) where the register code expects them in
+	consecutive registers (calls, closures, array and hash literals) and before jumps, so every
+	path into a jump target agrees on where the values live.
+
+	Values are also flushed at the start of every range covered by an exception handler, so the
+	values below the handler's depth are at home whenever an error is thrown in the range. The
+	handler's target is entered like a jump target, with the error in the home register of the
+	handler's depth.
+*/
+
+// AllocateRegisters returns the register code equivalent of bytecode. The compiled functions in
+// the constant pool are replaced by their register code counterparts.
+func AllocateRegisters(bytecode *Bytecode) (*Bytecode, error) {
+	constants := make([]object.Object, len(bytecode.Constants))
+	copy(constants, bytecode.Constants)
+
+	for i, constant := range constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		instructions, handlers, numRegisters, err := allocateRegisters(fn.Instructions, fn.Handlers, fn.NumLocals, false)
+		if err != nil {
+			return nil, err
+		}
+		constants[i] = &object.CompiledFunction{
+			Instructions:  instructions,
+			Name:          fn.Name,
+			NumLocals:     fn.NumLocals,
+			NumParameters: fn.NumParameters,
+			NumRegisters:  numRegisters,
+			Handlers:      handlers,
+		}
+	}
+
+	instructions, handlers, numRegisters, err := allocateRegisters(bytecode.Instructions, bytecode.Handlers, 0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bytecode{
+		Instructions: instructions,
+		Constants:    constants,
+		Handlers:     handlers,
+		NumRegisters: numRegisters,
+	}, nil
+}
+
+type registerAllocator struct {
+	numLocals int
+	main      bool // OpPop is only kept for the main program (the vm tracks the last popped value)
+
+	out      code.Instructions
+	stack    []int // RK operands of the values on the simulated stack
+	maxDepth int
+
+	depths    map[int]int  // stack depth at each (stack code) jump target
+	starts    map[int]bool // the starts of the ranges covered by exception handlers
+	positions map[int]int  // stack code position => register code position
+	jumps     []instruction
+}
+
+func allocateRegisters(ins code.Instructions, handlers []code.Handler, numLocals int, main bool) (code.Instructions, []code.Handler, int, error) {
+	a := &registerAllocator{
+		numLocals: numLocals,
+		main:      main,
+		out:       code.Instructions{},
+		depths:    map[int]int{},
+		starts:    map[int]bool{},
+		positions: map[int]int{},
+	}
+
+	for _, h := range handlers {
+		a.starts[h.Start] = true
+
+		// the target is entered with the error on top of the stack
+		if d, ok := a.depths[h.Target]; ok && d != h.Depth+1 {
+			return nil, nil, 0, fmt.Errorf("inconsistent stack depth at %d: %d and %d", h.Target, d, h.Depth+1)
+		}
+		a.depths[h.Target] = h.Depth + 1
+		if h.Depth+1 > a.maxDepth {
+			a.maxDepth = h.Depth + 1
+		}
+	}
+
+	reachable := true
+	for _, inst := range decode(ins) {
+		live, err := a.enter(inst.pos, reachable)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if !live { // nothing jumps to dead code, so it's dropped
+			continue
+		}
+
+		reachable, err = a.translate(inst)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if _, err := a.enter(len(ins), reachable); err != nil {
+		return nil, nil, 0, err
+	}
+	a.positions[len(ins)] = len(a.out)
+
+	// jumps are emitted before their targets are known
+	for _, jump := range a.jumps {
+		last := len(jump.operands) - 1
+		target, ok := a.positions[jump.operands[last]]
+		if !ok {
+			return nil, nil, 0, fmt.Errorf("jump to unknown position %d", jump.operands[last])
+		}
+
+		operands := append([]int{}, jump.operands...)
+		operands[last] = target
+		copy(a.out[jump.pos:], code.MakeRegister(jump.op, operands...))
+	}
+
+	numRegisters := a.numLocals + a.maxDepth
+	if numRegisters >= code.ConstantBit {
+		return nil, nil, 0, fmt.Errorf("too many registers: %d", numRegisters)
+	}
+
+	translated := make([]code.Handler, len(handlers))
+	for i, h := range handlers {
+		translated[i] = code.Handler{
+			Start:  a.position(h.Start, len(ins)),
+			End:    a.position(h.End, len(ins)),
+			Target: a.position(h.Target, len(ins)),
+			Depth:  h.Depth,
+		}
+	}
+
+	return a.out, translated, numRegisters, nil
+}
+
+// position returns the register code position of the stack code position pos. Dropped (dead)
+// code continues at the next position which was kept.
+func (a *registerAllocator) position(pos int, size int) int {
+	for ; pos < size; pos++ {
+		if p, ok := a.positions[pos]; ok {
+			return p
+		}
+	}
+	return a.positions[size]
+}
+
+// enter is called at every stack code position before it's translated and reports whether the
+// position is reachable
+func (a *registerAllocator) enter(pos int, reachable bool) (bool, error) {
+	depth, jumpedTo := a.depths[pos]
+
+	switch {
+	case reachable && jumpedTo:
+		a.flush(0)
+		if len(a.stack) != depth {
+			return false, fmt.Errorf("inconsistent stack depth at %d: %d and %d", pos, depth, len(a.stack))
+		}
+	case jumpedTo: // only reachable through jumps, all of which left their values at home
+		a.stack = a.stack[:0]
+		for i := 0; i < depth; i++ {
+			a.stack = append(a.stack, a.home(i))
+		}
+	case !reachable:
+		return false, nil
+	case a.starts[pos]:
+		a.flush(0)
+	}
+
+	a.positions[pos] = len(a.out)
+	return true, nil
+}
+
+// translate emits the register code for inst and reports whether the next instruction is
+// reachable from it
+func (a *registerAllocator) translate(inst instruction) (bool, error) {
+	switch inst.op {
+	case code.OpConstant:
+		a.push(a.constant(inst.operands[0], len(a.stack)))
+
+	case code.OpTrue, code.OpFalse, code.OpNull, code.OpCurrentClosure:
+		a.produce(inst.op, a.next())
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+		right := a.pop()
+		left := a.pop()
+		a.produce(inst.op, a.next(), left, right)
+
+	case code.OpMinus, code.OpBang:
+		operand := a.pop()
+		a.produce(inst.op, a.next(), operand)
+
+	case code.OpPop:
+		value := a.pop()
+		if a.main {
+			a.emit(code.OpPop, value)
+		}
+
+	case code.OpJump:
+		a.flush(0)
+		return false, a.jump(code.OpJump, inst)
+	case code.OpJumpNotTruthy:
+		condition := a.pop()
+		a.flush(0)
+		return true, a.jump(code.OpJumpNotTruthy, inst, condition)
+
+	case code.OpGetGlobal, code.OpGetBuiltin, code.OpGetFree:
+		a.produce(inst.op, a.next(), inst.operands[0])
+	case code.OpSetGlobal:
+		a.emit(code.OpSetGlobal, inst.operands[0], a.pop())
+
+	case code.OpGetLocal:
+		a.push(inst.operands[0])
+	case code.OpSetLocal:
+		local := inst.operands[0]
+		value := a.pop()
+
+		// values still waiting on the stack must not see the new value
+		for i, operand := range a.stack {
+			if operand == local {
+				a.materialize(i)
+			}
+		}
+		if value != local {
+			a.emit(code.OpMove, local, value)
+		}
+
+	case code.OpArray, code.OpHash:
+		start := a.consecutive(inst.operands[0])
+		a.produce(inst.op, start, inst.operands[0])
+	case code.OpIndex:
+		index := a.pop()
+		left := a.pop()
+		a.produce(code.OpIndex, a.next(), left, index)
+	case code.OpSetIndex:
+		value := a.pop()
+		index := a.pop()
+		left := a.pop()
+		a.produce(code.OpSetIndex, a.next(), left, index, value)
+	case code.OpSlice:
+		end := a.pop()
+		start := a.pop()
+		left := a.pop()
+		a.produce(code.OpSlice, a.next(), left, start, end)
+
+	case code.OpCall, code.OpTailCall:
+		base := a.consecutive(inst.operands[0] + 1) // the callee and its arguments
+		a.produce(inst.op, base, inst.operands[0])
+	case code.OpClosure:
+		start := a.consecutive(inst.operands[1]) // the free variables
+		a.produce(code.OpClosure, start, inst.operands[0], inst.operands[1])
+
+	case code.OpReturnValue:
+		a.emit(code.OpReturnValue, a.pop())
+		return false, nil
+	case code.OpReturn:
+		a.emit(code.OpReturn)
+		return false, nil
+	case code.OpThrow:
+		a.emit(code.OpThrow, a.pop())
+		return false, nil
+
+	// superinstructions, when translating optimized bytecode
+	case code.OpAddLocals:
+		a.produce(code.OpAdd, a.next(), inst.operands[0], inst.operands[1])
+	case code.OpAddConst, code.OpSubConst:
+		op := code.OpAdd
+		if inst.op == code.OpSubConst {
+			op = code.OpSub
+		}
+
+		left := a.pop()
+		right := a.constant(inst.operands[0], len(a.stack)+1)
+		a.produce(op, a.next(), left, right)
+	case code.OpJumpIfNotGreater, code.OpJumpIfNotEqual:
+		right := a.pop()
+		left := a.pop()
+		a.flush(0)
+		return true, a.jump(inst.op, inst, left, right)
+
+	default:
+		def, err := code.Lookup(byte(inst.op))
+		if err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("no register instruction for %s", def.Name)
+	}
+
+	return true, nil
+}
+
+// jump emits the jump of inst with the given leading operands. Its target is patched in once
+// all positions are known.
+func (a *registerAllocator) jump(op code.Opcode, inst instruction, operands ...int) error {
+	target := inst.operands[0]
+	if target <= inst.pos {
+		return fmt.Errorf("backward jump at %d", inst.pos)
+	}
+
+	if depth, ok := a.depths[target]; ok && depth != len(a.stack) {
+		return fmt.Errorf("inconsistent stack depth at %d: %d and %d", target, depth, len(a.stack))
+	}
+	a.depths[target] = len(a.stack)
+
+	operands = append(operands, target)
+	pos := a.emit(op, operands...)
+	a.jumps = append(a.jumps, instruction{op: op, operands: operands, pos: pos})
+	return nil
+}
+
+// home returns the register of stack depth d
+func (a *registerAllocator) home(d int) int {
+	return a.numLocals + d
+}
+
+// next returns the register for the value about to be pushed
+func (a *registerAllocator) next() int {
+	return a.home(len(a.stack))
+}
+
+func (a *registerAllocator) push(operand int) {
+	a.stack = append(a.stack, operand)
+	if len(a.stack) > a.maxDepth {
+		a.maxDepth = len(a.stack)
+	}
+}
+
+func (a *registerAllocator) pop() int {
+	operand := a.stack[len(a.stack)-1]
+	a.stack = a.stack[:len(a.stack)-1]
+	return operand
+}
+
+// constant returns the RK operand of constant index. Indexes too big for an RK operand are
+// loaded into the home register of depth d.
+func (a *registerAllocator) constant(index int, d int) int {
+	if index < code.ConstantBit {
+		return index | code.ConstantBit
+	}
+
+	if d+1 > a.maxDepth {
+		a.maxDepth = d + 1
+	}
+	a.emit(code.OpConstant, a.home(d), index)
+	return a.home(d)
+}
+
+// materialize moves the value at stack depth i to its home register
+func (a *registerAllocator) materialize(i int) {
+	if a.stack[i] != a.home(i) {
+		a.emit(code.OpMove, a.home(i), a.stack[i])
+		a.stack[i] = a.home(i)
+	}
+}
+
+// flush moves the values from stack depth i up to their home registers
+func (a *registerAllocator) flush(i int) {
+	for ; i < len(a.stack); i++ {
+		a.materialize(i)
+	}
+}
+
+// consecutive pops the top n values after moving them to their (consecutive) home registers
+// and returns the first of those registers
+func (a *registerAllocator) consecutive(n int) int {
+	start := len(a.stack) - n
+	a.flush(start)
+	a.stack = a.stack[:start]
+	return a.home(start)
+}
+
+// emit appends the instruction and returns its position
+func (a *registerAllocator) emit(op code.Opcode, operands ...int) int {
+	pos := len(a.out)
+	a.out = append(a.out, code.MakeRegister(op, operands...)...)
+	return pos
+}
+
+// produce emits an instruction writing its result to dst and pushes dst
+func (a *registerAllocator) produce(op code.Opcode, dst int, operands ...int) {
+	a.emit(op, append([]int{dst}, operands...)...)
+	a.push(dst)
+}
@@ -0,0 +1,112 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/object"
+)
+
+func TestAllocateRegisters(t *testing.T) {
+	k := func(index int) int { return index | code.ConstantBit }
+
+	tests := []struct {
+		input                string
+		optimize             bool
+		expectedFunction     []code.Instructions // register code of the first function constant
+		expectedRegisters    int                 // of the function
+		expectedInstructions []code.Instructions
+	}{
+		{
+			input: "let f = fn(a, b) { let c = a + b; c * 2 }; f(1, 2)",
+			expectedFunction: []code.Instructions{
+				code.MakeRegister(code.OpAdd, 3, 0, 1),
+				code.MakeRegister(code.OpMove, 2, 3),
+				code.MakeRegister(code.OpMul, 3, 2, k(0)),
+				code.MakeRegister(code.OpReturnValue, 3),
+			},
+			expectedRegisters: 5,
+			expectedInstructions: []code.Instructions{
+				code.MakeRegister(code.OpClosure, 0, 1, 0),
+				code.MakeRegister(code.OpSetGlobal, 0, 0),
+				code.MakeRegister(code.OpGetGlobal, 0, 0),
+				code.MakeRegister(code.OpMove, 1, k(2)),
+				code.MakeRegister(code.OpMove, 2, k(0)),
+				code.MakeRegister(code.OpCall, 0, 2),
+				code.MakeRegister(code.OpPop, 0),
+			},
+		},
+		{
+			// both branches leave their value in the same register
+			input: "let f = fn(x) { if (x > 1) { x } else { 0 } }",
+			expectedFunction: []code.Instructions{
+				code.MakeRegister(code.OpGreaterThan, 1, 0, k(0)),
+				code.MakeRegister(code.OpJumpNotTruthy, 1, 20),
+				code.MakeRegister(code.OpMove, 1, 0),
+				code.MakeRegister(code.OpJump, 25),
+				code.MakeRegister(code.OpMove, 1, k(1)),
+				code.MakeRegister(code.OpReturnValue, 1),
+			},
+			expectedRegisters: 3,
+			expectedInstructions: []code.Instructions{
+				code.MakeRegister(code.OpClosure, 0, 2, 0),
+				code.MakeRegister(code.OpSetGlobal, 0, 0),
+			},
+		},
+		{
+			input:    "let f = fn(x) { if (x > 1) { x } else { 0 } }",
+			optimize: true,
+			expectedFunction: []code.Instructions{
+				code.MakeRegister(code.OpJumpIfNotGreater, 0, k(0), 10),
+				code.MakeRegister(code.OpReturnValue, 0),
+				code.MakeRegister(code.OpReturnValue, k(1)),
+			},
+			expectedRegisters: 3,
+			expectedInstructions: []code.Instructions{
+				code.MakeRegister(code.OpClosure, 0, 2, 0),
+				code.MakeRegister(code.OpSetGlobal, 0, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if tt.optimize {
+			compiler.EnableOptimizations()
+		}
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode, err := AllocateRegisters(compiler.Bytecode())
+		if err != nil {
+			t.Fatalf("register allocation error: %s", err)
+		}
+
+		var fn *object.CompiledFunction
+		for _, constant := range bytecode.Constants {
+			if f, ok := constant.(*object.CompiledFunction); ok {
+				fn = f
+				break
+			}
+		}
+		if fn == nil {
+			t.Fatalf("no function constant for %q", tt.input)
+		}
+
+		expected := concatInstructions(tt.expectedFunction)
+		if fn.Instructions.RegisterString() != expected.RegisterString() {
+			t.Errorf("wrong function instructions for %q.\nwant=\n%s\ngot=\n%s",
+				tt.input, expected.RegisterString(), fn.Instructions.RegisterString())
+		}
+		if fn.NumRegisters != tt.expectedRegisters {
+			t.Errorf("wrong number of registers. want=%d, got=%d", tt.expectedRegisters, fn.NumRegisters)
+		}
+
+		expected = concatInstructions(tt.expectedInstructions)
+		if bytecode.Instructions.RegisterString() != expected.RegisterString() {
+			t.Errorf("wrong instructions for %q.\nwant=\n%s\ngot=\n%s",
+				tt.input, expected.RegisterString(), bytecode.Instructions.RegisterString())
+		}
+	}
+}
@@ -37,7 +37,13 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
+// Define defines name in the table. Redefining a name (e.g. when the same block is compiled more
+// than once, see the finally blocks of try expressions) reuses its slot.
 func (s *SymbolTable) Define(name string) Symbol {
+	if existing, ok := s.store[name]; ok && (existing.Scope == GlobalScope || existing.Scope == LocalScope) {
+		return existing
+	}
+
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil { // if the outer scope is nil, this is the outer most symbol table - global
 		symbol.Scope = GlobalScope
@@ -50,6 +56,17 @@ func (s *SymbolTable) Define(name string) Symbol {
 	return symbol
 }
 
+// Names returns the names of the globals or locals defined in the table, by index
+func (s *SymbolTable) Names() []string {
+	names := make([]string, s.numDefinitions)
+	for name, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	s.store[name] = symbol
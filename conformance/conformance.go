@@ -0,0 +1,144 @@
+// Package conformance runs monkey programs on every engine (the evaluator and the vms, with and
+// without the optimization passes) so their behavior can be compared.
+//
+// Engines agree on a program when they agree on its Result: what the program prints, followed
+// by the value of the last expression or the kind of the error escaping the program. Error
+// messages are worded by each engine and are left out; programs which care about a message can
+// inspect it (e["message"]). Programs run without input and without files.
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/evaluator"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/vm"
+)
+
+// Result is the outcome of running a program, rendered so that it can be compared across
+// engines and stored in a .out file
+type Result string
+
+// Engine runs a program. Engines may modify the program (the optimization passes fold it in
+// place), so each engine needs a program of its own.
+type Engine struct {
+	Name string
+	Run  func(program *ast.Program) Result
+}
+
+// Engines returns every engine
+func Engines() []Engine {
+	return []Engine{
+		{"evaluator", evaluate},
+		{"vm", func(program *ast.Program) Result { return execute(program, false, false) }},
+		{"vm optimized", func(program *ast.Program) Result { return execute(program, true, false) }},
+		{"register vm", func(program *ast.Program) Result { return execute(program, false, true) }},
+		{"register vm optimized", func(program *ast.Program) Result { return execute(program, true, true) }},
+	}
+}
+
+// Parse parses input, failing on any parser error
+func Parse(input string) (*ast.Program, error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+
+	return program, nil
+}
+
+func evaluate(program *ast.Program) Result {
+	var out bytes.Buffer
+	env := object.NewEnvironment()
+	env.SetIO(object.NewIO(nil, &out, &out))
+
+	result, err := evaluator.Run(program, env)
+	if err != nil {
+		return printed(&out, uncaught(err))
+	}
+
+	return printed(&out, Render(result))
+}
+
+func execute(program *ast.Program, optimize, registers bool) Result {
+	var out bytes.Buffer
+	result := executeWith(program, optimize, registers, object.NewIO(nil, &out, &out))
+	return printed(&out, result)
+}
+
+func executeWith(program *ast.Program, optimize, registers bool, streams *object.IO) Result {
+	comp := compiler.New()
+	if optimize {
+		comp.EnableOptimizations()
+	}
+	err := comp.Compile(program)
+	if err != nil {
+		return Result("compile error: " + err.Error())
+	}
+
+	bytecode := comp.Bytecode()
+
+	var machine vm.Machine = vm.New(bytecode)
+	if registers {
+		bytecode, err = compiler.AllocateRegisters(bytecode)
+		if err != nil {
+			return Result("register allocation error: " + err.Error())
+		}
+		machine = vm.NewRegister(bytecode)
+	}
+	machine.SetIO(streams)
+
+	err = machine.Run()
+	if err != nil {
+		return uncaught(err)
+	}
+
+	return Render(machine.LastPoppedStackElem())
+}
+
+// printed puts the output of a program before its result
+func printed(out *bytes.Buffer, result Result) Result {
+	return Result(out.String()) + result
+}
+
+func uncaught(err error) Result {
+	if e, ok := err.(*object.Error); ok {
+		return Result("uncaught " + e.Kind)
+	}
+
+	return Result("uncaught " + err.Error())
+}
+
+// Render renders obj the same way for every engine. Functions only render as <function>
+// (the engines represent them differently) and errors as their kind.
+func Render(obj object.Object) Result {
+	switch obj := obj.(type) {
+	case nil:
+		return "null"
+	case *object.Array:
+		elements := []string{}
+		for _, el := range obj.Elements {
+			elements = append(elements, string(Render(el)))
+		}
+		return Result("[" + strings.Join(elements, ", ") + "]")
+	case *object.Hash:
+		pairs := []string{}
+		for _, pair := range obj.Pairs() {
+			pairs = append(pairs, fmt.Sprintf("%s: %s", Render(pair.Key), Render(pair.Value)))
+		}
+		return Result("{" + strings.Join(pairs, ", ") + "}")
+	case *object.Error:
+		return Result("error(" + obj.Kind + ")")
+	case *object.Function, *object.CompiledFunction, *object.Closure, *object.Builtin:
+		return "<function>"
+	default:
+		return Result(obj.Inspect())
+	}
+}
@@ -0,0 +1,49 @@
+package conformance_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/conformance"
+)
+
+// TestCorpus runs every program in testdata on every engine. The expected result of name.mk
+// is stored in name.out.
+func TestCorpus(t *testing.T) {
+	programs, err := filepath.Glob(filepath.Join("testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(programs) == 0 {
+		t.Fatal("no programs in testdata")
+	}
+
+	for _, path := range programs {
+		name := strings.TrimSuffix(filepath.Base(path), ".mk")
+
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, err := os.ReadFile(strings.TrimSuffix(path, ".mk") + ".out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := conformance.Result(strings.TrimSpace(string(output)))
+
+			for _, engine := range conformance.Engines() {
+				program, err := conformance.Parse(string(input))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if result := engine.Run(program); result != expected {
+					t.Errorf("%s: wrong result.\nwant=%s\ngot =%s", engine.Name, expected, result)
+				}
+			}
+		})
+	}
+}
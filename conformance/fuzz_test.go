@@ -0,0 +1,49 @@
+package conformance_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/andy9775/monkey/conformance"
+)
+
+// FuzzEngines runs generated programs on every engine and reports programs the engines
+// disagree on
+func FuzzEngines(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{3, 3, 1, 0, 7})
+	f.Add([]byte("let f = fn(x) { try { x() } catch (e) { e } };"))
+	f.Add([]byte{1, 1, 8, 2, 1, 4, 3, 9, 3, 0, 11, 2, 9, 1, 3, 4, 0, 1, 9, 9})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		testEngines(t, data)
+	})
+}
+
+// TestGeneratedPrograms runs a fixed set of generated programs, so that the engines are compared
+// without running the fuzzer
+func TestGeneratedPrograms(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		data := make([]byte, random.Intn(512))
+		random.Read(data)
+
+		testEngines(t, data)
+	}
+}
+
+func testEngines(t *testing.T, data []byte) {
+	t.Helper()
+
+	engines := conformance.Engines()
+	expected := engines[0].Run(conformance.Generate(data))
+
+	for _, engine := range engines[1:] {
+		if result := engine.Run(conformance.Generate(data)); result != expected {
+			t.Fatalf("%s disagrees with %s on\n%s\n%s: %s\n%s: %s",
+				engine.Name, engines[0].Name, conformance.Generate(data).String(),
+				engines[0].Name, expected, engine.Name, result)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package conformance
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/token"
+)
+
+/*
+	Generate builds a random program out of data, each byte picking the next production. Once
+	data runs out every choice is the first alternative, which always ends the program quickly.
+
+	Generated programs are valid for every engine and always terminate:
+
+	- names are only used after their definition (the compiler rejects anything else)
+	- only names bound to a function literal are called (besides builtins and literals which
+	  aren't functions), and a function can only call names defined before it, so calls can't
+	  recurse, except in the functions built to recurse (see recursion), which count down from
+	  at most 15
+	- a let can bind a name of its block again, one which isn't called, so the functions
+	  defined in between still see the first value in a call but the second at the top level
+	- returns can be anywhere, the top level of the program included
+*/
+
+const (
+	maxDepth      = 4 // of nested expressions
+	maxStatements = 4 // per block
+	maxElements   = 3 // of array and hash literals, and call arguments
+)
+
+// builtins which can be called by generated programs. puts, print and eprint are left out, the
+// engines print functions and errors differently.
+var builtins = []string{
+	"len", "first", "last", "rest", "push", "error", "append!", "pop!", "insert", "remove", "freeze",
+	"map", "filter", "reduce", "sort", "range", "zip", "any", "all", "find", "flatten", "unique",
+	"split", "join", "trim", "upper", "lower", "contains", "starts_with", "ends_with", "replace",
+	"index_of", "substr", "repeat", "chars", "format", "json_parse", "json_stringify",
+	"read_line", "read_all", "read_file", "write_file", "list_dir", "exists", "remove_file",
+	"check_type", "assert", "assert_eq", "assert_throws",
+}
+
+var words = []string{"", "a", "monkey"}
+
+var infixOperators = []string{"+", "-", "*", "/", "<", ">", "<=", ">=", "==", "!="}
+
+// Generate returns the program described by data. It builds a new program on every call, so
+// calling it again with the same data gives every engine a program of its own.
+func Generate(data []byte) *ast.Program {
+	g := &generator{data: data, scope: &scope{}}
+
+	program := &ast.Program{}
+	for n := g.choose(maxStatements); n > 0; n-- {
+		program.Statements = append(program.Statements, g.statement(0))
+	}
+	// the program ends in an expression, which is its result
+	program.Statements = append(program.Statements, g.expressionStatement(0))
+
+	return program
+}
+
+type generator struct {
+	data       []byte
+	scope      *scope
+	names      int // the number of names defined so far
+	blockStart int // where the names of the current block start in scope.names
+
+	recursing bool // in the body of a recursive function, where recursion would take too long
+}
+
+// scope holds the names visible in a function (or the program)
+type scope struct {
+	outer    *scope
+	names    []string
+	callable []string // the names bound to function literals
+}
+
+func (s *scope) all() (names, callable []string) {
+	for ; s != nil; s = s.outer {
+		names = append(names, s.names...)
+		callable = append(callable, s.callable...)
+	}
+	return names, callable
+}
+
+// choose returns a number in [0, n)
+func (g *generator) choose(n int) int {
+	if len(g.data) == 0 {
+		return 0
+	}
+
+	b := g.data[0]
+	g.data = g.data[1:]
+	return int(b) % n
+}
+
+func (g *generator) statement(depth int) ast.Statement {
+	switch g.choose(4) {
+	case 1:
+		return g.letStatement(depth)
+	case 2:
+		return &ast.ReturnStatement{Token: tok(token.RETURN, "return"), ReturnValue: g.expression(depth + 1)}
+	case 3:
+		return &ast.ThrowStatement{Token: tok(token.THROW, "throw"), Value: g.expression(depth + 1)}
+	default:
+		return g.expressionStatement(depth)
+	}
+}
+
+func (g *generator) letStatement(depth int) ast.Statement {
+	if rebound := g.rebindable(); len(rebound) > 0 && g.choose(4) == 0 {
+		// the name stays uncallable, whatever its value, so functions calling it can't recurse
+		name := rebound[g.choose(len(rebound))]
+		value := g.expression(depth + 1)
+		if fn, ok := value.(*ast.FunctionLiteral); ok {
+			fn.Name = name
+		}
+		return &ast.LetStatement{Token: tok(token.LET, "let"), Name: &ast.Identifier{Token: tok(token.IDENT, name), Value: name}, Value: value}
+	}
+
+	name := g.newName()
+
+	// the name isn't visible in its own value
+	value := g.expression(depth + 1)
+
+	g.scope.names = append(g.scope.names, name.Value)
+	if fn, ok := value.(*ast.FunctionLiteral); ok {
+		fn.Name = name.Value
+		g.scope.callable = append(g.scope.callable, name.Value)
+	}
+
+	return &ast.LetStatement{Token: tok(token.LET, "let"), Name: name, Value: value}
+}
+
+// rebindable returns the names of the current block which can be bound again: those which
+// aren't callable. Names of outer blocks aren't, the block may not run.
+func (g *generator) rebindable() []string {
+	callable := map[string]bool{}
+	for _, name := range g.scope.callable {
+		callable[name] = true
+	}
+	names := []string{}
+	for _, name := range g.scope.names[g.blockStart:] {
+		if !callable[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (g *generator) expressionStatement(depth int) ast.Statement {
+	return &ast.ExpressionStatement{Expression: g.expression(depth + 1)}
+}
+
+// block returns a block whose statements are local to it: names defined in the block aren't
+// visible after it
+func (g *generator) block(depth int) *ast.BlockStatement {
+	names, callable, outer := len(g.scope.names), len(g.scope.callable), g.blockStart
+	g.blockStart = names
+
+	block := &ast.BlockStatement{Token: tok(token.LBRACE, "{")}
+	for n := g.choose(maxStatements); n > 0; n-- {
+		block.Statements = append(block.Statements, g.statement(depth))
+	}
+	// most blocks end in an expression, which is their value
+	if g.choose(4) != 0 {
+		block.Statements = append(block.Statements, g.expressionStatement(depth))
+	}
+
+	g.scope.names, g.scope.callable, g.blockStart = g.scope.names[:names], g.scope.callable[:callable], outer
+	return block
+}
+
+func (g *generator) expression(depth int) ast.Expression {
+	if depth >= maxDepth {
+		return g.literal()
+	}
+
+	switch g.choose(16) {
+	case 1:
+		return g.identifier()
+	case 2:
+		operator := "!"
+		if g.choose(2) == 1 {
+			operator = "-"
+		}
+		return &ast.PrefixExpression{Token: tok(token.TokenType(operator), operator), Operator: operator, Right: g.expression(depth + 1)}
+	case 3:
+		return g.infix(depth)
+	case 4:
+		exp := &ast.IfExpression{Token: tok(token.IF, "if"), Condition: g.expression(depth + 1)}
+		exp.Consequence = g.block(depth + 1)
+		if g.choose(2) == 1 {
+			exp.Alternative = g.block(depth + 1)
+		}
+		return exp
+	case 5:
+		return &ast.ArrayLiteral{Token: tok(token.LBRACKET, "["), Elements: g.expressions(depth)}
+	case 6:
+		exp := &ast.HashLiteral{Token: tok(token.LBRACE, "{"), Pairs: map[ast.Expression]ast.Expression{}}
+		for n := g.choose(maxElements); n > 0; n-- {
+			key := g.expression(depth + 1)
+			exp.Keys = append(exp.Keys, key)
+			exp.Pairs[key] = g.expression(depth + 1)
+		}
+		return exp
+	case 7:
+		return &ast.IndexExpression{Token: tok(token.LBRACKET, "["), Left: g.expression(depth + 1), Index: g.expression(depth + 1)}
+	case 8:
+		return g.function(depth)
+	case 9, 10:
+		return g.call(depth)
+	case 11:
+		return g.try(depth)
+	case 12:
+		return g.identifier()
+	case 13:
+		target := &ast.IndexExpression{Token: tok(token.LBRACKET, "["), Left: g.expression(depth + 1), Index: g.expression(depth + 1)}
+		return &ast.AssignExpression{Token: tok(token.ASSIGN, "="), Target: target, Value: g.expression(depth + 1)}
+	case 14:
+		exp := &ast.SliceExpression{Token: tok(token.LBRACKET, "["), Left: g.expression(depth + 1)}
+		if g.choose(2) == 1 {
+			exp.Start = g.expression(depth + 1)
+		}
+		if g.choose(2) == 1 {
+			exp.End = g.expression(depth + 1)
+		}
+		return exp
+	case 15:
+		if g.recursing {
+			return g.literal()
+		}
+		return g.recursion(depth)
+	default:
+		return g.literal()
+	}
+}
+
+func (g *generator) expressions(depth int) []ast.Expression {
+	expressions := []ast.Expression{}
+	for n := g.choose(maxElements + 1); n > 0; n-- {
+		expressions = append(expressions, g.expression(depth+1))
+	}
+	return expressions
+}
+
+func (g *generator) literal() ast.Expression {
+	switch g.choose(4) {
+	case 1:
+		if g.choose(2) == 1 {
+			return &ast.Boolean{Token: tok(token.TRUE, "true"), Value: true}
+		}
+		return &ast.Boolean{Token: tok(token.FALSE, "false"), Value: false}
+	case 2:
+		value := words[g.choose(len(words))]
+		return &ast.StringLiteral{Token: tok(token.STRING, value), Value: value}
+	case 3:
+		return g.integer(256)
+	default:
+		return g.integer(4)
+	}
+}
+
+// integer returns a literal in [0, max), max is at most 256
+func (g *generator) integer(max int) *ast.IntegerLiteral {
+	value := int64(g.choose(max))
+	return &ast.IntegerLiteral{Token: tok(token.INT, strconv.FormatInt(value, 10)), Value: value}
+}
+
+func (g *generator) identifier() ast.Expression {
+	names, _ := g.scope.all()
+	if len(names) == 0 {
+		return g.literal()
+	}
+
+	name := names[g.choose(len(names))]
+	return &ast.Identifier{Token: tok(token.IDENT, name), Value: name}
+}
+
+func (g *generator) newName() *ast.Identifier {
+	name := fmt.Sprintf("n%d", g.names)
+	g.names++
+	return &ast.Identifier{Token: tok(token.IDENT, name), Value: name}
+}
+
+func (g *generator) infix(depth int) ast.Expression {
+	operator := infixOperators[g.choose(len(infixOperators))]
+	exp := &ast.InfixExpression{Token: tok(token.TokenType(operator), operator), Operator: operator}
+
+	exp.Left = g.expression(depth + 1)
+	exp.Right = g.expression(depth + 1)
+
+	return exp
+}
+
+func (g *generator) function(depth int) *ast.FunctionLiteral {
+	fn := &ast.FunctionLiteral{Token: tok(token.FUNCTION, "fn")}
+
+	g.scope = &scope{outer: g.scope}
+	for n := g.choose(maxElements); n > 0; n-- {
+		param := g.newName()
+		fn.Parameters = append(fn.Parameters, param)
+		g.scope.names = append(g.scope.names, param.Value)
+	}
+	fn.Body = g.block(depth + 1)
+	g.scope = g.scope.outer
+
+	return fn
+}
+
+func (g *generator) call(depth int) ast.Expression {
+	exp := &ast.CallExpression{Token: tok(token.LPAREN, "(")}
+
+	_, callable := g.scope.all()
+	switch g.choose(4) {
+	case 1:
+		name := builtins[g.choose(len(builtins))]
+		exp.Function = &ast.Identifier{Token: tok(token.IDENT, name), Value: name}
+	case 2:
+		exp.Function = g.function(depth)
+	case 3:
+		if len(callable) > 0 {
+			name := callable[g.choose(len(callable))]
+			exp.Function = &ast.Identifier{Token: tok(token.IDENT, name), Value: name}
+			break
+		}
+		fallthrough
+	default:
+		exp.Function = g.literal() // not a function
+	}
+	exp.Arguments = g.expressions(depth)
+
+	return exp
+}
+
+// recursion returns the call of a function which defines a function calling itself and calls it,
+// with a count of at most 15 it calls itself with one less until it gets to 0:
+//
+//	fn() { let f = fn(n) { if (n < 1) { ... } else { ...; ... f(n - 1) } }; f(k) }()
+//
+// The recursive call is either a tail call or the right operand of an operator. Nothing else can
+// call the function, it's only visible to its body, and there only as the callee. The body
+// doesn't recurse in turn.
+func (g *generator) recursion(depth int) ast.Expression {
+	name, param := g.newName(), g.newName()
+	ident := func(id *ast.Identifier) *ast.Identifier {
+		return &ast.Identifier{Token: id.Token, Value: id.Value}
+	}
+	integer := func(value int64) *ast.IntegerLiteral {
+		return &ast.IntegerLiteral{Token: tok(token.INT, strconv.FormatInt(value, 10)), Value: value}
+	}
+
+	g.scope = &scope{outer: g.scope, names: []string{param.Value}}
+	g.recursing = true
+	base, recurse := g.block(depth+1), g.block(depth+1)
+	var self ast.Expression = &ast.CallExpression{
+		Token:     tok(token.LPAREN, "("),
+		Function:  ident(name),
+		Arguments: []ast.Expression{&ast.InfixExpression{Token: tok(token.MINUS, "-"), Operator: "-", Left: ident(param), Right: integer(1)}},
+	}
+	if g.choose(2) == 1 {
+		operator := infixOperators[g.choose(len(infixOperators))]
+		self = &ast.InfixExpression{Token: tok(token.TokenType(operator), operator), Operator: operator, Left: g.expression(depth + 1), Right: self}
+	}
+	recurse.Statements = append(recurse.Statements, &ast.ExpressionStatement{Expression: self})
+	g.scope = g.scope.outer
+	g.recursing = false
+
+	fn := &ast.FunctionLiteral{Token: tok(token.FUNCTION, "fn"), Name: name.Value, Parameters: []*ast.Identifier{param}}
+	fn.Body = &ast.BlockStatement{Token: tok(token.LBRACE, "{"), Statements: []ast.Statement{
+		&ast.ExpressionStatement{Expression: &ast.IfExpression{
+			Token:       tok(token.IF, "if"),
+			Condition:   &ast.InfixExpression{Token: tok(token.LT, "<"), Operator: "<", Left: ident(param), Right: integer(1)},
+			Consequence: base,
+			Alternative: recurse,
+		}},
+	}}
+
+	wrapper := &ast.FunctionLiteral{Token: tok(token.FUNCTION, "fn")}
+	wrapper.Body = &ast.BlockStatement{Token: tok(token.LBRACE, "{"), Statements: []ast.Statement{
+		&ast.LetStatement{Token: tok(token.LET, "let"), Name: name, Value: fn},
+		&ast.ExpressionStatement{Expression: &ast.CallExpression{
+			Token:     tok(token.LPAREN, "("),
+			Function:  ident(name),
+			Arguments: []ast.Expression{g.integer(16)},
+		}},
+	}}
+	return &ast.CallExpression{Token: tok(token.LPAREN, "("), Function: wrapper}
+}
+
+func (g *generator) try(depth int) ast.Expression {
+	exp := &ast.TryExpression{Token: tok(token.TRY, "try"), Block: g.block(depth + 1)}
+
+	switch g.choose(3) {
+	case 1:
+		exp.Finally = g.block(depth + 1)
+	case 2:
+		exp.Catch = g.catch(exp, depth)
+		exp.Finally = g.block(depth + 1)
+	default:
+		exp.Catch = g.catch(exp, depth)
+	}
+
+	return exp
+}
+
+func (g *generator) catch(exp *ast.TryExpression, depth int) *ast.BlockStatement {
+	if g.choose(4) == 0 {
+		return g.block(depth + 1)
+	}
+
+	// the error is bound in the catch block only
+	exp.CatchName = g.newName()
+	g.scope.names = append(g.scope.names, exp.CatchName.Value)
+	block := g.block(depth + 1)
+	g.scope.names = g.scope.names[:len(g.scope.names)-1]
+
+	return block
+}
+
+func tok(typ token.TokenType, literal string) token.Token {
+	return token.Token{Type: typ, Literal: literal}
+}
@@ -0,0 +1,3 @@
+let a = 10;
+let b = 3;
+[a + b, a - b, a * b, a / b, -a, (a + b) * 2 - b]
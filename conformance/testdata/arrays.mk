@@ -0,0 +1,2 @@
+let a = [1, 2 * 2, "three", [4]];
+[a[0], a[1], a[3][0], a[4], a[-1], len(a), first(a), last(a), rest(a), push(a, 5), rest([])]
@@ -0,0 +1,10 @@
+let failure = fn(f) { try { f(); "passed" } catch (e) { [e["kind"], e["message"]] } };
+[
+  assert(true), assert(1), assert_eq([1, {"a": [2]}], [1, {"a": [2]}]), assert_eq(error("x"), error("x")),
+  failure(fn() { assert(false) }), failure(fn() { assert(if (false) { 1 }, "custom") }),
+  failure(fn() { assert_eq(1, 2) }), failure(fn() { assert_eq("1", 1, "types") }),
+  failure(fn() { assert_eq({"a": 1}, {"a": 1, "b": 2}) }), failure(fn() { assert_eq([1, 2], [1, 2, 3]) }),
+  assert_throws(fn() { 1 + true })["kind"], assert_throws(fn() { throw "x" }, "Error")["message"],
+  failure(fn() { assert_throws(fn() { 1 }) }), failure(fn() { assert_throws(fn() { [][0] = 1 }, "TypeError") }),
+  failure(fn() { assert(true, 1) }), failure(fn() { assert_eq(1) })
+]
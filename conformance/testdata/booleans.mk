@@ -0,0 +1 @@
+[!true, !false, !!5, !0, true == true, true != false, (1 < 2) == true]
@@ -0,0 +1 @@
+len("a", "b")
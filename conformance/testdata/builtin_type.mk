@@ -0,0 +1 @@
+len(1)
@@ -0,0 +1,2 @@
+let safe = fn(f) { try { f() } catch (e) { [e["kind"], e["message"]] } };
+[safe(fn() { 1 }), safe(fn() { throw "bad" }), safe(fn() { throw error("x", "Custom") }), safe(fn() { len(1, 2) })[0], safe(fn() { fn(a) {}() })[0]]
@@ -0,0 +1,6 @@
+let counter = fn(start) {
+  let add = fn(n) { fn(m) { start + n + m } };
+  add(10)
+};
+let c = counter(1);
+[c(1), c(2), counter(100)(0)]
@@ -0,0 +1,20 @@
+let double = fn(x) { x * 2 };
+let odd = fn(x) { x - x / 2 * 2 == 1 };
+let byLength = fn(a, b) { len(a) - len(b) };
+[
+  map([1, 2, 3], double),
+  filter(range(10), odd),
+  reduce(range(1, 5), 0, fn(acc, x) { acc + x }),
+  sort([3, 1, 2]),
+  sort(["bb", "a", "ccc", "dd"], byLength),
+  range(5, 0, -2),
+  zip([1, 2, 3], ["a", "b"]),
+  any([1, 2], odd),
+  all([1, 2], odd),
+  find([2, 4, 5, 7], odd),
+  find([], odd),
+  flatten([1, [2, 3], [[4]]]),
+  unique([1, 2, 1, "a", "a", [1], [1]]),
+  map([[1, 2], [3]], len),
+  map(range(3), fn(x) { map(range(x), fn(y) { y + x }) })
+]
@@ -0,0 +1,14 @@
+let kinds = fn(f) { try { f(); "no error" } catch (e) { e["kind"] + ": " + e["message"] } };
+let boom = fn(x) { if (x == 2) { throw error("boom", "Boom") } x };
+[
+  kinds(fn() { map([1, 2, 3], boom) }),
+  kinds(fn() { map([1], fn(a, b) { a }) }),
+  kinds(fn() { map([1], 1) }),
+  kinds(fn() { sort([1, "a"]) }),
+  kinds(fn() { sort([2, 1], fn(a, b) { true }) }),
+  kinds(fn() { range(1, 2, 0) }),
+  kinds(fn() { range(0, 100000000000) }),
+  try { map([1, 2, 3], boom) } catch (e) { e["trace"] },
+  try { map([1], fn(x) { try { throw x } catch (e) { e["message"] + "!" } }) } catch (e) { 0 },
+  reduce([1, 2, 3], [], fn(acc, x) { append!(acc, x * x) })
+]
@@ -0,0 +1,2 @@
+let kindOf = fn(f) { try { f() } catch (e) { e["kind"] } };
+[kindOf(fn() { "a" < 1 }), kindOf(fn() { 1 <= "b" }), kindOf(fn() { true >= false }), "a" == "a", "a" != "a", "a" == "b", 1 == true]
@@ -0,0 +1,3 @@
+let a = 1;
+let b = 2;
+[a < b, a > b, a <= b, a >= b, a <= a, b >= b, a == b, a != b, 2 <= 1, 1 >= 2]
@@ -0,0 +1,2 @@
+let sign = fn(x) { if (x < 0) { -1 } else { if (x > 0) { 1 } else { 0 } } };
+[sign(-5), sign(0), sign(7), if (false) { 1 }]
@@ -0,0 +1,2 @@
+let e = error("message");
+[e, e["kind"], e["message"], e["nope"], error("x", "K")["kind"]]
@@ -0,0 +1,10 @@
+let fail = fn(kind) { throw error(kind, kind) };
+let kindOf = fn(f) { try { f() } catch (e) { e["kind"] } };
+[
+  kindOf(fn() { fail("Left") < fail("Right") }),
+  kindOf(fn() { fail("Left") <= fail("Right") }),
+  kindOf(fn() { fail("Left") >= fail("Right") }),
+  kindOf(fn() { fail("Left") + fail("Right") }),
+  kindOf(fn() { [fail("First"), fail("Second")] }),
+  kindOf(fn() { fail("Callee")(fail("Argument")) })
+]
@@ -0,0 +1,3 @@
+let kind = fn(f) { try { f(); "no error" } catch (e) { e["kind"] } };
+let a = [1, 2];
+[kind(fn() { read_file("a") }), kind(fn() { write_file("a", "b") }), kind(fn() { list_dir() }), kind(fn() { exists("a") }), kind(fn() { remove_file("a") }), kind(fn() { read_file(1) }), remove(a, 0), a]
@@ -0,0 +1,7 @@
+let log = fn(f) {
+  let result = try { f() } catch (e) { "caught " + e["message"] } finally { 0 };
+  result
+};
+let early = fn() { try { return 1; } finally { 2 } };
+let nested = fn() { try { try { throw "inner" } finally { 1 } } catch (e) { e["message"] } };
+[log(fn() { "ok" }), log(fn() { throw "no" }), early(), nested()]
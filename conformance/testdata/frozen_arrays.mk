@@ -0,0 +1,11 @@
+let a = freeze([1, [2]]);
+let kinds = [];
+append!(kinds, try { a[0] = 3 } catch (e) { e["kind"] });
+append!(kinds, try { append!(a, 3) } catch (e) { e["kind"] });
+append!(kinds, try { pop!(a) } catch (e) { e["kind"] });
+append!(kinds, try { remove(a, 0) } catch (e) { e["kind"] });
+append!(kinds, try { kinds[10] = 1 } catch (e) { e["kind"] });
+append!(kinds, try { kinds[0] = kinds } catch (e) { e["kind"] });
+append!(kinds, try { {}[0] = 1 } catch (e) { e["kind"] });
+a[1][0] = 4;
+[a, push(a, 5), kinds, pop!([])]
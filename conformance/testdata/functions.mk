@@ -0,0 +1,2 @@
+let apply = fn(f, x) { f(x) };
+[apply(len, "four"), apply(fn(x) { x }, apply), len, fn() {}(), puts()]
@@ -0,0 +1,2 @@
+let h = {"one": 1, 2: "two", true: [3], [4]: 4};
+[h["one"], h[2], h[true], h[[4]], h["missing"], h]
@@ -0,0 +1,7 @@
+let shout = fn(x) { print(upper(x), "!"); puts(""); x };
+puts("one", 2, [3, "four"], {"five": true});
+print("a", 1, [][0]);
+eprint(" and ", "stderr");
+puts("");
+let line = read_line();
+[shout("hey"), line, len(read_all()), read_line(), puts(), print(), eprint()]
@@ -0,0 +1,5 @@
+let q = json_stringify("")[:1];
+let j = fn(s) { replace(s, "'", q) };
+let doc = json_parse(j("{'name': 'monkey', 'tags': ['a', 'b'], 'age': 3, 'price': 9.99, 'big': 1e3, 'ok': true, 'none': null, 'nested': {'z': [], 'a': {}}}"));
+let round = json_stringify(doc);
+[doc["name"], doc["tags"][1], doc["age"] + 1, doc["price"], doc["big"], doc["ok"], doc["none"], round, json_stringify(json_parse(round)) == round, len(json_stringify({"k": [1, "<a & b>"]}, 2)), json_stringify([]), json_stringify("x"), json_parse(" 12 "), json_parse(j("'a\u00e9'"))]
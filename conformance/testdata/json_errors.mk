@@ -0,0 +1,4 @@
+let q = json_stringify("")[:1];
+let j = fn(s) { replace(s, "'", q) };
+let caught = fn(f) { try { f() } catch (e) { [e["kind"], e["message"]] } };
+[caught(fn() { json_parse(j("{'a': }")) }), caught(fn() { json_parse("[1, 2") }), caught(fn() { json_parse("") }), caught(fn() { json_parse("1 2") }), caught(fn() { json_parse("[1,]") }), caught(fn() { json_parse("1e999") }), caught(fn() { json_stringify([1, {"f": fn() { 1 }}]) }), caught(fn() { json_stringify({1: 2}) }), caught(fn() { json_stringify(len) }), caught(fn() { json_stringify(1, 17) }), caught(fn() { json_parse(1) })]
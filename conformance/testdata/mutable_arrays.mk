@@ -0,0 +1,14 @@
+let a = [1, 2, 3];
+let f = fn() { a[0] = a[0] + 10; };
+f();
+f();
+let b = a;
+append!(b, 4, 5);
+pop!(b);
+insert(a, 0, 0);
+remove(a, 1);
+let h = {a: 1};
+a[1] = 9;
+let c = [1, 2];
+c[0] = c[1] = 5;
+[a, h[[0, 2, 3, 4]], h[a], c]
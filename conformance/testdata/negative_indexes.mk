@@ -0,0 +1,5 @@
+let a = [1, 2, 3];
+let s = "monkey";
+let caught = fn(f) { try { f() } catch (e) { [e["kind"], e["message"]] } };
+a[-1] = 7;
+[a[-1], a[-3], a[-4], a, s[0], s[-1], s[-6], s[6], s[-7], s[len(s) - 1] + s[1:3], caught(fn() { a[-4] = 0 }), caught(fn() { s["a"] })]
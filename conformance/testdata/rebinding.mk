@@ -0,0 +1,8 @@
+let k = fn() { let y = 1; let g = fn() { y }; let y = 2; [g(), y] };
+let x = 1;
+let h = fn() { x };
+let x = 2;
+let local = fn() { let g = fn() { x }; let x = 3; [g(), x] };
+let self = fn() { let f = fn(n) { if (n < 1) { "done" } else { f(n - 1) } }; let g = f; let f = 0; g(3) };
+let shadow = fn(f) { let f = fn(f) { f }; f(5) };
+[k(), h(), local(), self(), shadow(1)]
@@ -0,0 +1,5 @@
+let down = fn(n) { if (n < 1) { 0 } else { 1 + down(n - 1) } };
+let forever = fn(n) { 1 + forever(n) };
+let loop = fn(n, acc) { if (n < 1) { acc } else { loop(n - 1, acc + 1) } };
+let kind = fn(f) { try { f() } catch (e) { e["kind"] } };
+[down(100), loop(5000, 0), kind(fn() { forever(0) }), kind(fn() { down(5000) }), kind(fn() { map([5000], down) })]
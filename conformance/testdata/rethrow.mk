@@ -0,0 +1,2 @@
+let f = fn() { try { throw "first" } catch (e) { throw e["message"] + " again" } };
+try { f() } catch (e) { e["message"] }
@@ -0,0 +1,3 @@
+let f = fn(x) { if (x > 10) { return "big"; } return "small"; "unreachable" };
+let g = fn() { let h = fn() { return 1; }; h() + 1 };
+[f(11), f(1), g()]
@@ -0,0 +1,6 @@
+let a = [1, 2, 3, 4, 5];
+let s = "monkey";
+let b = a[:];
+b[0] = 10;
+let caught = fn(f) { try { f() } catch (e) { [e["kind"], e["message"]] } };
+[a[1:3], a[:2], a[3:], b, a, a[-2:], a[:-1], a[-100:100], a[4:1], s[1:3], s[-3:], s[:0], a[1 + 1:len(a)], caught(fn() { 1[0:1] }), caught(fn() { a["x":] }), caught(fn() { freeze(a)[1:][0] = 0 })]
@@ -0,0 +1,2 @@
+let names = ["pear", "apple", "fig"];
+["a" < "b", "b" > "a", "ab" < "a", "" < "a", "a" <= "a", "a" >= "b", "Z" < "a", sort(names, fn(a, b) { if (a < b) { -1 } else { if (a > b) { 1 } else { 0 } } })]
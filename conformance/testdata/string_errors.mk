@@ -0,0 +1,2 @@
+let caught = fn(f) { try { f() } catch (e) { [e["kind"], e["message"]] } };
+[caught(fn() { upper(1) }), caught(fn() { split("a") }), caught(fn() { join(["a", 1], "") }), caught(fn() { substr("abc", 2, 1) }), caught(fn() { substr("abc", 0, 4) }), caught(fn() { repeat("a", -1) }), caught(fn() { repeat("ab", 10000000000) }), caught(fn() { format("%d", "a") }), caught(fn() { format("%s %s", "a") }), caught(fn() { format("%s", "a", "b") }), caught(fn() { format("%x", 1) })]
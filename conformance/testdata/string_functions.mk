@@ -0,0 +1,3 @@
+let words = split(" Hello, Monkey World ", " ");
+let name = trim(" monkey ");
+[words, join(["a", "b", "c"], "-"), upper(name), lower("MiXeD"), contains(name, "key"), starts_with(name, "mon"), ends_with(name, "mon"), replace("a.b.c", ".", "::"), index_of(name, "key"), index_of(name, "x"), substr(name, 3), substr(name, 1, 3), repeat("ab", 3), chars("héllo"), format("%s is %d, %v and 100%%", name, 6, [true, "x"])]
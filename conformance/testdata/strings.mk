@@ -0,0 +1,2 @@
+let greet = fn(name) { "hello, " + name + "!" };
+[greet("monkey"), len(greet("")), len("")]
@@ -0,0 +1,2 @@
+let f = fn() { throw error("boom", "MyError"); };
+f()
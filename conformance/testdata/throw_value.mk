@@ -0,0 +1 @@
+throw "oops"
@@ -0,0 +1,6 @@
+let f = fn(x) { x * 2 };
+let a = try { 1 } finally { 2 };
+if (a == 1) {
+  return [a, f(a)];
+}
+"unreachable"
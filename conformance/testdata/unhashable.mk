@@ -0,0 +1 @@
+{fn() {}: 1}
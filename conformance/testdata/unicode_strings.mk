@@ -0,0 +1,2 @@
+let s = "héllo wörld";
+[len(s), index_of(s, "w"), index_of(s, "ö"), substr(s, 1, 4), s[1:4], s[-4:], s[1], s[-4], len(chars(s)), join(chars(s[6:]), "-")]
@@ -0,0 +1,169 @@
+// Package cover reports the coverage of monkey programs: how often the vm ran their lines (see
+// vm.EnableCoverage), and the outcomes of the conditions of their ifs. Reports are text, HTML or
+// LCOV, the format coverage tools and CI servers read.
+package cover
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/vm"
+)
+
+/*
+	A line runs as often as the instructions starting the statements and ifs on it, and the
+	lines without instructions (blank lines, lines of braces) aren't part of the profile. A
+	branch is a conditional jump: the condition of an if, of the ones the compiler doesn't
+	optimize away. Each has two outcomes, truthy and falsy, either of which may never happen.
+
+	Profiles are by line rather than by instruction, so that the profiles of the runs of a
+	source, each compiled on its own (such as the tests of a file), can be added up.
+*/
+
+// Profile is the coverage of a source
+type Profile struct {
+	// Lines are by line the times the line ran, for the lines with instructions
+	Lines map[int]int
+
+	// Branches are by line the outcomes of the conditional jumps on it, in the order of the
+	// instructions
+	Branches map[int][]vm.Outcomes
+}
+
+// New returns the profile of a run of bytecode, coverage being what the run ran (nil for a
+// program which didn't run)
+func New(bytecode *compiler.Bytecode, coverage *vm.Coverage) *Profile {
+	p := &Profile{Lines: map[int]int{}, Branches: map[int][]vm.Outcomes{}}
+
+	main := &object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines}
+	if coverage != nil {
+		main = coverage.Main
+	}
+	p.addFunction(main, coverage)
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			p.addFunction(fn, coverage)
+		}
+	}
+	return p
+}
+
+func (p *Profile) addFunction(fn *object.CompiledFunction, coverage *vm.Coverage) {
+	var counts []int
+	var branches map[int]*vm.Outcomes
+	if coverage != nil {
+		counts, branches = coverage.Counts[fn], coverage.Branches[fn]
+	}
+
+	for i, entry := range fn.Lines {
+		end := len(fn.Instructions)
+		if i+1 < len(fn.Lines) {
+			end = fn.Lines[i+1].Offset
+		}
+		if entry.Line == 0 || entry.Offset >= end {
+			continue
+		}
+		count := 0
+		if counts != nil {
+			count = counts[entry.Offset]
+		}
+		if hits, ok := p.Lines[entry.Line]; !ok || count > hits {
+			p.Lines[entry.Line] = count
+		}
+	}
+
+	for offset := 0; offset < len(fn.Instructions); {
+		def, err := code.Lookup(fn.Instructions[offset])
+		if err != nil {
+			return
+		}
+		if code.Opcode(fn.Instructions[offset]) == code.OpJumpNotTruthy {
+			if line := fn.Lines.LineAt(offset); line > 0 {
+				outcomes := vm.Outcomes{}
+				if o, ok := branches[offset]; ok {
+					outcomes = *o
+				}
+				p.Branches[line] = append(p.Branches[line], outcomes)
+			}
+		}
+		_, read := code.ReadOperands(def, fn.Instructions[offset+1:])
+		offset += 1 + read
+	}
+}
+
+// Add adds the counts of other, a profile of the same source, to p
+func (p *Profile) Add(other *Profile) {
+	for line, hits := range other.Lines {
+		p.Lines[line] += hits
+	}
+	for line, outcomes := range other.Branches {
+		mine := p.Branches[line]
+		for i, o := range outcomes {
+			if i == len(mine) {
+				mine = append(mine, vm.Outcomes{})
+			}
+			mine[i].Truthy += o.Truthy
+			mine[i].Falsy += o.Falsy
+		}
+		p.Branches[line] = mine
+	}
+}
+
+// lines returns the lines of the profile, sorted
+func (p *Profile) lines() []int {
+	lines := make([]int, 0, len(p.Lines))
+	for line := range p.Lines {
+		lines = append(lines, line)
+	}
+	for line := range p.Branches {
+		if _, ok := p.Lines[line]; !ok {
+			lines = append(lines, line)
+		}
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Summary counts the lines and branch outcomes of a profile, and those which ran
+type Summary struct {
+	Lines, LinesHit       int
+	Branches, BranchesHit int // two outcomes per branch
+}
+
+// Summary returns the summary of p
+func (p *Profile) Summary() Summary {
+	s := Summary{Lines: len(p.Lines)}
+	for _, hits := range p.Lines {
+		if hits > 0 {
+			s.LinesHit++
+		}
+	}
+	for _, outcomes := range p.Branches {
+		for _, o := range outcomes {
+			s.Branches += 2
+			if o.Truthy > 0 {
+				s.BranchesHit++
+			}
+			if o.Falsy > 0 {
+				s.BranchesHit++
+			}
+		}
+	}
+	return s
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%s of lines (%d/%d), %s of branches (%d/%d)",
+		percent(s.LinesHit, s.Lines), s.LinesHit, s.Lines, percent(s.BranchesHit, s.Branches), s.BranchesHit, s.Branches)
+}
+
+// percent returns hit of total as a percentage, 100% of nothing
+func percent(hit, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(hit)/float64(total))
+}
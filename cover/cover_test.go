@@ -0,0 +1,147 @@
+package cover_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/conformance"
+	"github.com/andy9775/monkey/cover"
+	"github.com/andy9775/monkey/vm"
+)
+
+const source = `let sign = fn(x) {
+  if (x < 0) {
+    -1
+  } else {
+    1
+  }
+};
+let never = fn() {
+  0
+};
+sign(2)
+`
+
+// profile runs source and returns its profile
+func profile(t *testing.T) *cover.Profile {
+	t.Helper()
+	program, err := conformance.Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := vm.New(comp.Bytecode())
+	machine.EnableCoverage()
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return cover.New(comp.Bytecode(), machine.Coverage())
+}
+
+func TestProfile(t *testing.T) {
+	p := profile(t)
+	expectedLines := map[int]int{1: 1, 2: 1, 3: 0, 5: 1, 8: 1, 9: 0, 11: 1}
+	if !reflect.DeepEqual(p.Lines, expectedLines) {
+		t.Errorf("wrong lines.\nwant=%v\ngot =%v", expectedLines, p.Lines)
+	}
+	expectedBranches := map[int][]vm.Outcomes{2: {{Truthy: 0, Falsy: 1}}}
+	if !reflect.DeepEqual(p.Branches, expectedBranches) {
+		t.Errorf("wrong branches.\nwant=%v\ngot =%v", expectedBranches, p.Branches)
+	}
+	expectedSummary := cover.Summary{Lines: 7, LinesHit: 5, Branches: 2, BranchesHit: 1}
+	if s := p.Summary(); s != expectedSummary {
+		t.Errorf("wrong summary. want=%+v, got=%+v", expectedSummary, s)
+	}
+
+	p.Add(profile(t))
+	if p.Lines[1] != 2 || p.Lines[3] != 0 || p.Branches[2][0] != (vm.Outcomes{Truthy: 0, Falsy: 2}) {
+		t.Errorf("wrong sum of profiles. got=%v %v", p.Lines, p.Branches)
+	}
+}
+
+// The profile of a program which didn't run has its lines and branches, none of them covered
+func TestProfileNotRun(t *testing.T) {
+	program, err := conformance.Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	expected := cover.Summary{Lines: 7, LinesHit: 0, Branches: 2, BranchesHit: 0}
+	if s := cover.New(comp.Bytecode(), nil).Summary(); s != expected {
+		t.Errorf("wrong summary. want=%+v, got=%+v", expected, s)
+	}
+}
+
+func TestReports(t *testing.T) {
+	files := []cover.File{{Name: "sign.mk", Source: source, Profile: profile(t)}}
+
+	var text bytes.Buffer
+	cover.WriteText(&text, files)
+	expectedText := `sign.mk: 71.4% of lines (5/7), 50.0% of branches (1/2)
+        1:    1: let sign = fn(x) {
+        1:    2:   if (x < 0) {
+                 branch 0: truthy 0, falsy 1
+    #####:    3:     -1
+        -:    4:   } else {
+        1:    5:     1
+        -:    6:   }
+        -:    7: };
+        1:    8: let never = fn() {
+    #####:    9:   0
+        -:   10: };
+        1:   11: sign(2)
+`
+	if text.String() != expectedText {
+		t.Errorf("wrong text report.\nwant=%s\ngot =%s", expectedText, text.String())
+	}
+
+	var lcov bytes.Buffer
+	if err := cover.WriteLCOV(&lcov, files); err != nil {
+		t.Fatal(err)
+	}
+	expectedLCOV := `TN:
+SF:sign.mk
+BRDA:2,0,0,0
+BRDA:2,0,1,1
+BRF:2
+BRH:1
+DA:1,1
+DA:2,1
+DA:3,0
+DA:5,1
+DA:8,1
+DA:9,0
+DA:11,1
+LF:7
+LH:5
+end_of_record
+`
+	if lcov.String() != expectedLCOV {
+		t.Errorf("wrong LCOV.\nwant=%s\ngot =%s", expectedLCOV, lcov.String())
+	}
+
+	var html bytes.Buffer
+	if err := cover.WriteHTML(&html, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<tr class="partial" title="branch 0: truthy 0, falsy 1"><td class="number">2</td><td class="count">1</td><td class="text">  if (x &lt; 0) {</td></tr>`,
+		`<tr class="uncovered"><td class="number">3</td><td class="count">0</td><td class="text">    -1</td></tr>`,
+		`<tr class=""><td class="number">4</td><td class="count"></td><td class="text">  } else {</td></tr>`,
+		`<tr class="covered"><td class="number">5</td>`,
+		`sign.mk</a>: 71.4% of lines (5/7), 50.0% of branches (1/2)`,
+	} {
+		if !strings.Contains(html.String(), expected) {
+			t.Errorf("HTML report doesn't contain %q.\ngot=%s", expected, html.String())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package cover
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// File is the coverage of a source file, for the reports
+type File struct {
+	Name    string
+	Source  string
+	Profile *Profile
+}
+
+// sourceLines returns the lines of the source of f, the first one at index 0
+func (f File) sourceLines() []string {
+	return strings.Split(strings.TrimSuffix(f.Source, "\n"), "\n")
+}
+
+// WriteText writes a report of files for people: for each file its summary, then its lines each
+// after the times it ran, ##### if it never did and - if it has no code, with the outcomes of the
+// branches on it below it
+func WriteText(w io.Writer, files []File) {
+	for _, f := range files {
+		fmt.Fprintf(w, "%s: %s\n", f.Name, f.Profile.Summary())
+		for i, text := range f.sourceLines() {
+			line := i + 1
+			count := "-"
+			if hits, ok := f.Profile.Lines[line]; ok && hits > 0 {
+				count = fmt.Sprint(hits)
+			} else if ok {
+				count = "#####"
+			}
+			fmt.Fprintf(w, "%9s:%5d: %s\n", count, line, text)
+			for j, o := range f.Profile.Branches[line] {
+				fmt.Fprintf(w, "%9s        branch %d: truthy %d, falsy %d\n", "", j, o.Truthy, o.Falsy)
+			}
+		}
+	}
+}
+
+// WriteLCOV writes the coverage of files in the LCOV tracefile format: the runs of each line
+// (DA) and the outcomes of each branch (BRDA, a block per branch of a line, 0 being truthy and 1
+// falsy), - for those on lines which never ran
+func WriteLCOV(w io.Writer, files []File) error {
+	for _, f := range files {
+		s := f.Profile.Summary()
+		fmt.Fprintf(w, "TN:\nSF:%s\n", f.Name)
+		for _, line := range f.Profile.lines() {
+			for block, o := range f.Profile.Branches[line] {
+				for branch, taken := range []int{o.Truthy, o.Falsy} {
+					if f.Profile.Lines[line] == 0 {
+						fmt.Fprintf(w, "BRDA:%d,%d,%d,-\n", line, block, branch)
+					} else {
+						fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", line, block, branch, taken)
+					}
+				}
+			}
+		}
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", s.Branches, s.BranchesHit)
+		for _, line := range f.Profile.lines() {
+			if hits, ok := f.Profile.Lines[line]; ok {
+				fmt.Fprintf(w, "DA:%d,%d\n", line, hits)
+			}
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", s.Lines, s.LinesHit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// htmlLine is a line of a file in the HTML report
+type htmlLine struct {
+	Number int
+	Text   string
+	Count  string
+	Class  string // covered, partial (ran but not all of its branch outcomes happened) or uncovered
+	Title  string // the branch outcomes
+}
+
+type htmlFile struct {
+	Name    string
+	Summary Summary
+	Lines   []htmlLine
+}
+
+var htmlReport = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>monkey coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td { padding: 0 8px; white-space: pre; }
+td.number, td.count { text-align: right; color: #888; }
+tr.covered td.text { background: #dfd; }
+tr.partial td.text { background: #ffd; }
+tr.uncovered td.text { background: #fdd; }
+</style>
+</head>
+<body>
+<ul>
+{{range $i, $f := .}}<li><a href="#file{{$i}}">{{$f.Name}}</a>: {{$f.Summary}}</li>
+{{end}}</ul>
+{{range $i, $f := .}}<h2 id="file{{$i}}">{{$f.Name}}</h2>
+<p>{{$f.Summary}}</p>
+<table>
+{{range $f.Lines}}<tr class="{{.Class}}"{{if .Title}} title="{{.Title}}"{{end}}><td class="number">{{.Number}}</td><td class="count">{{.Count}}</td><td class="text">{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes a page showing the lines of files colored by whether they ran, partly ran
+// (ran, but without every outcome of the branches on them) or never ran
+func WriteHTML(w io.Writer, files []File) error {
+	page := []htmlFile{}
+	for _, f := range files {
+		hf := htmlFile{Name: f.Name, Summary: f.Profile.Summary()}
+		for i, text := range f.sourceLines() {
+			l := htmlLine{Number: i + 1, Text: text}
+			if hits, ok := f.Profile.Lines[l.Number]; ok {
+				l.Count, l.Class = fmt.Sprint(hits), "covered"
+				if hits == 0 {
+					l.Class = "uncovered"
+				}
+			}
+			outcomes := []string{}
+			for j, o := range f.Profile.Branches[l.Number] {
+				outcomes = append(outcomes, fmt.Sprintf("branch %d: truthy %d, falsy %d", j, o.Truthy, o.Falsy))
+				if l.Class == "covered" && (o.Truthy == 0 || o.Falsy == 0) {
+					l.Class = "partial"
+				}
+			}
+			l.Title = strings.Join(outcomes, "\n")
+			hf.Lines = append(hf.Lines, l)
+		}
+		page = append(page, hf)
+	}
+	return htmlReport.Execute(w, page)
+}
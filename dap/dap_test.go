@@ -0,0 +1,358 @@
+package dap_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/dap"
+)
+
+const program = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let twice = fn(x) {
+  add(x, x) * 1
+};
+let list = [1, {"k": 2}];
+let y = twice(2);
+puts(y);
+y`
+
+// client is a scripted DAP client. Messages it isn't waiting for are kept until it is.
+type client struct {
+	t       *testing.T
+	w       io.WriteCloser
+	r       *bufio.Reader
+	seq     int
+	pending []map[string]interface{}
+	served  chan error
+}
+
+func start(t *testing.T) *client {
+	t.Helper()
+
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &client{t: t, w: clientOut, r: bufio.NewReader(clientIn), served: make(chan error, 1)}
+	go func() {
+		err := dap.Serve(serverIn, serverOut)
+		serverOut.Close()
+		c.served <- err
+	}()
+	return c
+}
+
+func writeProgram(t *testing.T, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "program.mk")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatalf("cannot write program: %s", err)
+	}
+	return path
+}
+
+func (c *client) request(command string, args interface{}) {
+	c.t.Helper()
+
+	c.seq++
+	data, err := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if err != nil {
+		c.t.Fatalf("cannot encode request: %s", err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatalf("cannot send request: %s", err)
+	}
+}
+
+func (c *client) read() map[string]interface{} {
+	c.t.Helper()
+
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("cannot read message: %s", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf("invalid Content-Length: %s", err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.t.Fatalf("cannot read message: %s", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatalf("invalid message %s: %s", data, err)
+	}
+	return msg
+}
+
+// next returns the first message matching, reading until there is one
+func (c *client) next(matching func(msg map[string]interface{}) bool) map[string]interface{} {
+	c.t.Helper()
+	for i, msg := range c.pending {
+		if matching(msg) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return msg
+		}
+	}
+	for {
+		msg := c.read()
+		if matching(msg) {
+			return msg
+		}
+		c.pending = append(c.pending, msg)
+	}
+}
+
+// call sends a request and returns the body of its response, failing unless it succeeds
+func (c *client) call(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	response := c.response(command, args)
+	if response["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, response["message"])
+	}
+	body, _ := response["body"].(map[string]interface{})
+	return body
+}
+
+func (c *client) response(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.request(command, args)
+	seq := float64(c.seq)
+	return c.next(func(msg map[string]interface{}) bool {
+		return msg["type"] == "response" && msg["request_seq"] == seq
+	})
+}
+
+// event returns the body of the next event name
+func (c *client) event(name string) map[string]interface{} {
+	c.t.Helper()
+	msg := c.next(func(msg map[string]interface{}) bool {
+		return msg["type"] == "event" && msg["event"] == name
+	})
+	body, _ := msg["body"].(map[string]interface{})
+	return body
+}
+
+// stopped waits for the program to pause, and returns the reason and the line it paused at
+func (c *client) stopped() (string, float64) {
+	c.t.Helper()
+	reason := c.event("stopped")["reason"].(string)
+	frames := c.call("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"].([]interface{})
+	return reason, frames[0].(map[string]interface{})["line"].(float64)
+}
+
+// variables returns the variables of ref as name=value, and their references by name
+func (c *client) variables(ref float64) (string, map[string]float64) {
+	c.t.Helper()
+	vars := c.call("variables", map[string]interface{}{"variablesReference": ref})["variables"].([]interface{})
+	rendered := ""
+	refs := map[string]float64{}
+	for _, v := range vars {
+		v := v.(map[string]interface{})
+		if rendered != "" {
+			rendered += " "
+		}
+		rendered += fmt.Sprintf("%s=%s", v["name"], v["value"])
+		refs[v["name"].(string)] = v["variablesReference"].(float64)
+	}
+	return rendered, refs
+}
+
+func (c *client) disconnect() {
+	c.t.Helper()
+	c.call("disconnect", nil)
+	if err := <-c.served; err != nil {
+		c.t.Errorf("serve error: %s", err)
+	}
+}
+
+func (c *client) launch(path string, stopOnEntry bool) {
+	c.t.Helper()
+	c.call("initialize", map[string]interface{}{"adapterID": "monkey", "linesStartAt1": true})
+	c.call("launch", map[string]interface{}{"program": path, "stopOnEntry": stopOnEntry})
+	c.event("initialized")
+}
+
+func TestSession(t *testing.T) {
+	path := writeProgram(t, program)
+	c := start(t)
+	c.launch(path, false)
+
+	body := c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 3}, {"line": 4}},
+	})
+	breakpoints := body["breakpoints"].([]interface{})
+	if verified := breakpoints[0].(map[string]interface{})["verified"]; verified != true {
+		t.Errorf("breakpoint at line 3 not verified")
+	}
+	if verified := breakpoints[1].(map[string]interface{})["verified"]; verified != false {
+		t.Errorf("breakpoint at line 4, which has no statement, verified")
+	}
+	c.call("configurationDone", nil)
+
+	if reason, line := c.stopped(); reason != "breakpoint" || line != 3 {
+		t.Fatalf("wrong stop. want=breakpoint at 3, got=%s at %v", reason, line)
+	}
+
+	threads := c.call("threads", nil)["threads"].([]interface{})
+	if len(threads) != 1 {
+		t.Errorf("wrong number of threads. want=1, got=%d", len(threads))
+	}
+
+	frames := c.call("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"].([]interface{})
+	trace := ""
+	for _, frame := range frames {
+		frame := frame.(map[string]interface{})
+		trace += fmt.Sprintf("%s:%v ", frame["name"], frame["line"])
+	}
+	if trace != "add:3 twice:6 main program:9 " {
+		t.Errorf("wrong stack trace. got=%q", trace)
+	}
+
+	scopes := c.call("scopes", map[string]interface{}{"frameId": 1})["scopes"].([]interface{})
+	refs := map[string]float64{}
+	for _, s := range scopes {
+		s := s.(map[string]interface{})
+		refs[s["name"].(string)] = s["variablesReference"].(float64)
+	}
+	if locals, _ := c.variables(refs["Locals"]); locals != "a=2 b=2 sum=4" {
+		t.Errorf("wrong locals. got=%q", locals)
+	}
+
+	globals, globalRefs := c.variables(refs["Globals"])
+	if want := "list=[1, {k: 2}]"; !strings.HasSuffix(globals, want) {
+		t.Errorf("globals don't have %s. got=%q", want, globals)
+	}
+	elements, elementRefs := c.variables(globalRefs["list"])
+	if elements != "[0]=1 [1]={k: 2}" {
+		t.Errorf("wrong elements of list. got=%q", elements)
+	}
+	if pairs, _ := c.variables(elementRefs["[1]"]); pairs != "k=2" {
+		t.Errorf("wrong pairs of list[1]. got=%q", pairs)
+	}
+
+	c.call("next", map[string]interface{}{"threadId": 1})
+	if reason, line := c.stopped(); reason != "step" || line != 10 {
+		t.Fatalf("wrong stop. want=step at 10, got=%s at %v", reason, line)
+	}
+
+	c.call("continue", map[string]interface{}{"threadId": 1})
+	if out := c.event("output"); out["output"] != "4\n" || out["category"] != "stdout" {
+		t.Errorf("wrong output. got=%v", out)
+	}
+	if exited := c.event("exited"); exited["exitCode"] != 0.0 {
+		t.Errorf("wrong exit code. got=%v", exited["exitCode"])
+	}
+	c.event("terminated")
+
+	c.disconnect()
+}
+
+func TestStopOnEntry(t *testing.T) {
+	path := writeProgram(t, program)
+	c := start(t)
+	c.launch(path, true)
+	c.call("configurationDone", nil)
+
+	if reason, line := c.stopped(); reason != "entry" || line != 1 {
+		t.Fatalf("wrong stop. want=entry at 1, got=%s at %v", reason, line)
+	}
+	for _, want := range []float64{5, 8, 9, 6, 2} {
+		c.call("stepIn", map[string]interface{}{"threadId": 1})
+		if reason, line := c.stopped(); reason != "step" || line != want {
+			t.Fatalf("wrong stop. want=step at %v, got=%s at %v", want, reason, line)
+		}
+	}
+	c.call("stepOut", map[string]interface{}{"threadId": 1})
+	if _, line := c.stopped(); line != 10 {
+		t.Fatalf("wrong stop after stepOut. want=10, got=%v", line)
+	}
+
+	// disconnecting stops the paused program
+	c.disconnect()
+}
+
+// The program sees the files of its directory, or of the root it's launched with
+func TestLaunchFiles(t *testing.T) {
+	path := writeProgram(t, `puts(list_dir()); write_file("out.txt", "x")`)
+	tests := []struct {
+		args     map[string]interface{}
+		output   string
+		exitCode float64
+	}{
+		{map[string]interface{}{}, "[program.mk]\n", 0},
+		{map[string]interface{}{"root": filepath.Dir(filepath.Dir(path))}, "[" + filepath.Base(filepath.Dir(path)) + "]\n", 0},
+		{map[string]interface{}{"readOnly": true}, "[out.txt, program.mk]\n", 1}, // out.txt of the first launch
+	}
+
+	for _, tt := range tests {
+		c := start(t)
+		c.call("initialize", map[string]interface{}{"adapterID": "monkey", "linesStartAt1": true})
+		tt.args["program"] = path
+		c.call("launch", tt.args)
+		c.event("initialized")
+		c.call("configurationDone", nil)
+
+		if out := c.event("output"); out["output"] != tt.output {
+			t.Errorf("wrong files with %v. want=%q, got=%q", tt.args, tt.output, out["output"])
+		}
+		if tt.exitCode != 0 {
+			c.event("output") // the write failing
+		}
+		if exited := c.event("exited"); exited["exitCode"] != tt.exitCode {
+			t.Errorf("wrong exit code with %v. want=%v, got=%v", tt.args, tt.exitCode, exited["exitCode"])
+		}
+		c.disconnect()
+	}
+}
+
+func TestUncaughtError(t *testing.T) {
+	path := writeProgram(t, "let a = 1;\nthrow \"no\"")
+	c := start(t)
+	c.launch(path, false)
+	c.call("configurationDone", nil)
+
+	if out := c.event("output"); out["output"] != "uncaught Error: no\n" || out["category"] != "stderr" {
+		t.Errorf("wrong output. got=%v", out)
+	}
+	if exited := c.event("exited"); exited["exitCode"] != 1.0 {
+		t.Errorf("wrong exit code. got=%v", exited["exitCode"])
+	}
+	c.disconnect()
+}
+
+func TestFailedRequests(t *testing.T) {
+	c := start(t)
+	c.call("initialize", nil)
+
+	tests := []struct {
+		command string
+		args    interface{}
+	}{
+		{"launch", map[string]interface{}{"program": filepath.Join(t.TempDir(), "missing.mk")}},
+		{"configurationDone", nil},
+		{"stackTrace", map[string]interface{}{"threadId": 1}},
+		{"continue", map[string]interface{}{"threadId": 1}},
+		{"evaluate", map[string]interface{}{"expression": "1"}},
+		{"launch", "not an object"},
+	}
+	for _, tt := range tests {
+		response := c.response(tt.command, tt.args)
+		if response["success"] != false || response["message"] == "" {
+			t.Errorf("%s didn't fail. got=%v", tt.command, response)
+		}
+	}
+
+	c.disconnect()
+}
@@ -0,0 +1,480 @@
+// Package dap serves the Debug Adapter Protocol, so editors can debug monkey programs with the
+// debugger package. A session debugs one program (launched from a file) on one thread:
+// breakpoints are set by line, and while the program is paused its frames, their scopes (the
+// locals, free variables and globals, by the names the compiler gave them) and the elements of
+// arrays and hashes can be inspected.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/andy9775/monkey/debugger"
+	"github.com/andy9775/monkey/object"
+)
+
+// threadID is the id of the only thread of a program
+const threadID = 1
+
+// Serve serves a session over in and out until the client disconnects or in ends
+func Serve(in io.Reader, out io.Writer) error {
+	s := &session{
+		in:       bufio.NewReader(in),
+		out:      out,
+		lineBase: 1,
+		resume:   make(chan debugger.Action),
+		done:     make(chan struct{}),
+	}
+	return s.serve()
+}
+
+type session struct {
+	in *bufio.Reader
+
+	// the program sends output events while requests are served, mu guards writing messages
+	mu  sync.Mutex
+	out io.Writer
+	seq int
+
+	lineBase int // the first line is 1, or 0 if the client says so
+
+	path        string
+	d           *debugger.Debugger
+	stopOnEntry bool
+	started     bool
+	done        chan struct{} // closed once the program ended
+
+	// state guards what the goroutine running the program shares with the requests. While the
+	// program is paused the goroutine waits for resume, and refs holds the values of the
+	// variable references handed out since it paused.
+	state       sync.Mutex
+	paused      bool
+	entered     bool // the program paused at its entry
+	interrupted bool // a pause request interrupted the program
+	quitting    bool
+	resume      chan debugger.Action
+	refs        []interface{} // []debugger.Variable, or the *object.Array or *object.Hash
+}
+
+func (s *session) serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err != nil {
+			s.stop()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Type != "request" {
+			continue
+		}
+		if s.handle(msg) {
+			return nil
+		}
+	}
+}
+
+// handle serves the request req, reporting whether the session is over
+func (s *session) handle(req *message) bool {
+	switch req.Command {
+	case "initialize":
+		var args initializeArguments
+		if !s.arguments(req, &args) {
+			break
+		}
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		s.respond(req, capabilities{SupportsConfigurationDoneRequest: true, SupportsTerminateRequest: true})
+	case "launch":
+		s.launch(req)
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+	case "configurationDone":
+		if s.d == nil {
+			s.fail(req, "no program was launched")
+			break
+		}
+		s.respond(req, nil)
+		if !s.started {
+			s.started = true
+			go s.run()
+		}
+	case "threads":
+		s.respond(req, map[string]interface{}{"threads": []thread{{ID: threadID, Name: "main"}}})
+	case "stackTrace":
+		s.stackTrace(req)
+	case "scopes":
+		s.scopes(req)
+	case "variables":
+		s.variables(req)
+	case "continue":
+		s.resumeWith(req, debugger.Continue, map[string]interface{}{"allThreadsContinued": true})
+	case "next":
+		s.resumeWith(req, debugger.StepOver, nil)
+	case "stepIn":
+		s.resumeWith(req, debugger.StepIn, nil)
+	case "stepOut":
+		s.resumeWith(req, debugger.StepOut, nil)
+	case "pause":
+		if !s.started {
+			s.fail(req, "the program isn't running")
+			break
+		}
+		s.state.Lock()
+		s.interrupted = true
+		s.state.Unlock()
+		s.d.Interrupt()
+		s.respond(req, nil)
+	case "terminate":
+		s.stop()
+		s.respond(req, nil)
+		s.event("terminated", nil)
+	case "disconnect":
+		s.stop()
+		s.respond(req, nil)
+		return true
+	default:
+		s.fail(req, "unsupported request %s", req.Command)
+	}
+	return false
+}
+
+func (s *session) launch(req *message) {
+	var args launchArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+	if s.d != nil {
+		s.fail(req, "a program was launched already")
+		return
+	}
+
+	path, err := filepath.Abs(args.Program)
+	if err != nil {
+		s.fail(req, "cannot launch %s: %s", args.Program, err)
+		return
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		s.fail(req, "cannot launch %s: %s", args.Program, err)
+		return
+	}
+
+	// the program has no input, and its output is sent to the client
+	streams := object.NewIO(nil, output{s, "stdout"}, output{s, "stderr"})
+	streams.FS, err = object.ProgramFS(path, args.Root, args.ReadOnly)
+	if err != nil {
+		s.fail(req, "cannot launch %s: %s", args.Program, err)
+		return
+	}
+	d, err := debugger.New(string(source), streams)
+	if err != nil {
+		s.fail(req, "%s", err)
+		return
+	}
+
+	s.path, s.d, s.stopOnEntry = path, d, args.StopOnEntry
+	s.respond(req, nil)
+	s.event("initialized", nil) // the breakpoints can be set now the program is compiled
+}
+
+func (s *session) setBreakpoints(req *message) {
+	var args setBreakpointsArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+
+	problem := ""
+	switch {
+	case s.d == nil:
+		problem = "no program was launched"
+	case !samePath(args.Source.Path, s.path):
+		problem = "not the program being debugged"
+	default:
+		// the breakpoints of the source are replaced
+		for _, line := range s.d.Breakpoints() {
+			s.d.Clear(line)
+		}
+	}
+
+	breakpoints := make([]breakpoint, len(args.Breakpoints))
+	for i, b := range args.Breakpoints {
+		breakpoints[i] = breakpoint{Line: b.Line, Message: problem}
+		if problem != "" {
+			continue
+		}
+		if err := s.d.Break(s.fromClient(b.Line)); err != nil {
+			breakpoints[i].Message = err.Error()
+		} else {
+			breakpoints[i].Verified = true
+		}
+	}
+	s.respond(req, map[string]interface{}{"breakpoints": breakpoints})
+}
+
+func samePath(a, b string) bool {
+	abs, err := filepath.Abs(a)
+	return err == nil && abs == b
+}
+
+// run runs the program, telling the client how it ended
+func (s *session) run() {
+	defer close(s.done)
+
+	err := s.d.Run(s.pause)
+	if err == debugger.ErrQuit {
+		return
+	}
+
+	exitCode := 0
+	if err != nil {
+		exitCode = 1
+		message := err.Error()
+		if e, ok := err.(*object.Error); ok {
+			message = e.Kind + ": " + e.Message
+		}
+		s.event("output", map[string]interface{}{"category": "stderr", "output": "uncaught " + message + "\n"})
+	}
+	s.event("exited", map[string]interface{}{"exitCode": exitCode})
+	s.event("terminated", nil)
+}
+
+// pause tells the client the program paused and waits for it to carry on
+func (s *session) pause(d *debugger.Debugger) debugger.Action {
+	s.state.Lock()
+	if s.quitting {
+		s.state.Unlock()
+		return debugger.Quit
+	}
+
+	atBreakpoint := false
+	for _, line := range d.Breakpoints() {
+		atBreakpoint = atBreakpoint || line == d.Line()
+	}
+
+	reason := "step"
+	switch {
+	case !s.entered:
+		s.entered = true
+		if !s.stopOnEntry && !atBreakpoint {
+			s.state.Unlock()
+			return debugger.Continue
+		}
+		reason = "entry"
+	case s.interrupted:
+		reason = "pause"
+	case atBreakpoint:
+		reason = "breakpoint"
+	}
+	s.interrupted = false
+	s.paused = true
+	s.refs = nil
+	s.state.Unlock()
+
+	s.event("stopped", map[string]interface{}{"reason": reason, "threadId": threadID, "allThreadsStopped": true})
+	return <-s.resume
+}
+
+// isPaused reports whether the program is paused, failing req if it isn't
+func (s *session) isPaused(req *message) bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	if !s.paused {
+		s.fail(req, "the program isn't paused")
+	}
+	return s.paused
+}
+
+// resumeWith carries on with the paused program
+func (s *session) resumeWith(req *message, action debugger.Action, body interface{}) {
+	if !s.isPaused(req) {
+		return
+	}
+	s.state.Lock()
+	s.paused = false
+	s.state.Unlock()
+
+	s.respond(req, body) // before the program can pause again
+	s.resume <- action
+}
+
+// stop stops the program, if it runs, and waits for it to end
+func (s *session) stop() {
+	if !s.started {
+		return
+	}
+
+	s.state.Lock()
+	s.quitting = true
+	paused := s.paused
+	s.paused = false
+	s.state.Unlock()
+
+	if paused {
+		s.resume <- debugger.Quit
+	} else {
+		s.d.Interrupt()
+	}
+	<-s.done
+}
+
+func (s *session) stackTrace(req *message) {
+	if !s.isPaused(req) {
+		return
+	}
+
+	frames := s.d.Frames()
+	stackFrames := make([]stackFrame, len(frames))
+	for i, frame := range frames {
+		stackFrames[i] = stackFrame{
+			ID:     i + 1,
+			Name:   frame.Name(),
+			Source: source{Name: filepath.Base(s.path), Path: s.path},
+			Line:   s.toClient(frame.Line),
+			Column: s.lineBase,
+		}
+	}
+	s.respond(req, map[string]interface{}{"stackFrames": stackFrames, "totalFrames": len(frames)})
+}
+
+func (s *session) scopes(req *message) {
+	var args frameArguments
+	if !s.arguments(req, &args) || !s.isPaused(req) {
+		return
+	}
+	frames := s.d.Frames()
+	if args.FrameID < 1 || args.FrameID > len(frames) {
+		s.fail(req, "no frame %d", args.FrameID)
+		return
+	}
+	frame := frames[args.FrameID-1]
+
+	scopes := []scope{{Name: "Locals", VariablesReference: s.reference(frame.Locals)}}
+	if len(frame.Free) > 0 {
+		scopes = append(scopes, scope{Name: "Free variables", VariablesReference: s.reference(frame.Free)})
+	}
+	scopes = append(scopes, scope{Name: "Globals", VariablesReference: s.reference(s.d.Globals())})
+	s.respond(req, map[string]interface{}{"scopes": scopes})
+}
+
+func (s *session) variables(req *message) {
+	var args variablesArguments
+	if !s.arguments(req, &args) || !s.isPaused(req) {
+		return
+	}
+
+	s.state.Lock()
+	var target interface{}
+	if args.VariablesReference >= 1 && args.VariablesReference <= len(s.refs) {
+		target = s.refs[args.VariablesReference-1]
+	}
+	s.state.Unlock()
+
+	variables := []variable{}
+	switch target := target.(type) {
+	case []debugger.Variable:
+		for _, v := range target {
+			variables = append(variables, s.variable(v.Name, v.Value))
+		}
+	case *object.Array:
+		for i, el := range target.Elements {
+			variables = append(variables, s.variable(fmt.Sprintf("[%d]", i), el))
+		}
+	case *object.Hash:
+		for _, pair := range target.Pairs() {
+			variables = append(variables, s.variable(pair.Key.Inspect(), pair.Value))
+		}
+	default:
+		s.fail(req, "no variables %d", args.VariablesReference)
+		return
+	}
+	s.respond(req, map[string]interface{}{"variables": variables})
+}
+
+// variable returns the variable name of value, arrays and hashes have a reference to their
+// elements
+func (s *session) variable(name string, value object.Object) variable {
+	v := variable{Name: name, Value: value.Inspect(), Type: string(value.Type())}
+	switch value := value.(type) {
+	case *object.Array:
+		if len(value.Elements) > 0 {
+			v.VariablesReference = s.reference(value)
+		}
+	case *object.Hash:
+		if len(value.Pairs()) > 0 {
+			v.VariablesReference = s.reference(value)
+		}
+	}
+	return v
+}
+
+// reference returns a variable reference to target, valid until the program carries on
+func (s *session) reference(target interface{}) int {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.refs = append(s.refs, target)
+	return len(s.refs)
+}
+
+func (s *session) toClient(line int) int   { return line - 1 + s.lineBase }
+func (s *session) fromClient(line int) int { return line + 1 - s.lineBase }
+
+// arguments decodes the arguments of req into args, failing req if they're invalid
+func (s *session) arguments(req *message, args interface{}) bool {
+	if len(req.Arguments) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(req.Arguments, args); err != nil {
+		s.fail(req, "invalid arguments: %s", err)
+		return false
+	}
+	return true
+}
+
+func (s *session) respond(req *message, body interface{}) {
+	success := true
+	s.send(&message{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &success, Body: body})
+}
+
+func (s *session) fail(req *message, format string, a ...interface{}) {
+	success := false
+	s.send(&message{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Success:    &success,
+		Message:    fmt.Sprintf(format, a...),
+	})
+}
+
+func (s *session) event(name string, body interface{}) {
+	s.send(&message{Type: "event", Event: name, Body: body})
+}
+
+// send writes msg. A client which can't be written to will stop sending requests, so errors are
+// left to the reading side.
+func (s *session) send(msg *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	writeMessage(s.out, msg)
+}
+
+// output sends what the program writes to the client, as output events of category
+type output struct {
+	s        *session
+	category string
+}
+
+func (o output) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]interface{}{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
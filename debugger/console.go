@@ -0,0 +1,178 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andy9775/monkey/object"
+)
+
+// PROMPT is the prompt of the console
+const PROMPT = "(debug) "
+
+// listContext is the number of lines list shows around the current one
+const listContext = 3
+
+const help = `break N (b)     pause at line N
+clear N         remove the breakpoint at line N
+continue (c)    run to the next breakpoint
+step (s)        run to the next statement
+next (n)        run to the next statement of this function
+out (o)         run to the next statement of the caller
+locals          show the locals of this function
+free            show the free variables of this function
+globals         show the globals
+stack           show the operands on the stack, the top first
+backtrace (bt)  show the frames, this one first
+print NAME (p)  show a variable
+list (l)        show the source around this line
+quit (q)        stop the program
+`
+
+// Start debugs source with a console, reading the commands from the input of streams and
+// writing to its output. The program shares the streams with the console.
+func Start(source string, streams *object.IO) error {
+	d, err := New(source, streams)
+	if err != nil {
+		return err
+	}
+
+	c := &console{streams: streams}
+	err = d.Run(c.pause)
+	out := c.out()
+	switch {
+	case err == ErrQuit:
+		return nil
+	case err != nil:
+		if e, ok := err.(*object.Error); ok {
+			fmt.Fprintf(out, "uncaught %s: %s\n", e.Kind, e.Message)
+		} else {
+			fmt.Fprintf(out, "uncaught %s\n", err)
+		}
+	default:
+		if result := d.Result(); result != nil {
+			fmt.Fprintf(out, "program ended: %s\n", result.Inspect())
+		} else {
+			fmt.Fprintln(out, "program ended")
+		}
+	}
+	return nil
+}
+
+type console struct {
+	streams *object.IO
+}
+
+// pause shows where the program is and runs commands until one carries on with the program
+func (c *console) pause(d *Debugger) Action {
+	out := c.out()
+	fmt.Fprintf(out, "%d\t%s\n", d.Line(), d.Source(d.Line()))
+
+	for {
+		fmt.Fprint(out, PROMPT)
+		line, err := c.streams.ReadLine()
+		if err != nil {
+			return Quit
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if action, ok := c.command(d, fields[0], fields[1:]); ok {
+			return action
+		}
+	}
+}
+
+// command runs the command name, returning the action if it carries on with the program
+func (c *console) command(d *Debugger, name string, args []string) (Action, bool) {
+	out := c.out()
+
+	switch name {
+	case "continue", "c":
+		return Continue, true
+	case "step", "s":
+		return StepIn, true
+	case "next", "n":
+		return StepOver, true
+	case "out", "o":
+		return StepOut, true
+	case "quit", "q":
+		return Quit, true
+	case "break", "b", "clear":
+		if len(args) == 0 {
+			fmt.Fprintf(out, "breakpoints: %v\n", d.Breakpoints())
+			break
+		}
+		line, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(out, "not a line: %s\n", args[0])
+			break
+		}
+		if name == "clear" {
+			d.Clear(line)
+			break
+		}
+		if err := d.Break(line); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	case "locals":
+		printVariables(out, d.Locals())
+	case "free":
+		printVariables(out, d.Free())
+	case "globals":
+		printVariables(out, d.Globals())
+	case "stack":
+		stack := d.Stack()
+		for i := len(stack) - 1; i >= 0; i-- {
+			fmt.Fprintln(out, stack[i].Inspect())
+		}
+	case "backtrace", "bt":
+		for i, location := range d.Backtrace() {
+			fmt.Fprintf(out, "#%d %s at line %d\n", i, location.Name(), location.Line)
+		}
+	case "print", "p":
+		if len(args) == 0 {
+			fmt.Fprintln(out, "print needs a name")
+			break
+		}
+		if value, ok := d.Lookup(args[0]); ok {
+			fmt.Fprintln(out, value.Inspect())
+		} else {
+			fmt.Fprintf(out, "no variable %s\n", args[0])
+		}
+	case "list", "l":
+		from, to := d.Line()-listContext, d.Line()+listContext
+		for line := from; line <= to; line++ {
+			if line < 1 || line > d.NumLines() {
+				continue
+			}
+			marker := " "
+			if line == d.Line() {
+				marker = ">"
+			}
+			fmt.Fprintf(out, "%s %d\t%s\n", marker, line, d.Source(line))
+		}
+	case "help", "h":
+		fmt.Fprint(out, help)
+	default:
+		fmt.Fprintf(out, "unknown command %s, try help\n", name)
+	}
+	return Continue, false
+}
+
+func (c *console) out() io.Writer {
+	if c.streams.Stdout == nil {
+		return io.Discard
+	}
+	return c.streams.Stdout
+}
+
+func printVariables(out io.Writer, vars []Variable) {
+	for _, v := range vars {
+		fmt.Fprintf(out, "%s = %s\n", v.Name, v.Value.Inspect())
+	}
+}
@@ -0,0 +1,324 @@
+// Package debugger runs monkey programs in the vm one statement at a time. The program pauses at
+// breakpoints (set by source line) and after steps, and while it's paused its frames, variables
+// and stack can be inspected. See console.go for the command prompt of `monkey debug`.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/vm"
+)
+
+// ErrQuit is returned by Run when the program was stopped with Quit
+var ErrQuit = errors.New("debugger quit")
+
+// Action is how the program carries on after a pause
+type Action int
+
+const (
+	// Continue runs the program up to the next breakpoint
+	Continue Action = iota
+	// StepIn pauses at the next statement, in whichever function it is
+	StepIn
+	// StepOver pauses at the next statement of the current function, or of its callers once it
+	// returns
+	StepOver
+	// StepOut pauses at the next statement of the callers of the current function
+	StepOut
+	// Quit stops the program
+	Quit
+)
+
+// Pause is called whenever the program pauses, and returns how it carries on. The program can be
+// inspected (and breakpoints changed) until it returns.
+type Pause func(d *Debugger) Action
+
+// Variable is a named value of the program
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Location is where a frame of the program is, see Backtrace
+type Location struct {
+	Function string // the name of the function, "" for anonymous functions and the main program
+	Main     bool   // whether the frame is the main program's
+	Line     int
+}
+
+// Name returns the name the frame is shown with
+func (l Location) Name() string {
+	switch {
+	case l.Main:
+		return "main program"
+	case l.Function == "":
+		return "anonymous function"
+	}
+	return l.Function
+}
+
+// Debugger runs a program and pauses it
+type Debugger struct {
+	source   []string
+	bytecode *compiler.Bytecode
+	machine  *vm.VM
+
+	statements map[int]bool // the lines statements start on
+
+	// breakpoints can be changed while the program runs, and interrupt set to pause it at the
+	// next statement (see Interrupt)
+	mu          sync.Mutex
+	breakpoints map[int]bool
+	interrupt   int32
+
+	// action is how the program carries on after the last pause, at depth frames
+	action Action
+	depth  int
+
+	// lines holds the line of the last statement started by each frame, statements are only
+	// paused at when they start a new line
+	lines []int
+	line  int // the line the program is paused at
+
+	pause Pause
+}
+
+// New compiles source for debugging (without optimizations, so the program runs the way it's
+// written). The program uses streams as its IO.
+func New(source string, streams *object.IO) (*Debugger, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, fmt.Errorf("compilation failed: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	d := &Debugger{
+		source:      strings.Split(strings.TrimSuffix(source, "\n"), "\n"),
+		bytecode:    bytecode,
+		machine:     vm.New(bytecode),
+		statements:  map[int]bool{},
+		breakpoints: map[int]bool{},
+		action:      StepIn, // pause at the first statement
+	}
+	d.machine.SetIO(streams)
+	d.machine.SetHook(d.hook)
+
+	d.addStatements(bytecode.Lines)
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			d.addStatements(fn.Lines)
+		}
+	}
+
+	return d, nil
+}
+
+func (d *Debugger) addStatements(lines code.LineTable) {
+	for _, entry := range lines {
+		if entry.Statement {
+			d.statements[entry.Line] = true
+		}
+	}
+}
+
+// Run runs the program, calling pause whenever it pauses. It returns the *object.Error which
+// ended the program, ErrQuit if it was stopped, nil if it ran to its end.
+func (d *Debugger) Run(pause Pause) error {
+	d.pause = pause
+	return d.machine.Run()
+}
+
+// Result returns the value of the last expression statement the program ran
+func (d *Debugger) Result() object.Object {
+	return d.machine.LastPoppedStackElem()
+}
+
+// hook decides whether to pause before each instruction
+func (d *Debugger) hook() error {
+	fn, ip, depth := d.machine.Position()
+	line, ok := fn.Lines.StatementAt(ip)
+	if !ok {
+		return nil
+	}
+
+	for len(d.lines) <= depth {
+		d.lines = append(d.lines, 0)
+	}
+	if ip != 0 && d.lines[depth] == line {
+		return nil // another statement of the line being run
+	}
+	d.lines[depth] = line
+
+	interrupted := atomic.SwapInt32(&d.interrupt, 0) == 1
+	switch {
+	case interrupted:
+	case d.atBreakpoint(line):
+	case d.action == StepIn:
+	case d.action == StepOver && depth <= d.depth:
+	case d.action == StepOut && depth < d.depth:
+	default:
+		return nil
+	}
+
+	d.line = line
+	action := d.pause(d)
+	if action == Quit {
+		return ErrQuit
+	}
+	d.action, d.depth = action, depth
+	return nil
+}
+
+// Interrupt pauses the program at the next statement it starts. Unlike the other methods it
+// can be called while the program runs, from any goroutine.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interrupt, 1)
+}
+
+// Break sets a breakpoint at line, which must have a statement start on it. Breakpoints can be
+// changed while the program runs, from any goroutine.
+func (d *Debugger) Break(line int) error {
+	if !d.statements[line] {
+		return fmt.Errorf("no statement starts on line %d", line)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[line] = true
+	return nil
+}
+
+// Clear removes the breakpoint at line
+func (d *Debugger) Clear(line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.breakpoints, line)
+}
+
+func (d *Debugger) atBreakpoint(line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.breakpoints[line]
+}
+
+// Breakpoints returns the lines of the breakpoints, in order
+func (d *Debugger) Breakpoints() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Line returns the line the program is paused at
+func (d *Debugger) Line() int {
+	return d.line
+}
+
+// Source returns the text of line, "" for lines past the end of the source
+func (d *Debugger) Source(line int) string {
+	if line < 1 || line > len(d.source) {
+		return ""
+	}
+	return strings.TrimSuffix(d.source[line-1], "\r")
+}
+
+// NumLines returns the number of lines of the source
+func (d *Debugger) NumLines() int {
+	return len(d.source)
+}
+
+// Frame is a frame of the program, see Frames
+type Frame struct {
+	Location
+	Locals []Variable // those which are set
+	Free   []Variable
+}
+
+// Frames returns the frames of the program, the current one first
+func (d *Debugger) Frames() []Frame {
+	infos := d.machine.Frames()
+	frames := make([]Frame, len(infos))
+	for i, info := range infos {
+		frames[len(infos)-1-i] = Frame{
+			Location: Location{
+				Function: info.Fn.Name,
+				Main:     i == 0,
+				Line:     info.Fn.Lines.LineAt(info.IP),
+			},
+			Locals: variables(info.Fn.LocalNames, info.Locals),
+			Free:   variables(info.Fn.FreeNames, info.Free),
+		}
+	}
+	return frames
+}
+
+// Locals returns the locals of the current function which are set, in the order they're defined
+func (d *Debugger) Locals() []Variable {
+	return d.Frames()[0].Locals
+}
+
+// Free returns the free variables of the current function
+func (d *Debugger) Free() []Variable {
+	return d.Frames()[0].Free
+}
+
+// Globals returns the globals of the program which are set, in the order they're defined
+func (d *Debugger) Globals() []Variable {
+	return variables(d.bytecode.GlobalNames, d.machine.Globals())
+}
+
+func variables(names []string, values []object.Object) []Variable {
+	vars := []Variable{}
+	for i, name := range names {
+		if i < len(values) && values[i] != nil {
+			vars = append(vars, Variable{Name: name, Value: values[i]})
+		}
+	}
+	return vars
+}
+
+// Lookup returns the value of the variable name as the current function sees it
+func (d *Debugger) Lookup(name string) (object.Object, bool) {
+	for _, vars := range [][]Variable{d.Locals(), d.Free(), d.Globals()} {
+		for _, v := range vars {
+			if v.Name == name {
+				return v.Value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Stack returns the operands of the current function on the stack, the top last
+func (d *Debugger) Stack() []object.Object {
+	return d.machine.Stack()
+}
+
+// Backtrace returns where the frames of the program are, the current one first
+func (d *Debugger) Backtrace() []Location {
+	frames := d.Frames()
+	locations := make([]Location, len(frames))
+	for i, frame := range frames {
+		locations[i] = frame.Location
+	}
+	return locations
+}
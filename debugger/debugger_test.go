@@ -0,0 +1,236 @@
+package debugger_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/debugger"
+	"github.com/andy9775/monkey/object"
+)
+
+const program = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let twice = fn(x) {
+  add(x, x) * 1
+};
+let y = twice(2); let z = 1;
+puts(y);
+y`
+
+// pauses runs program with the actions, returning the lines it paused at
+func pauses(t *testing.T, breakpoints []int, actions ...debugger.Action) ([]int, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	d, err := debugger.New(program, object.NewIO(nil, &out, &out))
+	if err != nil {
+		t.Fatalf("debugger error: %s", err)
+	}
+	for _, line := range breakpoints {
+		if err := d.Break(line); err != nil {
+			t.Fatalf("break error: %s", err)
+		}
+	}
+
+	lines := []int{}
+	err = d.Run(func(d *debugger.Debugger) debugger.Action {
+		lines = append(lines, d.Line())
+		if len(actions) == 0 {
+			return debugger.Continue
+		}
+		action := actions[0]
+		actions = actions[1:]
+		return action
+	})
+	return lines, err
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		name          string
+		breakpoints   []int
+		actions       []debugger.Action
+		expectedLines []int
+	}{
+		{"continue", nil, nil, []int{1}},
+		{"step", nil, []debugger.Action{
+			debugger.StepIn, debugger.StepIn, debugger.StepIn, debugger.StepIn, debugger.StepIn,
+			debugger.StepIn, debugger.StepIn, debugger.StepIn, debugger.StepIn,
+		}, []int{1, 5, 8, 6, 2, 3, 9, 10}},
+		{"next", nil, []debugger.Action{
+			debugger.StepOver, debugger.StepOver, debugger.StepOver, debugger.StepOver, debugger.StepOver,
+		}, []int{1, 5, 8, 9, 10}},
+		{"next in a function", []int{2}, []debugger.Action{
+			debugger.Continue, debugger.StepOver, debugger.StepOver, debugger.StepOver,
+		}, []int{1, 2, 3, 9, 10}},
+		{"out", []int{2}, []debugger.Action{
+			debugger.Continue, debugger.StepOut, debugger.StepOut,
+		}, []int{1, 2, 9}},
+		{"breakpoints", []int{3, 9}, nil, []int{1, 3, 9}},
+	}
+
+	for _, tt := range tests {
+		lines, err := pauses(t, tt.breakpoints, tt.actions...)
+		if err != nil {
+			t.Errorf("%s: run error: %s", tt.name, err)
+		}
+		if fmt.Sprint(lines) != fmt.Sprint(tt.expectedLines) {
+			t.Errorf("%s: paused at the wrong lines. want=%v, got=%v", tt.name, tt.expectedLines, lines)
+		}
+	}
+}
+
+func TestQuit(t *testing.T) {
+	lines, err := pauses(t, nil, debugger.StepIn, debugger.Quit)
+	if err != debugger.ErrQuit {
+		t.Errorf("wrong error. want=%v, got=%v", debugger.ErrQuit, err)
+	}
+	if fmt.Sprint(lines) != "[1 5]" {
+		t.Errorf("paused at the wrong lines. want=[1 5], got=%v", lines)
+	}
+}
+
+func TestBreakErrors(t *testing.T) {
+	d, err := debugger.New(program, object.NewIO(nil, nil, nil))
+	if err != nil {
+		t.Fatalf("debugger error: %s", err)
+	}
+
+	for _, line := range []int{0, 4, 7, 11} {
+		if err := d.Break(line); err == nil {
+			t.Errorf("break at line %d didn't fail", line)
+		}
+	}
+	if len(d.Breakpoints()) != 0 {
+		t.Errorf("breakpoints set. got=%v", d.Breakpoints())
+	}
+}
+
+func TestInspection(t *testing.T) {
+	d, err := debugger.New(program, object.NewIO(nil, nil, nil))
+	if err != nil {
+		t.Fatalf("debugger error: %s", err)
+	}
+	if err := d.Break(3); err != nil {
+		t.Fatalf("break error: %s", err)
+	}
+
+	var locals, globals, backtrace string
+	var sum object.Object
+	err = d.Run(func(d *debugger.Debugger) debugger.Action {
+		if d.Line() != 3 {
+			return debugger.Continue
+		}
+		locals = render(d.Locals())
+		globals = render(d.Globals())
+		backtrace = fmt.Sprint(d.Backtrace())
+		sum, _ = d.Lookup("sum")
+		return debugger.Continue
+	})
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	if locals != "a=2 b=2 sum=4" {
+		t.Errorf("locals wrong. got=%q", locals)
+	}
+	// y isn't set until twice returns
+	if !strings.HasPrefix(globals, "add=Closure") || !strings.Contains(globals, "twice=Closure") ||
+		strings.Contains(globals, "y=") {
+		t.Errorf("globals wrong. got=%q", globals)
+	}
+	if backtrace != "[{add false 3} {twice false 6} { true 8}]" {
+		t.Errorf("backtrace wrong. got=%q", backtrace)
+	}
+	if sum == nil || sum.Inspect() != "4" {
+		t.Errorf("sum wrong. got=%v", sum)
+	}
+}
+
+func TestFree(t *testing.T) {
+	input := `let adder = fn(a) {
+  fn(b) {
+    a + b
+  }
+};
+adder(1)(2)`
+
+	d, err := debugger.New(input, object.NewIO(nil, nil, nil))
+	if err != nil {
+		t.Fatalf("debugger error: %s", err)
+	}
+	if err := d.Break(3); err != nil {
+		t.Fatalf("break error: %s", err)
+	}
+
+	var free, locals string
+	err = d.Run(func(d *debugger.Debugger) debugger.Action {
+		if d.Line() == 3 {
+			free, locals = render(d.Free()), render(d.Locals())
+		}
+		return debugger.Continue
+	})
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if free != "a=1" || locals != "b=2" {
+		t.Errorf("variables wrong. want free=%q locals=%q, got free=%q locals=%q", "a=1", "b=2", free, locals)
+	}
+}
+
+func TestUncaught(t *testing.T) {
+	d, err := debugger.New("let a = 1;\nthrow \"no\";\na", object.NewIO(nil, nil, nil))
+	if err != nil {
+		t.Fatalf("debugger error: %s", err)
+	}
+
+	err = d.Run(func(d *debugger.Debugger) debugger.Action { return debugger.StepIn })
+	e, ok := err.(*object.Error)
+	if !ok {
+		t.Fatalf("wrong error. want *object.Error, got=%T (%v)", err, err)
+	}
+	if e.Message != "no" {
+		t.Errorf("wrong message. want=%q, got=%q", "no", e.Message)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	commands := "b 3\nc\nlocals\nbt\np y\nstack\nout\nglobals\nl\nbogus\nn\nc\n"
+	var out bytes.Buffer
+	if err := debugger.Start(program, object.NewIO(strings.NewReader(commands), &out, &out)); err != nil {
+		t.Fatalf("debugger error: %s", err)
+	}
+
+	expected := []string{
+		"1\tlet add = fn(a, b) {",
+		"(debug) (debug) 3\t  sum",
+		"(debug) a = 2\nb = 2\nsum = 4",
+		"(debug) #0 add at line 3\n#1 twice at line 6\n#2 main program at line 8",
+		"(debug) no variable y",
+		"(debug) (debug) 9\tputs(y);",
+		"(debug) add = ",
+		"y = 4",
+		"(debug)   6\t  add(x, x) * 1\n  7\t};\n  8\tlet y = twice(2); let z = 1;\n> 9\tputs(y);\n  10\ty",
+		"(debug) unknown command bogus, try help",
+		"(debug) 4\n10\ty",
+		"(debug) program ended: 4",
+	}
+	got := out.String()
+	for _, want := range expected {
+		if !strings.Contains(got, want) {
+			t.Errorf("output doesn't contain %q. got=\n%s", want, got)
+		}
+	}
+}
+
+func render(vars []debugger.Variable) string {
+	parts := make([]string, len(vars))
+	for i, v := range vars {
+		parts[i] = v.Name + "=" + v.Value.Inspect()
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,31 +1,26 @@
 package evaluator
 
 import (
-	"fmt"
-
 	"github.com/andy9775/monkey/ast"
 	"github.com/andy9775/monkey/object"
 )
 
 // Create a single instance of the following objects as a performence optimization
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NullValue
+	TRUE  = object.True
+	FALSE = object.False
 )
 
-var builtins = map[string]*object.Builtin{
-	// len returns an integer object which represents the length of a string or array
-	"len": object.GetBuiltinByName("len"),
-	// first returns an object representing the first element of an array object
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-
-	"rest": object.GetBuiltinByName("rest"),
-	// push returns a new array object since arrays are immutable in monkey
-	"push": object.GetBuiltinByName("push"),
+// builtins are the builtin functions by name, the same as the vms' (see object.Builtins)
+var builtins = builtinsByName()
 
-	"puts": object.GetBuiltinByName("puts"),
+func builtinsByName() map[string]*object.Builtin {
+	byName := map[string]*object.Builtin{}
+	for _, b := range object.Builtins {
+		byName[b.Name] = b.Builtin
+	}
+	return byName
 }
 
 // Eval takes in an AST node, determines it's type and returns the
@@ -35,14 +30,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Program: // evaluate the statements
 		return evalProgram(node.Statements, env)
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
@@ -55,28 +50,28 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			note: for higher order functions, the inner functions environment is that of the outer function
 			This allows for closures - functions close over their environment and carry it with them
 		*/
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Env: env.Capture(), Body: body}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return applyFunction(function, args, env)
 
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
@@ -85,14 +80,23 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		// a returned call is always in tail position
+		val := evalTailExpression(node.ReturnValue, env)
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return &object.Exception{Err: object.ToError(val)}
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
@@ -103,24 +107,66 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 
 		index := Eval(node.Index, env)
-		if isError((index)) {
+		if isAbrupt(index) {
 			return index
 		}
 
 		return evalIndexExpression(left, index)
 
+	case *ast.SliceExpression:
+		left := Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+
+		// a bound which is left out stays nil
+		bounds := make([]object.Object, 2)
+		for i, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				continue
+			}
+			bounds[i] = Eval(bound, env)
+			if isAbrupt(bounds[i]) {
+				return bounds[i]
+			}
+		}
+
+		result, err := object.Slice(left, bounds[0], bounds[1])
+		if err != nil {
+			return &object.Exception{Err: err}
+		}
+		return result
+
+	case *ast.AssignExpression:
+		left := Eval(node.Target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+
+		index := Eval(node.Target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+
+		value := Eval(node.Value, env)
+		if isAbrupt(value) {
+			return value
+		}
+
+		return evalAssignExpression(left, index, value)
+
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	}
@@ -128,15 +174,32 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	return nil
 }
 
+// Run evaluates program like Eval, but reports an error escaping the program as an error rather
+// than as its result (the way vm.Machine does)
+func Run(program *ast.Program, env *object.Environment) (object.Object, error) {
+	result := runProgram(program.Statements, env)
+	if exception, ok := result.(*object.Exception); ok {
+		return nil, exception.Err
+	}
+
+	return result, nil
+}
+
 func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
+	// an uncaught error is the result of the program
+	return unwrapException(runProgram(stmts, env))
+}
+
+func runProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range stmts {
 		result = Eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue: // we've hit a return value
-			return result.Value
-		case *object.Error: // we've hit an error
+			// `return f(x)` at the top level
+			return applyTailCall(result.Value, env)
+		case *object.Exception: // we've hit an uncaught error
 			return result
 		}
 	}
@@ -154,14 +217,20 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 			till we get to the outermost block statement where it is unwrapped.
 			If we get a return value, stop evaluating the statements.
 		*/
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
-			}
+		if isAbrupt(result) {
+			return result
 		}
 	}
 
+	return valueOf(result)
+}
+
+// valueOf returns the value of a block: blocks which don't end in an expression are null
+func valueOf(result object.Object) object.Object {
+	if result == nil {
+		return NULL
+	}
+
 	return result
 }
 
@@ -172,7 +241,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.TypeErrorKind, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -191,11 +260,11 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+		return newError(object.TypeErrorKind, "unknown operator: -%s", right.Type())
 	}
 
 	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NewInteger(-value)
 }
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
@@ -216,20 +285,34 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TypeErrorKind, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TypeErrorKind, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newError(object.TypeErrorKind, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
@@ -249,22 +332,25 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.NewInteger(leftVal + rightVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return object.NewInteger(leftVal - rightVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return object.NewInteger(leftVal * rightVal)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError(object.RuntimeErrorKind, "division by zero")
+		}
+		return object.NewInteger(leftVal / rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TypeErrorKind, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 
 	}
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env) // should be truthy or false
-	if isError(condition) {              // error occurred evaluating the statement condition
+	if isAbrupt(condition) {             // error occurred evaluating the statement condition
 		return condition
 	}
 
@@ -302,7 +388,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError(object.NameErrorKind, "identifier not found: %s", node.Value)
 }
 
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
@@ -310,7 +396,7 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 
 	for _, e := range exps {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -319,36 +405,194 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	switch fn := fn.(type) {
-	case *object.Function: // user defined function
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+// MaxCalls is the number of calls which can run at once, the same as in the vms (whose main
+// program takes one of their vm.MaxFrames frames)
+const MaxCalls = 1023
+
+// applyFunction is a trampoline: calls in tail position come back as an *object.TailCall which
+// is applied by the next iteration of the loop instead of recursing, so tail recursive
+// functions run in constant go stack space. env is the environment of the call, whose streams
+// builtins use.
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	// tail calls take the place of the call, like they take its frame in the vms
+	calls := env.Calls() + 1
+
+	for {
+		switch f := fn.(type) {
+		case *object.Function: // user defined function
+			if len(args) != len(f.Parameters) {
+				return newError(object.ArgumentErrorKind, "wrong number of arguments: want=%d, got=%d",
+					len(f.Parameters), len(args))
+			}
+			if calls > MaxCalls {
+				return newError(object.RuntimeErrorKind, "stack overflow")
+			}
 
-		/*
-			Must unwrap the return, otherwise if we paseed up a return statement, it would stop evaluation
-			and bubble up to the original caller - therefore we need to evaluate the value of the return.
-			We only want to stop the evaluation of the last called function's body. This prvents
-			evalBlockStatement from stopping evaluation of further statements
-		*/
-		return unwrapReturnValue(evaluated)
-	case *object.Builtin: // built in function
-		// note that builtins never return an *object.ReturnValue so no need to unwrap
-		if result := fn.Fn(args...); result != nil {
+			extendedEnv := extendFunctionEnv(f, args)
+			extendedEnv.SetCalls(calls)
+			evaluated := evalTailBlock(f.Body, extendedEnv)
+
+			/*
+				Must unwrap the return, otherwise if we paseed up a return statement, it would stop evaluation
+				and bubble up to the original caller - therefore we need to evaluate the value of the return.
+				We only want to stop the evaluation of the last called function's body. This prvents
+				evalBlockStatement from stopping evaluation of further statements
+			*/
+			result := unwrapReturnValue(evaluated)
+
+			if tc, ok := result.(*object.TailCall); ok {
+				fn, args, env = tc.Fn, tc.Args, extendedEnv
+				continue
+			}
+			if exception, ok := result.(*object.Exception); ok {
+				exception.Err.AddTrace(f.Name)
+			}
 			return result
+		case *object.Builtin: // built in function
+			// note that builtins never return an *object.ReturnValue so no need to unwrap, errors
+			// they throw come back as an *object.Exception
+			if result := f.Fn(builtinRuntime{env}, args...); result != nil {
+				return result
+			}
+
+			return NULL
+		default:
+			return newError(object.TypeErrorKind, "not a function: %s", fn.Type())
 		}
+	}
+}
+
+// builtinRuntime lets builtins call functions and use the streams of the program (see
+// object.Runtime)
+type builtinRuntime struct {
+	env *object.Environment
+}
 
+func (rt builtinRuntime) Call(fn object.Object, args ...object.Object) (object.Object, *object.Error) {
+	result := applyFunction(fn, args, rt.env)
+	if exception, ok := result.(*object.Exception); ok {
+		return nil, exception.Err
+	}
+	return result, nil
+}
+
+func (rt builtinRuntime) IO() *object.IO {
+	return rt.env.IO()
+}
+
+// evalTailBlock evaluates a function body. It behaves like evalBlockStatement except that the
+// last statement is in tail position.
+func evalTailBlock(block *ast.BlockStatement, env *object.Environment) object.Object {
+	var result object.Object
+
+	for i, statement := range block.Statements {
+		if es, ok := statement.(*ast.ExpressionStatement); ok && i == len(block.Statements)-1 {
+			return evalTailExpression(es.Expression, env)
+		}
+
+		result = Eval(statement, env)
+		if isAbrupt(result) {
+			return result
+		}
+	}
+
+	return valueOf(result)
+}
+
+// evalTailExpression evaluates an expression in tail position. Calls are not applied but
+// returned as an *object.TailCall for applyFunction to pick up; if expressions pass the
+// tail position on to their branches.
+func evalTailExpression(node ast.Expression, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		function := Eval(node.Function, env)
+		if isAbrupt(function) {
+			return function
+		}
+
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
+			return args[0]
+		}
+
+		return &object.TailCall{Fn: function, Args: args}
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+
+		if isTruthy(condition) {
+			return evalTailBlock(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTailBlock(node.Alternative, env)
+		}
 		return NULL
 	default:
-		return newError("not a function: %s", fn.Type())
+		return Eval(node, env)
+	}
+}
+
+// evalTryExpression evaluates the try block, the catch block if the try block threw and then the
+// finally block. A return or throw in the finally block overrides the result of the others.
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := forceTailCall(Eval(te.Block, env), env)
+
+	if exception, ok := result.(*object.Exception); ok && te.Catch != nil {
+		if te.CatchName != nil {
+			env.Set(te.CatchName.Value, exception.Err)
+		}
+		result = forceTailCall(Eval(te.Catch, env), env)
+	}
+
+	if te.Finally != nil {
+		finally := Eval(te.Finally, env)
+		if isAbrupt(finally) {
+			return finally
+		}
 	}
 
+	if result == nil { // empty blocks
+		return NULL
+	}
+	return result
+}
+
+// forceTailCall applies a `return f(x)` coming out of a try or catch block right away, so an
+// error thrown by the call is still caught and the finally block runs after it.
+func forceTailCall(obj object.Object, env *object.Environment) object.Object {
+	returnValue, ok := obj.(*object.ReturnValue)
+	if !ok {
+		return obj
+	}
+	if _, ok := returnValue.Value.(*object.TailCall); !ok {
+		return obj
+	}
+
+	result := applyTailCall(returnValue.Value, env)
+	if isAbrupt(result) {
+		return result
+	}
+	return &object.ReturnValue{Value: result}
+}
+
+// applyTailCall applies obj if it's an *object.TailCall
+func applyTailCall(obj object.Object, env *object.Environment) object.Object {
+	if tc, ok := obj.(*object.TailCall); ok {
+		return applyFunction(tc.Fn, tc.Args, env)
+	}
+	return obj
 }
 
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	/* when fn was evaluated, it was provided with an environment */
 	env := object.NewEnclosedEnvironment(fn.Env)
 
+	// a function bound by a let refers to itself by its name, unless a parameter hides it (the
+	// name may have been bound to something else since fn was defined)
+	if fn.Name != "" {
+		env.Set(fn.Name, fn)
+	}
 	for paranIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paranIdx])
 	}
@@ -366,69 +610,84 @@ func unwrapReturnValue(obj object.Object) object.Object {
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return evalArrayIndexExpression(left, index)
+	case (left.Type() == object.ARRAY_OBJ || left.Type() == object.STRING_OBJ) && index.Type() == object.INTEGER_OBJ:
+		if element, ok := object.Index(left, index.(*object.Integer).Value); ok {
+			return element
+		}
+		return NULL
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR_OBJ && index.Type() == object.STRING_OBJ:
+		if field := left.(*object.Error).Field(index.(*object.String).Value); field != nil {
+			return field
+		}
+		return NULL
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(object.TypeErrorKind, "index operator not supported: %s", left.Type())
 	}
 }
 
-func evalArrayIndexExpression(array, index object.Object) object.Object {
-	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
-
-	if idx < 0 || idx > max {
-		return NULL
+// evalAssignExpression sets the element of an array, the only data structure which can be
+// changed
+func evalAssignExpression(left, index, value object.Object) object.Object {
+	array, ok := left.(*object.Array)
+	if !ok {
+		return newError(object.TypeErrorKind, "index assignment not supported: %s", left.Type())
+	}
+	i, ok := index.(*object.Integer)
+	if !ok {
+		return newError(object.TypeErrorKind, "array index must be INTEGER, got %s", index.Type())
 	}
 
-	return arrayObject.Elements[idx]
+	if err := array.SetIndex(i.Value, value); err != nil {
+		return &object.Exception{Err: err}
+	}
+	return value
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+	if !object.IsHashable(index) {
+		return newError(object.TypeErrorKind, "unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(index)
 	if !ok { // not found
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
 
 // evalHashLiteral takes a hash literal ast node and converts it to a hash object as
 // represented by the interpreters object system
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	// like the vm, evaluate every pair before building the hash
+	pairs := []object.Object{}
 
-	for keyNode, valueNode := range node.Pairs {
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable) // ensure it is a key which may be hashsed
-		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
-		}
-
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := Eval(node.Pairs[keyNode], env)
+		if isAbrupt(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		pairs = append(pairs, key, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	hash := object.NewHash()
+	for i := 0; i < len(pairs); i += 2 {
+		if !hash.Set(pairs[i], pairs[i+1]) { // ensure it is a key which may be hashsed
+			return newError(object.TypeErrorKind, "unusable as hash key: %s", pairs[i].Type())
+		}
+	}
+
+	return hash
 }
 
 // ------------------------ helpers ------------------------
@@ -440,14 +699,27 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return FALSE
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+// newError returns an error of the given kind, thrown
+func newError(kind string, format string, a ...interface{}) *object.Exception {
+	return object.Throw(kind, format, a...)
 }
 
-func isError(obj object.Object) bool {
+// isAbrupt reports whether obj is a thrown error or a return. Both stop the evaluation of the
+// expressions and statements they come out of.
+func isAbrupt(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		t := obj.Type()
+		return t == object.EXCEPTION_OBJ || t == object.RETURN_VALUE_OBJ
 	}
 
 	return false
 }
+
+// unwrapException returns the error of an uncaught exception
+func unwrapException(obj object.Object) object.Object {
+	if exception, ok := obj.(*object.Exception); ok {
+		return exception.Err
+	}
+
+	return obj
+}
@@ -1,6 +1,9 @@
 package evaluator_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/andy9775/monkey/evaluator"
@@ -88,6 +91,14 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`("mon" + "key") == "monkey"`, true},
+		{`"a" < "b"`, true},
+		{`"ab" > "a"`, true},
+		{`"a" <= "a"`, true},
+		{`"a" >= "b"`, false},
 	}
 
 	for _, tt := range tests {
@@ -140,6 +151,8 @@ func TestIfElseExpression(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (true) {}", nil},
+		{"if (true) { let x = 10; }", nil},
 	}
 
 	for _, tt := range tests {
@@ -177,6 +190,8 @@ func TestReturnStatements(t *testing.T) {
 			}
 			return 1;
 		}`, 10},
+		{"let f = fn() { let x = if (true) { return 10; }; 1 }; f()", 10},
+		{"let f = fn() { [1, if (true) { return 10; }] }; f()", 10},
 	}
 
 	for _, tt := range tests {
@@ -228,7 +243,13 @@ func TestErrorHandling(t *testing.T) {
 		{"foobar", "identifier not found: foobar"},
 		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
 		{`{"name": "Monkey"}[fn(x) {x}]`, "unusable as hash key: FUNCTION"},
+		{`{[1, fn(x) {x}]: 1}`, "unusable as hash key: ARRAY"},
 		{`10 >= "hello"`, "type mismatch: INTEGER >= STRING"},
+		{"fn(x) { x }()", "wrong number of arguments: want=1, got=0"},
+		{"fn() { 1 }(1, 2)", "wrong number of arguments: want=0, got=2"},
+		{`{fn() {}: 1, "key": len()}`, "wrong number of arguments. got=0, want=1"},
+		{"1 / 0", "division by zero"},
+		{`[1]["a"]`, "index operator not supported: ARRAY"},
 	}
 
 	for _, tt := range tests {
@@ -246,6 +267,106 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{} // an *object.Error is expected to be thrown and not caught
+	}{
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw 1 } catch { 2 }`, 2},
+		{`try { throw [1] } catch (e) { e["message"] }`, "[1]"},
+		{`try { throw "boom" } catch (e) { e["kind"] }`, "Error"},
+		{`try { throw "boom" } catch (e) { e["unknown"] }`, nil},
+		{`try { 1 + "a" } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { foobar } catch (e) { e["kind"] }`, "NameError"},
+		{`try { len(1) } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { len() } catch (e) { e["kind"] }`, "ArgumentError"},
+		{`try { } catch (e) { 1 }`, nil},
+		{`let e = error("bad", "ValueError"); e["kind"]`, "ValueError"},
+		{
+			`let f = fn(n) { if (n == 0) { throw "bottom" } 1 + f(n - 1) };
+			let r = [1, 2 * try { f(10) } catch (e) { 3 }];
+			r[0] + r[1]`,
+			7,
+		},
+		{
+			`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`,
+			"ab",
+		},
+		{
+			`let g = fn() { throw "g" }; let f = fn() { try { return g() } catch (e) { "caught" } }; f()`,
+			"caught",
+		},
+		{
+			`let inner = fn() { throw "x" }; let outer = fn() { inner() + 1 };
+			try { outer() } catch (e) { e["trace"][0] + " " + e["trace"][1] }`,
+			"inner outer",
+		},
+		{
+			`let inner = fn() { throw "x" }; let outer = fn() { inner() };
+			try { outer() } catch (e) { len(e["trace"]) }`,
+			1,
+		},
+		{
+			`let e = try { fn() { throw "x" }() } catch (e) { e }; e["trace"][0]`,
+			"<anonymous>",
+		},
+		{`try { 5 } finally { 6 }`, 5},
+		{`try { throw "a" } catch (e) { 1 } finally { let done = true; }; done`, true},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn() { try { throw "a" } finally { return "finally" } }; f()`, "finally"},
+		{`let f = fn() { try { throw "x" } catch (e) { return 1 } finally { 2 } }; f()`, 1},
+		{`try { try { throw "a" } finally { throw "b" } } catch (e) { e["message"] }`, "b"},
+		{
+			`let f = fn() { try { return 1 } catch (e) { 2 } finally { throw "f" } };
+			try { f() } catch (e) { e["message"] }`,
+			"f",
+		},
+		{`throw "oops"`, &object.Error{Message: "oops", Kind: "Error"}},
+		{
+			`let f = fn() { throw error("x") }; let g = fn() { f() + 1 }; g()`,
+			&object.Error{Message: "x", Kind: "Error", Trace: []string{"f", "g"}},
+		},
+		{`try { throw "a" } finally { 1 }`, &object.Error{Message: "a", Kind: "Error"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v) for %q", evaluated, evaluated, tt.input)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		case *object.Error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected.Message || errObj.Kind != expected.Kind {
+				t.Errorf("wrong error. expected=%s %q, got=%s %q",
+					expected.Kind, expected.Message, errObj.Kind, errObj.Message)
+			}
+			if strings.Join(errObj.Trace, " ") != strings.Join(expected.Trace, " ") {
+				t.Errorf("wrong trace. expected=%v, got=%v", expected.Trace, errObj.Trace)
+			}
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -303,6 +424,41 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+		let countDown = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				countDown(x - 1);
+			}
+		};
+		countDown(100000);`, 0},
+		{`
+		let sum = fn(x, acc) {
+			if (x == 0) {
+				return acc;
+			}
+			return sum(x - 1, acc + x);
+		};
+		sum(100000, 0);`, 5000050000},
+		{`
+		let isEven = fn(x) { if (x == 0) { 1 } else { isOdd(x - 1) } };
+		let isOdd = fn(x) { if (x == 0) { 0 } else { isEven(x - 1) } };
+		isEven(100001);`, 0},
+		{`let f = fn(arr) { len(arr) }; f([1, 2, 3]) + 1`, 4},
+		{`let f = fn(x) { x * 2 }; return f(21);`, 42},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
    let newAdder = fn(x) {
@@ -310,6 +466,20 @@ func TestClosures(t *testing.T) {
 };
 let addTwo = newAdder(2); addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
+
+	// in a call closures keep the values names had when they were made, as in the vms, while at
+	// the top level they see the names bound since
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let k = fn() { let y = 1; let g = fn() { y }; let y = 2; g() }; k()", 1},
+		{"let y = 1; let g = fn() { y }; let y = 2; g()", 2},
+		{"let k = fn() { let f = fn(n) { if (n < 1) { 7 } else { f(n - 1) } }; let g = f; let f = 0; g(2) }; k()", 7},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
 }
 
 func TestStringLiteral(t *testing.T) {
@@ -372,6 +542,85 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestStringFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`join(["a", "b"], ", ")`, "a, b"},
+		{`trim("  monkey ")`, "monkey"},
+		{`upper("Monkey")`, "MONKEY"},
+		{`lower("Monkey")`, "monkey"},
+		{`contains("monkey", "nke")`, "true"},
+		{`starts_with("monkey", "mon")`, "true"},
+		{`ends_with("monkey", "mon")`, "false"},
+		{`replace("a-b-c", "-", "")`, "abc"},
+		{`index_of("monkey", "key")`, "3"},
+		{`index_of("monkey", "x")`, "-1"},
+		{`substr("monkey", 3)`, "key"},
+		{`substr("monkey", 0, 3)`, "mon"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`chars("héllo")`, "[h, é, l, l, o]"},
+		{`len("héllo")`, "5"},
+		{`index_of("héllo", "l")`, "2"},
+		{`substr("héllo", 1, 2)`, "é"},
+		{`"héllo"[1:2]`, "é"},
+		{`"héllo"[-4]`, "é"},
+		{`format("%s has %d items: %v, 100%%", "cart", 2, [1, "a"])`, "cart has 2 items: [1, a], 100%"},
+		{`join(["a", 1], "")`, "ERROR: elements joined by `join` must be STRING, got INTEGER"},
+		{`substr("abc", 0, 4)`, "ERROR: substring out of range: [0:4] (length 3)"},
+		{`repeat("a", -1)`, "ERROR: count of `repeat` must not be negative, got -1"},
+		{`format("%d", "a")`, "ERROR: %d in format must be given INTEGER, got STRING"},
+		{`format("%s", "a", "b")`, "ERROR: format uses 1 of 2 arguments"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestCollectionFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", "[3, 4]"},
+		{"reduce([1, 2, 3], 10, fn(acc, x) { acc + x })", "16"},
+		{"sort([3, 1, 2])", "[1, 2, 3]"},
+		{`sort(["b", "a"])`, "[a, b]"},
+		{"sort([1, 3, 2], fn(a, b) { b - a })", "[3, 2, 1]"},
+		{"range(3)", "[0, 1, 2]"},
+		{"range(3, 0, -1)", "[3, 2, 1]"},
+		{`zip([1, 2], ["a", "b", "c"])`, "[[1, a], [2, b]]"},
+		{"any([1, 2], fn(x) { x == 2 }) == true", "true"},
+		{"all([1, 2], fn(x) { x == 2 }) == false", "true"},
+		{"find([1, 2, 3], fn(x) { x > 1 })", "2"},
+		{"find([1], fn(x) { false })", "null"},
+		{"flatten([[1], 2, [3, [4]]])", "[1, 2, 3, [4]]"},
+		{"unique([1, 1, [2], [2]])", "[1, [2]]"},
+		{"map([[1], [1, 2]], len)", "[1, 2]"},
+		{"let n = 10; map([1, 2], fn(x) { x + n })", "[11, 12]"},
+		{"let a = [1, 2]; map(a, fn(x) { pop!(a) })", "[2, 1]"},
+		{"map([1], fn(x) { throw \"bad\" })", "ERROR: bad"},
+		{"map([1], fn() { 1 })", "ERROR: wrong number of arguments: want=0, got=1"},
+		{"sort([2, 1], fn(a, b) { true })", "ERROR: comparison function of `sort` must return INTEGER, got BOOLEAN"},
+		{"range(1, 2, 0)", "ERROR: step of `range` must not be 0"},
+		{"map(1, len)", "ERROR: argument to `map` must be ARRAY, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -404,7 +653,9 @@ func TestArrayIndexExpressions(t *testing.T) {
 		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
 		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
 		{"[1, 2, 3][3]", nil},
-		{"[1, 2, 3][-1]", nil},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", nil},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -417,6 +668,189 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{`"abc"[0]`, "a"},
+		{`"abc"[2]`, "c"},
+		{`"abc"[-1]`, "c"},
+		{`"abc"[-3]`, "a"},
+		{`"abc"[3]`, "null"},
+		{`"abc"[-4]`, "null"},
+		{`""[0]`, "null"},
+		{`"abc"["a"]`, "ERROR: index operator not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{`json_stringify({"a": [1, true, "x"], "b": {}})`, `{"a":[1,true,"x"],"b":{}}`},
+		{`json_parse(json_stringify({"a": [1, 2]}))`, "{a: [1, 2]}"},
+		{`json_parse("[1, 2.5, 1e2, null]")`, "[1, 2.5, 100.0, null]"},
+		{`let a = json_parse("[null]"); a[0] == [][0]`, "true"},
+		{`json_parse("[1,")`, "ERROR: invalid JSON at offset 3: unexpected end of JSON input"},
+		{`json_stringify({"f": fn() { 1 }})`, `ERROR: cannot encode a function as JSON (at ["f"])`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	each, err := object.FromGo(func(xs []int, f func(int) int) []int {
+		for i, x := range xs {
+			xs[i] = f(x)
+		}
+		return xs
+	})
+	if err != nil {
+		t.Fatalf("converting each failed: %s", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{`each([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`try { each([1, 2], fn(x) { throw "boom" }) } catch (e) { e["message"] }`, "boom"},
+		{`each(["a"], fn(x) { x })`, "ERROR: cannot convert STRING to int (at argument 1[0])"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("each", each)
+		evaluated := evaluator.Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestIO(t *testing.T) {
+	input := `let f = fn() { print(read_line(), "|") }; puts(read_line(), 1); f(); eprint("e", [2]); read_all()`
+
+	var stdout, stderr strings.Builder
+	env := object.NewEnvironment()
+	env.SetIO(object.NewIO(strings.NewReader("first\r\nsecond\nthe rest"), &stdout, &stderr))
+	evaluated := evaluator.Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+
+	if evaluated.Inspect() != "the rest" {
+		t.Errorf("wrong result. got=%q", evaluated.Inspect())
+	}
+	if stdout.String() != "first\n1\nsecond|" {
+		t.Errorf("wrong stdout. got=%q", stdout.String())
+	}
+	if stderr.String() != "e[2]" {
+		t.Errorf("wrong stderr. got=%q", stderr.String())
+	}
+}
+
+func TestFiles(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "b.txt"), []byte("two"), 0o644)
+	fsys, err := object.DirFS(root)
+	if err != nil {
+		t.Fatalf("DirFS failed: %s", err)
+	}
+
+	input := `write_file("a.txt", "one"); let files = list_dir(); remove_file("a.txt"); [read_file("b.txt"), files, exists("a.txt")]`
+	env := object.NewEnvironment()
+	env.SetIO(&object.IO{FS: fsys})
+	evaluated := evaluator.Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+
+	if evaluated.Inspect() != "[two, [a.txt, b.txt], false]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{"[1, 2, 3][1:2]", "[2]"},
+		{"[1, 2, 3][1:]", "[2, 3]"},
+		{"[1, 2, 3][:2]", "[1, 2]"},
+		{"[1, 2, 3][:]", "[1, 2, 3]"},
+		{"[1, 2, 3][-2:-1]", "[2]"},
+		{"[1, 2, 3][-5:5]", "[1, 2, 3]"},
+		{"[1, 2, 3][2:1]", "[]"},
+		{`"monkey"[3:]`, "key"},
+		{`"monkey"[:-3]`, "mon"},
+		{"let a = [1, 2]; let b = a[:]; b[0] = 3; a", "[1, 2]"},
+		{"1[0:1]", "ERROR: slice operator not supported: INTEGER"},
+		{`[1]["a":]`, "ERROR: slice bounds must be INTEGER, got STRING"},
+		{"[1][x:]", "ERROR: identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestArrayChanges(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2]; a[0] = 3; a[0] + a[1]", 5},
+		{"let a = [1]; a[0] = 2", 2},
+		{"let a = [1]; let b = a; append!(b, 2); len(a)", 2},
+		{"let a = [1, 2, 3]; pop!(a) + len(a)", 5},
+		{"let a = [1, 3]; insert(a, 1, 2); a[1]", 2},
+		{"let a = [1, 2, 3]; remove(a, 0) + a[0]", 3},
+		{"let a = [0]; let inc = fn() { a[0] = a[0] + 1 }; inc(); inc(); a[0]", 2},
+		{"let counter = fn() { let a = [0]; [fn() { a[0] = a[0] + 1 }, fn() { a[0] }] }; let c = counter(); c[0](); c[1]()", 1},
+		{"let a = [1, 2, 3]; a[-1] = 7; a[2]", 7},
+		{"let a = [1, 2, 3]; a[-3] = 7; a[0]", 7},
+		{"let a = [1]; a[1] = 2", "index out of range: 1 (length 1)"},
+		{"let a = [1]; a[-2] = 2", "index out of range: -2 (length 1)"},
+		{"let a = [1]; a[0] = [a]", "cannot put an array into itself"},
+		{"let a = freeze([1]); a[0] = 2", "cannot modify a frozen array"},
+		{"let a = freeze([1]); append!(a, 2)", "cannot modify a frozen array"},
+		{`{}[1] = 2`, "index assignment not supported: HASH"},
+		{`[1]["a"] = 2`, "array index must be INTEGER, got STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
 	{
@@ -434,26 +868,38 @@ func TestHashLiterals(t *testing.T) {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		evaluator.TRUE.HashKey():                   5,
-		evaluator.FALSE.HashKey():                  6,
+	// in source order
+	expected := []struct {
+		key   object.Object
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{evaluator.TRUE, 5},
+		{evaluator.FALSE, 6},
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong number of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong number of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
-		if !ok {
-			t.Errorf("no pair for given key in pairs")
+	for i, pair := range result.Pairs() {
+		if pair.Key.Type() != expected[i].key.Type() || pair.Key.Inspect() != expected[i].key.Inspect() {
+			t.Errorf("wrong key at %d. want=%s, got=%s", i, expected[i].key.Inspect(), pair.Key.Inspect())
 		}
 
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, pair.Value, expected[i].value)
+	}
+}
+
+func TestHashInspectOrder(t *testing.T) {
+	evaluated := testEval(`{"b": 1, "a": 2, [1]: 3, "b": 4}`)
+
+	expected := "{b: 4, a: 2, [1]: 3}"
+	if evaluated.Inspect() != expected {
+		t.Errorf("wrong hash. want=%q, got=%q", expected, evaluated.Inspect())
 	}
 }
 
@@ -473,6 +919,10 @@ func TestHashIndexExpressions(t *testing.T) {
 		{`{5: 5}[5]`, 5},
 		{`{true: 5}[true]`, 5},
 		{`{false: 5}[false]`, 5},
+		{`{[1, 2]: 5}[[1, 2]]`, 5},
+		{`{[1, 2]: 5}[[2, 1]]`, nil},
+		{`{[[1], "a"]: 5}[[[1], "a"]]`, 5},
+		{`{1: 4, 1: 5}[1]`, 5},
 	}
 
 	for _, tt := range tests {
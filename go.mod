@@ -1,3 +1,3 @@
 module github.com/andy9775/monkey
 
-go 1.14
+go 1.18
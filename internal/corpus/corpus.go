@@ -0,0 +1,51 @@
+// Package corpus gives the tests of the other packages the programs of the conformance corpus
+// (conformance/testdata), to seed fuzz tests with and to check against.
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Program is a program of the corpus
+type Program struct {
+	Name   string // the name of its file, without .mk
+	Source string
+}
+
+// Programs returns the programs of the corpus, in the order of their names
+func Programs() ([]Program, error) {
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Join(filepath.Dir(file), "..", "..", "conformance", "testdata")
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.mk"))
+	if err != nil {
+		return nil, err
+	}
+	programs := []Program{}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		programs = append(programs, Program{Name: strings.TrimSuffix(filepath.Base(path), ".mk"), Source: string(source)})
+	}
+	return programs, nil
+}
+
+// AddSeeds adds the programs of the corpus to the seeds of f
+func AddSeeds(f *testing.F) {
+	programs, err := Programs()
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(programs) == 0 {
+		f.Fatal("no programs in the corpus")
+	}
+	for _, p := range programs {
+		f.Add(p.Source)
+	}
+}
@@ -0,0 +1,40 @@
+// Package jsonrpc reads and writes the messages of the base protocol shared by the language
+// server and the debug adapter: each message is a JSON object, preceded by a Content-Length
+// header and an empty line.
+package jsonrpc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// Read reads the content of the next message from r. Messages of more than max bytes are
+// rejected.
+func Read(r *bufio.Reader, max int) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	if length < 0 || length > max {
+		return nil, fmt.Errorf("message of %d bytes is too large", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Write writes a message with the content data to w
+func Write(w io.Writer, data []byte) error {
+	_, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
@@ -0,0 +1,54 @@
+package jsonrpc_test
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/internal/jsonrpc"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	for _, data := range []string{`{"a":1}`, `{}`} {
+		if err := jsonrpc.Write(&buf, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "Content-Length: 7\r\n\r\n{\"a\":1}Content-Length: 2\r\n\r\n{}"; buf.String() != want {
+		t.Fatalf("wrong messages. want=%q, got=%q", want, buf.String())
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, want := range []string{`{"a":1}`, `{}`} {
+		data, err := jsonrpc.Read(r, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("wrong message. want=%q, got=%q", want, data)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Content-Length: x\r\n\r\n{}", `invalid Content-Length "x"`},
+		{"Content-Type: json\r\n\r\n{}", `invalid Content-Length ""`},
+		{"Content-Length: 101\r\n\r\n{}", "message of 101 bytes is too large"},
+		{"Content-Length: -1\r\n\r\n{}", "message of -1 bytes is too large"},
+		{"Content-Length: 3\r\n\r\n{}", "unexpected EOF"},
+		{"", "EOF"},
+	}
+
+	for _, tt := range tests {
+		_, err := jsonrpc.Read(bufio.NewReader(strings.NewReader(tt.input)), 100)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
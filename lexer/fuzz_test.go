@@ -0,0 +1,35 @@
+package lexer_test
+
+import (
+	"testing"
+
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/token"
+)
+
+// FuzzNextToken checks that lexing any input ends in EOF
+func FuzzNextToken(f *testing.F) {
+	// inputs of the lexer tests
+	f.Add("=+(){},;")
+	f.Add(`let add = fn(x, y) { x + y; }; let result = add(five, ten); !-/*5; 5 < 10 > 5;`)
+	f.Add(`if (5 < 10) { return true; } else { return false; } 10 == 10; 10 != 9;`)
+	f.Add(`"foobar"; "foo bar"; [1,2]; {"foo": "bar"}; 10 <= 9; 10 >= 9;`)
+	f.Add(`try { throw "x"; } catch (e) { e } finally { 1 }`)
+	// malformed input
+	f.Add(`"unterminated`)
+	f.Add("fn(")
+	f.Add("\x00 \xff é")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := lexer.New(input)
+
+		// every token but EOF consumes at least one byte of input
+		for i := 0; i <= len(input); i++ {
+			tok := l.NextToken()
+			if tok.Type == token.EOF {
+				return
+			}
+		}
+		t.Fatalf("no EOF after %d tokens", len(input)+1)
+	})
+}
@@ -10,22 +10,31 @@ type Lexer struct {
 	position     int // current position in input (points to current char (ch))
 	readPosition int // current reading position in input (after current char)
 
-	ch byte // current char under examination
+	ch        byte // current char under examination
+	line      int  // the line of ch
+	lineStart int  // the position the line of ch starts at
 }
 
 // New creates a new instance of the lexer used to lex the input string
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar() // init the lexer
 	return l
 }
 
 // NextToken reads the current character and returns the Token representing it
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
 	l.skipWhitespace()
 
+	line, column := l.line, l.position-l.lineStart+1
+	tok := l.nextToken()
+	tok.Line, tok.Column = line, column
+	return tok
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -47,7 +56,12 @@ func (l *Lexer) NextToken() token.Token {
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "->"}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' { // not equal
 			ch := l.ch // get curr character and increment to next
@@ -84,8 +98,12 @@ func (l *Lexer) NextToken() token.Token {
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 	case '"':
+		literal, ok := l.readString()
+		if !ok { // the input ended before the closing quote
+			return token.Token{Type: token.ILLEGAL, Literal: literal}
+		}
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = literal
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -93,6 +111,10 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case 0:
+		if l.position < len(l.input) { // a NUL byte rather than the end of the input
+			tok = newToken(token.ILLEGAL, l.ch)
+			break
+		}
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
@@ -133,24 +155,35 @@ func (l *Lexer) readIdentifier() string {
 	for isLetter(l.ch) { // keep going while we have a letter
 		l.readChar()
 	}
+	// identifiers can end in a bang (append!), unless it starts a != operator
+	if l.ch == '!' && l.peekChar() != '=' {
+		l.readChar()
+	}
 
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
+// readString reads a string up to its closing quote. If the input ends first, it returns the
+// rest of the input (including the opening quote) and false.
+func (l *Lexer) readString() (string, bool) {
 	position := l.position + 1
 	for { // read characters till we get to a closing quote
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		if l.ch == '"' {
+			return l.input[position:l.position], true
+		}
+		if l.position >= len(l.input) {
+			return l.input[position-1:], false
 		}
 	}
-
-	return l.input[position:l.position]
 }
 
 // readChar gets the next character and advances the pointer one step
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
 	if l.readPosition >= len(l.input) { // end of file
 		l.ch = 0
 	} else { // set the next character
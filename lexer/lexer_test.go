@@ -65,6 +65,7 @@ func TestNextToken(t *testing.T) {
 
 	10 <= 9;
 	10 >= 9;
+	fn(a: int) -> bool { a - 1 }
 	`
 
 	l := lexer.New(input)
@@ -185,8 +186,81 @@ func TestNextToken(t *testing.T) {
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
 
+		{token.FUNCTION, "fn"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.IDENT, "int"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "->"},
+		{token.IDENT, "bool"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "a"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+
+		{token.EOF, ""},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestIllegalTokens(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+	}{
+		{`"unterminated`, `"unterminated`},
+		{"\x00", "\x00"},
+		{"@", "@"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL {
+			t.Fatalf("tokentype wrong for %q. expected=%q, got=%q", tt.input, token.ILLEGAL, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("literal wrong for %q. expected=%q, got=%q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Fatalf("expected EOF after the illegal token of %q. got=%q", tt.input, tok.Type)
+		}
+	}
+}
+
+func TestIdentifiersEndingInBang(t *testing.T) {
+	input := "append!(a); a!=b; !a"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "append!"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.RPAREN, ")"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.NOT_EQ, "!="},
+		{token.IDENT, "b"},
+		{token.SEMICOLON, ";"},
+		{token.BANG, "!"},
+		{token.IDENT, "a"},
 		{token.EOF, ""},
 	}
+	l := lexer.New(input)
 
 	for i, tt := range tests {
 		tok := l.NextToken()
@@ -198,3 +272,40 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestLines(t *testing.T) {
+	input := "let a = 1;\n\nlet s = \"two\nlines\";\r\n  a\n"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"a", 1, 5},
+		{"=", 1, 7},
+		{"1", 1, 9},
+		{";", 1, 10},
+		{"let", 3, 1},
+		{"s", 3, 5},
+		{"=", 3, 7},
+		{"two\nlines", 3, 9},
+		{";", 4, 7},
+		{"a", 5, 3},
+		{"", 6, 1},
+	}
+	l := lexer.New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Line != tt.expectedLine {
+			t.Fatalf("tests[%d] - line wrong. expected=%d, got=%d", i, tt.expectedLine, tok.Line)
+		}
+		if tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - column wrong. expected=%d, got=%d", i, tt.expectedColumn, tok.Column)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package lint_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/internal/corpus"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/lint"
+	"github.com/andy9775/monkey/parser"
+)
+
+// FuzzLint checks that linting any program finishes, and that the linter finds the undefined
+// variables the compiler does
+func FuzzLint(f *testing.F) {
+	f.Add("let add = fn(a, b) { a + b }; add(1); let len = 1; if (1 < 2) { return c; 3 }")
+	f.Add("let f = fn(n) { fn() { f(n - 1) } }; try { g } catch (e) { e } finally { [1][0] = {1: 2}[1:] }")
+	corpus.AddSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		issues := lint.Lint(input, lint.Rules)
+
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+		undefined := map[string]bool{}
+		for _, issue := range issues {
+			if issue.Rule == "undefined" {
+				undefined[strings.TrimPrefix(issue.Message, "undefined variable ")] = true
+			}
+		}
+
+		err := compiler.New().Compile(program)
+		if err != nil && strings.HasPrefix(err.Error(), "undefined variable ") {
+			if name := strings.TrimPrefix(err.Error(), "undefined variable "); !undefined[name] {
+				t.Fatalf("the compiler finds %s undefined, the linter doesn't. got=%v", name, issues)
+			}
+		} else if err == nil && len(undefined) != 0 {
+			t.Fatalf("the linter finds undefined variables the compiler doesn't. got=%v", issues)
+		}
+	})
+}
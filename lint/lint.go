@@ -0,0 +1,140 @@
+// Package lint finds likely bugs in monkey programs without running them. Names are resolved
+// the way the compiler does (see package resolver), but where the compiler stops at the first
+// undefined variable every issue is reported.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/resolver"
+	"github.com/andy9775/monkey/token"
+)
+
+// SyntaxRule is the rule of the issues the parser reports, which are always checked
+const SyntaxRule = "syntax"
+
+// Issue is a problem found at a line and column (in bytes) of a source, both counting from 1
+type Issue struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// String formats the issue as file:line:column: message (rule)
+func (i Issue) String() string {
+	s := fmt.Sprintf("%d:%d: %s (%s)", i.Line, i.Column, i.Message, i.Rule)
+	if i.File != "" {
+		s = i.File + ":" + s
+	}
+	return s
+}
+
+// Rule is a check of the linter. Each rule is in a set: "correctness" for code which fails or
+// has no effect when run, "style" for code which runs but likely isn't what was meant.
+type Rule struct {
+	Name        string
+	Set         string
+	Description string
+
+	check func(l *linter)
+}
+
+// Rules are the rules of the linter, in the order they're checked
+var Rules = []*Rule{
+	{Name: "undefined", Set: "correctness", Description: "uses of names which aren't defined", check: (*linter).undefined},
+	{Name: "arity", Set: "correctness", Description: "calls of function literals with the wrong number of arguments", check: (*linter).arity},
+	{Name: "unreachable", Set: "correctness", Description: "statements after a return or throw", check: (*linter).unreachable},
+	{Name: "unused", Set: "style", Description: "let bindings which are never used (unless their names start with _)", check: (*linter).unused},
+	{Name: "shadowed-builtin", Set: "style", Description: "definitions of names which hide a builtin", check: (*linter).shadowedBuiltin},
+	{Name: "constant-condition", Set: "style", Description: "if conditions which are always true or always false", check: (*linter).constantCondition},
+}
+
+// Select returns the rules spec selects. The spec is a comma separated list of rule names, set
+// names and "all", applied in order: a name adds its rules and a name with a - in front takes
+// them away again, e.g. "all,-unused". An empty spec selects all the rules, as does a spec which
+// starts by taking rules away ("-style" is "all,-style"). A spec selecting no rules is an error.
+func Select(spec string) ([]*Rule, error) {
+	names := []string{"all"}
+	if trimmed := strings.TrimSpace(spec); trimmed != "" {
+		if !strings.HasPrefix(trimmed, "-") {
+			names = nil
+		}
+		names = append(names, strings.Split(trimmed, ",")...)
+	}
+
+	selected := map[*Rule]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		remove := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		found := false
+		for _, rule := range Rules {
+			if name == "all" || name == rule.Set || name == rule.Name {
+				selected[rule] = !remove
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown rule or rule set %q", name)
+		}
+	}
+
+	rules := []*Rule{}
+	for _, rule := range Rules {
+		if selected[rule] {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules selected by %q", spec)
+	}
+	return rules, nil
+}
+
+// Lint checks source with rules and returns the issues found, ordered by position. The errors
+// of the parser are issues of the SyntaxRule, and the rest of the program is checked anyway.
+func Lint(source string, rules []*Rule) []Issue {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+
+	l := &linter{program: program, resolution: resolver.Resolve(program), occurrences: map[*ast.Identifier]resolver.Occurrence{}}
+	for _, o := range l.resolution.Occurrences {
+		l.occurrences[o.Ident] = o
+	}
+
+	l.rule = SyntaxRule
+	for _, err := range p.ErrorsAt() {
+		l.report(err.Token, "%s", err.Message)
+	}
+	for _, rule := range rules {
+		l.rule = rule.Name
+		rule.check(l)
+	}
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		a, b := l.issues[i], l.issues[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return l.issues
+}
+
+type linter struct {
+	program     *ast.Program
+	resolution  *resolver.Resolution
+	occurrences map[*ast.Identifier]resolver.Occurrence
+
+	rule   string // the rule being checked
+	issues []Issue
+}
+
+func (l *linter) report(tok token.Token, format string, a ...interface{}) {
+	l.issues = append(l.issues, Issue{Line: tok.Line, Column: tok.Column, Rule: l.rule, Message: fmt.Sprintf(format, a...)})
+}
@@ -0,0 +1,130 @@
+package lint_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/lint"
+)
+
+func lintAll(t *testing.T, source string) []string {
+	t.Helper()
+	issues := []string{}
+	for _, issue := range lint.Lint(source, lint.Rules) {
+		issues = append(issues, issue.String())
+	}
+	return issues
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// undefined
+		{"let a = b + c; a", []string{"1:9: undefined variable b (undefined)", "1:13: undefined variable c (undefined)"}},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f() + g()", []string{"1:16: undefined variable g (undefined)"}},
+		{"let a = a; a", []string{"1:9: undefined variable a (undefined)"}},
+
+		// arity
+		{"let add = fn(a, b) { a + b }; add(1)", []string{"1:31: add takes 2 arguments, called with 1 (arity)"}},
+		{"let f = fn(a) { if (a) { f() } else { 1 } }; f(1, 2)", []string{
+			"1:26: f takes 1 argument, called with 0 (arity)",
+			"1:46: f takes 1 argument, called with 2 (arity)",
+		}},
+		{"fn() { 1 }(2)", []string{"1:1: the function takes 0 arguments, called with 1 (arity)"}},
+		{"let f = fn(a) { a }; let f = fn(a, b) { a }; f(1, 2)", []string{}}, // f is defined twice
+		{"let f = 1; let g = fn() { f(1) }; g()", []string{}},
+
+		// unreachable
+		{"let f = fn() { return 1; let a = 2; a }; f()", []string{"1:26: unreachable code after return (unreachable)"}},
+		{"let f = fn() { if (true) { throw 1; 2 } else { 3 } }; f()", []string{
+			"1:16: the condition of the if is always true (constant-condition)",
+			"1:37: unreachable code after throw (unreachable)",
+		}},
+		{"return 1; 2; 3", []string{"1:11: unreachable code after return (unreachable)"}},
+
+		// unused
+		{"let a = 1; let b = 2; b", []string{"1:5: a is defined but never used (unused)"}},
+		{"let f = fn(n) { let x = 1; f(n) }", []string{
+			"1:5: f is defined but never used (unused)",
+			"1:21: x is defined but never used (unused)",
+		}},
+		{"let _a = 1; let f = fn(unused) { 1 }; f(1)", []string{}},
+		{"let f = fn() { let a = 1; fn() { a } }; f()", []string{}},
+
+		// shadowed-builtin
+		{"let len = fn(x) { 0 }; len([])", []string{"1:5: len hides the builtin len (shadowed-builtin)"}},
+		{"let f = fn(puts) { puts }; f(1); try { 1 } catch (first) { first }", []string{
+			"1:12: puts hides the builtin puts (shadowed-builtin)",
+			"1:51: first hides the builtin first (shadowed-builtin)",
+		}},
+
+		// constant-condition
+		{"if (1 < 2) { 1 }", []string{"1:1: the condition of the if is always true (constant-condition)"}},
+		{"if (!\"a\") { 1 }", []string{"1:1: the condition of the if is always false (constant-condition)"}},
+		{"if ([1, 2]) { 1 }", []string{"1:1: the condition of the if is always true (constant-condition)"}},
+		{"if (1 / 0) { 1 }", []string{"1:1: the condition of the if is constant, and fails (constant-condition)"}},
+		{"let a = 1; if (a < 2) { 1 }", []string{}},
+
+		// syntax errors are reported along with the rest
+		{"let = 1; b", []string{
+			"1:5: expected next token to be IDENT, got = instead (syntax)",
+			"1:5: no prefix parse function for = found (syntax)",
+			"1:10: undefined variable b (undefined)",
+		}},
+	}
+
+	for _, tt := range tests {
+		issues := lintAll(t, tt.input)
+		if strings.Join(issues, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong issues for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, issues)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"", "undefined arity unreachable unused shadowed-builtin constant-condition"},
+		{"all", "undefined arity unreachable unused shadowed-builtin constant-condition"},
+		{"correctness", "undefined arity unreachable"},
+		{"style,-unused", "shadowed-builtin constant-condition"},
+		{"all, -style, unused", "undefined arity unreachable unused"},
+		{"arity", "arity"},
+		{"-style", "undefined arity unreachable"},
+		{" -style, unused", "undefined arity unreachable unused"},
+	}
+
+	for _, tt := range tests {
+		rules, err := lint.Select(tt.spec)
+		if err != nil {
+			t.Errorf("Select(%q) failed: %s", tt.spec, err)
+			continue
+		}
+		names := []string{}
+		for _, rule := range rules {
+			names = append(names, rule.Name)
+		}
+		if strings.Join(names, " ") != tt.expected {
+			t.Errorf("wrong rules for %q. want=%s, got=%s", tt.spec, tt.expected, strings.Join(names, " "))
+		}
+	}
+
+	if _, err := lint.Select("all,typo"); err == nil || err.Error() != `unknown rule or rule set "typo"` {
+		t.Errorf("wrong error for an unknown rule. got=%v", err)
+	}
+	if _, err := lint.Select("style,-style"); err == nil || err.Error() != `no rules selected by "style,-style"` {
+		t.Errorf("wrong error for a spec selecting no rules. got=%v", err)
+	}
+}
+
+func TestSelectedRulesOnly(t *testing.T) {
+	rules, _ := lint.Select("unused")
+	issues := lint.Lint("let a = b; if (true) { 1 }", rules)
+	if len(issues) != 1 || issues[0].Rule != "unused" || issues[0].Message != "a is defined but never used" {
+		t.Errorf("wrong issues. got=%v", issues)
+	}
+}
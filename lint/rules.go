@@ -0,0 +1,195 @@
+package lint
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/evaluator"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/token"
+)
+
+func (l *linter) undefined() {
+	for _, ident := range l.resolution.Undefined {
+		l.report(ident.Token, "undefined variable %s", ident.Value)
+	}
+}
+
+// arity checks the calls of function literals, and of names bound to one by a let statement
+// which is the only definition of the name
+func (l *linter) arity() {
+	ast.Inspect(l.program, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return true
+		}
+
+		var lit *ast.FunctionLiteral
+		var tok token.Token
+		name := "the function"
+		switch fn := call.Function.(type) {
+		case *ast.FunctionLiteral:
+			if fn != nil {
+				lit, tok = fn, fn.Token
+			}
+		case *ast.Identifier:
+			if o, ok := l.occurrences[fn]; ok && l.definitions(o.Binding.Refs) == 1 {
+				lit, tok, name = o.Binding.Literal, fn.Token, fn.Value
+			}
+		}
+
+		if lit != nil && len(lit.Parameters) != len(call.Arguments) {
+			l.report(tok, "%s takes %s, called with %d", name, plural(len(lit.Parameters), "argument"), len(call.Arguments))
+		}
+		return true
+	})
+}
+
+// definitions returns how many of refs define the name
+func (l *linter) definitions(refs []*ast.Identifier) int {
+	n := 0
+	for _, ref := range refs {
+		if l.resolution.Definitions[ref] {
+			n++
+		}
+	}
+	return n
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
+// unreachable reports the first statement after a return or throw in each block
+func (l *linter) unreachable() {
+	check := func(statements []ast.Statement) {
+		for i := 0; i+1 < len(statements); i++ {
+			var after string
+			switch statements[i].(type) {
+			case *ast.ReturnStatement:
+				after = "return"
+			case *ast.ThrowStatement:
+				after = "throw"
+			default:
+				continue
+			}
+			l.report(statementToken(statements[i+1]), "unreachable code after %s", after)
+			return
+		}
+	}
+
+	ast.Inspect(l.program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Program:
+			check(node.Statements)
+		case *ast.BlockStatement:
+			check(node.Statements)
+		}
+		return true
+	})
+}
+
+func statementToken(s ast.Statement) token.Token {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		return s.Token
+	case *ast.ReturnStatement:
+		return s.Token
+	case *ast.ThrowStatement:
+		return s.Token
+	case *ast.ExpressionStatement:
+		return s.Token
+	case *ast.BlockStatement:
+		return s.Token
+	}
+	return token.Token{}
+}
+
+// unused reports the names let statements define which are never used. A function only
+// calling itself isn't a use.
+func (l *linter) unused() {
+	for _, b := range l.resolution.Bindings {
+		if b.Let == nil || strings.HasPrefix(b.Name, "_") {
+			continue
+		}
+
+		used := false
+		for _, ref := range b.Refs {
+			if l.resolution.Definitions[ref] {
+				continue
+			}
+			if b.Literal != nil && l.occurrences[ref].Function.Encloses(b.Literal) {
+				continue
+			}
+			used = true
+			break
+		}
+		if !used {
+			l.report(b.Def.Token, "%s is defined but never used", b.Name)
+		}
+	}
+}
+
+func (l *linter) shadowedBuiltin() {
+	for _, b := range l.resolution.Bindings {
+		if object.GetBuiltinByName(b.Name) != nil {
+			l.report(b.Def.Token, "%s hides the builtin %s", b.Name, b.Name)
+		}
+	}
+}
+
+// constantCondition reports the ifs whose conditions are made of literals only, evaluating
+// them to tell whether they're always true or always false
+func (l *linter) constantCondition() {
+	ast.Inspect(l.program, func(node ast.Node) bool {
+		ifExp, ok := node.(*ast.IfExpression)
+		if !ok || ifExp.Condition == nil || !isConstant(ifExp.Condition) {
+			return true
+		}
+
+		switch result := evaluator.Eval(ifExp.Condition, object.NewEnvironment()); result.Type() {
+		case object.ERROR_OBJ, object.EXCEPTION_OBJ:
+			l.report(ifExp.Token, "the condition of the if is constant, and fails")
+		case object.BOOLEAN_OBJ, object.NULL_OBJ:
+			if result == object.True {
+				l.report(ifExp.Token, "the condition of the if is always true")
+			} else {
+				l.report(ifExp.Token, "the condition of the if is always false")
+			}
+		default:
+			l.report(ifExp.Token, "the condition of the if is always true")
+		}
+		return true
+	})
+}
+
+// isConstant reports whether exp is made of literals and operators only
+func isConstant(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.FunctionLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return exp.Right != nil && isConstant(exp.Right)
+	case *ast.InfixExpression:
+		return exp.Left != nil && exp.Right != nil && isConstant(exp.Left) && isConstant(exp.Right)
+	case *ast.ArrayLiteral:
+		for _, el := range exp.Elements {
+			if !isConstant(el) {
+				return false
+			}
+		}
+		return true
+	case *ast.HashLiteral:
+		for _, key := range exp.Keys {
+			if !isConstant(key) || !isConstant(exp.Pairs[key]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package lsp
+
+import (
+	"sort"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/resolver"
+	"github.com/andy9775/monkey/token"
+)
+
+// analysis is what analyze finds out about a document
+type analysis struct {
+	*resolver.Resolution
+
+	program *ast.Program
+	errors  []parser.Error
+	globals []*ast.LetStatement // the top level let statements
+}
+
+func analyze(text string) *analysis {
+	p := parser.New(lexer.New(text))
+	program := p.ParseProgram()
+
+	a := &analysis{Resolution: resolver.Resolve(program), program: program, errors: p.ErrorsAt()}
+	for _, s := range program.Statements {
+		if let, ok := s.(*ast.LetStatement); ok && let.Name != nil {
+			a.globals = append(a.globals, let)
+		}
+	}
+	return a
+}
+
+// at returns the occurrence of the identifier at (or right after) line and column
+func (a *analysis) at(line, column int) (resolver.Occurrence, bool) {
+	for _, o := range a.Occurrences {
+		tok := o.Ident.Token
+		if tok.Line == line && tok.Column <= column && column <= tok.Column+len(tok.Literal) {
+			return o, true
+		}
+	}
+	return resolver.Occurrence{}, false
+}
+
+// visible returns the bindings of the program a use at line and column could refer to, inner
+// ones shadowing outer ones, sorted by name
+func (a *analysis) visible(line, column int) []*resolver.Binding {
+	at := token.Token{Line: line, Column: column}
+
+	// the functions around the position
+	around := map[*ast.FunctionLiteral]bool{nil: true}
+	for _, f := range a.Functions {
+		body := f.Literal.Body
+		if body != nil && resolver.Before(body.Token, at) && (body.End.Type == token.EOF || resolver.Before(at, body.End)) {
+			around[f.Literal] = true
+		}
+	}
+
+	depth := map[*ast.FunctionLiteral]int{}
+	for _, f := range a.Functions {
+		for outer := f; outer != nil; outer = outer.Outer {
+			depth[f.Literal]++
+		}
+	}
+
+	byName := map[string]*resolver.Binding{}
+	for _, b := range a.Bindings {
+		if !around[b.Function] || !resolver.Before(b.Def.Token, at) {
+			continue
+		}
+		if other, ok := byName[b.Name]; !ok || depth[b.Function] >= depth[other.Function] {
+			byName[b.Name] = b
+		}
+	}
+
+	visible := make([]*resolver.Binding, 0, len(byName))
+	for _, b := range byName {
+		visible = append(visible, b)
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].Name < visible[j].Name })
+	return visible
+}
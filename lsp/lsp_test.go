@@ -0,0 +1,462 @@
+package lsp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/lsp"
+)
+
+const uri = "file:///program.mk"
+
+const program = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let counter = fn(n) {
+  fn() { n + len([]) }
+};
+let x = add(1, 2);
+x`
+
+// client is a scripted LSP client. The server publishes diagnostics whenever a document changes,
+// so messages are read as they come, and the ones the client isn't waiting for are kept until it is.
+type client struct {
+	t        *testing.T
+	w        io.WriteCloser
+	messages chan map[string]interface{}
+	id       int
+	pending  []map[string]interface{}
+	served   chan error
+}
+
+func start(t *testing.T) *client {
+	t.Helper()
+
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &client{t: t, w: clientOut, messages: make(chan map[string]interface{}, 16), served: make(chan error, 1)}
+	go func() {
+		err := lsp.Serve(serverIn, serverOut)
+		serverOut.Close()
+		c.served <- err
+	}()
+	go read(bufio.NewReader(clientIn), c.messages)
+
+	c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}})
+	c.notify("initialized", map[string]interface{}{})
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "monkey", "version": 1, "text": program},
+	})
+	return c
+}
+
+// read sends the messages of r to messages, until r ends or has an invalid message
+func read(r *bufio.Reader, messages chan<- map[string]interface{}) {
+	defer close(messages)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		messages <- msg
+	}
+}
+
+func (c *client) send(msg map[string]interface{}) {
+	c.t.Helper()
+
+	msg["jsonrpc"] = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatalf("cannot encode message: %s", err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatalf("cannot send message: %s", err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+func (c *client) read() map[string]interface{} {
+	c.t.Helper()
+	msg, ok := <-c.messages
+	if !ok {
+		c.t.Fatalf("cannot read message")
+	}
+	return msg
+}
+
+// next returns the first message matching, reading until there is one
+func (c *client) next(matching func(msg map[string]interface{}) bool) map[string]interface{} {
+	c.t.Helper()
+	for i, msg := range c.pending {
+		if matching(msg) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return msg
+		}
+	}
+	for {
+		msg := c.read()
+		if matching(msg) {
+			return msg
+		}
+		c.pending = append(c.pending, msg)
+	}
+}
+
+// response sends a request and returns its response
+func (c *client) response(method string, params interface{}) map[string]interface{} {
+	c.t.Helper()
+
+	c.id++
+	id := float64(c.id)
+	c.send(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	return c.next(func(msg map[string]interface{}) bool { return msg["id"] == id && msg["method"] == nil })
+}
+
+// call sends a request and returns its result, failing unless it succeeds
+func (c *client) call(method string, params interface{}) interface{} {
+	c.t.Helper()
+	response := c.response(method, params)
+	if response["error"] != nil {
+		c.t.Fatalf("%s failed: %v", method, response["error"])
+	}
+	result, ok := response["result"]
+	if !ok {
+		c.t.Fatalf("%s has no result: %v", method, response)
+	}
+	return result
+}
+
+// diagnostics returns the messages of the next diagnostics published, with their positions
+func (c *client) diagnostics() []string {
+	c.t.Helper()
+	msg := c.next(func(msg map[string]interface{}) bool { return msg["method"] == "textDocument/publishDiagnostics" })
+	params := msg["params"].(map[string]interface{})
+	if params["uri"] != uri {
+		c.t.Errorf("diagnostics for the wrong document. got=%v", params["uri"])
+	}
+
+	diagnostics := []string{}
+	for _, d := range params["diagnostics"].([]interface{}) {
+		d := d.(map[string]interface{})
+		diagnostics = append(diagnostics, fmt.Sprintf("%s %s", rangeOf(d["range"]), d["message"]))
+	}
+	return diagnostics
+}
+
+func (c *client) exit() {
+	c.t.Helper()
+	c.call("shutdown", nil)
+	c.notify("exit", nil)
+	if err := <-c.served; err != nil {
+		c.t.Errorf("serve error: %s", err)
+	}
+}
+
+func at(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}
+}
+
+// rangeOf renders a range as line:character-line:character
+func rangeOf(r interface{}) string {
+	pos := func(p interface{}) string {
+		m := p.(map[string]interface{})
+		return fmt.Sprintf("%v:%v", m["line"], m["character"])
+	}
+	m := r.(map[string]interface{})
+	return pos(m["start"]) + "-" + pos(m["end"])
+}
+
+func ranges(locations interface{}) string {
+	rendered := []string{}
+	for _, l := range locations.([]interface{}) {
+		rendered = append(rendered, rangeOf(l.(map[string]interface{})["range"]))
+	}
+	return strings.Join(rendered, " ")
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := start(t)
+
+	if diagnostics := c.diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("diagnostics for a valid program. got=%v", diagnostics)
+	}
+
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]interface{}{{"text": "let a = 1;\nlet = 2;"}},
+	})
+	diagnostics := c.diagnostics()
+	if len(diagnostics) == 0 || !strings.HasPrefix(diagnostics[0], "1:4-1:5 ") {
+		t.Errorf("wrong diagnostics. got=%q", diagnostics)
+	}
+
+	c.notify("textDocument/didClose", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}})
+	if diagnostics := c.diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("diagnostics for a closed document. got=%v", diagnostics)
+	}
+
+	c.exit()
+}
+
+func TestDefinition(t *testing.T) {
+	c := start(t)
+
+	tests := []struct {
+		line, character int
+		want            string
+	}{
+		{8, 0, "7:4-7:5"},    // x
+		{8, 1, "7:4-7:5"},    // right after x
+		{2, 2, "1:6-1:9"},    // sum
+		{1, 12, "0:13-0:14"}, // a
+		{5, 9, "4:17-4:18"},  // n, a free variable
+		{7, 9, "0:4-0:7"},    // add
+	}
+	for _, tt := range tests {
+		result := c.call("textDocument/definition", at(tt.line, tt.character))
+		if result == nil {
+			t.Errorf("no definition at %d:%d", tt.line, tt.character)
+			continue
+		}
+		if got := rangeOf(result.(map[string]interface{})["range"]); got != tt.want {
+			t.Errorf("wrong definition at %d:%d. want=%s, got=%s", tt.line, tt.character, tt.want, got)
+		}
+	}
+
+	// builtins and what isn't an identifier have none
+	for _, pos := range [][2]int{{5, 13}, {0, 0}} {
+		if result := c.call("textDocument/definition", at(pos[0], pos[1])); result != nil {
+			t.Errorf("definition at %v. got=%v", pos, result)
+		}
+	}
+
+	c.exit()
+}
+
+func TestReferences(t *testing.T) {
+	c := start(t)
+
+	tests := []struct {
+		line, character    int
+		includeDeclaration bool
+		want               string
+	}{
+		{7, 8, true, "0:4-0:7 7:8-7:11"},
+		{7, 8, false, "7:8-7:11"},
+		{0, 13, true, "0:13-0:14 1:12-1:13"},
+		{5, 9, true, "4:17-4:18 5:9-5:10"},
+		{5, 13, true, "5:13-5:16"},
+	}
+	for _, tt := range tests {
+		params := at(tt.line, tt.character)
+		params["context"] = map[string]interface{}{"includeDeclaration": tt.includeDeclaration}
+		if got := ranges(c.call("textDocument/references", params)); got != tt.want {
+			t.Errorf("wrong references at %d:%d. want=%s, got=%s", tt.line, tt.character, tt.want, got)
+		}
+	}
+
+	c.exit()
+}
+
+func TestHover(t *testing.T) {
+	c := start(t)
+
+	tests := []struct {
+		line, character int
+		want            string
+	}{
+		{8, 0, "variable `x` (GLOBAL)\n\ndefined on line 8"},
+		{7, 8, "function `add` (GLOBAL)\n\ndefined on line 1"},
+		{1, 12, "variable `a` (LOCAL)\n\ndefined on line 1"},
+		{5, 9, "variable `n` (FREE)\n\ndefined on line 5 as a LOCAL of an enclosing scope"},
+		{5, 13, "builtin function `len` (BUILTIN)"},
+	}
+	for _, tt := range tests {
+		result := c.call("textDocument/hover", at(tt.line, tt.character))
+		if result == nil {
+			t.Errorf("no hover at %d:%d", tt.line, tt.character)
+			continue
+		}
+		contents := result.(map[string]interface{})["contents"].(map[string]interface{})
+		if contents["kind"] != "markdown" || contents["value"] != tt.want {
+			t.Errorf("wrong hover at %d:%d. want=%q, got=%v", tt.line, tt.character, tt.want, contents)
+		}
+	}
+
+	c.exit()
+}
+
+func TestRename(t *testing.T) {
+	c := start(t)
+
+	params := at(1, 12)
+	params["newName"] = "c"
+	result := c.call("textDocument/rename", params).(map[string]interface{})
+	edits := result["changes"].(map[string]interface{})[uri].([]interface{})
+	got := []string{}
+	for _, e := range edits {
+		e := e.(map[string]interface{})
+		got = append(got, fmt.Sprintf("%s=%s", rangeOf(e["range"]), e["newText"]))
+	}
+	if want := "0:13-0:14=c 1:12-1:13=c"; strings.Join(got, " ") != want {
+		t.Errorf("wrong edits. want=%s, got=%s", want, strings.Join(got, " "))
+	}
+
+	tests := []struct {
+		line, character int
+		newName         string
+	}{
+		{1, 12, "let"},
+		{1, 12, "1a"},
+		{1, 12, "a b"},
+		{5, 13, "length"},
+	}
+	for _, tt := range tests {
+		params := at(tt.line, tt.character)
+		params["newName"] = tt.newName
+		if response := c.response("textDocument/rename", params); response["error"] == nil {
+			t.Errorf("renaming %d:%d to %q didn't fail. got=%v", tt.line, tt.character, tt.newName, response)
+		}
+	}
+
+	c.exit()
+}
+
+func TestCompletion(t *testing.T) {
+	c := start(t)
+
+	labels := func(line, character int) map[string]string {
+		details := map[string]string{}
+		for _, item := range c.call("textDocument/completion", at(line, character)).([]interface{}) {
+			item := item.(map[string]interface{})
+			details[item["label"].(string)], _ = item["detail"].(string)
+		}
+		return details
+	}
+
+	inAdd := labels(2, 2)
+	for name, detail := range map[string]string{"a": "LOCAL", "b": "LOCAL", "sum": "LOCAL", "add": "GLOBAL", "len": "builtin", "puts": "builtin"} {
+		if inAdd[name] != detail {
+			t.Errorf("wrong completion of %s in add. want=%s, got=%q", name, detail, inAdd[name])
+		}
+	}
+	for _, name := range []string{"counter", "x", "n"} {
+		if _, ok := inAdd[name]; ok {
+			t.Errorf("%s completed in add", name)
+		}
+	}
+
+	atEnd := labels(8, 1)
+	names := []string{}
+	for name, detail := range atEnd {
+		if detail != "builtin" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "add counter x" {
+		t.Errorf("wrong completions at the end. got=%s", got)
+	}
+
+	c.exit()
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := start(t)
+
+	result := c.call("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}})
+	got := []string{}
+	for _, s := range result.([]interface{}) {
+		s := s.(map[string]interface{})
+		got = append(got, fmt.Sprintf("%s:%v@%s", s["name"], s["kind"], rangeOf(s["selectionRange"])))
+	}
+	if want := "add:12@0:4-0:7 counter:12@4:4-4:11 x:13@7:4-7:5"; strings.Join(got, " ") != want {
+		t.Errorf("wrong symbols. want=%s, got=%s", want, strings.Join(got, " "))
+	}
+
+	c.exit()
+}
+
+func TestFailedRequests(t *testing.T) {
+	c := start(t)
+
+	tests := []struct {
+		method string
+		params interface{}
+		code   float64
+	}{
+		{"textDocument/formatting", at(0, 0), -32601},
+		{"textDocument/hover", map[string]interface{}{"textDocument": map[string]interface{}{"uri": "file:///missing.mk"}}, -32602},
+		{"textDocument/hover", "not an object", -32602},
+	}
+	for _, tt := range tests {
+		response := c.response(tt.method, tt.params)
+		err, _ := response["error"].(map[string]interface{})
+		if err == nil || err["code"] != tt.code {
+			t.Errorf("wrong error for %s. want=%v, got=%v", tt.method, tt.code, response)
+		}
+	}
+
+	c.call("shutdown", nil)
+	if response := c.response("textDocument/hover", at(0, 4)); response["error"] == nil {
+		t.Errorf("request after shutdown didn't fail. got=%v", response)
+	}
+	c.notify("exit", nil)
+	if err := <-c.served; err != nil {
+		t.Errorf("serve error: %s", err)
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	c := start(t)
+	c.diagnostics()
+
+	// é is two bytes and one UTF-16 unit, 😀 four bytes and two units
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]interface{}{{"text": "let s = \"é😀\"; let v = s;\nv"}},
+	})
+	c.diagnostics()
+
+	result := c.call("textDocument/definition", at(1, 0))
+	if got := rangeOf(result.(map[string]interface{})["range"]); got != "0:19-0:20" {
+		t.Errorf("wrong definition. want=0:19-0:20, got=%s", got)
+	}
+	if got := ranges(c.call("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": 0, "character": 23},
+		"context":      map[string]interface{}{"includeDeclaration": true},
+	})); got != "0:4-0:5 0:23-0:24" {
+		t.Errorf("wrong references. want=0:4-0:5 0:23-0:24, got=%s", got)
+	}
+
+	c.exit()
+}
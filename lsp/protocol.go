@@ -0,0 +1,228 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/andy9775/monkey/internal/jsonrpc"
+	"github.com/andy9775/monkey/token"
+)
+
+/*
+	The base protocol is JSON-RPC, each message preceded by a Content-Length header and an empty
+	line (see the jsonrpc package). Only the parts of the messages the server uses are declared
+	here.
+*/
+
+// maxMessageSize bounds the messages the server reads
+const maxMessageSize = 16 << 20
+
+// the error codes of responses
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInvalidRequest = -32600
+)
+
+// message is a request, response or notification
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"` // nil for notifications
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string { return e.Message }
+
+// readMessage reads the next message from r
+func readMessage(r *bufio.Reader) (*message, error) {
+	data, err := jsonrpc.Read(r, maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: fmt.Sprintf("invalid message: %s", err)}
+	}
+	return &msg, nil
+}
+
+// writeMessage writes msg to w
+func writeMessage(w io.Writer, msg *message) error {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return jsonrpc.Write(w, data)
+}
+
+// positions and ranges count lines from 0, and characters in UTF-16 code units
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type rng struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string `json:"uri"`
+	Range rng    `json:"range"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type didOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type referenceParams struct {
+	textDocumentPositionParams
+	Context struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type renameParams struct {
+	textDocumentPositionParams
+	NewName string `json:"newName"`
+}
+
+type documentSymbolParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type diagnostic struct {
+	Range    rng    `json:"range"`
+	Severity int    `json:"severity"` // 1 is an error
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type hover struct {
+	Contents struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	} `json:"contents"`
+	Range rng `json:"range"`
+}
+
+type textEdit struct {
+	Range   rng    `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type completionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type documentSymbol struct {
+	Name           string `json:"name"`
+	Kind           int    `json:"kind"`
+	Range          rng    `json:"range"`
+	SelectionRange rng    `json:"selectionRange"`
+}
+
+// the kinds of completion items and symbols
+const (
+	completionFunction = 3
+	completionVariable = 6
+	symbolFunction     = 12
+	symbolVariable     = 13
+)
+
+// document is the text of an open document, split into lines to convert positions
+type document struct {
+	lines    []string
+	analysis *analysis
+}
+
+func newDocument(text string) *document {
+	lines := []string{}
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, text[start:i])
+			start = i + 1
+		}
+	}
+	lines = append(lines, text[start:])
+	return &document{lines: lines, analysis: analyze(text)}
+}
+
+// position returns the position of the byte column (counting from 1) of line (counting from 1)
+func (d *document) position(line, column int) position {
+	if line < 1 || line > len(d.lines) {
+		return position{Line: line - 1}
+	}
+	text := d.lines[line-1]
+	if column-1 > len(text) {
+		column = len(text) + 1
+	}
+
+	character := 0
+	for _, r := range text[:column-1] {
+		character += len(utf16.Encode([]rune{r}))
+	}
+	return position{Line: line - 1, Character: character}
+}
+
+// column returns the line and byte column (counting from 1) of pos
+func (d *document) column(pos position) (int, int) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos.Line + 1, 1
+	}
+	text := d.lines[pos.Line]
+
+	offset, character := 0, 0
+	for offset < len(text) && character < pos.Character {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		character += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return pos.Line + 1, offset + 1
+}
+
+// rangeOf returns the range of the text of tok
+func (d *document) rangeOf(tok token.Token) rng {
+	return rng{Start: d.position(tok.Line, tok.Column), End: d.position(tok.Line, tok.Column+len(tok.Literal))}
+}
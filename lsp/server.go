@@ -0,0 +1,314 @@
+// Package lsp serves the Language Server Protocol for monkey programs: diagnostics of the parser
+// on every change, and, resolving names the way the compiler does (see package resolver),
+// go-to-definition, find-references, hover, rename, completion and the symbols of the top
+// level let statements. Documents are synced in full.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/resolver"
+	"github.com/andy9775/monkey/token"
+)
+
+// Serve serves a session over in and out, until the client exits or in ends
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{in: bufio.NewReader(in), out: out, documents: map[string]*document{}}
+	return s.serve()
+}
+
+type server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	documents map[string]*document // by URI
+	shutdown  bool
+}
+
+func (s *server) serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if e, ok := err.(*responseError); ok {
+			s.send(&message{Error: e, ID: nullID()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.ID == nil {
+			if msg.Method == "exit" {
+				return nil
+			}
+			s.notification(msg)
+			continue
+		}
+
+		result, err := s.request(msg)
+		response := &message{ID: msg.ID, Result: result}
+		if err != nil {
+			response.Result = nil
+			response.Error = err.(*responseError)
+		} else if result == nil {
+			response.Result = json.RawMessage("null") // results must be there, even if null
+		}
+		s.send(response)
+	}
+}
+
+func nullID() *json.RawMessage {
+	id := json.RawMessage("null")
+	return &id
+}
+
+func (s *server) notification(msg *message) {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(msg.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			// documents are synced in full, so the last change is the text
+			s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			delete(s.documents, params.TextDocument.URI)
+			s.publish(params.TextDocument.URI, []diagnostic{})
+		}
+	}
+}
+
+// update analyzes the new text of the document uri and publishes its diagnostics
+func (s *server) update(uri, text string) {
+	doc := newDocument(text)
+	s.documents[uri] = doc
+
+	diagnostics := []diagnostic{}
+	for _, err := range doc.analysis.errors {
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    doc.rangeOf(err.Token),
+			Severity: 1,
+			Source:   "monkey",
+			Message:  err.Message,
+		})
+	}
+	s.publish(uri, diagnostics)
+}
+
+func (s *server) publish(uri string, diagnostics []diagnostic) {
+	params, _ := json.Marshal(map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+	s.send(&message{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// request serves msg, returning its result
+func (s *server) request(msg *message) (interface{}, error) {
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server was shut down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // full
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"renameProvider":         true,
+				"completionProvider":     map[string]interface{}{},
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]interface{}{"name": "monkey"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/definition":
+		return s.definition(msg)
+	case "textDocument/references":
+		return s.references(msg)
+	case "textDocument/hover":
+		return s.hover(msg)
+	case "textDocument/rename":
+		return s.rename(msg)
+	case "textDocument/completion":
+		return s.completion(msg)
+	case "textDocument/documentSymbol":
+		return s.documentSymbols(msg)
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("unsupported method %s", msg.Method)}
+}
+
+// params decodes the params of msg into params, and returns the document they refer to
+func (s *server) params(msg *message, params interface{}, uri func() string) (*document, error) {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params: %s", err)}
+	}
+	doc, ok := s.documents[uri()]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("document %s isn't open", uri())}
+	}
+	return doc, nil
+}
+
+// occurrence decodes the params of msg into params, and returns the occurrence of an identifier
+// at pos, the position they embed
+func (s *server) occurrence(msg *message, params interface{}, pos *textDocumentPositionParams) (*document, resolver.Occurrence, bool, error) {
+	doc, err := s.params(msg, params, func() string { return pos.TextDocument.URI })
+	if err != nil {
+		return nil, resolver.Occurrence{}, false, err
+	}
+	o, ok := doc.analysis.at(doc.column(pos.Position))
+	return doc, o, ok, nil
+}
+
+func (s *server) definition(msg *message) (interface{}, error) {
+	var params textDocumentPositionParams
+	doc, o, ok, err := s.occurrence(msg, &params, &params)
+	if err != nil || !ok || o.Binding.Def == nil {
+		return nil, err
+	}
+	return location{URI: params.TextDocument.URI, Range: doc.rangeOf(o.Binding.Def.Token)}, nil
+}
+
+func (s *server) references(msg *message) (interface{}, error) {
+	var params referenceParams
+	doc, o, ok, err := s.occurrence(msg, &params, &params.textDocumentPositionParams)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	locations := []location{}
+	for _, ref := range o.Binding.Refs {
+		if doc.analysis.Definitions[ref] && !params.Context.IncludeDeclaration {
+			continue
+		}
+		locations = append(locations, location{URI: params.TextDocument.URI, Range: doc.rangeOf(ref.Token)})
+	}
+	return locations, nil
+}
+
+func (s *server) hover(msg *message) (interface{}, error) {
+	var params textDocumentPositionParams
+	doc, o, ok, err := s.occurrence(msg, &params, &params)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	kind := "variable"
+	if o.Binding.Literal != nil {
+		kind = "function"
+	}
+	text := fmt.Sprintf("%s `%s` (%s)", kind, o.Binding.Name, o.Scope)
+	switch {
+	case o.Binding.Def == nil:
+		text = fmt.Sprintf("builtin function `%s` (%s)", o.Binding.Name, o.Scope)
+	case o.Scope == compiler.FreeScope || o.Scope == compiler.FunctionScope:
+		text += fmt.Sprintf("\n\ndefined on line %d as a %s of an enclosing scope", o.Binding.Def.Token.Line, o.Binding.Scope)
+	default:
+		text += fmt.Sprintf("\n\ndefined on line %d", o.Binding.Def.Token.Line)
+	}
+
+	var h hover
+	h.Contents.Kind = "markdown"
+	h.Contents.Value = text
+	h.Range = doc.rangeOf(o.Ident.Token)
+	return h, nil
+}
+
+func (s *server) rename(msg *message) (interface{}, error) {
+	var params renameParams
+	doc, o, ok, err := s.occurrence(msg, &params, &params.textDocumentPositionParams)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if o.Binding.Def == nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("cannot rename the builtin %s", o.Binding.Name)}
+	}
+	if !isIdentifier(params.NewName) {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("%q isn't an identifier", params.NewName)}
+	}
+
+	edits := []textEdit{}
+	for _, ref := range o.Binding.Refs {
+		edits = append(edits, textEdit{Range: doc.rangeOf(ref.Token), NewText: params.NewName})
+	}
+	return map[string]interface{}{"changes": map[string][]textEdit{params.TextDocument.URI: edits}}, nil
+}
+
+// isIdentifier reports whether name lexes as a single identifier (and not a keyword)
+func isIdentifier(name string) bool {
+	l := lexer.New(name)
+	tok := l.NextToken()
+	return tok.Type == token.IDENT && tok.Literal == name && l.NextToken().Type == token.EOF
+}
+
+func (s *server) completion(msg *message) (interface{}, error) {
+	var params textDocumentPositionParams
+	doc, err := s.params(msg, &params, func() string { return params.TextDocument.URI })
+	if err != nil {
+		return nil, err
+	}
+
+	items := []completionItem{}
+	defined := map[string]bool{}
+	for _, b := range doc.analysis.visible(doc.column(params.Position)) {
+		kind := completionVariable
+		if b.Literal != nil {
+			kind = completionFunction
+		}
+		items = append(items, completionItem{Label: b.Name, Kind: kind, Detail: string(b.Scope)})
+		defined[b.Name] = true
+	}
+	for _, builtin := range object.Builtins {
+		if !defined[builtin.Name] { // shadowed by the program
+			items = append(items, completionItem{Label: builtin.Name, Kind: completionFunction, Detail: "builtin"})
+		}
+	}
+	return items, nil
+}
+
+func (s *server) documentSymbols(msg *message) (interface{}, error) {
+	var params documentSymbolParams
+	doc, err := s.params(msg, &params, func() string { return params.TextDocument.URI })
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := []documentSymbol{}
+	for _, let := range doc.analysis.globals {
+		kind := symbolVariable
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			kind = symbolFunction
+		}
+		name := doc.rangeOf(let.Name.Token)
+		symbols = append(symbols, documentSymbol{
+			Name:           let.Name.Value,
+			Kind:           kind,
+			Range:          rng{Start: doc.position(let.Token.Line, let.Token.Column), End: name.End},
+			SelectionRange: name,
+		})
+	}
+	return symbols, nil
+}
+
+// send writes msg. A client which can't be written to will stop sending messages, so errors are
+// left to the reading side.
+func (s *server) send(msg *message) {
+	writeMessage(s.out, msg)
+}
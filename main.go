@@ -1,16 +1,31 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
+	"regexp"
 	"time"
 
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/cover"
+	"github.com/andy9775/monkey/dap"
+	"github.com/andy9775/monkey/debugger"
 	"github.com/andy9775/monkey/evaluator"
 	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/lint"
+	"github.com/andy9775/monkey/lsp"
 	"github.com/andy9775/monkey/object"
 	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/profile"
 	"github.com/andy9775/monkey/repl"
+	"github.com/andy9775/monkey/tester"
+	"github.com/andy9775/monkey/types"
+	"github.com/andy9775/monkey/vm"
 )
 
 func main() {
@@ -49,5 +64,400 @@ func main() {
 		fmt.Printf("hello %s! This is the monkey programming language!\n", user.Username)
 		fmt.Printf("Feel free to type in commands\n")
 		repl.Start(os.Stdin, os.Stdout)
+	} else if os.Args[1] == "debug" {
+		os.Exit(runDebug(os.Args[2:]))
+	} else if os.Args[1] == "dap" {
+		// the client talks to the server over stdin and stdout
+		if err := dap.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else if os.Args[1] == "lint" {
+		os.Exit(runLint(os.Args[2:]))
+	} else if os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	} else if os.Args[1] == "run" {
+		os.Exit(runScript(os.Args[2:]))
+	} else if os.Args[1] == "test" {
+		os.Exit(runTests(os.Args[2:]))
+	} else if os.Args[1] == "lsp" {
+		// as is the language server
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// fileSystemFlags are the flags of the commands running scripts which choose the files the
+// scripts see, see object.ProgramFS
+type fileSystemFlags struct {
+	root     *string
+	readOnly *bool
+}
+
+func addFileSystemFlags(flags *flag.FlagSet) fileSystemFlags {
+	return fileSystemFlags{
+		root:     flags.String("root", "", "the `directory` of the files the script sees (default the directory of the script)"),
+		readOnly: flags.Bool("readonly", false, "don't let the script write or remove files"),
+	}
+}
+
+// streams returns the standard streams with the file system of script
+func (f fileSystemFlags) streams(script string) (*object.IO, error) {
+	fsys, err := object.ProgramFS(script, *f.root, *f.readOnly)
+	if err != nil {
+		return nil, err
+	}
+	streams := object.StdIO()
+	streams.FS = fsys
+	return streams, nil
+}
+
+// runDebug runs a script in the debugger and returns the exit code
+func runDebug(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey debug [-root dir] [-readonly] script.mk")
+		flags.PrintDefaults()
+	}
+	files := addFileSystemFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	file := flags.Arg(0)
+	source, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	streams, err := files.streams(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := debugger.Start(string(source), streams); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runLint lints the files of args and returns the exit code: 1 when there are issues, 2 when
+// the files can't be linted
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey lint [-rules spec] [-json] [-list] script.mk...")
+		flags.PrintDefaults()
+	}
+	spec := flags.String("rules", "all", "the rules and rule sets to check, separated by commas; -name leaves them out (of all the rules when first)")
+	asJSON := flags.Bool("json", false, "write the issues as a JSON array")
+	list := flags.Bool("list", false, "list the rules and exit")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *list {
+		for _, rule := range lint.Rules {
+			fmt.Printf("%-20s %-12s %s\n", rule.Name, rule.Set, rule.Description)
+		}
+		return 0
+	}
+	rules, err := lint.Select(*spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	issues := []lint.Issue{}
+	for _, file := range flags.Args() {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, issue := range lint.Lint(string(source), rules) {
+			issue.File = file
+			issues = append(issues, issue)
+		}
+	}
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(issues, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+	}
+	if len(issues) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseFile parses the program of file, printing the parse errors if there are any
+func parseFile(file string) (*ast.Program, bool) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	for _, msg := range p.Errors() {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, msg)
+	}
+	return program, len(p.Errors()) == 0
+}
+
+// checkFile type checks a parsed program, printing the type errors, and reports whether it has
+// none
+func checkFile(file string, program *ast.Program) bool {
+	errors := types.Check(program)
+	for _, err := range errors {
+		fmt.Printf("%s:%s\n", file, err)
+	}
+	return len(errors) == 0
+}
+
+// runCheck type checks the files of args and returns the exit code: 1 when there are type
+// errors, 2 when the files can't be checked
+func runCheck(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: monkey check script.mk...")
+		return 2
+	}
+
+	code := 0
+	for _, file := range args {
+		program, ok := parseFile(file)
+		if !ok {
+			return 2
+		}
+		if !checkFile(file, program) {
+			code = 1
+		}
+	}
+	return code
+}
+
+// runScript runs a script in the vm and returns the exit code: 1 when the program throws, 2
+// when it can't be run. With -typecheck, the script is type checked first, isn't run if it has
+// type errors and runs with the runtime checks of its annotations.
+func runScript(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey run [-typecheck] [-profile file] [-root dir] [-readonly] script.mk")
+		flags.PrintDefaults()
+	}
+	typecheck := flags.Bool("typecheck", false, "type check the script and check its annotations at runtime")
+	files := addFileSystemFlags(flags)
+	profileFile := flags.String("profile", "", "profile the script: write a table of its profile to stderr and the profile to `file` in the pprof format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	file := flags.Arg(0)
+	program, ok := parseFile(file)
+	if !ok {
+		return 2
+	}
+	if *typecheck {
+		if !checkFile(file, program) {
+			return 2
+		}
+		if err := types.Instrument(program); err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", file, err)
+			return 2
+		}
+	}
+
+	comp := compiler.New()
+	comp.EnableOptimizations()
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+		return 2
+	}
+
+	streams, err := files.streams(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	machine := vm.New(comp.Bytecode())
+	machine.SetIO(streams)
+	if *profileFile != "" {
+		machine.EnableProfiling()
+	}
+	err = machine.Run()
+	if *profileFile != "" {
+		if err := writeProfile(machine.Profile(), file, *profileFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if err != nil {
+		if e, ok := err.(*object.Error); ok {
+			fmt.Fprintf(os.Stderr, "uncaught %s: %s\n", e.Kind, e.Message)
+		} else {
+			fmt.Fprintf(os.Stderr, "uncaught %s\n", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// writeProfile writes the profile p of the script file as a table to stderr and in the pprof
+// format to path
+func writeProfile(p *vm.Profile, file, path string) error {
+	if err := profile.WriteTable(os.Stderr, p, file); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = profile.WritePprof(f, p, file)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runTests runs the tests of the test files in and below the directories of args (the current
+// one by default), or of the test files args names, and returns the exit code: 1 when tests
+// fail, 2 when they can't be run
+func runTests(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey test [-run regexp] [-json | -junit] [-cover] [-coverhtml file] [-coverprofile file] [dir | file.mk]...")
+		flags.PrintDefaults()
+	}
+	run := flags.String("run", "", "only run the tests whose names match the regular expression")
+	asJSON := flags.Bool("json", false, "write the results as JSON")
+	asJUnit := flags.Bool("junit", false, "write the results as JUnit XML")
+	coverText := flags.Bool("cover", false, "record coverage and write a report of it after the results")
+	coverHTML := flags.String("coverhtml", "", "record coverage and write an HTML report of it to `file`")
+	coverLCOV := flags.String("coverprofile", "", "record coverage and write it to `file` in the LCOV format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	cover := *coverText || *coverHTML != "" || *coverLCOV != ""
+
+	var filter *regexp.Regexp
+	if *run != "" {
+		var err error
+		if filter, err = regexp.Compile(*run); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -run: %s\n", err)
+			return 2
+		}
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		found, err := tester.Find(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		files = append(files, found...)
+	}
+
+	results := []tester.FileResult{}
+	failed := false
+	for _, file := range files {
+		result := tester.RunFile(file, tester.Options{Filter: filter, Cover: cover})
+		failed = failed || result.Failed()
+		results = append(results, result)
+	}
+
+	switch {
+	case *asJSON:
+		tester.WriteJSON(os.Stdout, results)
+	case *asJUnit:
+		tester.WriteJUnit(os.Stdout, results)
+	case len(files) == 0:
+		fmt.Println("no test files")
+	default:
+		tester.WriteText(os.Stdout, results)
+	}
+	if cover {
+		if err := writeCoverage(results, *coverText && !*asJSON && !*asJUnit, *coverHTML, *coverLCOV); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// writeCoverage writes the coverage of the files of results which could be run: as text to
+// stdout if text is set, and as HTML and LCOV to the files named (unless they're empty)
+func writeCoverage(results []tester.FileResult, text bool, htmlFile, lcovFile string) error {
+	files := []cover.File{}
+	for _, result := range results {
+		if result.Coverage == nil {
+			continue
+		}
+		source, err := os.ReadFile(result.File)
+		if err != nil {
+			return err
+		}
+		files = append(files, cover.File{Name: result.File, Source: string(source), Profile: result.Coverage})
+	}
+
+	if text {
+		fmt.Println()
+		cover.WriteText(os.Stdout, files)
+	}
+	for _, report := range []struct {
+		path  string
+		write func(io.Writer, []cover.File) error
+	}{{htmlFile, cover.WriteHTML}, {lcovFile, cover.WriteLCOV}} {
+		if report.path == "" {
+			continue
+		}
+		f, err := os.Create(report.path)
+		if err != nil {
+			return err
+		}
+		err = report.write(f, files)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
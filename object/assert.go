@@ -0,0 +1,258 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	The assertion builtins, for tests (see `monkey test`): assert, assert_eq and assert_throws.
+	A failed assertion throws an AssertionError. assert_eq compares values the way programs see
+	them rather than by identity, and its message shows both values, as a line diff of them when
+	they don't fit on a line.
+*/
+
+// maxInline is the length up to which assert_eq shows values on one line
+const maxInline = 40
+
+// assertionError returns the exception of a failed assertion, with the message of the test (if
+// it passed one) before the message of the assertion
+func assertionError(custom []Object, format string, a ...interface{}) Object {
+	msg := fmt.Sprintf(format, a...)
+	if len(custom) > 0 {
+		msg = custom[0].(*String).Value + ": " + msg
+	}
+	return Throw(AssertionErrorKind, "%s", msg)
+}
+
+// assertArgs checks that the builtin name has between min and min+1 arguments, the last of
+// which is the message of the test
+func assertArgs(name string, args []Object, min int) *Exception {
+	if len(args) != min && len(args) != min+1 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=%d or %d", len(args), min, min+1)
+	}
+	if len(args) == min+1 {
+		if _, ok := args[min].(*String); !ok {
+			return Throw(TypeErrorKind, "message of `%s` must be STRING, got %s", name, args[min].Type())
+		}
+	}
+	return nil
+}
+
+// assert implements assert(condition[, message]): throws unless the condition is truthy
+func assert(_ Runtime, args ...Object) Object {
+	if exception := assertArgs("assert", args, 1); exception != nil {
+		return exception
+	}
+
+	switch cond := args[0].(type) {
+	case *Boolean:
+		if cond.Value {
+			return NullValue
+		}
+	case *Null:
+	default:
+		return NullValue
+	}
+	return assertionError(args[1:], "assertion failed")
+}
+
+// assertEq implements assert_eq(actual, expected[, message]): throws unless the values are
+// equal, see Equal
+func assertEq(_ Runtime, args ...Object) Object {
+	if exception := assertArgs("assert_eq", args, 2); exception != nil {
+		return exception
+	}
+
+	actual, expected := args[0], args[1]
+	if Equal(actual, expected) {
+		return NullValue
+	}
+
+	a, e := show(actual, ""), show(expected, "")
+	if len(a) == 1 && len(e) == 1 && len(a[0]) <= maxInline && len(e[0]) <= maxInline {
+		return assertionError(args[2:], "expected %s, got %s", e[0], a[0])
+	}
+	return assertionError(args[2:], "values differ (-expected +actual):\n%s", strings.Join(diff(e, a), "\n"))
+}
+
+// assertThrows implements assert_throws(fn[, kind]): calls fn and throws unless it throws (an
+// error of the kind), returns the error fn threw
+func assertThrows(rt Runtime, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	kind := ""
+	if len(args) == 2 {
+		s, ok := args[1].(*String)
+		if !ok {
+			return Throw(TypeErrorKind, "kind of `assert_throws` must be STRING, got %s", args[1].Type())
+		}
+		kind = s.Value
+	}
+
+	result, err := rt.Call(args[0])
+	if err == nil {
+		return Throw(AssertionErrorKind, "expected an error to be thrown, got %s", strings.Join(show(result, ""), " "))
+	}
+	if kind != "" && err.Kind != kind {
+		return Throw(AssertionErrorKind, "expected a %s to be thrown, got %s: %s", kind, err.Kind, err.Message)
+	}
+	return err
+}
+
+// Equal reports whether a and b are equal values: numbers, strings, booleans and null by value,
+// arrays and hashes by their elements, errors by their kind and message, and anything else by
+// identity
+func Equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Float:
+		b, ok := b.(*Float)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !Equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || len(a.Pairs()) != len(b.Pairs()) {
+			return false
+		}
+		for _, pair := range a.Pairs() {
+			value, ok := b.Get(pair.Key)
+			if !ok || !Equal(pair.Value, value) {
+				return false
+			}
+		}
+		return true
+	case *Error:
+		b, ok := b.(*Error)
+		return ok && a.Kind == b.Kind && a.Message == b.Message
+	default:
+		return a == b
+	}
+}
+
+// show returns the lines showing obj in assertion messages, indented by indent. Strings are
+// quoted, and those which don't fit on a line have a line per line of theirs (each quoted with
+// its newline), as arrays and hashes have a line per element.
+func show(obj Object, indent string) []string {
+	if line := showInline(obj); len(line) <= maxInline {
+		return []string{indent + line}
+	}
+
+	lines := []string{}
+	switch obj := obj.(type) {
+	case *Array:
+		lines = append(lines, indent+"[")
+		for _, el := range obj.Elements {
+			lines = append(lines, withComma(show(el, indent+"  "))...)
+		}
+		lines = append(lines, indent+"]")
+	case *Hash:
+		lines = append(lines, indent+"{")
+		for _, pair := range obj.Pairs() {
+			value := show(pair.Value, indent+"  ")
+			value[0] = indent + "  " + showInline(pair.Key) + ": " + strings.TrimLeft(value[0], " ")
+			lines = append(lines, withComma(value)...)
+		}
+		lines = append(lines, indent+"}")
+	case *String:
+		for _, line := range strings.SplitAfter(obj.Value, "\n") {
+			if line != "" { // after a final newline
+				lines = append(lines, indent+strconv.Quote(line))
+			}
+		}
+	default:
+		lines = append(lines, indent+showInline(obj))
+	}
+	return lines
+}
+
+func withComma(lines []string) []string {
+	lines[len(lines)-1] += ","
+	return lines
+}
+
+func showInline(obj Object) string {
+	switch obj := obj.(type) {
+	case *String:
+		return strconv.Quote(obj.Value)
+	case *Array:
+		elements := []string{}
+		for _, el := range obj.Elements {
+			elements = append(elements, showInline(el))
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *Hash:
+		pairs := []string{}
+		for _, pair := range obj.Pairs() {
+			pairs = append(pairs, showInline(pair.Key)+": "+showInline(pair.Value))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case *Error:
+		return obj.Kind + ": " + obj.Message
+	case nil:
+		return "null"
+	default:
+		return obj.Inspect()
+	}
+}
+
+// diff returns the lines of a diff from a to b: the lines only a has start with -, those only b
+// has with + and the common ones with a space
+func diff(a, b []string) []string {
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i, j = i+1, j+1
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return lines
+}
@@ -1,6 +1,9 @@
 package object
 
-import "fmt"
+import (
+	"strings"
+	"unicode/utf8"
+)
 
 var Builtins = []struct {
 	Name    string
@@ -9,41 +12,31 @@ var Builtins = []struct {
 	{
 		"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(_ Runtime, args ...Object) Object {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 				switch arg := args[0].(type) {
 				case *Array:
-					return &Integer{Value: int64(len(arg.Elements))}
+					return NewInteger(int64(len(arg.Elements)))
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					return NewInteger(int64(utf8.RuneCountInString(arg.Value)))
 				default:
-					return newError("argument to `len` not supported, got %s", args[0].Type())
+					return Throw(TypeErrorKind, "argument to `len` not supported, got %s", args[0].Type())
 				}
 			},
 		},
 	},
-	{
-		"puts",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				for _, arg := range args {
-					fmt.Println(arg.Inspect())
-				}
-				return nil
-			},
-		},
-	},
+	{"puts", &Builtin{Fn: puts}},
 	{
 		"first",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(_ Runtime, args ...Object) Object {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+					return Throw(TypeErrorKind, "argument to `first` must be ARRAY, got %s", args[0].Type())
 				}
 
 				arr := args[0].(*Array)
@@ -58,12 +51,12 @@ var Builtins = []struct {
 	{
 		"last",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(_ Runtime, args ...Object) Object {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+					return Throw(TypeErrorKind, "argument to `last` must be ARRAY, got %s", args[0].Type())
 				}
 
 				arr := args[0].(*Array)
@@ -78,12 +71,12 @@ var Builtins = []struct {
 	{
 		"rest",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(_ Runtime, args ...Object) Object {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `rest` must be ARRAY, got=%s", args[0].Type())
+					return Throw(TypeErrorKind, "argument to `rest` must be ARRAY, got=%s", args[0].Type())
 				}
 
 				arr := args[0].(*Array)
@@ -101,13 +94,13 @@ var Builtins = []struct {
 	{
 		"push",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(_ Runtime, args ...Object) Object {
 				if len(args) != 2 {
-					return newError("wrong number of arguments. got=%d, want=2", len(args))
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=2", len(args))
 				}
 
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+					return Throw(TypeErrorKind, "argument to `push` must be ARRAY, got %s", args[0].Type())
 				}
 
 				arr := args[0].(*Array)
@@ -121,6 +114,193 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		// error returns an error value with the given message and kind (Error by default)
+		// without throwing it
+		"error",
+		&Builtin{
+			Fn: func(_ Runtime, args ...Object) Object {
+				if len(args) != 1 && len(args) != 2 {
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+				}
+
+				message, ok := args[0].(*String)
+				if !ok {
+					return Throw(TypeErrorKind, "argument to `error` must be STRING, got %s", args[0].Type())
+				}
+
+				kind := ErrorKind
+				if len(args) == 2 {
+					k, ok := args[1].(*String)
+					if !ok {
+						return Throw(TypeErrorKind, "second argument to `error` must be STRING, got %s", args[1].Type())
+					}
+					kind = k.Value
+				}
+
+				return NewError(kind, "%s", message.Value)
+			},
+		},
+	},
+	// the builtins below change the array they're given rather than returning a copy
+	{
+		// append! adds the values to the end of the array and returns the array
+		"append!",
+		&Builtin{
+			Fn: func(_ Runtime, args ...Object) Object {
+				if len(args) < 1 {
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want at least 1", len(args))
+				}
+				arr, ok := args[0].(*Array)
+				if !ok {
+					return Throw(TypeErrorKind, "argument to `append!` must be ARRAY, got %s", args[0].Type())
+				}
+
+				if err := arr.Append(args[1:]...); err != nil {
+					return &Exception{Err: err}
+				}
+				return arr
+			},
+		},
+	},
+	{
+		// pop! removes the last element of the array and returns it, null if the array is empty
+		"pop!",
+		&Builtin{
+			Fn: func(_ Runtime, args ...Object) Object {
+				if len(args) != 1 {
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
+				}
+				arr, ok := args[0].(*Array)
+				if !ok {
+					return Throw(TypeErrorKind, "argument to `pop!` must be ARRAY, got %s", args[0].Type())
+				}
+				if len(arr.Elements) == 0 {
+					return nil
+				}
+
+				removed, err := arr.Remove(int64(len(arr.Elements) - 1))
+				if err != nil {
+					return &Exception{Err: err}
+				}
+				return removed
+			},
+		},
+	},
+	{
+		// insert inserts the value before the element at the index and returns the array
+		"insert",
+		&Builtin{
+			Fn: func(_ Runtime, args ...Object) Object {
+				if len(args) != 3 {
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=3", len(args))
+				}
+				arr, ok := args[0].(*Array)
+				if !ok {
+					return Throw(TypeErrorKind, "argument to `insert` must be ARRAY, got %s", args[0].Type())
+				}
+				index, ok := args[1].(*Integer)
+				if !ok {
+					return Throw(TypeErrorKind, "second argument to `insert` must be INTEGER, got %s", args[1].Type())
+				}
+
+				if err := arr.Insert(index.Value, args[2]); err != nil {
+					return &Exception{Err: err}
+				}
+				return arr
+			},
+		},
+	},
+	{
+		// remove removes the element of the array at the index and returns it
+		"remove",
+		&Builtin{
+			Fn: func(_ Runtime, args ...Object) Object {
+				if len(args) != 2 {
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=2", len(args))
+				}
+				arr, ok := args[0].(*Array)
+				if !ok {
+					return Throw(TypeErrorKind, "argument to `remove` must be ARRAY, got %s", args[0].Type())
+				}
+				index, ok := args[1].(*Integer)
+				if !ok {
+					return Throw(TypeErrorKind, "second argument to `remove` must be INTEGER, got %s", args[1].Type())
+				}
+
+				removed, err := arr.Remove(index.Value)
+				if err != nil {
+					return &Exception{Err: err}
+				}
+				return removed
+			},
+		},
+	},
+	{
+		// freeze stops the array from being changed and returns it
+		"freeze",
+		&Builtin{
+			Fn: func(_ Runtime, args ...Object) Object {
+				if len(args) != 1 {
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
+				}
+				arr, ok := args[0].(*Array)
+				if !ok {
+					return Throw(TypeErrorKind, "argument to `freeze` must be ARRAY, got %s", args[0].Type())
+				}
+
+				arr.Freeze()
+				return arr
+			},
+		},
+	},
+	// collections, see collections.go
+	{"map", &Builtin{Fn: mapArray}},
+	{"filter", &Builtin{Fn: filter}},
+	{"reduce", &Builtin{Fn: reduce}},
+	{"sort", &Builtin{Fn: sortArray}},
+	{"range", &Builtin{Fn: rangeArray}},
+	{"zip", &Builtin{Fn: zip}},
+	{"any", &Builtin{Fn: anyElement}},
+	{"all", &Builtin{Fn: allElements}},
+	{"find", &Builtin{Fn: find}},
+	{"flatten", &Builtin{Fn: flatten}},
+	{"unique", &Builtin{Fn: unique}},
+	// strings, see strings.go
+	{"split", &Builtin{Fn: split}},
+	{"join", &Builtin{Fn: join}},
+	{"trim", stringFunction("trim", strings.TrimSpace)},
+	{"upper", stringFunction("upper", strings.ToUpper)},
+	{"lower", stringFunction("lower", strings.ToLower)},
+	{"contains", stringPredicate("contains", strings.Contains)},
+	{"starts_with", stringPredicate("starts_with", strings.HasPrefix)},
+	{"ends_with", stringPredicate("ends_with", strings.HasSuffix)},
+	{"replace", &Builtin{Fn: replace}},
+	{"index_of", &Builtin{Fn: indexOf}},
+	{"substr", &Builtin{Fn: substr}},
+	{"repeat", &Builtin{Fn: repeat}},
+	{"chars", &Builtin{Fn: chars}},
+	{"format", &Builtin{Fn: format}},
+	// json, see json.go
+	{"json_parse", &Builtin{Fn: jsonParse}},
+	{"json_stringify", &Builtin{Fn: jsonStringify}},
+	// io, see io.go
+	{"print", &Builtin{Fn: printValues}},
+	{"eprint", &Builtin{Fn: eprintValues}},
+	{"read_line", &Builtin{Fn: readLine}},
+	{"read_all", &Builtin{Fn: readAll}},
+	// files, see files.go
+	{"read_file", &Builtin{Fn: readFile}},
+	{"write_file", &Builtin{Fn: writeFile}},
+	{"list_dir", &Builtin{Fn: listDir}},
+	{"exists", &Builtin{Fn: exists}},
+	{"remove_file", &Builtin{Fn: removeFile}},
+	// types, see types.go
+	{"check_type", &Builtin{Fn: checkType}},
+	// assertions, see assert.go
+	{"assert", &Builtin{Fn: assert}},
+	{"assert_eq", &Builtin{Fn: assertEq}},
+	{"assert_throws", &Builtin{Fn: assertThrows}},
 }
 
 // GetBuiltinByName returns a built in if found, otherwise nil
@@ -133,7 +313,3 @@ func GetBuiltinByName(name string) *Builtin {
 
 	return nil
 }
-
-func newError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
-}
@@ -0,0 +1,380 @@
+package object
+
+import "sort"
+
+/*
+	The collection builtins. Those taking a function call it through the Runtime, so the function
+	can be a closure of either engine or another builtin. An error thrown by the function stops the
+	builtin and is thrown on.
+
+	None of them change the arrays they're given (see append! and friends for that).
+*/
+
+// maxRange bounds the length of the arrays built by range
+const maxRange = 1 << 24
+
+// callback calls fn through rt with args, converting a thrown error into the Exception a
+// builtin returns
+func callback(rt Runtime, fn Object, args ...Object) (Object, *Exception) {
+	result, err := rt.Call(fn, args...)
+	if err != nil {
+		return nil, &Exception{Err: err}
+	}
+	return result, nil
+}
+
+// arrayAndFunction checks the arguments of the builtins which take an array and a function.
+// It returns a copy of the array's elements, as the function may change the array.
+func arrayAndFunction(name string, args []Object) ([]Object, Object, *Exception) {
+	if len(args) != 2 {
+		return nil, nil, Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, nil, Throw(TypeErrorKind, "argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	if !isCallable(args[1]) {
+		return nil, nil, Throw(TypeErrorKind, "second argument to `%s` must be a function, got %s", name, args[1].Type())
+	}
+	return elementsOf(arr), args[1], nil
+}
+
+func elementsOf(arr *Array) []Object {
+	elements := make([]Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+	return elements
+}
+
+func isCallable(obj Object) bool {
+	switch obj.(type) {
+	case *Function, *Closure, *Builtin:
+		return true
+	default:
+		return false
+	}
+}
+
+// mapArray implements map(arr, fn): the results of calling fn with each element
+func mapArray(rt Runtime, args ...Object) Object {
+	elements, fn, exception := arrayAndFunction("map", args)
+	if exception != nil {
+		return exception
+	}
+
+	results := make([]Object, 0, len(elements))
+	for _, el := range elements {
+		result, exception := callback(rt, fn, el)
+		if exception != nil {
+			return exception
+		}
+		results = append(results, result)
+	}
+	return &Array{Elements: results}
+}
+
+// filter implements filter(arr, fn): the elements for which fn returns a truthy value
+func filter(rt Runtime, args ...Object) Object {
+	elements, fn, exception := arrayAndFunction("filter", args)
+	if exception != nil {
+		return exception
+	}
+
+	kept := []Object{}
+	for _, el := range elements {
+		result, exception := callback(rt, fn, el)
+		if exception != nil {
+			return exception
+		}
+		if IsTruthy(result) {
+			kept = append(kept, el)
+		}
+	}
+	return &Array{Elements: kept}
+}
+
+// reduce implements reduce(arr, initial, fn): fn is called with the result so far (initial to
+// begin with) and each element, its last result is returned
+func reduce(rt Runtime, args ...Object) Object {
+	if len(args) != 3 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=3", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `reduce` must be ARRAY, got %s", args[0].Type())
+	}
+	fn := args[2]
+	if !isCallable(fn) {
+		return Throw(TypeErrorKind, "third argument to `reduce` must be a function, got %s", fn.Type())
+	}
+
+	result := args[1]
+	for _, el := range elementsOf(arr) { // fn may change the array
+		var exception *Exception
+		result, exception = callback(rt, fn, result, el)
+		if exception != nil {
+			return exception
+		}
+	}
+	return result
+}
+
+// sortArray implements sort(arr) and sort(arr, fn). Without fn the elements must be all integers
+// or all strings. fn compares two elements, returning a negative integer if the first goes
+// before the second, a positive one if it goes after and 0 if their order doesn't matter. The
+// sort is stable.
+func sortArray(rt Runtime, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `sort` must be ARRAY, got %s", args[0].Type())
+	}
+
+	elements := elementsOf(arr)
+
+	// the first error stops the comparisons
+	var exception *Exception
+
+	var less func(a, b Object) bool
+	if len(args) == 2 {
+		fn := args[1]
+		if !isCallable(fn) {
+			return Throw(TypeErrorKind, "second argument to `sort` must be a function, got %s", fn.Type())
+		}
+
+		less = func(a, b Object) bool {
+			result, e := callback(rt, fn, a, b)
+			if e != nil {
+				exception = e
+				return false
+			}
+			order, ok := result.(*Integer)
+			if !ok {
+				exception = Throw(TypeErrorKind, "comparison function of `sort` must return INTEGER, got %s", result.Type())
+				return false
+			}
+			return order.Value < 0
+		}
+	} else {
+		less = func(a, b Object) bool {
+			switch a := a.(type) {
+			case *Integer:
+				if b, ok := b.(*Integer); ok {
+					return a.Value < b.Value
+				}
+			case *String:
+				if b, ok := b.(*String); ok {
+					return a.Value < b.Value
+				}
+			}
+			exception = Throw(TypeErrorKind, "cannot compare %s and %s", a.Type(), b.Type())
+			return false
+		}
+	}
+
+	sort.SliceStable(elements, func(i, j int) bool {
+		if exception != nil {
+			return false
+		}
+		return less(elements[i], elements[j])
+	})
+	if exception != nil {
+		return exception
+	}
+
+	return &Array{Elements: elements}
+}
+
+// rangeArray implements range(end), range(start, end) and range(start, end, step): the integers
+// from start (0 by default) up to but excluding end, step (1 by default) apart. A negative step
+// counts down.
+func rangeArray(_ Runtime, args ...Object) Object {
+	if len(args) < 1 || len(args) > 3 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1 to 3", len(args))
+	}
+
+	bounds := []int64{0, 0, 1} // start, end and step
+	for i, arg := range args {
+		integer, ok := arg.(*Integer)
+		if !ok {
+			return Throw(TypeErrorKind, "arguments to `range` must be INTEGER, got %s", arg.Type())
+		}
+		bounds[i] = integer.Value
+	}
+	if len(args) == 1 {
+		bounds[0], bounds[1] = 0, bounds[0]
+	}
+
+	start, end, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return Throw(ArgumentErrorKind, "step of `range` must not be 0")
+	}
+
+	// the length is computed in unsigned integers, so large bounds don't overflow
+	var length uint64
+	switch {
+	case step > 0 && end > start:
+		length = (uint64(end)-uint64(start)-1)/uint64(step) + 1
+	case step < 0 && end < start:
+		length = (uint64(start)-uint64(end)-1)/(-uint64(step)) + 1
+	}
+	if length > maxRange {
+		return Throw(RuntimeErrorKind, "range of %d integers is too large", length)
+	}
+
+	elements := make([]Object, length)
+	for i := range elements {
+		elements[i] = NewInteger(start + int64(i)*step)
+	}
+	return &Array{Elements: elements}
+}
+
+// zip implements zip(arrs...): arrays of the elements at the same index of each array, as many
+// as the shortest array has
+func zip(_ Runtime, args ...Object) Object {
+	if len(args) == 0 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=0, want at least 1")
+	}
+
+	arrays := make([]*Array, len(args))
+	length := -1
+	for i, arg := range args {
+		arr, ok := arg.(*Array)
+		if !ok {
+			return Throw(TypeErrorKind, "arguments to `zip` must be ARRAY, got %s", arg.Type())
+		}
+		arrays[i] = arr
+		if length == -1 || len(arr.Elements) < length {
+			length = len(arr.Elements)
+		}
+	}
+
+	elements := make([]Object, length)
+	for i := range elements {
+		tuple := make([]Object, len(arrays))
+		for j, arr := range arrays {
+			tuple[j] = arr.Elements[i]
+		}
+		elements[i] = &Array{Elements: tuple}
+	}
+	return &Array{Elements: elements}
+}
+
+// anyElement implements any(arr, fn): whether fn returns a truthy value for any element. It
+// stops at the first one.
+func anyElement(rt Runtime, args ...Object) Object {
+	elements, fn, exception := arrayAndFunction("any", args)
+	if exception != nil {
+		return exception
+	}
+
+	for _, el := range elements {
+		result, exception := callback(rt, fn, el)
+		if exception != nil {
+			return exception
+		}
+		if IsTruthy(result) {
+			return True
+		}
+	}
+	return False
+}
+
+// allElements implements all(arr, fn): whether fn returns a truthy value for every element. It
+// stops at the first one it doesn't.
+func allElements(rt Runtime, args ...Object) Object {
+	elements, fn, exception := arrayAndFunction("all", args)
+	if exception != nil {
+		return exception
+	}
+
+	for _, el := range elements {
+		result, exception := callback(rt, fn, el)
+		if exception != nil {
+			return exception
+		}
+		if !IsTruthy(result) {
+			return False
+		}
+	}
+	return True
+}
+
+// find implements find(arr, fn): the first element for which fn returns a truthy value, null
+// if there is none
+func find(rt Runtime, args ...Object) Object {
+	elements, fn, exception := arrayAndFunction("find", args)
+	if exception != nil {
+		return exception
+	}
+
+	for _, el := range elements {
+		result, exception := callback(rt, fn, el)
+		if exception != nil {
+			return exception
+		}
+		if IsTruthy(result) {
+			return el
+		}
+	}
+	return nil
+}
+
+// flatten implements flatten(arr): the elements of arr with the arrays among them replaced by
+// their elements. Only one level is flattened.
+func flatten(_ Runtime, args ...Object) Object {
+	if len(args) != 1 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `flatten` must be ARRAY, got %s", args[0].Type())
+	}
+
+	elements := []Object{}
+	for _, el := range arr.Elements {
+		if inner, ok := el.(*Array); ok {
+			elements = append(elements, inner.Elements...)
+		} else {
+			elements = append(elements, el)
+		}
+	}
+	return &Array{Elements: elements}
+}
+
+// unique implements unique(arr): the elements of arr without repetitions, in the order they
+// first appear. Values which can be hash keys are compared by value, anything else by identity.
+func unique(_ Runtime, args ...Object) Object {
+	if len(args) != 1 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `unique` must be ARRAY, got %s", args[0].Type())
+	}
+
+	seen := NewHash()
+	elements := []Object{}
+	for _, el := range arr.Elements {
+		if IsHashable(el) {
+			if _, ok := seen.Get(el); ok {
+				continue
+			}
+			seen.Set(el, True)
+		} else if containsIdentical(elements, el) {
+			continue
+		}
+		elements = append(elements, el)
+	}
+	return &Array{Elements: elements}
+}
+
+func containsIdentical(elements []Object, obj Object) bool {
+	for _, el := range elements {
+		if el == obj {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,650 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+	Conversion between objects and go values, for programs embedding the interpreter. FromGo
+	turns go values into objects and ToGo does the reverse, both by reflection:
+
+	- bools, integers, floats and strings become booleans, integers, floats and strings
+	- slices and arrays become arrays, maps become hashes (with their keys sorted, as go maps
+	  have no order) and structs become hashes of their exported fields
+	- pointers and interfaces stand for the value they point to, nil ones for null
+	- funcs become builtins, see goFunction
+	- objects stay as they are
+
+	A struct field is named by its monkey tag, `monkey:"name"`, or by its go name if it has
+	none; `monkey:"-"` leaves the field out.
+*/
+
+var (
+	runtimeType = reflect.TypeOf((*Runtime)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// FromGo returns the object for value
+func FromGo(value interface{}) (Object, error) {
+	obj, err := (&converter{}).fromGo(reflect.ValueOf(value))
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ToGo stores obj into the go value target points to, which must be of a type obj converts
+// to. An interface{} receives the go value closest to obj: int64, float64, string, bool, nil,
+// []interface{} or a map[string]interface{} (map[interface{}]interface{} if not all keys are
+// strings), or obj itself for anything else. Functions can't be converted to funcs, only the
+// arguments of funcs called by the program can be (see goFunction).
+func ToGo(obj Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return NewError(TypeErrorKind, "target of ToGo must be a non-nil pointer, got %T", target)
+	}
+
+	if err := (&converter{}).toGo(obj, v.Elem()); err != nil {
+		return err
+	}
+	return nil
+}
+
+type converter struct {
+	rt   Runtime            // for calling functions of the program, nil outside of calls to funcs
+	path []string           // leading to the value being converted, for errors
+	seen map[seenValue]bool // the pointers, maps and slices being converted, to catch cycles
+}
+
+type seenValue struct {
+	kind    reflect.Kind
+	pointer uintptr
+}
+
+// error returns a TypeError saying where in the converted value the problem is
+func (c *converter) error(format string, a ...interface{}) *Error {
+	err := NewError(TypeErrorKind, format, a...)
+	if len(c.path) > 0 {
+		err.Message += " (at " + strings.Join(c.path, "") + ")"
+	}
+	return err
+}
+
+func (c *converter) push(step string) { c.path = append(c.path, step) }
+func (c *converter) pop()             { c.path = c.path[:len(c.path)-1] }
+
+// enter records that v is being converted, reporting false if it already is: v holds itself
+func (c *converter) enter(v reflect.Value) bool {
+	if c.seen == nil {
+		c.seen = make(map[seenValue]bool)
+	}
+	key := seenValue{v.Kind(), v.Pointer()}
+	if c.seen[key] {
+		return false
+	}
+	c.seen[key] = true
+	return true
+}
+
+func (c *converter) leave(v reflect.Value) {
+	delete(c.seen, seenValue{v.Kind(), v.Pointer()})
+}
+
+func (c *converter) fromGo(v reflect.Value) (Object, *Error) {
+	if !v.IsValid() {
+		return NullValue, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Func, reflect.Map, reflect.Slice:
+		if v.IsNil() && v.Kind() != reflect.Map && v.Kind() != reflect.Slice {
+			return NullValue, nil
+		}
+	}
+	if v.CanInterface() {
+		if obj, ok := v.Interface().(Object); ok {
+			return obj, nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return NativeBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, c.error("cannot convert %d to an integer, it's too large", v.Uint())
+		}
+		return NewInteger(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Len() > 0 {
+			if !c.enter(v) {
+				return nil, c.error("cannot convert %s, it holds itself", v.Type())
+			}
+			defer c.leave(v)
+		}
+
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			c.push(fmt.Sprintf("[%d]", i))
+			el, err := c.fromGo(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			c.pop()
+			elements[i] = el
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		return c.mapFromGo(v)
+	case reflect.Struct:
+		hash := NewHash()
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := fieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			c.push("." + v.Type().Field(i).Name)
+			value, err := c.fromGo(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			c.pop()
+			hash.Set(&String{Value: name}, value)
+		}
+		return hash, nil
+	case reflect.Ptr:
+		if !c.enter(v) {
+			return nil, c.error("cannot convert %s, it holds itself", v.Type())
+		}
+		defer c.leave(v)
+		return c.fromGo(v.Elem())
+	case reflect.Interface:
+		return c.fromGo(v.Elem())
+	case reflect.Func:
+		return goFunction(v), nil
+	default:
+		return nil, c.error("cannot convert %s to an object", v.Type())
+	}
+}
+
+func (c *converter) mapFromGo(v reflect.Value) (Object, *Error) {
+	if v.Len() > 0 {
+		if !c.enter(v) {
+			return nil, c.error("cannot convert %s, it holds itself", v.Type())
+		}
+		defer c.leave(v)
+	}
+
+	pairs := make([]HashPair, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := c.fromGo(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if !IsHashable(key) {
+			return nil, c.error("cannot use %s as a hash key", key.Type())
+		}
+
+		c.push(pathIndex(key))
+		value, err := c.fromGo(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		c.pop()
+		pairs = append(pairs, HashPair{Key: key, Value: value})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return keyLess(pairs[i].Key, pairs[j].Key) })
+	hash := NewHash()
+	for _, pair := range pairs {
+		hash.Set(pair.Key, pair.Value)
+	}
+	return hash, nil
+}
+
+// keyLess orders hash keys: integers and strings by value, anything else by type and Inspect
+func keyLess(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value < b.Value
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value < b.Value
+		}
+	}
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	return a.Inspect() < b.Inspect()
+}
+
+func pathIndex(key Object) string {
+	if str, ok := key.(*String); ok {
+		return fmt.Sprintf("[%q]", str.Value)
+	}
+	return "[" + key.Inspect() + "]"
+}
+
+// fieldName returns the key of a struct field in a hash, reporting false if the field is left
+// out
+func fieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" { // unexported
+		return "", false
+	}
+	switch tag := f.Tag.Get("monkey"); tag {
+	case "-":
+		return "", false
+	case "":
+		return f.Name, true
+	default:
+		return tag, true
+	}
+}
+
+func (c *converter) toGo(obj Object, v reflect.Value) *Error {
+	if obj == nil {
+		obj = NullValue
+	}
+
+	t := v.Type()
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		value, err := c.natural(obj)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) { // Object, *Hash, ...
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	if _, ok := obj.(*Null); ok {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return c.error("cannot convert null to %s", t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			v.SetBool(b.Value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			if v.OverflowInt(i.Value) {
+				return c.error("cannot convert %d to %s, it's out of range", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return c.error("cannot convert %d to %s, it's out of range", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *Float:
+			v.SetFloat(n.Value)
+			return nil
+		case *Integer:
+			v.SetFloat(float64(n.Value))
+			return nil
+		}
+	case reflect.String:
+		if str, ok := obj.(*String); ok {
+			v.SetString(str.Value)
+			return nil
+		}
+	case reflect.Slice:
+		if arr, ok := obj.(*Array); ok {
+			slice := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			if err := c.elementsToGo(arr, slice); err != nil {
+				return err
+			}
+			v.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if arr, ok := obj.(*Array); ok {
+			if len(arr.Elements) != t.Len() {
+				return c.error("cannot convert an array of %d elements to %s", len(arr.Elements), t)
+			}
+			return c.elementsToGo(arr, v)
+		}
+	case reflect.Map:
+		if hash, ok := obj.(*Hash); ok {
+			m := reflect.MakeMapWithSize(t, hash.Len())
+			for _, pair := range hash.Pairs() {
+				c.push(pathIndex(pair.Key))
+				key := reflect.New(t.Key()).Elem()
+				if err := c.toGo(pair.Key, key); err != nil {
+					return err
+				}
+				value := reflect.New(t.Elem()).Elem()
+				if err := c.toGo(pair.Value, value); err != nil {
+					return err
+				}
+				c.pop()
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if hash, ok := obj.(*Hash); ok {
+			// keys without a field are ignored, and fields without a key left alone
+			for i := 0; i < t.NumField(); i++ {
+				name, ok := fieldName(t.Field(i))
+				if !ok {
+					continue
+				}
+				value, ok := hash.Get(&String{Value: name})
+				if !ok {
+					continue
+				}
+				c.push(fmt.Sprintf("[%q]", name))
+				if err := c.toGo(value, v.Field(i)); err != nil {
+					return err
+				}
+				c.pop()
+			}
+			return nil
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return c.toGo(obj, v.Elem())
+	case reflect.Func:
+		if isCallable(obj) {
+			return c.function(obj, v)
+		}
+	}
+
+	return c.error("cannot convert %s to %s", obj.Type(), t)
+}
+
+func (c *converter) elementsToGo(arr *Array, v reflect.Value) *Error {
+	for i, el := range arr.Elements {
+		c.push(fmt.Sprintf("[%d]", i))
+		if err := c.toGo(el, v.Index(i)); err != nil {
+			return err
+		}
+		c.pop()
+	}
+	return nil
+}
+
+// natural returns the go value closest to obj, see ToGo
+func (c *converter) natural(obj Object) (interface{}, *Error) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value, nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Null:
+		return nil, nil
+	case *Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			c.push(fmt.Sprintf("[%d]", i))
+			value, err := c.natural(el)
+			if err != nil {
+				return nil, err
+			}
+			c.pop()
+			values[i] = value
+		}
+		return values, nil
+	case *Hash:
+		return c.naturalMap(obj)
+	default:
+		return obj, nil
+	}
+}
+
+func (c *converter) naturalMap(hash *Hash) (interface{}, *Error) {
+	stringKeys := true
+	for _, pair := range hash.Pairs() {
+		if _, ok := pair.Key.(*String); !ok {
+			stringKeys = false
+		}
+	}
+
+	var m reflect.Value
+	if stringKeys {
+		m = reflect.ValueOf(make(map[string]interface{}, hash.Len()))
+	} else {
+		m = reflect.ValueOf(make(map[interface{}]interface{}, hash.Len()))
+	}
+	for _, pair := range hash.Pairs() {
+		if _, ok := pair.Key.(*Array); ok {
+			return nil, c.error("cannot use the array %s as a go map key", pair.Key.Inspect())
+		}
+		key, _ := c.natural(pair.Key) // the other keys are integers, strings and booleans
+
+		c.push(pathIndex(pair.Key))
+		value, err := c.natural(pair.Value)
+		if err != nil {
+			return nil, err
+		}
+		c.pop()
+
+		if value == nil {
+			m.SetMapIndex(reflect.ValueOf(key), reflect.Zero(m.Type().Elem()))
+		} else {
+			m.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(value))
+		}
+	}
+	return m.Interface(), nil
+}
+
+/*
+	Go funcs called by the program.
+
+	goFunction makes a builtin of a func. Its arguments are converted with ToGo, so the program
+	must pass values of the types the func takes. A first parameter of type Runtime isn't passed
+	by the program but receives the runtime calling the func. The func's results are converted
+	with FromGo: no results give null, several an array. A last result of type error is thrown if
+	it isn't nil, as it is if it's an *Error and as an Error otherwise.
+
+	The program can pass its functions for parameters of func types: calling the func calls the
+	function. An error thrown by the function is returned by the func if its last result is an
+	error, and otherwise thrown on once the func returns to the program. Such funcs must only be
+	called while the call from the program is running.
+*/
+
+// callbackError carries an error thrown by a function of the program out of a func which can't
+// return it
+type callbackError struct {
+	err *Error
+}
+
+func goFunction(fn reflect.Value) *Builtin {
+	t := fn.Type()
+
+	first := 0 // the first parameter the program passes
+	if t.NumIn() > 0 && t.In(0) == runtimeType {
+		first = 1
+	}
+	want := t.NumIn() - first
+	if t.IsVariadic() {
+		want--
+	}
+
+	return &Builtin{
+		Fn: func(rt Runtime, args ...Object) (result Object) {
+			if len(args) < want || (!t.IsVariadic() && len(args) > want) {
+				if t.IsVariadic() {
+					return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want at least %d", len(args), want)
+				}
+				return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=%d", len(args), want)
+			}
+
+			in := make([]reflect.Value, 0, first+len(args))
+			if first == 1 {
+				in = append(in, reflect.ValueOf(&rt).Elem())
+			}
+			for i, arg := range args {
+				var paramType reflect.Type
+				if t.IsVariadic() && i >= want {
+					paramType = t.In(t.NumIn() - 1).Elem()
+				} else {
+					paramType = t.In(first + i)
+				}
+
+				param := reflect.New(paramType).Elem()
+				c := &converter{rt: rt, path: []string{fmt.Sprintf("argument %d", i+1)}}
+				if err := c.toGo(arg, param); err != nil {
+					return &Exception{Err: err}
+				}
+				in = append(in, param)
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					callback, ok := r.(callbackError)
+					if !ok {
+						panic(r)
+					}
+					result = &Exception{Err: callback.err}
+				}
+			}()
+			return results(fn.Call(in))
+		},
+	}
+}
+
+// results converts the results of a func, see goFunction
+func results(out []reflect.Value) Object {
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if err := out[len(out)-1]; !err.IsNil() {
+			return &Exception{Err: goError(err.Interface().(error))}
+		}
+		out = out[:len(out)-1]
+	}
+
+	c := &converter{}
+	switch len(out) {
+	case 0:
+		return nil
+	case 1:
+		obj, err := c.fromGo(out[0])
+		if err != nil {
+			return &Exception{Err: err}
+		}
+		return obj
+	default:
+		elements := make([]Object, len(out))
+		for i, value := range out {
+			obj, err := c.fromGo(value)
+			if err != nil {
+				return &Exception{Err: err}
+			}
+			elements[i] = obj
+		}
+		return &Array{Elements: elements}
+	}
+}
+
+func goError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return NewError(ErrorKind, "%s", err)
+}
+
+// function stores a func calling fn into v, see goFunction
+func (c *converter) function(fn Object, v reflect.Value) *Error {
+	if c.rt == nil {
+		return c.error("cannot convert %s to %s outside of a call from the program", fn.Type(), v.Type())
+	}
+	rt, t := c.rt, v.Type()
+
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	numResults := t.NumOut()
+	if returnsError {
+		numResults--
+	}
+
+	v.Set(reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.New(t.Out(i)).Elem()
+		}
+		fail := func(err *Error) []reflect.Value {
+			if !returnsError {
+				panic(callbackError{err})
+			}
+			out[len(out)-1] = reflect.ValueOf(error(err))
+			return out
+		}
+
+		args := make([]Object, len(in))
+		c := &converter{rt: rt}
+		for i, value := range in {
+			arg, err := c.fromGo(value)
+			if err != nil {
+				return fail(err)
+			}
+			args[i] = arg
+		}
+
+		result, err := rt.Call(fn, args...)
+		if err != nil {
+			return fail(err)
+		}
+
+		switch numResults {
+		case 0:
+		case 1:
+			if err := c.toGo(result, out[0]); err != nil {
+				return fail(err)
+			}
+		default:
+			arr, ok := result.(*Array)
+			if !ok || len(arr.Elements) != numResults {
+				return fail(NewError(TypeErrorKind, "function passed for %s must return an array of %d results, got %s", t, numResults, result.Inspect()))
+			}
+			for i, el := range arr.Elements {
+				if err := c.toGo(el, out[i]); err != nil {
+					return fail(err)
+				}
+			}
+		}
+		return out
+	}))
+	return nil
+}
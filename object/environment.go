@@ -3,6 +3,8 @@ package object
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	io    *IO
+	calls int // for the environment of a call, the number of calls running (see Calls)
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -41,3 +43,58 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Capture returns the environment a function defined in e closes over. At the top level of the
+// program that's e itself, so functions see the names defined after them, but in a call it's a
+// copy of the names of the calls e is in (enclosed by the top level): rebinding a name doesn't
+// change the functions defined before, as the vms copy the free variables of closures.
+func (e *Environment) Capture() *Environment {
+	if e.outer == nil {
+		return e
+	}
+
+	captured := NewEnvironment()
+	env := e
+	for ; env.outer != nil; env = env.outer {
+		for name, value := range env.store {
+			if _, ok := captured.store[name]; !ok { // the innermost binding of the name
+				captured.store[name] = value
+			}
+		}
+	}
+	captured.outer = env
+	return captured
+}
+
+// SetCalls records that e is the environment of a call, calls being the number of calls running
+// with it
+func (e *Environment) SetCalls(calls int) {
+	e.calls = calls
+}
+
+// Calls returns the number of calls running in e: those of the call whose environment e is, or
+// encloses, 0 at the top level of the program
+func (e *Environment) Calls() int {
+	for env := e; env != nil; env = env.outer {
+		if env.calls > 0 {
+			return env.calls
+		}
+	}
+	return 0
+}
+
+// SetIO sets the streams of the programs evaluated in e and the environments it encloses
+func (e *Environment) SetIO(io *IO) {
+	e.io = io
+}
+
+// IO returns the streams set for e or the closest environment enclosing it, the standard
+// streams if none are set
+func (e *Environment) IO() *IO {
+	for env := e; env != nil; env = env.outer {
+		if env.io != nil {
+			return env.io
+		}
+	}
+	return StdIO()
+}
@@ -0,0 +1,316 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	The file builtins, which use the file system of the program (IO.FS): read_file, write_file,
+	list_dir, exists and remove_file. Programs only see the files below the root of the file
+	system, paths are relative to it (a leading / is the root) and can't lead out of it with ..
+	(or with symbolic links, see DirFS). Without a file system, the builtins throw.
+
+	Removing a file is remove_file rather than remove, which already removes an element of an
+	array: one name for both would make remove(path) delete a file where an array was meant.
+*/
+
+// FileSystem is the file system of the file builtins. Paths are slash separated and relative
+// to its root, and are valid in the sense of fs.ValidPath.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Remove(name string) error
+}
+
+var (
+	errReadOnly    = errors.New("file system is read-only")
+	errOutsideRoot = errors.New("path leads outside the root")
+)
+
+// DirFS returns the file system of the directory root. Symbolic links are followed as long as
+// they lead to files below root; note that a link changed while it's being followed can still
+// lead out of it.
+func DirFS(root string) (FileSystem, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return dirFS{root: resolved}, nil
+}
+
+type dirFS struct {
+	root string // absolute, without symbolic links
+}
+
+// resolve returns the path of name on the disk, making sure it's below the root
+func (d dirFS) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	// resolve the links of the part of the path which exists, the rest can't hold any
+	existing := filepath.Join(d.root, filepath.FromSlash(name))
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !d.contains(resolved) {
+				return "", &fs.PathError{Op: op, Path: name, Err: errOutsideRoot}
+			}
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, err := os.Lstat(existing); err == nil {
+			// a link to a file which doesn't exist, which may be outside the root
+			return "", &fs.PathError{Op: op, Path: name, Err: errOutsideRoot}
+		}
+
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = filepath.Dir(existing) // the root exists, so this ends
+	}
+}
+
+func (d dirFS) contains(p string) bool {
+	if p == d.root {
+		return true
+	}
+	return strings.HasPrefix(p, strings.TrimSuffix(d.root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func (d dirFS) ReadFile(name string) ([]byte, error) {
+	p, err := d.resolve("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func (d dirFS) WriteFile(name string, data []byte) error {
+	p, err := d.resolve("write", name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := d.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (d dirFS) Remove(name string) error {
+	p, err := d.resolve("remove", name)
+	if err != nil {
+		return err
+	}
+	if p == d.root {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return os.Remove(p)
+}
+
+// ReadOnly returns fsys with writing and removing files refused
+func ReadOnly(fsys FileSystem) FileSystem {
+	return readOnlyFS{fsys}
+}
+
+// ProgramFS returns the file system of the program of the file script: that of the directory of
+// script, or of root unless it's empty, read-only if readOnly is set. The commands running
+// programs from files (run, test, debug and the debug adapter) give them this file system, so a
+// program sees the same files however it's started.
+func ProgramFS(script, root string, readOnly bool) (FileSystem, error) {
+	if root == "" {
+		root = filepath.Dir(script)
+	}
+	fsys, err := DirFS(root)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return ReadOnly(fsys), nil
+	}
+	return fsys, nil
+}
+
+type readOnlyFS struct {
+	FileSystem
+}
+
+func (readOnlyFS) WriteFile(name string, _ []byte) error {
+	return &fs.PathError{Op: "write", Path: name, Err: errReadOnly}
+}
+
+func (readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: errReadOnly}
+}
+
+// FromFS returns a read-only FileSystem of fsys, e.g. an embed.FS or fstest.MapFS
+func FromFS(fsys fs.FS) FileSystem {
+	return readOnlyFS{ioFS{fsys}}
+}
+
+// ioFS is an fs.FS, without the writing methods a FileSystem must have
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (f ioFS) ReadFile(name string) ([]byte, error)       { return fs.ReadFile(f.fsys, name) }
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(f.fsys, name) }
+func (f ioFS) Stat(name string) (fs.FileInfo, error)      { return fs.Stat(f.fsys, name) }
+func (f ioFS) WriteFile(string, []byte) error             { return errReadOnly }
+func (f ioFS) Remove(string) error                        { return errReadOnly }
+
+// fileArgs checks that args are between min and max strings, the first a path, and returns the
+// file system and the path in it (the root if there are no args)
+func fileArgs(rt Runtime, name string, args []Object, min, max int) (FileSystem, string, []string, *Exception) {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return nil, "", nil, Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=%d", len(args), min)
+		}
+		return nil, "", nil, Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=%d or %d", len(args), min, max)
+	}
+	values := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return nil, "", nil, Throw(TypeErrorKind, "argument to `%s` must be STRING, got %s", name, arg.Type())
+		}
+		values[i] = str.Value
+	}
+
+	fsys := streams(rt).FS
+	if fsys == nil {
+		return nil, "", nil, Throw(RuntimeErrorKind, "`%s` needs a file system, the program has none", name)
+	}
+	if len(values) == 0 {
+		return fsys, ".", nil, nil
+	}
+
+	p := path.Clean(strings.TrimLeft(values[0], "/"))
+	if p == ".." || strings.HasPrefix(p, "../") || !fs.ValidPath(p) {
+		return nil, "", nil, Throw(ArgumentErrorKind, "path %s leads outside the root", values[0])
+	}
+	return fsys, p, values[1:], nil
+}
+
+// fileError throws err, the error of the operation op on the file name. Paths in err are left
+// out, for they may be those of the host.
+func fileError(op, name string, err error) *Exception {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return Throw(RuntimeErrorKind, "cannot %s %s: %s", op, name, err)
+}
+
+// readFile implements read_file(path): the contents of the file
+func readFile(rt Runtime, args ...Object) Object {
+	fsys, name, _, exception := fileArgs(rt, "read_file", args, 1, 1)
+	if exception != nil {
+		return exception
+	}
+
+	data, err := fsys.ReadFile(name)
+	if err != nil {
+		return fileError("read", name, err)
+	}
+	if len(data) > maxStringLength {
+		return Throw(RuntimeErrorKind, "file of more than %d bytes is too large", maxStringLength)
+	}
+	return &String{Value: string(data)}
+}
+
+// writeFile implements write_file(path, contents): replaces the file with contents, creating it
+// if it doesn't exist
+func writeFile(rt Runtime, args ...Object) Object {
+	fsys, name, values, exception := fileArgs(rt, "write_file", args, 2, 2)
+	if exception != nil {
+		return exception
+	}
+
+	if err := fsys.WriteFile(name, []byte(values[0])); err != nil {
+		return fileError("write", name, err)
+	}
+	return nil
+}
+
+// listDir implements list_dir() and list_dir(path): the names of the files in the directory
+// (the root by default), sorted
+func listDir(rt Runtime, args ...Object) Object {
+	fsys, name, _, exception := fileArgs(rt, "list_dir", args, 0, 1)
+	if exception != nil {
+		return exception
+	}
+
+	entries, err := fsys.ReadDir(name)
+	if err != nil {
+		return fileError("list", name, err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return stringsToArray(names)
+}
+
+// exists implements exists(path): whether there is a file or directory at path
+func exists(rt Runtime, args ...Object) Object {
+	fsys, name, _, exception := fileArgs(rt, "exists", args, 1, 1)
+	if exception != nil {
+		return exception
+	}
+
+	_, err := fsys.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return False
+	}
+	if err != nil {
+		return fileError("stat", name, err)
+	}
+	return True
+}
+
+// removeFile implements remove_file(path): removes the file or empty directory
+func removeFile(rt Runtime, args ...Object) Object {
+	fsys, name, _, exception := fileArgs(rt, "remove_file", args, 1, 1)
+	if exception != nil {
+		return exception
+	}
+
+	if err := fsys.Remove(name); err != nil {
+		return fileError("remove", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+/*
+	The IO builtins, which use the streams of the engine running the program (see Runtime):
+	puts, print and eprint write to Stdout and Stderr, read_line and read_all read Stdin.
+*/
+
+// IO holds the streams of a program, and the file system the file builtins use (see files.go).
+// A nil Stdin is empty, what's written to a nil Stdout or Stderr is discarded and without an FS
+// the program can't use files.
+type IO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	FS     FileSystem
+
+	in *bufio.Reader // buffers Stdin, so read_line doesn't lose what it reads ahead
+}
+
+// NewIO returns an IO for the given streams
+func NewIO(stdin io.Reader, stdout, stderr io.Writer) *IO {
+	return &IO{Stdin: stdin, Stdout: stdout, Stderr: stderr}
+}
+
+var stdIO = NewIO(os.Stdin, os.Stdout, os.Stderr)
+
+// StdIO returns the IO of the process' standard streams, which engines use unless they are
+// given another
+func StdIO() *IO {
+	return stdIO
+}
+
+func (s *IO) reader() *bufio.Reader {
+	if s.in == nil {
+		if s.Stdin == nil {
+			s.in = bufio.NewReader(bytes.NewReader(nil))
+		} else {
+			s.in = bufio.NewReader(s.Stdin)
+		}
+	}
+	return s.in
+}
+
+// ReadLine returns the next line of Stdin without its line ending, io.EOF once there are none
+func (s *IO) ReadLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := s.reader().ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxStringLength {
+			return "", NewError(RuntimeErrorKind, "line of more than %d bytes is too large", maxStringLength)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			break // the last line doesn't end in a newline
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
+// ReadAll returns the rest of Stdin
+func (s *IO) ReadAll() (string, error) {
+	data, err := io.ReadAll(io.LimitReader(s.reader(), maxStringLength+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxStringLength {
+		return "", NewError(RuntimeErrorKind, "input of more than %d bytes is too large", maxStringLength)
+	}
+	return string(data), nil
+}
+
+// streams returns the IO of rt, which is nil when builtins are called directly
+func streams(rt Runtime) *IO {
+	if rt == nil {
+		return StdIO()
+	}
+	return rt.IO()
+}
+
+// puts implements puts(args...): each argument on a line of its own
+func puts(rt Runtime, args ...Object) Object {
+	var out bytes.Buffer
+	for _, arg := range args {
+		out.WriteString(arg.Inspect())
+		out.WriteByte('\n')
+	}
+	return write(streams(rt).Stdout, out.Bytes())
+}
+
+// printValues implements print(args...): the arguments one after the other, without a newline
+func printValues(rt Runtime, args ...Object) Object {
+	return write(streams(rt).Stdout, concat(args))
+}
+
+// eprintValues implements eprint(args...): print, to Stderr
+func eprintValues(rt Runtime, args ...Object) Object {
+	return write(streams(rt).Stderr, concat(args))
+}
+
+func concat(args []Object) []byte {
+	var out bytes.Buffer
+	for _, arg := range args {
+		out.WriteString(arg.Inspect())
+	}
+	return out.Bytes()
+}
+
+func write(w io.Writer, data []byte) Object {
+	if w == nil {
+		return nil
+	}
+	if _, err := w.Write(data); err != nil {
+		return Throw(RuntimeErrorKind, "cannot write output: %s", err)
+	}
+	return nil
+}
+
+// readLine implements read_line(): the next line of input, null once there are none
+func readLine(rt Runtime, args ...Object) Object {
+	if len(args) != 0 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=0", len(args))
+	}
+
+	line, err := streams(rt).ReadLine()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return readError(err)
+	}
+	return &String{Value: line}
+}
+
+// readAll implements read_all(): the rest of the input, "" once there is none
+func readAll(rt Runtime, args ...Object) Object {
+	if len(args) != 0 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=0", len(args))
+	}
+
+	data, err := streams(rt).ReadAll()
+	if err != nil {
+		return readError(err)
+	}
+	return &String{Value: data}
+}
+
+func readError(err error) Object {
+	if e, ok := err.(*Error); ok {
+		return &Exception{Err: e}
+	}
+	return Throw(RuntimeErrorKind, "cannot read input: %s", err)
+}
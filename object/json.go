@@ -0,0 +1,242 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+	The JSON builtins. json_parse turns objects into hashes (keeping the order of their keys),
+	arrays into arrays, numbers into integers (or floats, when they have a fraction or exponent or
+	don't fit an integer) and strings, booleans and null into themselves. json_stringify does the
+	reverse, for the values json_parse can produce: hash keys must be strings, and functions and
+	errors can't be encoded.
+*/
+
+// maxJSONIndent bounds the indent of json_stringify
+const maxJSONIndent = 16
+
+func jsonParse(_ Runtime, args ...Object) Object {
+	if len(args) != 1 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `json_parse` must be STRING, got %s", args[0].Type())
+	}
+
+	value, err := DecodeJSON(str.Value)
+	if err != nil {
+		return &Exception{Err: err}
+	}
+	return value
+}
+
+func jsonStringify(_ Runtime, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+
+	indent := 0
+	if len(args) == 2 {
+		n, ok := args[1].(*Integer)
+		if !ok {
+			return Throw(TypeErrorKind, "indent of `json_stringify` must be INTEGER, got %s", args[1].Type())
+		}
+		if n.Value < 0 || n.Value > maxJSONIndent {
+			return Throw(ArgumentErrorKind, "indent of `json_stringify` must be between 0 and %d, got %d", maxJSONIndent, n.Value)
+		}
+		indent = int(n.Value)
+	}
+
+	encoded, err := EncodeJSON(args[0], strings.Repeat(" ", indent))
+	if err != nil {
+		return &Exception{Err: err}
+	}
+	return &String{Value: encoded}
+}
+
+// DecodeJSON returns the value of the JSON document data, see json_parse
+func DecodeJSON(data string) (Object, *Error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	value, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, jsonError(err, dec.InputOffset())
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, NewError(ArgumentErrorKind, "invalid JSON at offset %d: data after the value", dec.InputOffset())
+	}
+	return value, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (Object, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			elements := []Object{}
+			for dec.More() {
+				el, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, el)
+			}
+			_, err := dec.Token() // the ]
+			return &Array{Elements: elements}, err
+		}
+
+		// dec.Token only returns the delimiters which start a value here, so this is {
+		hash := NewHash()
+		for dec.More() {
+			key, err := dec.Token() // always a string
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&String{Value: key.(string)}, value)
+		}
+		_, err := dec.Token() // the }
+		return hash, err
+	case json.Number:
+		return jsonNumber(tok)
+	case string:
+		return &String{Value: tok}, nil
+	case bool:
+		return NativeBool(tok), nil
+	default: // nil
+		return NullValue, nil
+	}
+}
+
+func jsonNumber(n json.Number) (Object, error) {
+	if i, err := n.Int64(); err == nil {
+		return NewInteger(i), nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("number %s is out of range", n)
+	}
+	return &Float{Value: f}, nil
+}
+
+func jsonError(err error, offset int64) *Error {
+	var syntax *json.SyntaxError
+	switch {
+	case errors.As(err, &syntax):
+		return NewError(ArgumentErrorKind, "invalid JSON at offset %d: %s", syntax.Offset, syntax)
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return NewError(ArgumentErrorKind, "invalid JSON at offset %d: unexpected end of JSON input", offset)
+	default:
+		return NewError(ArgumentErrorKind, "invalid JSON: %s", err)
+	}
+}
+
+// EncodeJSON returns obj as a JSON document, see json_stringify. Unless indent is empty, nested
+// values go on lines of their own, indented by indent per level.
+func EncodeJSON(obj Object, indent string) (string, *Error) {
+	enc := &jsonEncoder{}
+	if err := enc.encode(obj); err != nil {
+		return "", err
+	}
+	if indent == "" {
+		return enc.buf.String(), nil
+	}
+
+	var out bytes.Buffer
+	json.Indent(&out, enc.buf.Bytes(), "", indent) // the document is valid, so this can't fail
+	return out.String(), nil
+}
+
+type jsonEncoder struct {
+	buf  bytes.Buffer
+	path []string // the indexes leading to the value being encoded, for errors
+}
+
+func (enc *jsonEncoder) encode(obj Object) *Error {
+	switch obj := obj.(type) {
+	case *Integer:
+		enc.buf.WriteString(strconv.FormatInt(obj.Value, 10))
+	case *Float:
+		if math.IsInf(obj.Value, 0) || math.IsNaN(obj.Value) {
+			return enc.error("cannot encode %s as JSON", obj.Inspect())
+		}
+		enc.buf.WriteString(obj.Inspect())
+	case *String:
+		enc.string(obj.Value)
+	case *Boolean:
+		enc.buf.WriteString(strconv.FormatBool(obj.Value))
+	case *Null:
+		enc.buf.WriteString("null")
+	case *Array:
+		enc.buf.WriteByte('[')
+		for i, el := range obj.Elements {
+			if i > 0 {
+				enc.buf.WriteByte(',')
+			}
+			enc.path = append(enc.path, fmt.Sprintf("[%d]", i))
+			if err := enc.encode(el); err != nil {
+				return err
+			}
+			enc.path = enc.path[:len(enc.path)-1]
+		}
+		enc.buf.WriteByte(']')
+	case *Hash:
+		enc.buf.WriteByte('{')
+		for i, pair := range obj.Pairs() {
+			key, ok := pair.Key.(*String)
+			if !ok {
+				return enc.error("cannot encode hash key %s as JSON, keys must be STRING", pair.Key.Inspect())
+			}
+			if i > 0 {
+				enc.buf.WriteByte(',')
+			}
+			enc.string(key.Value)
+			enc.buf.WriteByte(':')
+			enc.path = append(enc.path, fmt.Sprintf("[%q]", key.Value))
+			if err := enc.encode(pair.Value); err != nil {
+				return err
+			}
+			enc.path = enc.path[:len(enc.path)-1]
+		}
+		enc.buf.WriteByte('}')
+	case *Function, *Closure, *CompiledFunction, *Builtin:
+		// the engines give functions different types
+		return enc.error("cannot encode a function as JSON")
+	default:
+		return enc.error("cannot encode %s as JSON", obj.Type())
+	}
+	return nil
+}
+
+func (enc *jsonEncoder) string(s string) {
+	// unlike json.Marshal, this leaves <, > and & alone
+	e := json.NewEncoder(&enc.buf)
+	e.SetEscapeHTML(false)
+	e.Encode(s)
+	enc.buf.Truncate(enc.buf.Len() - 1) // Encode ends the value with a newline
+}
+
+// error returns a TypeError saying where in the encoded value the problem is
+func (enc *jsonEncoder) error(format string, a ...interface{}) *Error {
+	err := NewError(TypeErrorKind, format, a...)
+	if len(enc.path) > 0 {
+		err.Message += " (at " + strings.Join(enc.path, "") + ")"
+	}
+	return err
+}
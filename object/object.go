@@ -8,7 +8,9 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"hash/fnv"
@@ -24,7 +26,9 @@ const (
 	BOOLEAN_OBJ                      = "BOOLEAN"
 	NULL_OBJ                         = "NULL"
 	RETURN_VALUE_OBJ                 = "RETURN_VALUE"
+	TAIL_CALL_OBJ                    = "TAIL_CALL"
 	ERROR_OBJ                        = "ERROR"
+	EXCEPTION_OBJ                    = "EXCEPTION"
 	FUNCTION_OBJ                     = "FUNCTION"
 	COMPILED_FUNCTION_OBJ            = "COMPILED_FUNCTION_OBJ"
 	STRING_OBJ                       = "STRING"
@@ -35,6 +39,8 @@ const (
 	CLOSURE_OBJ = "CLOSURE"
 
 	HASH_OBJ = "HASH"
+
+	FLOAT_OBJ = "FLOAT"
 )
 
 // Object is a wrapper interface around the object system for our language.
@@ -55,6 +61,47 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+// the range of integers NewInteger hands out preallocated objects for
+const (
+	MinCachedInteger = -128
+	MaxCachedInteger = 1024
+)
+
+var integers = func() []*Integer {
+	cache := make([]*Integer, MaxCachedInteger-MinCachedInteger+1)
+	for i := range cache {
+		cache[i] = &Integer{Value: int64(i + MinCachedInteger)}
+	}
+	return cache
+}()
+
+// NewInteger returns an Integer for value. Integers are immutable, so small values share a
+// preallocated object rather than allocating a new one for every result.
+func NewInteger(value int64) *Integer {
+	if value >= MinCachedInteger && value <= MaxCachedInteger {
+		return integers[value-MinCachedInteger]
+	}
+	return &Integer{Value: value}
+}
+
+// ---------- float ----------
+
+// Float is a number with a fraction, such as those read from JSON. The engines only pass floats
+// around: they have no literals and no arithmetic.
+type Float struct {
+	Value float64
+}
+
+// Inspect always shows a fraction or exponent, so a float never looks like an integer
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // IN for Inf and NaN
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 // ---------- string ---------
 
 type String struct {
@@ -73,6 +120,33 @@ type Boolean struct {
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 
+// True and False are the only booleans. The engines compare booleans by identity, so builtins
+// must return these.
+var (
+	True  = &Boolean{Value: true}
+	False = &Boolean{Value: false}
+)
+
+// NativeBool returns True or False
+func NativeBool(value bool) *Boolean {
+	if value {
+		return True
+	}
+	return False
+}
+
+// IsTruthy reports whether obj counts as true in a condition: anything but false and null
+func IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null, nil:
+		return false
+	default:
+		return true
+	}
+}
+
 // ------- null -------
 
 type Null struct{}
@@ -80,6 +154,10 @@ type Null struct{}
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "null" }
 
+// NullValue is the only null. The engines compare null by identity, like booleans, so builtins
+// must use it for the nulls they put into arrays and hashes.
+var NullValue = &Null{}
+
 // --------- return ---------
 
 type ReturnValue struct {
@@ -89,18 +167,111 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// --------- tail call ---------
+
+// TailCall is used by the evaluator for a call in tail position. Rather than applying the
+// function (and growing the go stack) the call is handed back to the caller which applies it
+// in a loop.
+type TailCall struct {
+	Fn   Object
+	Args []Object
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (tc *TailCall) Inspect() string  { return "tail call to " + tc.Fn.Inspect() }
+
 // ---------- error ----------
 
+// The kinds of errors raised by the interpreter; errors created by scripts default to
+// ErrorKind.
+const (
+	ErrorKind         = "Error"
+	TypeErrorKind     = "TypeError"
+	ArgumentErrorKind = "ArgumentError"
+	NameErrorKind     = "NameError"
+	RuntimeErrorKind  = "RuntimeError"
+
+	AssertionErrorKind = "AssertionError" // a failed assertion, see assert.go
+)
+
+// Error is the value a script sees in a catch block. Trace lists the functions the error was
+// thrown through, innermost first. Errors are also go errors so the vm can return them from Run.
 type Error struct {
 	Message string
+	Kind    string
+	Trace   []string
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Error() string    { return e.Message }
+
+// NewError returns an error of the given kind
+func NewError(kind string, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Kind: kind}
+}
+
+// AnonymousFunction is the name traces use for functions that weren't bound by a let statement
+const AnonymousFunction = "<anonymous>"
+
+// AddTrace records that the error was thrown out of the function with the given name
+func (e *Error) AddTrace(name string) {
+	if name == "" {
+		name = AnonymousFunction
+	}
+	e.Trace = append(e.Trace, name)
+}
+
+// Field returns the value of `e["message"]`, `e["kind"]` or `e["trace"]`, nil for any other name
+func (e *Error) Field(name string) Object {
+	switch name {
+	case "message":
+		return &String{Value: e.Message}
+	case "kind":
+		return &String{Value: e.Kind}
+	case "trace":
+		trace := make([]Object, len(e.Trace))
+		for i, name := range e.Trace {
+			trace[i] = &String{Value: name}
+		}
+		return &Array{Elements: trace}
+	}
+	return nil
+}
+
+// ToError converts a thrown value into an error. Errors are thrown as they are, anything else is
+// wrapped into an ErrorKind error whose message is the string's value or the object's Inspect.
+func ToError(obj Object) *Error {
+	switch obj := obj.(type) {
+	case *Error:
+		return obj
+	case *String:
+		return NewError(ErrorKind, "%s", obj.Value)
+	default:
+		return NewError(ErrorKind, "%s", obj.Inspect())
+	}
+}
+
+// --------- exception ---------
+
+// Exception is used by the evaluator (and returned by builtins) for an error in flight. Like a
+// ReturnValue it stops the evaluation of blocks until it's caught or reaches the top level.
+type Exception struct {
+	Err *Error
+}
+
+func (ex *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (ex *Exception) Inspect() string  { return ex.Err.Inspect() }
+
+// Throw returns an exception for an error of the given kind
+func Throw(kind string, format string, a ...interface{}) *Exception {
+	return &Exception{Err: NewError(kind, format, a...)}
+}
 
 // ------------- func -------------
 
 type Function struct {
+	Name       string // the name the function was bound to by a let statement, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	/*
@@ -134,11 +305,26 @@ func (f *Function) Inspect() string {
 // it is used for the vm/compiler
 type CompiledFunction struct {
 	Instructions code.Instructions
+	// Name is the name the function was bound to by a let statement, if any
+	Name string
 	// NumLocals specifies the number of locally scope variables this function uses
 	NumLocals int
 
 	// NumParameters specifies how many arguments this function expects
 	NumParameters int
+
+	// NumRegisters is the size of the function's frame when Instructions is register code
+	// (its locals and temporaries)
+	NumRegisters int
+
+	// Handlers is the exception handler table of the function
+	Handlers []code.Handler
+
+	// Lines maps Instructions to source lines, and LocalNames and FreeNames are the names of the
+	// function's locals and free variables by index, for debuggers. Register code has none.
+	Lines      code.LineTable
+	LocalNames []string
+	FreeNames  []string
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -158,7 +344,18 @@ func (c *Closure) Inspect() string {
 
 // --------- built in funcs ---------
 
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction implements a builtin. Builtins which take functions call them through rt.
+type BuiltinFunction func(rt Runtime, args ...Object) Object
+
+// Runtime is the engine running a builtin
+type Runtime interface {
+	// Call calls fn, a function or builtin, with args. An error thrown by the call is returned
+	// instead of a value.
+	Call(fn Object, args ...Object) (Object, *Error)
+
+	// IO returns the streams of the program, see io.go
+	IO() *IO
+}
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -169,8 +366,11 @@ func (b *Builtin) Inspect() string  { return "builtin function" }
 
 // ---------- array ----------
 
+// Array is shared by everything holding it: changes made through one binding (or closure) are
+// seen by all of them. A frozen array can't be changed.
 type Array struct {
 	Elements []Object
+	Frozen   bool
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
@@ -189,6 +389,197 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// Freeze stops the array from being changed. The arrays it holds can still be changed.
+func (ao *Array) Freeze() { ao.Frozen = true }
+
+// SetIndex replaces the element at index, a negative index counts from the end
+func (ao *Array) SetIndex(index int64, value Object) *Error {
+	if err := ao.checkInsert(value); err != nil {
+		return err
+	}
+	i, ok := position(len(ao.Elements), index)
+	if !ok {
+		return NewError(RuntimeErrorKind, "index out of range: %d (length %d)", index, len(ao.Elements))
+	}
+
+	ao.Elements[i] = value
+	return nil
+}
+
+// Append adds values to the end of the array
+func (ao *Array) Append(values ...Object) *Error {
+	for _, value := range values {
+		if err := ao.checkInsert(value); err != nil {
+			return err
+		}
+	}
+
+	ao.Elements = append(ao.Elements, values...)
+	return nil
+}
+
+// Insert inserts value before the element at index, index may be the length of the array
+func (ao *Array) Insert(index int64, value Object) *Error {
+	if err := ao.checkInsert(value); err != nil {
+		return err
+	}
+	if index < 0 || index > int64(len(ao.Elements)) {
+		return NewError(RuntimeErrorKind, "index out of range: %d (length %d)", index, len(ao.Elements))
+	}
+
+	ao.Elements = append(ao.Elements, nil)
+	copy(ao.Elements[index+1:], ao.Elements[index:])
+	ao.Elements[index] = value
+	return nil
+}
+
+// Remove removes the element at index and returns it
+func (ao *Array) Remove(index int64) (Object, *Error) {
+	if err := ao.checkMutable(); err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= int64(len(ao.Elements)) {
+		return nil, NewError(RuntimeErrorKind, "index out of range: %d (length %d)", index, len(ao.Elements))
+	}
+
+	removed := ao.Elements[index]
+	copy(ao.Elements[index:], ao.Elements[index+1:])
+	ao.Elements[len(ao.Elements)-1] = nil // don't keep the last element alive
+	ao.Elements = ao.Elements[:len(ao.Elements)-1]
+	return removed, nil
+}
+
+func (ao *Array) checkMutable() *Error {
+	if ao.Frozen {
+		return NewError(TypeErrorKind, "cannot modify a frozen array")
+	}
+	return nil
+}
+
+// checkInsert reports an error if value can't be put into the array. Arrays can't hold
+// themselves: printing, comparing or hashing them would never end.
+func (ao *Array) checkInsert(value Object) *Error {
+	if err := ao.checkMutable(); err != nil {
+		return err
+	}
+	if reaches(value, ao) {
+		return NewError(RuntimeErrorKind, "cannot put an array into itself")
+	}
+	return nil
+}
+
+// reaches reports whether array is obj or is held by it, through arrays and hashes
+func reaches(obj Object, array *Array) bool {
+	switch obj := obj.(type) {
+	case *Array:
+		if obj == array {
+			return true
+		}
+		for _, el := range obj.Elements {
+			if reaches(el, array) {
+				return true
+			}
+		}
+	case *Hash:
+		for _, pair := range obj.pairs {
+			if reaches(pair.Value, array) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Index returns the element of an array or the character of a string at index, a string itself.
+// A negative index counts from the end as it does in Slice. It reports false if index is out of
+// range.
+func Index(obj Object, index int64) (Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		i, ok := position(len(obj.Elements), index)
+		if !ok {
+			return nil, false
+		}
+		return obj.Elements[i], true
+	case *String:
+		runes := []rune(obj.Value)
+		i, ok := position(len(runes), index)
+		if !ok {
+			return nil, false
+		}
+		return &String{Value: string(runes[i])}, true
+	default:
+		return nil, false
+	}
+}
+
+// position returns index as a position within length elements, counting a negative index from
+// the end
+func position(length int, index int64) (int, bool) {
+	if index < 0 {
+		index += int64(length)
+	}
+	if index < 0 || index >= int64(length) {
+		return 0, false
+	}
+	return int(index), true
+}
+
+// Slice returns the part of an array or string from start up to but excluding end, a new array
+// for arrays and the characters between them for strings. A bound which is nil or null is left out:
+// start defaults to 0 and end to the length. Negative bounds count from the end, and bounds
+// outside of the array or string are moved to its nearest end, so a slice is never out of range
+// (it's empty when start isn't before end).
+func Slice(obj, start, end Object) (Object, *Error) {
+	switch obj := obj.(type) {
+	case *Array:
+		from, to, err := sliceBounds(len(obj.Elements), start, end)
+		if err != nil {
+			return nil, err
+		}
+		elements := make([]Object, to-from)
+		copy(elements, obj.Elements[from:to])
+		return &Array{Elements: elements}, nil
+	case *String:
+		runes := []rune(obj.Value)
+		from, to, err := sliceBounds(len(runes), start, end)
+		if err != nil {
+			return nil, err
+		}
+		return &String{Value: string(runes[from:to])}, nil
+	default:
+		return nil, NewError(TypeErrorKind, "slice operator not supported: %s", obj.Type())
+	}
+}
+
+func sliceBounds(length int, start, end Object) (int, int, *Error) {
+	bounds := []int{0, length}
+	for i, bound := range []Object{start, end} {
+		switch bound := bound.(type) {
+		case nil, *Null:
+			continue
+		case *Integer:
+			value := bound.Value
+			if value < 0 {
+				value += int64(length)
+			}
+			if value < 0 {
+				value = 0
+			} else if value > int64(length) {
+				value = int64(length)
+			}
+			bounds[i] = int(value)
+		default:
+			return 0, 0, NewError(TypeErrorKind, "slice bounds must be INTEGER, got %s", bound.Type())
+		}
+	}
+
+	if bounds[0] > bounds[1] {
+		bounds[0] = bounds[1]
+	}
+	return bounds[0], bounds[1], nil
+}
+
 // --------- hash ---------
 
 type HashPair struct {
@@ -196,16 +587,81 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps keys to values and keeps them in insertion order. Keys are bucketed by their
+// HashKey and compared by value within a bucket, so keys whose HashKeys collide don't replace
+// each other.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs   []HashPair
+	buckets map[HashKey][]int // indexes into pairs
 }
 
+// NewHash returns an empty Hash
+func NewHash() *Hash {
+	return &Hash{buckets: make(map[HashKey][]int)}
+}
+
+// Set associates value with key. Setting an existing key keeps its position. It reports false
+// if key can't be used as a hash key (see IsHashable).
+func (h *Hash) Set(key, value Object) bool {
+	if !IsHashable(key) {
+		return false
+	}
+
+	hashKey := key.(Hashable).HashKey()
+	for _, i := range h.buckets[hashKey] {
+		if keysEqual(h.pairs[i].Key, key) {
+			h.pairs[i].Value = value
+			return true
+		}
+	}
+
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: frozenKey(key), Value: value})
+	return true
+}
+
+// frozenKey returns a frozen copy of an array key, so changing the array later doesn't change
+// the key it was stored under
+func frozenKey(key Object) Object {
+	array, ok := key.(*Array)
+	if !ok {
+		return key
+	}
+
+	elements := make([]Object, len(array.Elements))
+	for i, el := range array.Elements {
+		elements[i] = frozenKey(el)
+	}
+	return &Array{Elements: elements, Frozen: true}
+}
+
+// Get returns the value associated with key. It reports false if there is none or key can't
+// be used as a hash key.
+func (h *Hash) Get(key Object) (Object, bool) {
+	if !IsHashable(key) {
+		return nil, false
+	}
+
+	for _, i := range h.buckets[key.(Hashable).HashKey()] {
+		if keysEqual(h.pairs[i].Key, key) {
+			return h.pairs[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of pairs
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs in insertion order. The slice must not be modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
+
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -227,6 +683,50 @@ type HashKey struct {
 	Value uint64
 }
 
+// IsHashable reports whether obj can be used as a hash key: integers, booleans, strings and
+// arrays of those
+func IsHashable(obj Object) bool {
+	if array, ok := obj.(*Array); ok {
+		for _, el := range array.Elements {
+			if !IsHashable(el) {
+				return false
+			}
+		}
+		return true
+	}
+
+	_, ok := obj.(Hashable)
+	return ok
+}
+
+// keysEqual compares hash keys by value
+func keysEqual(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !keysEqual(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
 func (b *Boolean) HashKey() HashKey {
 	var value uint64
 
@@ -249,3 +749,18 @@ func (s *String) HashKey() HashKey {
 
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
+
+// HashKey combines the keys of the elements, which must be hashable (see IsHashable)
+func (ao *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+
+	for _, el := range ao.Elements {
+		key := el.(Hashable).HashKey()
+		h.Write([]byte(key.Type))
+		binary.BigEndian.PutUint64(buf, key.Value)
+		h.Write(buf)
+	}
+
+	return HashKey{Type: ao.Type(), Value: h.Sum64()}
+}
@@ -1,7 +1,14 @@
 package object_test
 
 import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/andy9775/monkey/object"
 )
@@ -24,3 +31,759 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash key")
 	}
 }
+
+func TestNewInteger(t *testing.T) {
+	for _, value := range []int64{object.MinCachedInteger, -1, 0, 1, 1024, object.MaxCachedInteger} {
+		if object.NewInteger(value) != object.NewInteger(value) {
+			t.Errorf("integer %d is not cached", value)
+		}
+		if got := object.NewInteger(value).Value; got != value {
+			t.Errorf("wrong value. want=%d, got=%d", value, got)
+		}
+	}
+
+	for _, value := range []int64{object.MinCachedInteger - 1, object.MaxCachedInteger + 1, 1 << 40} {
+		if object.NewInteger(value) == object.NewInteger(value) {
+			t.Errorf("integer %d is cached", value)
+		}
+		if got := object.NewInteger(value).Value; got != value {
+			t.Errorf("wrong value. want=%d, got=%d", value, got)
+		}
+	}
+}
+
+// collidingKey is a hash key whose HashKey collides with every other collidingKey
+type collidingKey struct{ name string }
+
+func (c *collidingKey) Type() object.ObjectType { return "COLLIDING" }
+func (c *collidingKey) Inspect() string         { return c.name }
+func (c *collidingKey) HashKey() object.HashKey {
+	return object.HashKey{Type: c.Type(), Value: 1}
+}
+
+func TestHash(t *testing.T) {
+	hash := object.NewHash()
+
+	a, b := &collidingKey{"a"}, &collidingKey{"b"}
+	hash.Set(&object.String{Value: "z"}, object.NewInteger(1))
+	hash.Set(a, object.NewInteger(2))
+	hash.Set(b, object.NewInteger(3))
+	hash.Set(&object.Array{Elements: []object.Object{object.NewInteger(1)}}, object.NewInteger(4))
+	hash.Set(&object.String{Value: "z"}, object.NewInteger(5)) // keeps its position
+
+	if ok := hash.Set(&object.Array{Elements: []object.Object{&object.Hash{}}}, object.NewInteger(6)); ok {
+		t.Errorf("array of hashes used as hash key")
+	}
+
+	expected := "{z: 5, a: 2, b: 3, [1]: 4}"
+	if hash.Inspect() != expected {
+		t.Errorf("wrong hash. want=%q, got=%q", expected, hash.Inspect())
+	}
+
+	tests := []struct {
+		key      object.Object
+		expected int64
+	}{
+		{a, 2},
+		{b, 3},
+		{&object.String{Value: "z"}, 5},
+		{&object.Array{Elements: []object.Object{object.NewInteger(1)}}, 4},
+	}
+
+	for _, tt := range tests {
+		value, ok := hash.Get(tt.key)
+		if !ok {
+			t.Errorf("no value for %s", tt.key.Inspect())
+			continue
+		}
+		if value.(*object.Integer).Value != tt.expected {
+			t.Errorf("wrong value for %s. want=%d, got=%s", tt.key.Inspect(), tt.expected, value.Inspect())
+		}
+	}
+
+	if _, ok := hash.Get(&collidingKey{"c"}); ok {
+		t.Errorf("colliding key c found")
+	}
+}
+
+func TestArrayHashKey(t *testing.T) {
+	array := func(elements ...object.Object) *object.Array {
+		return &object.Array{Elements: elements}
+	}
+
+	one := array(object.NewInteger(1), &object.String{Value: "a"})
+	two := array(object.NewInteger(1), &object.String{Value: "a"})
+	diff := array(&object.String{Value: "a"}, object.NewInteger(1))
+
+	if one.HashKey() != two.HashKey() {
+		t.Errorf("arrays with the same content have different hash keys")
+	}
+	if one.HashKey() == diff.HashKey() {
+		t.Errorf("arrays with different content have same hash key")
+	}
+}
+
+func TestArrayChanges(t *testing.T) {
+	one, two, three := object.NewInteger(1), object.NewInteger(2), object.NewInteger(3)
+	array := &object.Array{Elements: []object.Object{one}}
+
+	if err := array.Append(two, three); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+	if err := array.Insert(0, three); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	if err := array.SetIndex(1, two); err != nil {
+		t.Fatalf("set failed: %s", err)
+	}
+	removed, err := array.Remove(2)
+	if err != nil {
+		t.Fatalf("remove failed: %s", err)
+	}
+	if removed != two {
+		t.Errorf("wrong element removed. got=%s", removed.Inspect())
+	}
+	if array.Inspect() != "[3, 2, 3]" {
+		t.Errorf("wrong elements. got=%s", array.Inspect())
+	}
+
+	for _, err := range []*object.Error{
+		array.SetIndex(3, one),
+		array.Insert(4, one),
+		array.Append(array),
+		array.SetIndex(0, &object.Array{Elements: []object.Object{array}}),
+	} {
+		if err == nil || err.Kind != object.RuntimeErrorKind {
+			t.Errorf("expected a RuntimeError, got %v", err)
+		}
+	}
+
+	array.Freeze()
+	if err := array.Append(one); err == nil || err.Kind != object.TypeErrorKind {
+		t.Errorf("frozen array changed, got %v", err)
+	}
+	if _, err := array.Remove(0); err == nil || err.Kind != object.TypeErrorKind {
+		t.Errorf("frozen array changed, got %v", err)
+	}
+}
+
+func TestSlice(t *testing.T) {
+	array := &object.Array{Elements: []object.Object{
+		object.NewInteger(1), object.NewInteger(2), object.NewInteger(3),
+	}}
+	str := &object.String{Value: "monkey"}
+	integer := func(i int64) object.Object { return object.NewInteger(i) }
+
+	tests := []struct {
+		obj        object.Object
+		start, end object.Object
+		expected   string
+	}{
+		{array, nil, nil, "[1, 2, 3]"},
+		{array, integer(1), nil, "[2, 3]"},
+		{array, nil, &object.Null{}, "[1, 2, 3]"},
+		{array, integer(-2), integer(-1), "[2]"},
+		{array, integer(-10), integer(10), "[1, 2, 3]"},
+		{array, integer(2), integer(1), "[]"},
+		{array, integer(5), nil, "[]"},
+		{str, integer(3), nil, "key"},
+		{str, nil, integer(-3), "mon"},
+	}
+
+	for _, tt := range tests {
+		result, err := object.Slice(tt.obj, tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("slice failed: %s", err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong slice of %s. want=%s, got=%s", tt.obj.Inspect(), tt.expected, result.Inspect())
+		}
+	}
+
+	result, _ := object.Slice(array, nil, nil)
+	if result == array {
+		t.Errorf("slice isn't a copy")
+	}
+
+	if _, err := object.Slice(integer(1), nil, nil); err == nil || err.Kind != object.TypeErrorKind {
+		t.Errorf("expected a TypeError, got %v", err)
+	}
+	if _, err := object.Slice(array, str, nil); err == nil || err.Kind != object.TypeErrorKind {
+		t.Errorf("expected a TypeError, got %v", err)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []string{
+		`1`,
+		`-12`,
+		`1.5`,
+		`1.0`,
+		`1e+100`,
+		`"a \"quoted\" <string> & é\n"`,
+		`true`,
+		`null`,
+		`[]`,
+		`{}`,
+		`[1,"two",[3.5,null],{"four":false}]`,
+		// keys keep their order
+		`{"z":1,"a":{"y":[],"b":2},"m":3}`,
+		// too large for an integer
+		`1e+19`,
+	}
+
+	for _, input := range tests {
+		decoded, err := object.DecodeJSON(input)
+		if err != nil {
+			t.Fatalf("decoding %s failed: %s", input, err)
+		}
+		encoded, err := object.EncodeJSON(decoded, "")
+		if err != nil {
+			t.Fatalf("encoding %s failed: %s", decoded.Inspect(), err)
+		}
+		if encoded != input {
+			t.Errorf("wrong round trip. want=%s, got=%s", input, encoded)
+		}
+	}
+}
+
+func TestJSONValues(t *testing.T) {
+	decoded, err := object.DecodeJSON(`{"n": 9007199254740993, "f": 2.5, "i": 2e0, "s": "x", "nil": null}`)
+	if err != nil {
+		t.Fatalf("decoding failed: %s", err)
+	}
+	hash := decoded.(*object.Hash)
+
+	get := func(key string) object.Object {
+		value, _ := hash.Get(&object.String{Value: key})
+		return value
+	}
+	if n, ok := get("n").(*object.Integer); !ok || n.Value != 9007199254740993 {
+		t.Errorf("integer not decoded exactly. got=%v", get("n"))
+	}
+	if f, ok := get("f").(*object.Float); !ok || f.Value != 2.5 {
+		t.Errorf("float not decoded. got=%v", get("f"))
+	}
+	if f, ok := get("i").(*object.Float); !ok || f.Inspect() != "2.0" {
+		t.Errorf("number with an exponent not decoded as a float. got=%v", get("i"))
+	}
+	if get("nil") != object.NullValue {
+		t.Errorf("null not decoded as NullValue. got=%v", get("nil"))
+	}
+
+	indented, _ := object.EncodeJSON(&object.Array{Elements: []object.Object{
+		object.NewInteger(1), &object.Array{},
+	}}, "  ")
+	if indented != "[\n  1,\n  []\n]" {
+		t.Errorf("wrong indentation. got=%q", indented)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		`{"a" 1}`: "invalid JSON at offset 6: invalid character '1' after object key",
+		`[1, 2`:   "invalid JSON at offset 5: unexpected end of JSON input",
+		``:        "invalid JSON at offset 0: unexpected end of JSON input",
+		`{} []`:   "invalid JSON at offset 4: data after the value",
+		`-1e400`:  "invalid JSON: number -1e400 is out of range",
+	} {
+		_, err := object.DecodeJSON(input)
+		if err == nil || err.Kind != object.ArgumentErrorKind || err.Message != expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", input, expected, err)
+		}
+	}
+
+	fn := &object.Builtin{}
+	nested := object.NewHash()
+	nested.Set(&object.String{Value: "f"}, fn)
+	intKeys := object.NewHash()
+	intKeys.Set(object.NewInteger(1), object.NewInteger(2))
+
+	for _, tt := range []struct {
+		value    object.Object
+		expected string
+	}{
+		{fn, "cannot encode a function as JSON"},
+		{&object.Array{Elements: []object.Object{object.NewInteger(1), nested}}, `cannot encode a function as JSON (at [1]["f"])`},
+		{intKeys, "cannot encode hash key 1 as JSON, keys must be STRING"},
+		{object.NewError(object.ErrorKind, "boom"), "cannot encode ERROR as JSON"},
+	} {
+		_, err := object.EncodeJSON(tt.value, "")
+		if err == nil || err.Kind != object.TypeErrorKind || err.Message != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.value.Inspect(), tt.expected, err)
+		}
+	}
+}
+
+func TestArrayKeysAreCopied(t *testing.T) {
+	key := &object.Array{Elements: []object.Object{object.NewInteger(1)}}
+
+	hash := object.NewHash()
+	hash.Set(key, object.NewInteger(1))
+	key.SetIndex(0, object.NewInteger(2))
+
+	if _, ok := hash.Get(&object.Array{Elements: []object.Object{object.NewInteger(1)}}); !ok {
+		t.Errorf("key changed with the array it was set with")
+	}
+	if _, ok := hash.Get(key); ok {
+		t.Errorf("changed array found")
+	}
+}
+
+type point struct {
+	X, Y   int
+	Label  string `monkey:"label"`
+	Hidden bool   `monkey:"-"`
+	secret int
+}
+
+func TestFromGo(t *testing.T) {
+	var nilPointer *point
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint64(7), "7"},
+		{2.5, "2.5"},
+		{"monkey", "monkey"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]int(nil), "[]"},
+		// go maps have no order, their keys are sorted
+		{map[string]int{"b": 2, "a": 1, "c": 3}, "{a: 1, b: 2, c: 3}"},
+		{map[int]bool{10: true, -1: false}, "{-1: false, 10: true}"},
+		{point{X: 1, Y: 2, Label: "p", Hidden: true}, "{X: 1, Y: 2, label: p}"},
+		{&point{}, "{X: 0, Y: 0, label: }"},
+		{nilPointer, "null"},
+		{[]interface{}{1, "a", nil, []float64{0.5}}, "[1, a, null, [0.5]]"},
+		{object.NewInteger(5), "5"},
+	}
+
+	for _, tt := range tests {
+		obj, err := object.FromGo(tt.value)
+		if err != nil {
+			t.Fatalf("converting %#v failed: %s", tt.value, err)
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("wrong object for %#v. want=%s, got=%s", tt.value, tt.expected, obj.Inspect())
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	decoded, err := object.DecodeJSON(`{"X": 1, "label": "p", "Hidden": true, "unknown": 3}`)
+	if err != nil {
+		t.Fatalf("decoding failed: %s", err)
+	}
+	var p point
+	if err := object.ToGo(decoded, &p); err != nil {
+		t.Fatalf("converting to a struct failed: %s", err)
+	}
+	if p != (point{X: 1, Label: "p"}) {
+		t.Errorf("wrong struct. got=%+v", p)
+	}
+
+	decoded, _ = object.DecodeJSON(`{"a": [1, 2], "b": []}`)
+	var m map[string][]uint8
+	if err := object.ToGo(decoded, &m); err != nil {
+		t.Fatalf("converting to a map failed: %s", err)
+	}
+	if !reflect.DeepEqual(m, map[string][]uint8{"a": {1, 2}, "b": {}}) {
+		t.Errorf("wrong map. got=%v", m)
+	}
+
+	decoded, _ = object.DecodeJSON(`[1, 2.5, "s", true, null, {"k": [false]}]`)
+	var value interface{}
+	if err := object.ToGo(decoded, &value); err != nil {
+		t.Fatalf("converting to an interface{} failed: %s", err)
+	}
+	expected := []interface{}{int64(1), 2.5, "s", true, nil, map[string]interface{}{"k": []interface{}{false}}}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("wrong value. want=%#v, got=%#v", expected, value)
+	}
+
+	// objects are stored as they are
+	empty := &object.Array{}
+	var obj object.Object
+	var arr *object.Array
+	if err := object.ToGo(empty, &obj); err != nil || obj != empty {
+		t.Errorf("wrong object. got=%v, %v", obj, err)
+	}
+	if err := object.ToGo(empty, &arr); err != nil || arr != empty {
+		t.Errorf("wrong array. got=%v, %v", arr, err)
+	}
+
+	var f float64
+	var pointer *int
+	if err := object.ToGo(object.NewInteger(3), &pointer); err != nil || *pointer != 3 {
+		t.Errorf("wrong pointer. got=%v, %v", pointer, err)
+	}
+	if err := object.ToGo(object.NewInteger(3), &f); err != nil || f != 3 {
+		t.Errorf("wrong float. got=%v, %v", f, err)
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	cycle := []interface{}{nil}
+	cycle[0] = cycle
+
+	for _, tt := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{make(chan int), "cannot convert chan int to an object"},
+		{uint64(1 << 63), "cannot convert 9223372036854775808 to an integer, it's too large"},
+		{map[string]interface{}{"a": []interface{}{1, complex(1, 1)}}, `cannot convert complex128 to an object (at ["a"][1])`},
+		{cycle, "cannot convert []interface {}, it holds itself (at [0])"},
+	} {
+		_, err := object.FromGo(tt.value)
+		if e, ok := err.(*object.Error); !ok || e.Kind != object.TypeErrorKind || e.Message != tt.expected {
+			t.Errorf("wrong error for %T. want=%q, got=%v", tt.value, tt.expected, err)
+		}
+	}
+
+	decoded, _ := object.DecodeJSON(`{"X": 1, "Y": [2]}`)
+	for _, tt := range []struct {
+		obj      object.Object
+		target   interface{}
+		expected string
+	}{
+		{object.NewInteger(300), new(int8), "cannot convert 300 to int8, it's out of range"},
+		{object.NewInteger(-1), new(uint), "cannot convert -1 to uint, it's out of range"},
+		{&object.Float{Value: 1.5}, new(int), "cannot convert FLOAT to int"},
+		{object.NullValue, new(string), "cannot convert null to string"},
+		{decoded, new(point), `cannot convert ARRAY to int (at ["Y"])`},
+		{&object.Array{Elements: []object.Object{object.NewInteger(1)}}, new([2]int), "cannot convert an array of 1 elements to [2]int"},
+		{&object.Builtin{}, new(func()), "cannot convert BUILTIN to func() outside of a call from the program"},
+		{object.NewInteger(1), point{}, "target of ToGo must be a non-nil pointer, got object_test.point"},
+	} {
+		err := object.ToGo(tt.obj, tt.target)
+		if e, ok := err.(*object.Error); !ok || e.Kind != object.TypeErrorKind || e.Message != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.obj.Inspect(), tt.expected, err)
+		}
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	call := func(fn interface{}, args ...object.Object) object.Object {
+		t.Helper()
+		obj, err := object.FromGo(fn)
+		if err != nil {
+			t.Fatalf("converting %T failed: %s", fn, err)
+		}
+		result := obj.(*object.Builtin).Fn(nil, args...)
+		if result == nil {
+			return object.NullValue
+		}
+		return result
+	}
+	one, two := object.NewInteger(1), object.NewInteger(2)
+
+	tests := []struct {
+		fn       interface{}
+		args     []object.Object
+		expected string
+	}{
+		{func(a, b int) int { return a + b }, []object.Object{one, two}, "3"},
+		{func(xs ...int) int { return len(xs) }, []object.Object{one, two, one}, "3"},
+		{func() {}, nil, "null"},
+		{func() (int, string) { return 1, "a" }, nil, "[1, a]"},
+		{func(p point) string { return p.Label }, []object.Object{mustDecode(t, `{"label": "x"}`)}, "x"},
+		{func(rt object.Runtime, s string) bool { return rt == nil && s == "a" }, []object.Object{&object.String{Value: "a"}}, "true"},
+		{func() (int, error) { return 0, errors.New("failed") }, nil, "Error: failed"},
+		{func() error { return object.NewError(object.RuntimeErrorKind, "bad") }, nil, "RuntimeError: bad"},
+		{func(a int) int { return a }, nil, "ArgumentError: wrong number of arguments. got=0, want=1"},
+		{func(a int, rest ...int) int { return a }, nil, "ArgumentError: wrong number of arguments. got=0, want at least 1"},
+		{func(a int) int { return a }, []object.Object{&object.String{Value: "a"}}, "TypeError: cannot convert STRING to int (at argument 1)"},
+	}
+
+	for _, tt := range tests {
+		obj := call(tt.fn, tt.args...)
+		result := obj.Inspect()
+		if exception, ok := obj.(*object.Exception); ok {
+			result = exception.Err.Kind + ": " + exception.Err.Message
+		}
+		if result != tt.expected {
+			t.Errorf("wrong result of %T. want=%s, got=%s", tt.fn, tt.expected, result)
+		}
+	}
+}
+
+func mustDecode(t *testing.T, data string) object.Object {
+	t.Helper()
+	obj, err := object.DecodeJSON(data)
+	if err != nil {
+		t.Fatalf("decoding %s failed: %s", data, err)
+	}
+	return obj
+}
+
+func TestIO(t *testing.T) {
+	long := strings.Repeat("x", 10000) // longer than the buffer of Stdin
+	streams := object.NewIO(strings.NewReader("one\r\n\n"+long+"\nlast"), nil, nil)
+
+	for _, expected := range []string{"one", "", long, "last"} {
+		line, err := streams.ReadLine()
+		if err != nil || line != expected {
+			t.Fatalf("wrong line. want=%.10q, got=%.10q (%v)", expected, line, err)
+		}
+	}
+	if _, err := streams.ReadLine(); err != io.EOF {
+		t.Errorf("expected io.EOF after the last line, got %v", err)
+	}
+
+	streams = object.NewIO(strings.NewReader("a\nb\nc"), nil, nil)
+	streams.ReadLine()
+	if rest, err := streams.ReadAll(); err != nil || rest != "b\nc" {
+		t.Errorf("wrong rest of the input. got=%q (%v)", rest, err)
+	}
+	if rest, err := (&object.IO{}).ReadAll(); err != nil || rest != "" {
+		t.Errorf("nil Stdin isn't empty. got=%q (%v)", rest, err)
+	}
+}
+
+// fileRuntime runs builtins with a file system
+type fileRuntime struct {
+	io *object.IO
+}
+
+func (fileRuntime) Call(fn object.Object, args ...object.Object) (object.Object, *object.Error) {
+	return nil, object.NewError(object.ErrorKind, "no calls")
+}
+
+func (rt fileRuntime) IO() *object.IO { return rt.io }
+
+func TestFileBuiltins(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644)
+	os.Mkdir(filepath.Join(root, "dir"), 0o755)
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "escape"))
+	os.Symlink(outside, filepath.Join(root, "dir", "out"))
+	os.Symlink(filepath.Join(outside, "missing"), filepath.Join(root, "dangling"))
+	os.Symlink("dir", filepath.Join(root, "inside"))
+
+	fsys, err := object.DirFS(root)
+	if err != nil {
+		t.Fatalf("DirFS failed: %s", err)
+	}
+	readOnly := fileRuntime{&object.IO{FS: object.ReadOnly(fsys)}}
+	rt := fileRuntime{&object.IO{FS: fsys}}
+
+	tests := []struct {
+		rt       object.Runtime
+		builtin  string
+		args     []string
+		expected string // the Inspect of the result, or the error
+	}{
+		{rt, "write_file", []string{"a.txt", "hello"}, "null"},
+		{rt, "read_file", []string{"/a.txt"}, "hello"},
+		{rt, "write_file", []string{"dir/../inside/b.txt", "b"}, "null"},
+		{rt, "read_file", []string{"dir/b.txt"}, "b"},
+		{rt, "list_dir", nil, "[a.txt, dangling, dir, escape, inside]"},
+		{rt, "list_dir", []string{"inside"}, "[b.txt, out]"},
+		{rt, "exists", []string{"a.txt"}, "true"},
+		{rt, "exists", []string{"c.txt"}, "false"},
+		{rt, "remove_file", []string{"a.txt"}, "null"},
+		{rt, "exists", []string{"a.txt"}, "false"},
+		{rt, "read_file", []string{"a.txt"}, "RuntimeError: cannot read a.txt: no such file or directory"},
+		{rt, "remove_file", []string{"dir"}, "RuntimeError: cannot remove dir: directory not empty"},
+		{rt, "remove_file", []string{"/"}, "RuntimeError: cannot remove .: permission denied"},
+		{rt, "remove", []string{"dir/b.txt"}, "ArgumentError: wrong number of arguments. got=1, want=2"}, // not a file builtin
+		{rt, "exists", []string{"dir/b.txt"}, "true"},
+		// paths can't lead out of the root
+		{rt, "read_file", []string{"../secret"}, "ArgumentError: path ../secret leads outside the root"},
+		{rt, "read_file", []string{"dir/../../secret"}, "ArgumentError: path dir/../../secret leads outside the root"},
+		{rt, "read_file", []string{"escape"}, "RuntimeError: cannot read escape: path leads outside the root"},
+		{rt, "read_file", []string{"dir/out/secret"}, "RuntimeError: cannot read dir/out/secret: path leads outside the root"},
+		{rt, "write_file", []string{"dangling", "x"}, "RuntimeError: cannot write dangling: path leads outside the root"},
+		{rt, "write_file", []string{"dir/out/new", "x"}, "RuntimeError: cannot write dir/out/new: path leads outside the root"},
+		// read-only and missing file systems
+		{readOnly, "read_file", []string{"dir/b.txt"}, "b"},
+		{readOnly, "write_file", []string{"c.txt", "c"}, "RuntimeError: cannot write c.txt: file system is read-only"},
+		{readOnly, "remove_file", []string{"dir/b.txt"}, "RuntimeError: cannot remove dir/b.txt: file system is read-only"},
+		{fileRuntime{&object.IO{}}, "exists", []string{"a.txt"}, "RuntimeError: `exists` needs a file system, the program has none"},
+	}
+
+	for _, tt := range tests {
+		args := make([]object.Object, len(tt.args))
+		for i, arg := range tt.args {
+			args[i] = &object.String{Value: arg}
+		}
+
+		obj := object.GetBuiltinByName(tt.builtin).Fn(tt.rt, args...)
+		result := "null"
+		if exception, ok := obj.(*object.Exception); ok {
+			result = exception.Err.Kind + ": " + exception.Err.Message
+		} else if obj != nil {
+			result = obj.Inspect()
+		}
+		if result != tt.expected {
+			t.Errorf("wrong result of %s(%q). want=%s, got=%s", tt.builtin, tt.args, tt.expected, result)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "new")); err == nil {
+		t.Errorf("file written outside the root")
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "dir", "b.txt")); string(data) != "b" {
+		t.Errorf("file removed from a read-only file system")
+	}
+}
+
+func TestProgramFS(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "scripts"), 0o755)
+	script := filepath.Join(root, "scripts", "s.mk")
+
+	tests := []struct {
+		root     string
+		readOnly bool
+		file     string // a file the file system has
+	}{
+		{"", false, "s.mk"}, // the directory of the script
+		{root, false, "scripts/s.mk"},
+		{"", true, "s.mk"},
+	}
+
+	os.WriteFile(script, nil, 0o644)
+	for _, tt := range tests {
+		fsys, err := object.ProgramFS(script, tt.root, tt.readOnly)
+		if err != nil {
+			t.Fatalf("ProgramFS(%q, %t) failed: %s", tt.root, tt.readOnly, err)
+		}
+		if _, err := fsys.Stat(tt.file); err != nil {
+			t.Errorf("%s not found with root %q: %s", tt.file, tt.root, err)
+		}
+		if err := fsys.WriteFile("new.txt", nil); (err != nil) != tt.readOnly {
+			t.Errorf("wrong error writing with root %q (readOnly=%t). got=%v", tt.root, tt.readOnly, err)
+		}
+	}
+
+	if _, err := object.ProgramFS(script, filepath.Join(root, "missing"), false); err == nil {
+		t.Errorf("no error for a missing root")
+	}
+}
+
+func TestFromFS(t *testing.T) {
+	fsys := object.FromFS(fstest.MapFS{
+		"config.json": {Data: []byte(`{"debug": true}`)},
+		"data/1.txt":  {Data: []byte("one")},
+	})
+	rt := fileRuntime{&object.IO{FS: fsys}}
+
+	call := func(name string, args ...object.Object) string {
+		obj := object.GetBuiltinByName(name).Fn(rt, args...)
+		if exception, ok := obj.(*object.Exception); ok {
+			return exception.Err.Message
+		}
+		return obj.Inspect()
+	}
+	if result := call("read_file", &object.String{Value: "config.json"}); result != `{"debug": true}` {
+		t.Errorf("wrong contents. got=%s", result)
+	}
+	if result := call("list_dir", &object.String{Value: "data"}); result != "[1.txt]" {
+		t.Errorf("wrong files. got=%s", result)
+	}
+	if result := call("write_file", &object.String{Value: "x"}, &object.String{Value: "x"}); result != "cannot write x: file system is read-only" {
+		t.Errorf("wrong error. got=%s", result)
+	}
+}
+
+func TestCheckType(t *testing.T) {
+	function := object.GetBuiltinByName("len")
+	tests := []struct {
+		value    object.Object
+		t        string
+		expected string // the Inspect of the result, or the error
+	}{
+		{object.NewInteger(1), "int", "1"},
+		{&object.String{Value: "a"}, "string", "a"},
+		{object.True, "bool", "true"},
+		{object.NullValue, "null", "null"},
+		{object.NewInteger(1), "any", "1"},
+		{&object.Array{}, "[int]", "[]"},
+		{&object.Array{Elements: []object.Object{object.True}}, "[int]", "[true]"}, // checks are shallow
+		{&object.Hash{}, "{string: int}", "{}"},
+		{function, "fn(int) -> int", "builtin function"},
+		{object.NewInteger(1), "string", "TypeError: x must be string, got INTEGER"},
+		{&object.Hash{}, "[int]", "TypeError: x must be [int], got HASH"},
+		{object.NullValue, "fn()", "TypeError: x must be fn(), got NULL"},
+		{object.NewInteger(1), "number", "ArgumentError: unknown type number"},
+	}
+
+	for _, tt := range tests {
+		obj := object.GetBuiltinByName("check_type").Fn(nil, tt.value, &object.String{Value: tt.t}, &object.String{Value: "x"})
+		result := obj.Inspect()
+		if exception, ok := obj.(*object.Exception); ok {
+			result = exception.Err.Kind + ": " + exception.Err.Message
+		}
+		if result != tt.expected {
+			t.Errorf("wrong result of check_type(%s, %q). want=%s, got=%s", tt.value.Inspect(), tt.t, tt.expected, result)
+		}
+	}
+}
+
+func TestAssertEqDiff(t *testing.T) {
+	ints := func(values ...int64) *object.Array {
+		elements := []object.Object{}
+		for _, v := range values {
+			elements = append(elements, object.NewInteger(v))
+		}
+		return &object.Array{Elements: elements}
+	}
+	actual := &object.Array{Elements: []object.Object{ints(1, 2, 3), &object.String{Value: "a long string of some length"}, ints(4)}}
+	expected := &object.Array{Elements: []object.Object{ints(1, 2, 3), &object.String{Value: "a longer string of some length"}}}
+
+	obj := object.GetBuiltinByName("assert_eq").Fn(nil, actual, expected)
+	exception, ok := obj.(*object.Exception)
+	if !ok {
+		t.Fatalf("assert_eq didn't throw. got=%v", obj)
+	}
+	want := `values differ (-expected +actual):
+  [
+    [1, 2, 3],
+-   "a longer string of some length",
++   "a long string of some length",
++   [4],
+  ]`
+	if exception.Err.Kind != object.AssertionErrorKind || exception.Err.Message != want {
+		t.Errorf("wrong error.\nwant=%s\ngot= %s: %s", want, exception.Err.Kind, exception.Err.Message)
+	}
+}
+
+func TestAssertEqStringDiff(t *testing.T) {
+	actual := &object.String{Value: "the first line\nsecond line\nthe third line\n"}
+	expected := &object.String{Value: "the first line\nthe second line\nthe third line\n"}
+
+	obj := object.GetBuiltinByName("assert_eq").Fn(nil, actual, expected)
+	exception, ok := obj.(*object.Exception)
+	if !ok {
+		t.Fatalf("assert_eq didn't throw. got=%v", obj)
+	}
+	want := `values differ (-expected +actual):
+  "the first line\n"
+- "the second line\n"
++ "second line\n"
+  "the third line\n"`
+	if exception.Err.Kind != object.AssertionErrorKind || exception.Err.Message != want {
+		t.Errorf("wrong error.\nwant=%s\ngot= %s: %s", want, exception.Err.Kind, exception.Err.Message)
+	}
+
+	// strings in arrays are split too
+	obj = object.GetBuiltinByName("assert_eq").Fn(nil,
+		&object.Array{Elements: []object.Object{actual}}, &object.Array{Elements: []object.Object{expected}})
+	want = `values differ (-expected +actual):
+  [
+    "the first line\n"
+-   "the second line\n"
++   "second line\n"
+    "the third line\n",
+  ]`
+	if exception, ok := obj.(*object.Exception); !ok || exception.Err.Message != want {
+		t.Errorf("wrong error.\nwant=%s\ngot= %v", want, obj)
+	}
+}
@@ -0,0 +1,259 @@
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	The string builtins. Lengths and positions in strings (len, index_of, substr, indexes and
+	slices) count characters, not bytes, and chars splits a string into the same characters. None
+	of them change their arguments, strings can't be changed.
+*/
+
+// maxStringLength bounds the length of the strings built by replace, repeat and format
+const maxStringLength = 1 << 24
+
+// stringArgs checks that args are n strings and returns their values
+func stringArgs(name string, args []Object, n int) ([]string, *Exception) {
+	if len(args) != n {
+		return nil, Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=%d", len(args), n)
+	}
+	values := make([]string, n)
+	for i, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return nil, Throw(TypeErrorKind, "argument to `%s` must be STRING, got %s", name, arg.Type())
+		}
+		values[i] = str.Value
+	}
+	return values, nil
+}
+
+// stringFunction makes a builtin of a function from a string to a string
+func stringFunction(name string, fn func(string) string) *Builtin {
+	return &Builtin{
+		Fn: func(_ Runtime, args ...Object) Object {
+			values, exception := stringArgs(name, args, 1)
+			if exception != nil {
+				return exception
+			}
+			return &String{Value: fn(values[0])}
+		},
+	}
+}
+
+// stringPredicate makes a builtin of a function reporting something about two strings
+func stringPredicate(name string, fn func(s, substr string) bool) *Builtin {
+	return &Builtin{
+		Fn: func(_ Runtime, args ...Object) Object {
+			values, exception := stringArgs(name, args, 2)
+			if exception != nil {
+				return exception
+			}
+			return NativeBool(fn(values[0], values[1]))
+		},
+	}
+}
+
+func stringsToArray(values []string) *Array {
+	elements := make([]Object, len(values))
+	for i, v := range values {
+		elements[i] = &String{Value: v}
+	}
+	return &Array{Elements: elements}
+}
+
+// split implements split(s, sep): the parts of s between the occurrences of sep, or the
+// characters of s if sep is empty
+func split(_ Runtime, args ...Object) Object {
+	values, exception := stringArgs("split", args, 2)
+	if exception != nil {
+		return exception
+	}
+	return stringsToArray(strings.Split(values[0], values[1]))
+}
+
+// join implements join(arr, sep): the strings of arr with sep between them
+func join(_ Runtime, args ...Object) Object {
+	if len(args) != 2 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `join` must be ARRAY, got %s", args[0].Type())
+	}
+	sep, ok := args[1].(*String)
+	if !ok {
+		return Throw(TypeErrorKind, "second argument to `join` must be STRING, got %s", args[1].Type())
+	}
+
+	values := make([]string, len(arr.Elements))
+	for i, el := range arr.Elements {
+		str, ok := el.(*String)
+		if !ok {
+			return Throw(TypeErrorKind, "elements joined by `join` must be STRING, got %s", el.Type())
+		}
+		values[i] = str.Value
+	}
+	return &String{Value: strings.Join(values, sep.Value)}
+}
+
+// replace implements replace(s, old, new): s with every occurrence of old replaced by new
+func replace(_ Runtime, args ...Object) Object {
+	values, exception := stringArgs("replace", args, 3)
+	if exception != nil {
+		return exception
+	}
+	s, old, new := values[0], values[1], values[2]
+
+	if old == "" || len(new) > len(old) {
+		// bound the result before building it, in case it's huge
+		if n := int64(strings.Count(s, old)); int64(len(s))+n*int64(len(new)-len(old)) > maxStringLength {
+			return Throw(RuntimeErrorKind, "string of more than %d bytes is too large", maxStringLength)
+		}
+	}
+	return &String{Value: strings.ReplaceAll(s, old, new)}
+}
+
+// indexOf implements index_of(s, substr): the position of the first occurrence of substr in s,
+// -1 if there is none
+func indexOf(_ Runtime, args ...Object) Object {
+	values, exception := stringArgs("index_of", args, 2)
+	if exception != nil {
+		return exception
+	}
+	i := strings.Index(values[0], values[1])
+	if i < 0 {
+		return NewInteger(-1)
+	}
+	return NewInteger(int64(utf8.RuneCountInString(values[0][:i])))
+}
+
+// substr implements substr(s, start) and substr(s, start, end): the characters of s from start
+// up to but excluding end (the end of s by default)
+func substr(_ Runtime, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `substr` must be STRING, got %s", args[0].Type())
+	}
+
+	runes := []rune(str.Value)
+	bounds := []int64{0, int64(len(runes))} // start and end
+	for i, arg := range args[1:] {
+		integer, ok := arg.(*Integer)
+		if !ok {
+			return Throw(TypeErrorKind, "positions given to `substr` must be INTEGER, got %s", arg.Type())
+		}
+		bounds[i] = integer.Value
+	}
+
+	start, end := bounds[0], bounds[1]
+	if start < 0 || start > end || end > int64(len(runes)) {
+		return Throw(RuntimeErrorKind, "substring out of range: [%d:%d] (length %d)", start, end, len(runes))
+	}
+	return &String{Value: string(runes[start:end])}
+}
+
+// repeat implements repeat(s, n): n copies of s
+func repeat(_ Runtime, args ...Object) Object {
+	if len(args) != 2 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `repeat` must be STRING, got %s", args[0].Type())
+	}
+	count, ok := args[1].(*Integer)
+	if !ok {
+		return Throw(TypeErrorKind, "second argument to `repeat` must be INTEGER, got %s", args[1].Type())
+	}
+
+	if count.Value < 0 {
+		return Throw(ArgumentErrorKind, "count of `repeat` must not be negative, got %d", count.Value)
+	}
+	if len(str.Value) > 0 && count.Value > maxStringLength/int64(len(str.Value)) {
+		return Throw(RuntimeErrorKind, "string of more than %d bytes is too large", maxStringLength)
+	}
+	return &String{Value: strings.Repeat(str.Value, int(count.Value))}
+}
+
+// chars implements chars(s): the characters of s, each as a string
+func chars(_ Runtime, args ...Object) Object {
+	values, exception := stringArgs("chars", args, 1)
+	if exception != nil {
+		return exception
+	}
+
+	s := values[0]
+	elements := make([]Object, 0, utf8.RuneCountInString(s))
+	for _, c := range s {
+		elements = append(elements, &String{Value: string(c)})
+	}
+	return &Array{Elements: elements}
+}
+
+// format implements format(f, args...): f with its verbs replaced by the arguments in turn. %d
+// takes an integer, %s a string and %v any value (as puts shows it), while %% is a literal %.
+func format(_ Runtime, args ...Object) Object {
+	if len(args) == 0 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=0, want at least 1")
+	}
+	f, ok := args[0].(*String)
+	if !ok {
+		return Throw(TypeErrorKind, "argument to `format` must be STRING, got %s", args[0].Type())
+	}
+
+	var out strings.Builder
+	values := args[1:]
+	used := 0
+	for i := 0; i < len(f.Value); i++ {
+		if f.Value[i] != '%' {
+			out.WriteByte(f.Value[i])
+			continue
+		}
+
+		i++
+		if i == len(f.Value) {
+			return Throw(ArgumentErrorKind, "format ends in %%")
+		}
+		verb := f.Value[i]
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if verb != 'd' && verb != 's' && verb != 'v' {
+			return Throw(ArgumentErrorKind, "unknown verb %%%c in format", verb)
+		}
+
+		if used == len(values) {
+			return Throw(ArgumentErrorKind, "format needs more arguments than the %d given", len(values))
+		}
+		value := values[used]
+		used++
+
+		switch verb {
+		case 'd':
+			if _, ok := value.(*Integer); !ok {
+				return Throw(TypeErrorKind, "%%d in format must be given INTEGER, got %s", value.Type())
+			}
+		case 's':
+			if _, ok := value.(*String); !ok {
+				return Throw(TypeErrorKind, "%%s in format must be given STRING, got %s", value.Type())
+			}
+		}
+		out.WriteString(value.Inspect())
+
+		if out.Len() > maxStringLength {
+			return Throw(RuntimeErrorKind, "string of more than %d bytes is too large", maxStringLength)
+		}
+	}
+
+	if used != len(values) {
+		return Throw(ArgumentErrorKind, "format uses %d of %d arguments", used, len(values))
+	}
+	return &String{Value: out.String()}
+}
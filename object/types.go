@@ -0,0 +1,61 @@
+package object
+
+import "strings"
+
+/*
+	check_type, which the runtime checks of the type checker call at the boundaries of typed
+	functions (see types.Instrument). The check is shallow: [int] only checks that the value is
+	an array, fn(int) -> int that it's a function, as checking the elements or the results of
+	calls would cost more than the calls being checked.
+*/
+
+// typeKinds are the object types the basic types name
+var typeKinds = map[string][]ObjectType{
+	"int":    {INTEGER_OBJ},
+	"float":  {FLOAT_OBJ},
+	"string": {STRING_OBJ},
+	"bool":   {BOOLEAN_OBJ},
+	"null":   {NULL_OBJ},
+	"error":  {ERROR_OBJ},
+	"array":  {ARRAY_OBJ},
+	"hash":   {HASH_OBJ},
+	"fn":     {FUNCTION_OBJ, COMPILED_FUNCTION_OBJ, CLOSURE_OBJ, BUILTIN_OBJ},
+}
+
+// checkType implements check_type(value, type, what): throws a TypeError saying what must be
+// of the type unless the value is, and returns the value
+func checkType(_ Runtime, args ...Object) Object {
+	if len(args) != 3 {
+		return Throw(ArgumentErrorKind, "wrong number of arguments. got=%d, want=3", len(args))
+	}
+	t, ok := args[1].(*String)
+	if !ok {
+		return Throw(TypeErrorKind, "second argument to `check_type` must be STRING, got %s", args[1].Type())
+	}
+	what, ok := args[2].(*String)
+	if !ok {
+		return Throw(TypeErrorKind, "third argument to `check_type` must be STRING, got %s", args[2].Type())
+	}
+
+	name := t.Value
+	switch {
+	case name == "any":
+		return args[0]
+	case strings.HasPrefix(name, "["):
+		name = "array"
+	case strings.HasPrefix(name, "{"):
+		name = "hash"
+	case strings.HasPrefix(name, "fn("):
+		name = "fn"
+	}
+	kinds, ok := typeKinds[name]
+	if !ok {
+		return Throw(ArgumentErrorKind, "unknown type %s", t.Value)
+	}
+	for _, kind := range kinds {
+		if args[0].Type() == kind {
+			return args[0]
+		}
+	}
+	return Throw(TypeErrorKind, "%s must be %s, got %s", what.Value, t.Value, args[0].Type())
+}
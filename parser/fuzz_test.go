@@ -0,0 +1,38 @@
+package parser_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
+)
+
+// FuzzParseProgram checks that parsing any input returns a program or errors
+func FuzzParseProgram(f *testing.F) {
+	// inputs of the parser tests
+	f.Add("let x = 5; let y = true; let foobar = y;")
+	f.Add("let myFunction = fn() { }; return 5; return foobar;")
+	f.Add(`throw error("boom", "MyError"); try { x } catch (e) { e } finally { y }`)
+	f.Add("-a * b + !c - d / e; 5 > 4 == 3 < 4; a + add(b * c) + d; add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))")
+	f.Add("if (x < y) { x } else { y }; fn(x, y) { x + y; }(1, 2); a * [1, 2, 3, 4][b * c] * d")
+	f.Add(`{"one": 1, "two": 2}; {}; {"one": 0 + 1, true: 10 / 5}; "hello world"; myArray[1 + 1]`)
+	f.Add("2 <= 3 >= 1; let f = fn(x) { return f(x); };")
+	// malformed input
+	f.Add("fn(")
+	f.Add(`"unterminated`)
+	f.Add("let = ; if ( { [1, ; {1:")
+	f.Add("try { 1 }")
+	// input nesting too deeply
+	f.Add(strings.Repeat("[", parser.MaxDepth+1))
+	f.Add(strings.Repeat("fn() { (", parser.MaxDepth/2+1))
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			_ = program.String()
+		}
+	})
+}
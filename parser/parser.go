@@ -15,6 +15,7 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // array[index] = X
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -32,6 +33,7 @@ type (
 
 // outlines the precedence of each token
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -47,11 +49,19 @@ var precedences = map[token.TokenType]int{
 	token.LBRACKET: INDEX,
 }
 
+// MaxDepth bounds how deeply expressions and types nest. Deeper input is a parse error, rather
+// than overflowing the stack of the parser or of what goes on to walk the program.
+const MaxDepth = 1000
+
 // Parser handles parsing the given text
 type Parser struct {
 	l *lexer.Lexer
 
 	errors []string
+	at     []Error // errors with the tokens they were found at
+
+	depth   int  // the nesting of the expression or type being parsed
+	tooDeep bool // whether the input nests more than MaxDepth, which ends parsing
 
 	currToken token.Token
 	peekToken token.Token
@@ -79,6 +89,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
@@ -97,6 +108,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.GTE, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 
 	// read two tokens so curr and peek are set
 	p.nextToken()
@@ -110,6 +122,27 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Error is a parser error and the token it was found at
+type Error struct {
+	Token   token.Token
+	Message string
+}
+
+// ErrorsAt returns the errors with the tokens they were found at, in the order of Errors
+func (p *Parser) ErrorsAt() []Error {
+	return p.at
+}
+
+// error records the error msg, found at tok. Once the input nests too deeply the errors of the
+// parse functions unwinding are left out, parsing is over.
+func (p *Parser) error(tok token.Token, msg string) {
+	if p.tooDeep {
+		return
+	}
+	p.errors = append(p.errors, msg)
+	p.at = append(p.at, Error{Token: tok, Message: msg})
+}
+
 // ---------------- parse program ----------------
 
 // ParseProgram parses the full program
@@ -117,7 +150,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{} // root node
 	program.Statements = []ast.Statement{}
 
-	for p.currToken.Type != token.EOF {
+	for p.currToken.Type != token.EOF && !p.tooDeep {
 		stmt := p.parseStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
@@ -133,13 +166,21 @@ func (p *Parser) ParseProgram() *ast.Program {
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.currToken.Type {
 	case token.LET: // current token is let
-		return p.parseLetStatement()
+		// a nil *ast.LetStatement isn't a nil ast.Statement
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		if stmt := p.parseThrowStatement(); stmt != nil {
+			return stmt
+		}
 	default:
 		// only have two statements, hence if we don't encounter either, it's an expression
 		return p.parseExpressionStatement()
 	}
+	return nil
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
@@ -164,6 +205,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	// Name is an Identifier AST with a token (ident) and a value (name of variable)
 	stmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
 
+	var ok bool
+	if stmt.Type, ok = p.parseAnnotation(); !ok { // let identifier: type
+		return nil
+	}
+
 	if !p.expectPeek(token.ASSIGN) { // let identifier = - next token should be an =
 		return nil
 	}
@@ -196,9 +242,30 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.currToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) { // semicolons are optional
+		p.nextToken()
+	}
+	return stmt
+}
+
 // ----------- parse expressions -------------
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	defer func(depth int) { p.depth = depth }(p.depth)
+	if !p.nest() {
+		return nil
+	}
+
 	// only specific token types can be at the start of an expression
 	prefix := p.prefixParseFns[p.currToken.Type]
 	if prefix == nil { // not a prefix operator
@@ -206,6 +273,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		return nil
 	}
 	leftExp := prefix() // parse the prefix operator using the specified function
+	if leftExp == nil { // the error is already recorded
+		return nil
+	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		// only specific token types can be in the infix position between other tokens
@@ -215,8 +285,15 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		}
 		p.nextToken()
 
+		// each operator nests the expression so far one deeper
+		if !p.nest() {
+			return nil
+		}
 		// leftExp ends up being re-assigned to the next expression result as per the call to infix
 		leftExp = infix(leftExp)
+		if leftExp == nil {
+			return nil
+		}
 	}
 
 	return leftExp
@@ -235,7 +312,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.currToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.currToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.error(p.currToken, msg)
 		return nil
 	}
 
@@ -258,6 +335,11 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 
 	expression.Right = p.parseExpression(PREFIX) // set the right side
 
+	// a node is never left without its operand
+	if expression.Right == nil {
+		return nil
+	}
+
 	return expression
 }
 
@@ -271,6 +353,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	precedence := p.curPrecedence()
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
+	if expression.Right == nil {
+		return nil
+	}
 
 	return expression
 }
@@ -279,6 +364,9 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.currToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if array.Elements == nil {
+		return nil
+	}
 
 	return array
 }
@@ -290,6 +378,9 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	for !p.peekTokenIs(token.RBRACE) { // while not }
 		p.nextToken()
 		key := p.parseExpression(LOWEST) // get the key
+		if key == nil {
+			return nil
+		}
 
 		if !p.expectPeek(token.COLON) { // ensure colon is there
 			return nil
@@ -297,8 +388,12 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 		p.nextToken()
 		value := p.parseExpression(LOWEST) // get the value
+		if value == nil {
+			return nil
+		}
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -311,11 +406,36 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// parseIndexExpression parses left[index] and the slices left[start:end], left[start:],
+// left[:end] and left[:]
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.currToken, Left: left}
+	tok := p.currToken
 
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+		if index == nil {
+			return nil
+		}
+	}
+
+	if !p.peekTokenIs(token.COLON) {
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+	}
+
+	p.nextToken() // the colon
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: index}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+		if exp.End == nil {
+			return nil
+		}
+	}
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
@@ -323,6 +443,29 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseAssignExpression parses the value assigned to left, which must be an index expression.
+// Assignments are right associative: a[0] = b[0] = 1 assigns 1 to both.
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	target, ok := left.(*ast.IndexExpression)
+	if left == nil { // left already failed to parse
+		return nil
+	}
+	if !ok {
+		p.error(p.currToken, fmt.Sprintf("cannot assign to %s", left.String()))
+		return nil
+	}
+
+	exp := &ast.AssignExpression{Token: p.currToken, Target: target}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(LOWEST)
+	if exp.Value == nil {
+		return nil
+	}
+
+	return exp
+}
+
 // ---------------- booleans -----------------
 
 func (p *Parser) parseBoolean() ast.Expression {
@@ -354,6 +497,9 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST) // condition for if statement
+	if expression.Condition == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
@@ -378,6 +524,53 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// --------------- try ---------------
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.currToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) { // catch (e) binds the error
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			expression.CatchName = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.error(expression.Token, "expected catch or finally after try block")
+		return nil
+	}
+
+	return expression
+}
+
 // ------------------ block ------------------
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
@@ -393,6 +586,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	block.End = p.currToken
 
 	return block
 }
@@ -406,7 +600,18 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.ParameterTypes = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.ARROW) { // the return type
+		p.nextToken()
+		p.nextToken()
+		if lit.ReturnType = p.parseType(); lit.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.LBRACE) { // opening block
 		return nil
@@ -417,32 +622,44 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// parseFunctionParameters parses the parameters and their types, which are nil unless one of
+// the parameters has a type
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.Type) {
 	identifiers := []*ast.Identifier{}
+	var types []ast.Type
+	annotated := false
 
 	if p.peekTokenIs(token.RPAREN) { // no parameters
 		p.nextToken()
-		return identifiers
+		return identifiers, nil
 	}
 
-	p.nextToken()
-
-	ident := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-	identifiers = append(identifiers, ident)
-
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+	for {
 		p.nextToken()
 		ident := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
 		identifiers = append(identifiers, ident)
+
+		t, ok := p.parseAnnotation()
+		if !ok {
+			return nil, nil
+		}
+		types = append(types, t)
+		annotated = annotated || t != nil
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil
 	}
 
-	return identifiers
-
+	if !annotated {
+		types = nil
+	}
+	return identifiers, types
 }
 
 func (p *Parser) parseCallExpression(
@@ -450,6 +667,9 @@ func (p *Parser) parseCallExpression(
 ) ast.Expression {
 	exp := &ast.CallExpression{Token: p.currToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if exp.Arguments == nil {
+		return nil
+	}
 	return exp
 }
 
@@ -489,14 +709,32 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	return false
 }
 
+// nest goes one level deeper into the input. It reports false once the input nests too deeply,
+// recording the error the first time.
+func (p *Parser) nest() bool {
+	if p.tooDeep {
+		return false
+	}
+	p.depth++
+	if p.depth > MaxDepth {
+		p.error(p.currToken, fmt.Sprintf("nested more than %d deep", MaxDepth))
+		p.tooDeep = true
+		return false
+	}
+	return true
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.error(p.peekToken, msg)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL { // characters the lexer doesn't know, or an unterminated string
+		msg = fmt.Sprintf("illegal token %q", p.currToken.Literal)
+	}
+	p.error(p.currToken, msg)
 }
 
 // get the precedence of the next token
@@ -525,13 +763,18 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 		return list
 	}
 
-	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
-
-	for p.peekTokenIs(token.COMMA) {
+	for {
 		p.nextToken()
+		exp := p.parseExpression(LOWEST)
+		if exp == nil { // a list never holds an expression which failed to parse
+			return nil
+		}
+		list = append(list, exp)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		list = append(list, p.parseExpression(LOWEST))
 	}
 
 	if !p.expectPeek(end) {
@@ -2,6 +2,7 @@ package parser_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/andy9775/monkey/ast"
@@ -142,6 +143,123 @@ func TestReturnStatements(t *testing.T) {
 	}
 }
 
+func TestThrowStatements(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedValue interface{}
+	}{
+		{`throw 5;`, 5},
+		{`throw foobar`, "foobar"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		throwStmt, ok := program.Statements[0].(*ast.ThrowStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.ThrowStatement. got=%T", program.Statements[0])
+		}
+		if !testLiteralExpression(t, throwStmt.Value, tt.expectedValue) {
+			return
+		}
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input      string
+		catchName  string
+		hasCatch   bool
+		hasFinally bool
+		expected   string
+	}{
+		{`try { x } catch (e) { y }`, "e", true, false, "try x catch (e) y"},
+		{`try { x } catch { y }`, "", true, false, "try x catch y"},
+		{`try { x } finally { z }`, "", false, true, "try x finally z"},
+		{`try { x } catch (err) { y } finally { z }`, "err", true, true, "try x catch (err) y finally z"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+		}
+
+		if (exp.Catch != nil) != tt.hasCatch || (exp.Finally != nil) != tt.hasFinally {
+			t.Errorf("wrong blocks for %q. catch=%v finally=%v", tt.input, exp.Catch != nil, exp.Finally != nil)
+		}
+
+		if tt.catchName == "" && exp.CatchName != nil {
+			t.Errorf("catch binds %s, expected no binding", exp.CatchName)
+		}
+		if tt.catchName != "" && !testIdentifier(t, exp.CatchName, tt.catchName) {
+			return
+		}
+
+		if exp.String() != tt.expected {
+			t.Errorf("exp.String() wrong. expected=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+}
+
+func TestTryWithoutCatchOrFinally(t *testing.T) {
+	p := parser.New(lexer.New(`try { x }`))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "expected catch or finally after try block" {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+}
+
+func TestIllegalTokens(t *testing.T) {
+	p := parser.New(lexer.New(`let a = "mon`))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != `illegal token "\"mon"` {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	p := parser.New(lexer.New(`a = 1`))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "cannot assign to a" {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+
+	// the left side failed to parse
+	p = parser.New(lexer.New(`!# = 1`))
+	p.ParseProgram()
+
+	errors = p.Errors()
+	if len(errors) == 0 || errors[0] != `illegal token "#"` {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+}
+
 func TestIdentifierExpression(t *testing.T) {
 	input := "foobar;"
 
@@ -386,6 +504,18 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		input    string
 		expected string
 	}{
+		{
+			"a[0] = b[1] = 1 + 2",
+			"((a[0]) = ((b[1]) = (1 + 2)))",
+		},
+		{
+			"a[0] = b == c",
+			"((a[0]) = (b == c))",
+		},
+		{
+			"a[1 + 1:-1] + b[:c[0]] + d[e:] + f[:]",
+			"((((a[(1 + 1):(-1)]) + (b[:(c[0])])) + (d[e:])) + (f[:]))",
+		},
 		{
 			"-a * b",
 			"((-a) * b)",
@@ -718,6 +848,60 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let xs: [string] = [];", "let xs: [string] = [];"},
+		{"let h: {string: [int]} = {};", "let h: {string: [int]} = {};"},
+		{"let f: fn(int, string) -> bool = g;", "let f: fn(int, string) -> bool = g;"},
+		{"let f: fn() = g;", "let f: fn() = g;"},
+		{"fn(a: int, b: string) -> bool { a }", "fn(a: int, b: string) -> bool a"},
+		{"fn(a, b: [int]) { a }", "fn(a, b: [int]) a"},
+		{"fn() -> fn(int) -> int { a }", "fn() -> fn(int) -> int a"},
+		{"let f = fn(x: any) -> {int: null} { {} };", "let f = fn<f>(x: any) -> {int: null} {};"},
+		{"a - -1 - b", "((a - (-1)) - b)"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	program := parser.New(lexer.New("fn(a, b: int, c) {}")).ParseProgram()
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.ParameterTypes) != 3 || function.ParameterTypes[0] != nil || function.ParameterTypes[2] != nil ||
+		function.ParameterTypes[1].String() != "int" || function.ReturnType != nil {
+		t.Errorf("wrong parameter types. got=%v, return=%v", function.ParameterTypes, function.ReturnType)
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		"let x: = 5;":             "expected a type, got = instead",
+		"let x: [int = 5;":        "expected next token to be ], got = instead",
+		"let x: {int} = 5;":       "expected next token to be :, got } instead",
+		"fn(a: 1) {}":             "expected a type, got INT instead",
+		"fn(a) -> {}":             "expected a type, got } instead",
+		"let f: fn(int int) = g;": "expected next token to be ,, got IDENT instead",
+	} {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != expected {
+			t.Errorf("wrong parser errors for %q. got=%q", input, errors)
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -817,6 +1001,119 @@ func TestParsingIndexExpression(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		start interface{} // nil when left out
+		end   interface{}
+	}{
+		{"myArray[1:2]", 1, 2},
+		{"myArray[1:]", 1, nil},
+		{"myArray[:2]", nil, 2},
+		{"myArray[:]", nil, nil},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		sliceExp, ok := stmt.Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.SliceExpression. got=%T", stmt.Expression)
+		}
+
+		if !testIdentifier(t, sliceExp.Left, "myArray") {
+			return
+		}
+		for _, bound := range []struct {
+			exp      ast.Expression
+			expected interface{}
+		}{{sliceExp.Start, tt.start}, {sliceExp.End, tt.end}} {
+			if bound.expected == nil {
+				if bound.exp != nil {
+					t.Errorf("bound of %q not left out. got=%s", tt.input, bound.exp)
+				}
+			} else if !testLiteralExpression(t, bound.exp, bound.expected) {
+				return
+			}
+		}
+	}
+}
+
+func TestSliceErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		"a[1:2:3]": "expected next token to be ], got : instead",
+		"a[1:2":    "expected next token to be ], got EOF instead",
+	} {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != expected {
+			t.Errorf("wrong parser errors for %q. got=%q", input, errors)
+		}
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	deep := parser.MaxDepth + 1
+	for _, input := range []string{
+		strings.Repeat("[", 2000000),
+		strings.Repeat("(", deep) + "1" + strings.Repeat(")", deep),
+		strings.Repeat("-", deep) + "1",
+		strings.Repeat("if (true) { ", deep),
+		"1" + strings.Repeat(" + 1", deep),
+		"let x: " + strings.Repeat("[", deep) + "int" + strings.Repeat("]", deep) + " = 1",
+	} {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+
+		// parsing stops at the first error
+		expected := fmt.Sprintf("nested more than %d deep", parser.MaxDepth)
+		if errors := p.Errors(); len(errors) != 1 || errors[0] != expected {
+			t.Errorf("wrong parser errors for %.20q... got=%q", input, errors)
+		}
+	}
+
+	// up to the limit is fine
+	p := parser.New(lexer.New(strings.Repeat("[", parser.MaxDepth-1) + strings.Repeat("]", parser.MaxDepth-1)))
+	p.ParseProgram()
+	checkParseErrors(t, p)
+}
+
+func TestErrorsAt(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedLine   int
+		expectedColumn int
+	}{
+		{"let a = 1;\nlet = 2;", 2, 5},      // the unexpected token
+		{"let a = 1;\n  a + ;", 2, 7},       // no prefix parse function
+		{"let a = 1;\n\n[1] + 1 = 2", 3, 9}, // the = of the assignment
+		{"try { 1 }", 1, 1},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.ErrorsAt()
+		if len(errors) == 0 || len(errors) != len(p.Errors()) {
+			t.Fatalf("wrong number of errors for %q. got=%d, want=%d", tt.input, len(errors), len(p.Errors()))
+		}
+		if errors[0].Message != p.Errors()[0] {
+			t.Errorf("wrong message for %q. got=%q, want=%q", tt.input, errors[0].Message, p.Errors()[0])
+		}
+		if errors[0].Token.Line != tt.expectedLine || errors[0].Token.Column != tt.expectedColumn {
+			t.Errorf("wrong position for %q. got=%d:%d, want=%d:%d", tt.input,
+				errors[0].Token.Line, errors[0].Token.Column, tt.expectedLine, tt.expectedColumn)
+		}
+	}
+}
+
 func TestParsingHashLiteralStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/token"
+)
+
+// ---------------- type annotations ----------------
+
+// parseAnnotation parses the `: type` after a name if there is one. The bool is false when the
+// annotation is there but isn't a type.
+func (p *Parser) parseAnnotation() (ast.Type, bool) {
+	if !p.peekTokenIs(token.COLON) {
+		return nil, true
+	}
+	p.nextToken()
+	p.nextToken()
+
+	t := p.parseType()
+	return t, t != nil
+}
+
+// parseType parses the type starting at the current token: a name, [element], {key: value}
+// or fn(parameters) -> return
+func (p *Parser) parseType() ast.Type {
+	defer func(depth int) { p.depth = depth }(p.depth)
+	if !p.nest() {
+		return nil
+	}
+
+	switch p.currToken.Type {
+	case token.IDENT:
+		return &ast.NamedType{Token: p.currToken, Name: p.currToken.Literal}
+
+	case token.LBRACKET:
+		t := &ast.ArrayType{Token: p.currToken}
+		p.nextToken()
+		if t.Element = p.parseType(); t.Element == nil || !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return t
+
+	case token.LBRACE:
+		t := &ast.HashType{Token: p.currToken}
+		p.nextToken()
+		if t.Key = p.parseType(); t.Key == nil || !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		if t.Value = p.parseType(); t.Value == nil || !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		return t
+
+	case token.FUNCTION:
+		t := &ast.FunctionType{Token: p.currToken, Parameters: []ast.Type{}}
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		for !p.peekTokenIs(token.RPAREN) {
+			if len(t.Parameters) > 0 && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+			p.nextToken()
+			param := p.parseType()
+			if param == nil {
+				return nil
+			}
+			t.Parameters = append(t.Parameters, param)
+		}
+		p.nextToken()
+
+		if p.peekTokenIs(token.ARROW) {
+			p.nextToken()
+			p.nextToken()
+			if t.Return = p.parseType(); t.Return == nil {
+				return nil
+			}
+		}
+		return t
+	}
+
+	p.error(p.currToken, fmt.Sprintf("expected a type, got %s instead", p.currToken.Type))
+	return nil
+}
@@ -0,0 +1,206 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/vm"
+)
+
+/*
+	The pprof format is a gzipped protocol buffer, a Profile message of
+	github.com/google/pprof/proto/profile.proto. A profile has a sample for each node of the
+	call tree, its stack being the functions from the node up to the main program, with three
+	values: the calls and the time of the node outside of the calls it made, and no samples.
+	pprof adds up the values of the stacks, so the cumulative times it shows are those of the
+	tree. The stacks the vm sampled follow, with only samples, their locations being lines
+	rather than the starts of functions. pprof shows the times unless asked for
+	`-sample_index=samples`.
+
+	The fields are written by hand, there being few of them, rather than with a protobuf
+	library.
+*/
+
+// the field numbers of the messages of profile.proto
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+	profileDefaultType   = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID        = 1
+	functionName      = 2
+	functionFilename  = 4
+	functionStartLine = 5
+)
+
+// buffer encodes a protocol buffer message
+type buffer []byte
+
+func (b *buffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+// uint64 writes a field of a varint type, leaving out zeros as protocol buffers do
+func (b *buffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *buffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+// bytes writes a field of a length delimited type: strings, messages and packed numbers
+func (b *buffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *buffer) packed(field int, xs []uint64) {
+	var packed buffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytes(field, packed)
+}
+
+// stringTable is the string table of a profile, which the other messages refer to by index
+type stringTable struct {
+	table []string
+	index map[string]int
+}
+
+func (s *stringTable) of(str string) uint64 {
+	i, ok := s.index[str]
+	if !ok {
+		i = len(s.table)
+		s.table = append(s.table, str)
+		s.index[str] = i
+	}
+	return uint64(i)
+}
+
+// WritePprof writes p in the pprof format, the program being that of file
+func WritePprof(w io.Writer, p *vm.Profile, file string) error {
+	var b buffer
+	strs := &stringTable{index: map[string]int{}}
+	strs.of("") // the first string is the empty one
+
+	valueType := func(typ, unit string) buffer {
+		var vt buffer
+		vt.uint64(valueTypeType, strs.of(typ))
+		vt.uint64(valueTypeUnit, strs.of(unit))
+		return vt
+	}
+	for _, typ := range [][2]string{{"calls", "count"}, {"time", "nanoseconds"}, {"samples", "count"}} {
+		b.bytes(profileSampleType, valueType(typ[0], typ[1]))
+	}
+
+	// an id per function of the profile, and per line of a function
+	functionIDs := map[*object.CompiledFunction]uint64{}
+	locationIDs := map[vm.Location]uint64{}
+	var locations, functions []buffer
+	function := func(fn *object.CompiledFunction) uint64 {
+		if id, ok := functionIDs[fn]; ok {
+			return id
+		}
+		id := uint64(len(functionIDs) + 1)
+		functionIDs[fn] = id
+
+		var f buffer
+		f.uint64(functionID, id)
+		f.uint64(functionName, strs.of(name(p, fn)))
+		f.uint64(functionFilename, strs.of(file))
+		f.int64(functionStartLine, int64(line(fn)))
+		functions = append(functions, f)
+		return id
+	}
+	location := func(loc vm.Location) uint64 {
+		if id, ok := locationIDs[loc]; ok {
+			return id
+		}
+		id := uint64(len(locationIDs) + 1)
+		locationIDs[loc] = id
+
+		var l, ln buffer
+		ln.uint64(lineFunctionID, function(loc.Fn))
+		ln.int64(lineLine, int64(loc.Line))
+		l.uint64(locationID, id)
+		l.bytes(locationLine, ln)
+		locations = append(locations, l)
+		return id
+	}
+	sample := func(stack []uint64, values ...uint64) {
+		var s buffer
+		s.packed(sampleLocationID, stack)
+		s.packed(sampleValue, values)
+		b.bytes(profileSample, s)
+	}
+
+	var walk func(c *vm.Call, stack []uint64)
+	walk = func(c *vm.Call, stack []uint64) {
+		stack = append([]uint64{location(vm.Location{Fn: c.Fn, Line: line(c.Fn)})}, stack...) // the leaf first
+		sample(stack, uint64(c.Calls), uint64(c.Self), 0)
+		for _, child := range c.Children {
+			walk(child, stack)
+		}
+	}
+	walk(p.Root, nil)
+
+	for _, s := range p.Samples {
+		stack := make([]uint64, len(s.Stack))
+		for i, loc := range s.Stack {
+			stack[i] = location(loc)
+		}
+		sample(stack, 0, 0, uint64(s.Count))
+	}
+
+	for _, l := range locations {
+		b.bytes(profileLocation, l)
+	}
+	for _, f := range functions {
+		b.bytes(profileFunction, f)
+	}
+	b.bytes(profilePeriodType, valueType("samples", "nanoseconds"))
+	b.int64(profilePeriod, int64(vm.SamplePeriod))
+	b.uint64(profileDefaultType, strs.of("time"))
+	for _, s := range strs.table {
+		b.bytes(profileStringTable, []byte(s))
+	}
+	b.int64(profileTimeNanos, p.Start.UnixNano())
+	b.int64(profileDurationNanos, int64(p.Root.Total))
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b); err != nil {
+		return err
+	}
+	return gz.Close()
+}
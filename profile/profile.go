@@ -0,0 +1,191 @@
+// Package profile reports the profiles of monkey programs (see vm.EnableProfiling): as a flat
+// table of the time, calls and samples of functions, the lines sampled most and the
+// instructions run, and in the format of pprof, so that `go tool pprof` shows where programs
+// spend their time, down to flame graphs.
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/vm"
+)
+
+// Function is what the calls of a function took, wherever they were made from
+type Function struct {
+	Name  string
+	Line  int // where the function's code starts
+	Calls int
+
+	// Flat is the time the function took outside of the functions it called, Cum the time it
+	// took with them (without counting the time of recursive calls twice)
+	Flat, Cum time.Duration
+
+	Samples int // the samples taken while running the function's own code
+}
+
+// name returns the name of fn for profiles: main for the main program, and the name and line of
+// the functions which have no name of their own
+func name(p *vm.Profile, fn *object.CompiledFunction) string {
+	switch {
+	case fn == p.Root.Fn:
+		return "main"
+	case fn.Name != "":
+		return fn.Name
+	default:
+		return fmt.Sprintf("%s:%d", object.AnonymousFunction, line(fn))
+	}
+}
+
+// line returns the first line of the code of fn, 0 if it's not known
+func line(fn *object.CompiledFunction) int {
+	if len(fn.Lines) == 0 {
+		return 0
+	}
+	return fn.Lines[0].Line
+}
+
+// Functions returns the functions of p, those which took the longest first
+func Functions(p *vm.Profile) []Function {
+	byFn := map[*object.CompiledFunction]*Function{}
+	order := []*object.CompiledFunction{}
+	running := map[*object.CompiledFunction]bool{} // the functions on the path to the node
+
+	var walk func(c *vm.Call)
+	walk = func(c *vm.Call) {
+		f, ok := byFn[c.Fn]
+		if !ok {
+			f = &Function{Name: name(p, c.Fn), Line: line(c.Fn)}
+			byFn[c.Fn] = f
+			order = append(order, c.Fn)
+		}
+		f.Calls += c.Calls
+		f.Flat += c.Self
+		if !running[c.Fn] {
+			f.Cum += c.Total
+			running[c.Fn] = true
+			defer delete(running, c.Fn)
+		}
+		for _, child := range c.Children {
+			walk(child)
+		}
+	}
+	walk(p.Root)
+
+	for _, s := range p.Samples {
+		if f, ok := byFn[s.Stack[0].Fn]; ok {
+			f.Samples += s.Count
+		}
+	}
+
+	functions := []Function{}
+	for _, fn := range order {
+		functions = append(functions, *byFn[fn])
+	}
+	sort.SliceStable(functions, func(i, j int) bool { return functions[i].Flat > functions[j].Flat })
+	return functions
+}
+
+// Line is the number of samples taken while a program ran a line of a function
+type Line struct {
+	Name    string // of the function
+	Line    int
+	Samples int
+}
+
+// Lines returns the lines the samples of p found the program running, the most sampled first
+func Lines(p *vm.Profile) []Line {
+	byLine := map[vm.Location]*Line{}
+	order := []vm.Location{}
+	for _, s := range p.Samples {
+		loc := s.Stack[0]
+		l, ok := byLine[loc]
+		if !ok {
+			l = &Line{Name: name(p, loc.Fn), Line: loc.Line}
+			byLine[loc] = l
+			order = append(order, loc)
+		}
+		l.Samples += s.Count
+	}
+
+	lines := []Line{}
+	for _, loc := range order {
+		lines = append(lines, *byLine[loc])
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Samples > lines[j].Samples })
+	return lines
+}
+
+// Opcode is the number of instructions with an opcode a program ran
+type Opcode struct {
+	Name  string
+	Count int
+}
+
+// Opcodes returns the opcodes of the instructions the program of p ran, the most frequent first
+func Opcodes(p *vm.Profile) []Opcode {
+	opcodes := []Opcode{}
+	for op, count := range p.Opcodes {
+		if count == 0 {
+			continue
+		}
+		name := fmt.Sprintf("Op%d", op)
+		if def, err := code.Lookup(byte(op)); err == nil {
+			name = def.Name
+		}
+		opcodes = append(opcodes, Opcode{Name: name, Count: count})
+	}
+	sort.SliceStable(opcodes, func(i, j int) bool { return opcodes[i].Count > opcodes[j].Count })
+	return opcodes
+}
+
+// WriteTable writes p as flat tables: the functions of the program of file, those which took
+// the longest first, the lines sampled most, then the opcodes of the instructions it ran, the
+// most frequent first
+func WriteTable(w io.Writer, p *vm.Profile, file string) error {
+	total := p.Root.Total
+	share := func(d time.Duration) string {
+		if total == 0 {
+			return "0.0%"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(d)/float64(total))
+	}
+
+	fmt.Fprintf(w, "total %s\n", round(total))
+	fmt.Fprintf(w, "%12s %6s %12s %6s %10s %8s  %s\n", "flat", "flat%", "cum", "cum%", "calls", "samples", "function")
+	for _, f := range Functions(p) {
+		fmt.Fprintf(w, "%12s %6s %12s %6s %10d %8d  %s (%s:%d)\n",
+			round(f.Flat), share(f.Flat), round(f.Cum), share(f.Cum), f.Calls, f.Samples, f.Name, file, f.Line)
+	}
+
+	lines := Lines(p)
+	samples := 0
+	for _, l := range lines {
+		samples += l.Samples
+	}
+	fmt.Fprintf(w, "\n%d samples every %s\n%12s %8s  %s\n", samples, vm.SamplePeriod, "samples", "samples%", "line")
+	for _, l := range lines {
+		fmt.Fprintf(w, "%12d %7.1f%%  %s (%s:%d)\n", l.Samples, 100*float64(l.Samples)/float64(samples), l.Name, file, l.Line)
+	}
+
+	opcodes := Opcodes(p)
+	instructions := 0
+	for _, op := range opcodes {
+		instructions += op.Count
+	}
+	fmt.Fprintf(w, "\n%d instructions\n%12s %6s  %s\n", instructions, "count", "count%", "opcode")
+	for _, op := range opcodes {
+		if _, err := fmt.Fprintf(w, "%12d %5.1f%%  %s\n", op.Count, 100*float64(op.Count)/float64(instructions), op.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
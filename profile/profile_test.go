@@ -0,0 +1,200 @@
+package profile_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/profile"
+	"github.com/andy9775/monkey/vm"
+)
+
+// testProfile returns the profile of a main program calling fib, which calls itself, and an
+// anonymous function, with samples of both fibs and of main
+func testProfile() *vm.Profile {
+	main := &object.CompiledFunction{Lines: code.LineTable{{Offset: 0, Line: 1}}}
+	fib := &object.CompiledFunction{Name: "fib", Lines: code.LineTable{{Offset: 0, Line: 2}}}
+	anonymous := &object.CompiledFunction{Lines: code.LineTable{{Offset: 0, Line: 7}}}
+
+	p := &vm.Profile{Start: time.Unix(1, 0), Root: &vm.Call{Fn: main, Calls: 1, Total: 10 * time.Millisecond, Self: time.Millisecond}}
+	p.Root.Children = []*vm.Call{
+		{Fn: fib, Calls: 1, Total: 8 * time.Millisecond, Self: 3 * time.Millisecond, Children: []*vm.Call{
+			{Fn: fib, Calls: 2, Total: 5 * time.Millisecond, Self: 5 * time.Millisecond},
+		}},
+		{Fn: anonymous, Calls: 1, Total: time.Millisecond, Self: time.Millisecond},
+	}
+	p.Opcodes[code.OpCall] = 4
+	p.Opcodes[code.OpConstant] = 12
+	p.Samples = []*vm.Sample{
+		{Stack: []vm.Location{{Fn: fib, Line: 3}, {Fn: main, Line: 5}}, Count: 3},
+		{Stack: []vm.Location{{Fn: fib, Line: 3}, {Fn: fib, Line: 4}, {Fn: main, Line: 5}}, Count: 2},
+		{Stack: []vm.Location{{Fn: main, Line: 6}}, Count: 1},
+	}
+	return p
+}
+
+func TestFunctions(t *testing.T) {
+	expected := []profile.Function{
+		{Name: "fib", Line: 2, Calls: 3, Flat: 8 * time.Millisecond, Cum: 8 * time.Millisecond, Samples: 5}, // not 13ms
+		{Name: "main", Line: 1, Calls: 1, Flat: time.Millisecond, Cum: 10 * time.Millisecond, Samples: 1},
+		{Name: "<anonymous>:7", Line: 7, Calls: 1, Flat: time.Millisecond, Cum: time.Millisecond},
+	}
+	if functions := profile.Functions(testProfile()); !reflect.DeepEqual(functions, expected) {
+		t.Errorf("wrong functions.\nwant=%+v\ngot =%+v", expected, functions)
+	}
+}
+
+func TestLines(t *testing.T) {
+	expected := []profile.Line{{Name: "fib", Line: 3, Samples: 5}, {Name: "main", Line: 6, Samples: 1}}
+	if lines := profile.Lines(testProfile()); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("wrong lines.\nwant=%+v\ngot =%+v", expected, lines)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var out bytes.Buffer
+	if err := profile.WriteTable(&out, testProfile(), "fib.mk"); err != nil {
+		t.Fatal(err)
+	}
+	expected := `total 10ms
+        flat  flat%          cum   cum%      calls  samples  function
+         8ms  80.0%          8ms  80.0%          3        5  fib (fib.mk:2)
+         1ms  10.0%         10ms 100.0%          1        1  main (fib.mk:1)
+         1ms  10.0%          1ms  10.0%          1        0  <anonymous>:7 (fib.mk:7)
+
+6 samples every 1ms
+     samples samples%  line
+           5    83.3%  fib (fib.mk:3)
+           1    16.7%  main (fib.mk:6)
+
+16 instructions
+       count count%  opcode
+          12  75.0%  OpConstant
+           4  25.0%  OpCall
+`
+	if out.String() != expected {
+		t.Errorf("wrong table.\nwant=%s\ngot =%s", expected, out.String())
+	}
+}
+
+// message returns the fields of the protocol buffer message data by number, the varints as
+// uint64 and the others as []byte
+func message(t *testing.T, data []byte) map[int][]interface{} {
+	t.Helper()
+	varint := func() uint64 {
+		var x uint64
+		for shift := 0; ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatalf("truncated message")
+			}
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+
+	fields := map[int][]interface{}{}
+	for len(data) > 0 {
+		key := varint()
+		switch key & 7 {
+		case 0:
+			fields[int(key>>3)] = append(fields[int(key>>3)], varint())
+		case 2:
+			n := varint()
+			fields[int(key>>3)] = append(fields[int(key>>3)], data[:n])
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func TestWritePprof(t *testing.T) {
+	var out bytes.Buffer
+	if err := profile.WritePprof(&out, testProfile(), "fib.mk"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := message(t, data)
+	strs := []string{}
+	for _, s := range p[6] {
+		strs = append(strs, string(s.([]byte)))
+	}
+	expectedStrings := []string{"", "calls", "count", "time", "nanoseconds", "samples", "main", "fib.mk", "fib", "<anonymous>:7"}
+	if !reflect.DeepEqual(strs, expectedStrings) {
+		t.Errorf("wrong string table.\nwant=%q\ngot =%q", expectedStrings, strs)
+	}
+	if len(p[1]) != 3 || len(p[4]) != 7 || len(p[5]) != 3 {
+		t.Errorf("wrong number of sample types, locations or functions. got=%d, %d, %d", len(p[1]), len(p[4]), len(p[5]))
+	}
+	if p[9][0] != uint64(time.Second) || p[10][0] != uint64(10*time.Millisecond) {
+		t.Errorf("wrong time or duration. got=%v, %v", p[9], p[10])
+	}
+	if p[12][0] != uint64(vm.SamplePeriod) || p[14][0] != uint64(3) { // "time"
+		t.Errorf("wrong period or default sample type. got=%v, %v", p[12], p[14])
+	}
+
+	// a sample per node of the call tree: its stack from the leaf, its calls and self time, then
+	// the sampled stacks by line
+	type sample struct {
+		stack, values []uint64
+	}
+	packed := func(data []byte) []uint64 {
+		var xs []uint64
+		for _, x := range message(t, appendPacked(data))[1] {
+			xs = append(xs, x.(uint64))
+		}
+		return xs
+	}
+	samples := []sample{}
+	for _, s := range p[2] {
+		fields := message(t, s.([]byte))
+		samples = append(samples, sample{packed(fields[1][0].([]byte)), packed(fields[2][0].([]byte))})
+	}
+	ms := uint64(time.Millisecond)
+	expectedSamples := []sample{
+		{[]uint64{1}, []uint64{1, ms, 0}},
+		{[]uint64{2, 1}, []uint64{1, 3 * ms, 0}},
+		{[]uint64{2, 2, 1}, []uint64{2, 5 * ms, 0}},
+		{[]uint64{3, 1}, []uint64{1, ms, 0}},
+		{[]uint64{4, 5}, []uint64{0, 0, 3}},
+		{[]uint64{4, 6, 5}, []uint64{0, 0, 2}},
+		{[]uint64{7}, []uint64{0, 0, 1}},
+	}
+	if !reflect.DeepEqual(samples, expectedSamples) {
+		t.Errorf("wrong samples.\nwant=%v\ngot =%v", expectedSamples, samples)
+	}
+}
+
+// appendPacked turns packed varints into a message of fields 1, for message to read
+func appendPacked(data []byte) []byte {
+	out := []byte{}
+	for len(data) > 0 {
+		out = append(out, 1<<3)
+		for {
+			b := data[0]
+			out, data = append(out, b), data[1:]
+			if b < 0x80 {
+				break
+			}
+		}
+	}
+	return out
+}
@@ -1,7 +1,6 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 
@@ -15,9 +14,13 @@ import (
 // PROMPT is the text input console prompt
 const PROMPT = ">> "
 
-// Start begins the repl loop
+// Start begins the repl loop. Programs use in and out as their streams (see object.IO), so
+// read_line reads the lines after the one being run, and the files of the working directory.
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	streams := object.NewIO(in, out, out)
+	if fsys, err := object.DirFS("."); err == nil {
+		streams.FS = fsys
+	}
 
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
@@ -27,14 +30,12 @@ func Start(in io.Reader, out io.Writer) {
 	}
 
 	for {
-		fmt.Printf(PROMPT)        // print prompt and accept new input
-		scanned := scanner.Scan() // read input
-
-		if !scanned {
+		fmt.Fprint(out, PROMPT)         // print prompt and accept new input
+		line, err := streams.ReadLine() // read input
+		if err != nil {
 			return
 		}
 
-		line := scanner.Text()
 		l := lexer.New(line) // new lexer
 		p := parser.New(l)
 
@@ -45,7 +46,8 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		comp.EnableOptimizations()
+		err = comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Whoops! Compilation failed:\n%s\n", err)
 			continue
@@ -55,6 +57,7 @@ func Start(in io.Reader, out io.Writer) {
 		constants = code.Constants
 
 		machine := vm.NewWithGlobalStore(code, globals)
+		machine.SetIO(streams)
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Whoops! Executing bytecode failed:\n%s\n", err)
@@ -0,0 +1,285 @@
+// Package resolver resolves the identifiers of a program the way the compiler does, for the
+// tools which look at programs without running them (the language server and the linter).
+package resolver
+
+import (
+	"sort"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/token"
+)
+
+/*
+	Resolve walks a program with a compiler.SymbolTable per function: names are defined by let
+	statements, parameters and catch blocks, a let defines its name after its value (unless the
+	value is a function, which can call itself) and a function sees the names of the functions
+	around it as free variables. Each definition of a name in a table is one binding, which all
+	the identifiers resolving to it refer to.
+*/
+
+// Binding is a name the program defines, or a builtin
+type Binding struct {
+	Name  string
+	Scope compiler.SymbolScope // where it's defined: GLOBAL, LOCAL or BUILTIN
+
+	Def      *ast.Identifier      // the first definition, nil for builtins
+	Let      *ast.LetStatement    // the let statement of the first definition, if it's one
+	Literal  *ast.FunctionLiteral // the function literal the first definition binds, if any
+	Function *ast.FunctionLiteral // the function it's defined in, nil for globals and builtins
+	Refs     []*ast.Identifier    // the definitions and uses, in source order
+}
+
+// Occurrence is an identifier, the binding it refers to and the scope it has where it's used
+// (e.g. FREE, for a local of an enclosing function)
+type Occurrence struct {
+	Ident    *ast.Identifier
+	Binding  *Binding
+	Scope    compiler.SymbolScope
+	Function *Function // the innermost function it's in, nil at the top level
+}
+
+// Function is a function literal of the program and the function around it
+type Function struct {
+	Literal *ast.FunctionLiteral
+	Outer   *Function
+}
+
+// Encloses reports whether lit is f or one of the functions around it
+func (f *Function) Encloses(lit *ast.FunctionLiteral) bool {
+	for ; f != nil; f = f.Outer {
+		if f.Literal == lit {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolution is what Resolve finds out about a program
+type Resolution struct {
+	Occurrences []Occurrence // in source order
+	Bindings    []*Binding   // those of the program, in the order they're defined
+	Functions   []*Function  // in the order they're found
+	Definitions map[*ast.Identifier]bool
+
+	Undefined []*ast.Identifier // the uses of names which aren't defined, in source order
+}
+
+// entry is the binding of a name in a table. An alias is the name of the function the table is
+// of, which refers to the binding the function was defined with.
+type entry struct {
+	binding *Binding
+	alias   bool
+}
+
+type resolver struct {
+	*Resolution
+
+	table    *compiler.SymbolTable
+	entries  map[*compiler.SymbolTable]map[string]entry
+	builtins map[string]*Binding
+	fn       *Function
+}
+
+// Resolve resolves the identifiers of program, which may be one the parser reported errors for
+func Resolve(program *ast.Program) *Resolution {
+	table := compiler.NewSymbolTable()
+	for i, builtin := range object.Builtins {
+		table.DefineBuiltin(i, builtin.Name)
+	}
+
+	r := &resolver{
+		Resolution: &Resolution{Definitions: map[*ast.Identifier]bool{}},
+		table:      table,
+		entries:    map[*compiler.SymbolTable]map[string]entry{},
+		builtins:   map[string]*Binding{},
+	}
+	for _, s := range program.Statements {
+		r.statement(s)
+	}
+
+	sort.SliceStable(r.Occurrences, func(i, j int) bool {
+		return Before(r.Occurrences[i].Ident.Token, r.Occurrences[j].Ident.Token)
+	})
+	for _, b := range r.Bindings {
+		sort.SliceStable(b.Refs, func(i, j int) bool { return Before(b.Refs[i].Token, b.Refs[j].Token) })
+	}
+	sort.SliceStable(r.Undefined, func(i, j int) bool { return Before(r.Undefined[i].Token, r.Undefined[j].Token) })
+	return r.Resolution
+}
+
+// Before reports whether a comes before b in the source
+func Before(a, b token.Token) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+func (r *resolver) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		if s.Name == nil {
+			return
+		}
+		if lit, ok := s.Value.(*ast.FunctionLiteral); ok {
+			r.function(lit, r.define(s.Name, s))
+			return
+		}
+		r.expression(s.Value)
+		r.define(s.Name, s)
+	case *ast.ReturnStatement:
+		r.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(s.Value)
+	case *ast.ExpressionStatement:
+		r.expression(s.Expression)
+	case *ast.BlockStatement:
+		r.block(s)
+	}
+}
+
+func (r *resolver) block(b *ast.BlockStatement) {
+	if b == nil {
+		return
+	}
+	for _, s := range b.Statements {
+		r.statement(s)
+	}
+}
+
+func (r *resolver) expression(e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		r.use(e)
+	case *ast.PrefixExpression:
+		r.expression(e.Right)
+	case *ast.InfixExpression:
+		r.expression(e.Left)
+		r.expression(e.Right)
+	case *ast.IfExpression:
+		r.expression(e.Condition)
+		r.block(e.Consequence)
+		r.block(e.Alternative)
+	case *ast.TryExpression:
+		r.block(e.Block)
+		if e.CatchName != nil {
+			r.define(e.CatchName, nil)
+		}
+		r.block(e.Catch)
+		r.block(e.Finally)
+	case *ast.FunctionLiteral:
+		r.function(e, nil)
+	case *ast.CallExpression:
+		r.expression(e.Function)
+		for _, a := range e.Arguments {
+			r.expression(a)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			r.expression(el)
+		}
+	case *ast.IndexExpression:
+		r.expression(e.Left)
+		r.expression(e.Index)
+	case *ast.SliceExpression:
+		r.expression(e.Left)
+		r.expression(e.Start)
+		r.expression(e.End)
+	case *ast.AssignExpression:
+		if e.Target != nil {
+			r.expression(e.Target)
+		}
+		r.expression(e.Value)
+	case *ast.HashLiteral:
+		for _, key := range e.Keys {
+			r.expression(key)
+			r.expression(e.Pairs[key])
+		}
+	}
+}
+
+// function resolves lit in a table of its own. self is the binding of the let the function is
+// the value of, which the function can refer to by its name.
+func (r *resolver) function(lit *ast.FunctionLiteral, self *Binding) {
+	outerTable, outerFn := r.table, r.fn
+	r.table = compiler.NewEnclosedSymbolTable(outerTable)
+	r.fn = &Function{Literal: lit, Outer: outerFn}
+	r.Functions = append(r.Functions, r.fn)
+
+	if lit.Name != "" && self != nil {
+		r.table.DefineFunctionName(lit.Name)
+		r.entriesOf(r.table)[lit.Name] = entry{binding: self, alias: true}
+	}
+	for _, param := range lit.Parameters {
+		r.define(param, nil)
+	}
+	r.block(lit.Body)
+
+	r.table, r.fn = outerTable, outerFn
+}
+
+func (r *resolver) entriesOf(table *compiler.SymbolTable) map[string]entry {
+	entries, ok := r.entries[table]
+	if !ok {
+		entries = map[string]entry{}
+		r.entries[table] = entries
+	}
+	return entries
+}
+
+// define defines the name ident in the current table, by let if it's a let statement's name.
+// Defining a name again refers to the binding of the first definition, as it does in the
+// compiler.
+func (r *resolver) define(ident *ast.Identifier, let *ast.LetStatement) *Binding {
+	symbol := r.table.Define(ident.Value)
+	entries := r.entriesOf(r.table)
+
+	e, ok := entries[ident.Value]
+	if !ok || e.alias {
+		b := &Binding{Name: ident.Value, Scope: symbol.Scope, Def: ident, Let: let}
+		if let != nil {
+			b.Literal, _ = let.Value.(*ast.FunctionLiteral)
+		}
+		if r.fn != nil {
+			b.Function = r.fn.Literal
+		}
+		e = entry{binding: b}
+		entries[ident.Value] = e
+		r.Bindings = append(r.Bindings, b)
+	}
+
+	b := e.binding
+	b.Refs = append(b.Refs, ident)
+	r.Definitions[ident] = true
+	r.Occurrences = append(r.Occurrences, Occurrence{Ident: ident, Binding: b, Scope: symbol.Scope, Function: r.fn})
+	return b
+}
+
+// use resolves the name ident is a use of
+func (r *resolver) use(ident *ast.Identifier) {
+	symbol, ok := r.table.Resolve(ident.Value)
+	if !ok {
+		r.Undefined = append(r.Undefined, ident)
+		return
+	}
+
+	var b *Binding
+	if symbol.Scope == compiler.BuiltinScope {
+		b = r.builtins[ident.Value]
+		if b == nil {
+			b = &Binding{Name: ident.Value, Scope: compiler.BuiltinScope}
+			r.builtins[ident.Value] = b
+		}
+	} else {
+		for table := r.table; table != nil && b == nil; table = table.Outer {
+			if e, ok := r.entries[table][ident.Value]; ok {
+				b = e.binding
+			}
+		}
+		if b == nil {
+			return
+		}
+	}
+
+	b.Refs = append(b.Refs, ident)
+	r.Occurrences = append(r.Occurrences, Occurrence{Ident: ident, Binding: b, Scope: symbol.Scope, Function: r.fn})
+}
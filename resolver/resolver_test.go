@@ -0,0 +1,108 @@
+package resolver_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/resolver"
+)
+
+func resolve(t *testing.T, input string) *resolver.Resolution {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return resolver.Resolve(program)
+}
+
+func TestOccurrences(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // name:scope@line:column=line:column of the definition
+	}{
+		{
+			"let a = 1; a",
+			"a:GLOBAL@1:5=1:5 a:GLOBAL@1:12=1:5",
+		},
+		{
+			"let f = fn(a) { fn() { a + f } }",
+			"f:GLOBAL@1:5=1:5 a:LOCAL@1:12=1:12 a:FREE@1:24=1:12 f:FREE@1:28=1:5",
+		},
+		{
+			"let f = fn(n) { f(n) }",
+			"f:GLOBAL@1:5=1:5 n:LOCAL@1:12=1:12 f:FUNCTION@1:17=1:5 n:LOCAL@1:19=1:12",
+		},
+		{
+			"let g = fn() { let f = fn() { f }; f }",
+			"g:GLOBAL@1:5=1:5 f:LOCAL@1:20=1:20 f:FUNCTION@1:31=1:20 f:LOCAL@1:36=1:20",
+		},
+		{
+			"let len = len([]); len",
+			"len:GLOBAL@1:5=1:5 len:BUILTIN@1:11=- len:GLOBAL@1:20=1:5",
+		},
+		{
+			"let a = 1; let a = a; a",
+			"a:GLOBAL@1:5=1:5 a:GLOBAL@1:16=1:5 a:GLOBAL@1:20=1:5 a:GLOBAL@1:23=1:5",
+		},
+		{
+			"try { 1 } catch (e) { e }",
+			"e:GLOBAL@1:18=1:18 e:GLOBAL@1:23=1:18",
+		},
+	}
+
+	for _, tt := range tests {
+		r := resolve(t, tt.input)
+		got := []string{}
+		for _, o := range r.Occurrences {
+			def := "-"
+			if o.Binding.Def != nil {
+				def = fmt.Sprintf("%d:%d", o.Binding.Def.Token.Line, o.Binding.Def.Token.Column)
+			}
+			got = append(got, fmt.Sprintf("%s:%s@%d:%d=%s", o.Ident.Value, o.Scope, o.Ident.Token.Line, o.Ident.Token.Column, def))
+		}
+		if strings.Join(got, " ") != tt.expected {
+			t.Errorf("wrong occurrences of %q.\nwant=%s\ngot= %s", tt.input, tt.expected, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestBindings(t *testing.T) {
+	r := resolve(t, "let f = fn(a) { let b = a; b }; let c = f(1); try { c } catch (e) { e }")
+
+	got := []string{}
+	for _, b := range r.Bindings {
+		kind := "param"
+		if b.Let != nil {
+			kind = "let"
+		}
+		if b.Literal != nil {
+			kind += " fn"
+		}
+		got = append(got, fmt.Sprintf("%s:%s:%s:%d", b.Name, b.Scope, kind, len(b.Refs)))
+	}
+	expected := "f:GLOBAL:let fn:2 a:LOCAL:param:2 b:LOCAL:let:2 c:GLOBAL:let:2 e:GLOBAL:param:2"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("wrong bindings.\nwant=%s\ngot= %s", expected, strings.Join(got, " "))
+	}
+
+	if len(r.Functions) != 1 || r.Bindings[1].Function != r.Functions[0].Literal {
+		t.Errorf("wrong functions. got=%v", r.Functions)
+	}
+}
+
+func TestUndefined(t *testing.T) {
+	r := resolve(t, "let a = fn() { b + a(c) }; d; let b = 1; b")
+
+	got := []string{}
+	for _, ident := range r.Undefined {
+		got = append(got, fmt.Sprintf("%s@%d:%d", ident.Value, ident.Token.Line, ident.Token.Column))
+	}
+	if expected := "b@1:16 c@1:22 d@1:28"; strings.Join(got, " ") != expected {
+		t.Errorf("wrong undefined names. want=%s, got=%s", expected, strings.Join(got, " "))
+	}
+}
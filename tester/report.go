@@ -0,0 +1,148 @@
+package tester
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteText writes a report of results for people: the failures with where they happened, the
+// error and what the test printed, then a line per file
+func WriteText(w io.Writer, results []FileResult) {
+	for _, fr := range results {
+		if fr.Error != "" {
+			fmt.Fprintf(w, "FAIL\t%s [setup failed]\n\t%s\n", fr.File, indent(fr.Error))
+			continue
+		}
+
+		failed := 0
+		var duration time.Duration
+		for _, r := range fr.Tests {
+			duration += r.Duration
+			if r.Passed {
+				continue
+			}
+			failed++
+			fmt.Fprintf(w, "--- FAIL: %s (%s:%d)\n", r.Name, fr.File, r.Line)
+			if r.ErrorLine > 0 {
+				fmt.Fprintf(w, "\t%s:%d: %s: %s\n", fr.File, r.ErrorLine, r.Kind, indent(r.Message))
+			} else {
+				fmt.Fprintf(w, "\t%s: %s\n", r.Kind, indent(r.Message))
+			}
+			if r.Output != "" {
+				fmt.Fprintf(w, "\toutput:\n\t%s\n", indent(strings.TrimSuffix(r.Output, "\n")))
+			}
+		}
+
+		switch {
+		case len(fr.Tests) == 0:
+			fmt.Fprintf(w, "ok  \t%s\t[no tests to run]\n", fr.File)
+		case failed > 0:
+			fmt.Fprintf(w, "FAIL\t%s\t%s (%d of %d failed)\n", fr.File, duration, failed, len(fr.Tests))
+		default:
+			fmt.Fprintf(w, "ok  \t%s\t%s (%d passed)\n", fr.File, duration, len(fr.Tests))
+		}
+	}
+}
+
+// indent indents the lines of s after the first, for the text report
+func indent(s string) string {
+	return strings.ReplaceAll(s, "\n", "\n\t")
+}
+
+// WriteJSON writes results as a JSON array
+func WriteJSON(w io.Writer, results []FileResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// The JUnit XML format, which CI servers read: a test suite per file with a test case per test
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Output    string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes results in the JUnit XML format. A file which can't be run is a suite with
+// an error.
+func WriteJUnit(w io.Writer, results []FileResult) error {
+	suites := junitSuites{Suites: []junitSuite{}}
+	for _, fr := range results {
+		suite := junitSuite{Name: fr.File, Tests: len(fr.Tests), Cases: []junitCase{}}
+		if fr.Error != "" {
+			suite.Tests, suite.Errors = 1, 1
+			suite.Cases = append(suite.Cases, junitCase{
+				Name:      "setup",
+				ClassName: fr.File,
+				Time:      seconds(0),
+				Error:     &junitProblem{Message: firstLine(fr.Error), Type: "SetupError", Text: fr.Error},
+			})
+		}
+
+		var total time.Duration
+		for _, r := range fr.Tests {
+			total += r.Duration
+			c := junitCase{Name: r.Name, ClassName: fr.File, Time: seconds(r.Duration), Output: r.Output}
+			if !r.Passed {
+				suite.Failures++
+				text := fmt.Sprintf("%s:%d: %s: %s", fr.File, r.ErrorLine, r.Kind, r.Message)
+				if r.ErrorLine == 0 {
+					text = fmt.Sprintf("%s: %s", r.Kind, r.Message)
+				}
+				c.Failure = &junitProblem{Message: firstLine(r.Message), Type: r.Kind, Text: text}
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		suite.Time = seconds(total)
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,22 @@
+let add = fn(a, b) { a + b };
+let counter = [0];
+
+let test_add = fn() {
+  assert_eq(add(1, 2), 3);
+};
+
+let test_wrong = fn() {
+  puts("adding");
+  assert_eq(add(1, 2), 4, "sum");
+};
+
+let test_isolated_a = fn() { counter[0] = counter[0] + 1; assert_eq(counter[0], 1) };
+let test_isolated_b = fn() { counter[0] = counter[0] + 1; assert_eq(counter[0], 1) };
+
+let test_files = fn() { assert_eq(read_file("data.txt"), "data") };
+let test_type_error = fn() {
+  let f = fn() { 1 + true };
+  f()
+};
+let test_arguments = fn(a) { a };
+let helper = fn() { 1 };
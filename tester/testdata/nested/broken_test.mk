@@ -0,0 +1 @@
+let test_broken = fn() { 1 +
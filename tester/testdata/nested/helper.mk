@@ -0,0 +1 @@
+let test_not_a_test_file = fn() { assert(false) };
@@ -0,0 +1,234 @@
+// Package tester runs the tests of monkey programs. Tests are the functions named test_... that
+// the top level of files named ..._test.mk defines with let; they take no arguments and fail by
+// throwing, typically through the assertion builtins (assert, assert_eq and assert_throws).
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/cover"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/object"
+	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/token"
+	"github.com/andy9775/monkey/vm"
+)
+
+/*
+	Every test runs in a vm of its own: the file runs from the start, so each test sees what the
+	top level of the file sets up and none of what other tests did, and then the test is called.
+	A test's output is captured, and its files are those of the directory of the test file. With
+	coverage on, the coverage of a file is that of all of its tests.
+*/
+
+// Suffix is the suffix of the names of test files
+const Suffix = "_test.mk"
+
+// Prefix is the prefix of the names of tests
+const Prefix = "test_"
+
+// Result is the outcome of a test
+type Result struct {
+	Name     string        `json:"name"`
+	Line     int           `json:"line"` // where the test is defined
+	Passed   bool          `json:"passed"`
+	Duration time.Duration `json:"duration"`
+	Output   string        `json:"output,omitempty"` // what the test printed
+
+	// the error a failed test threw and the line it was thrown on (0 if it's not known)
+	Kind      string `json:"kind,omitempty"`
+	Message   string `json:"message,omitempty"`
+	ErrorLine int    `json:"error_line,omitempty"`
+}
+
+// FileResult is the outcome of the tests of a file. Error is set if the file can't be run, in
+// which case there are no Tests. Coverage is the coverage of the tests when coverage is on and
+// the file could be run.
+type FileResult struct {
+	File     string         `json:"file"`
+	Error    string         `json:"error,omitempty"`
+	Tests    []Result       `json:"tests"`
+	Coverage *cover.Profile `json:"-"`
+}
+
+// Options are the options of RunFile
+type Options struct {
+	Filter *regexp.Regexp // run only the tests whose names match, all of them if nil
+	Cover  bool           // record the coverage of the tests
+}
+
+// Failed reports whether the file couldn't be run or any of its tests failed
+func (fr FileResult) Failed() bool {
+	if fr.Error != "" {
+		return true
+	}
+	for _, r := range fr.Tests {
+		if !r.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the test files in and below dir, sorted
+func Find(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), Suffix) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// test is a test a file defines
+type test struct {
+	name  string
+	tok   token.Token // of the let statement
+	ident *ast.Identifier
+	lit   *ast.FunctionLiteral
+}
+
+// tests returns the tests program defines, in source order
+func tests(program *ast.Program) []test {
+	found := []test{}
+	for _, s := range program.Statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok || !strings.HasPrefix(let.Name.Value, Prefix) {
+			continue
+		}
+		if lit, ok := let.Value.(*ast.FunctionLiteral); ok {
+			found = append(found, test{name: let.Name.Value, tok: let.Token, ident: let.Name, lit: lit})
+		}
+	}
+	return found
+}
+
+// parse parses source, failing on any parser error
+func parse(source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+	return program, nil
+}
+
+// RunFile runs the tests of the file path
+func RunFile(path string, options Options) FileResult {
+	result := FileResult{File: path, Tests: []Result{}}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	program, err := parse(string(source))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		result.Error = fmt.Sprintf("compilation failed: %s", err)
+		return result
+	}
+	if options.Cover {
+		result.Coverage = cover.New(comp.Bytecode(), nil) // nothing ran yet
+	}
+
+	fsys, err := object.ProgramFS(path, "", false)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, t := range tests(program) {
+		if options.Filter != nil && !options.Filter.MatchString(t.name) {
+			continue
+		}
+		result.Tests = append(result.Tests, run(string(source), t, fsys, result.Coverage))
+	}
+	return result
+}
+
+// run runs the test t of the file of source in a vm of its own, adding its coverage to coverage
+// unless it's nil
+func run(source string, t test, fsys object.FileSystem, coverage *cover.Profile) Result {
+	result := Result{Name: t.name, Line: t.tok.Line}
+	if len(t.lit.Parameters) != 0 {
+		result.Kind, result.Message = object.ArgumentErrorKind, "tests take no arguments"
+		return result
+	}
+
+	// the file followed by a call of the test, parsed again as compiling changes programs
+	program, err := parse(source)
+	if err != nil {
+		result.Kind, result.Message = object.ErrorKind, err.Error()
+		return result
+	}
+	program.Statements = append(program.Statements, &ast.ExpressionStatement{
+		Token: t.tok,
+		Expression: &ast.CallExpression{
+			Token:    token.Token{Type: token.LPAREN, Literal: "(", Line: t.tok.Line, Column: t.tok.Column},
+			Function: &ast.Identifier{Token: t.ident.Token, Value: t.name},
+		},
+	})
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		result.Kind, result.Message = object.ErrorKind, fmt.Sprintf("compilation failed: %s", err)
+		return result
+	}
+
+	var out bytes.Buffer
+	streams := object.NewIO(strings.NewReader(""), &out, &out)
+	streams.FS = fsys
+	machine := vm.New(comp.Bytecode())
+	machine.SetIO(streams)
+	if coverage != nil {
+		machine.EnableCoverage()
+		defer func() { coverage.Add(cover.New(comp.Bytecode(), machine.Coverage())) }()
+	}
+
+	// the position of the instruction running, which is the one that threw once Run fails
+	var fn *object.CompiledFunction
+	var ip int
+	machine.SetHook(func() error {
+		fn, ip, _ = machine.Position()
+		return nil
+	})
+
+	start := time.Now()
+	err = machine.Run()
+	result.Duration = time.Since(start)
+	result.Output = out.String()
+	if err == nil {
+		result.Passed = true
+		return result
+	}
+
+	result.Kind, result.Message = object.ErrorKind, err.Error()
+	if e, ok := err.(*object.Error); ok {
+		result.Kind = e.Kind
+	}
+	if fn != nil {
+		result.ErrorLine = fn.Lines.LineAt(ip)
+	}
+	return result
+}
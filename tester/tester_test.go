@@ -0,0 +1,193 @@
+package tester_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/tester"
+)
+
+func TestFind(t *testing.T) {
+	files, err := tester.Find("testdata")
+	if err != nil {
+		t.Fatalf("Find failed: %s", err)
+	}
+	expected := []string{filepath.Join("testdata", "math_test.mk"), filepath.Join("testdata", "nested", "broken_test.mk")}
+	if strings.Join(files, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong files. want=%v, got=%v", expected, files)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	result := tester.RunFile(filepath.Join("testdata", "math_test.mk"), tester.Options{})
+	if result.Error != "" {
+		t.Fatalf("the file can't be run: %s", result.Error)
+	}
+
+	expected := []struct {
+		name      string
+		line      int
+		passed    bool
+		kind      string
+		message   string
+		errorLine int
+		output    string
+	}{
+		{"test_add", 4, true, "", "", 0, ""},
+		{"test_wrong", 8, false, "AssertionError", "sum: expected 4, got 3", 10, "adding\n"},
+		{"test_isolated_a", 13, true, "", "", 0, ""},
+		{"test_isolated_b", 14, true, "", "", 0, ""},
+		{"test_files", 16, true, "", "", 0, ""},
+		{"test_type_error", 17, false, "TypeError", "unsupported types for binary operation: INTEGER BOOLEAN", 18, ""},
+		{"test_arguments", 21, false, "ArgumentError", "tests take no arguments", 0, ""},
+	}
+	if len(result.Tests) != len(expected) {
+		t.Fatalf("wrong number of tests. want=%d, got=%d (%v)", len(expected), len(result.Tests), result.Tests)
+	}
+	for i, want := range expected {
+		got := result.Tests[i]
+		if got.Name != want.name || got.Line != want.line || got.Passed != want.passed || got.Kind != want.kind ||
+			got.Message != want.message || got.ErrorLine != want.errorLine || got.Output != want.output {
+			t.Errorf("wrong result of test %d.\nwant=%+v\ngot= %+v", i, want, got)
+		}
+	}
+	if !result.Failed() {
+		t.Errorf("the file didn't fail")
+	}
+}
+
+func TestRunFileFilter(t *testing.T) {
+	result := tester.RunFile(filepath.Join("testdata", "math_test.mk"), tester.Options{Filter: regexp.MustCompile("isolated|^test_add$")})
+	names := []string{}
+	for _, r := range result.Tests {
+		names = append(names, r.Name)
+	}
+	if strings.Join(names, " ") != "test_add test_isolated_a test_isolated_b" || result.Failed() {
+		t.Errorf("wrong tests run. got=%v (failed=%t)", names, result.Failed())
+	}
+}
+
+func TestRunFileCover(t *testing.T) {
+	path := filepath.Join("testdata", "math_test.mk")
+	if result := tester.RunFile(path, tester.Options{}); result.Coverage != nil {
+		t.Errorf("coverage recorded without Cover")
+	}
+
+	// the coverage is that of the tests run, each running the top level
+	result := tester.RunFile(path, tester.Options{Filter: regexp.MustCompile("^test_add$|isolated"), Cover: true})
+	if result.Coverage == nil {
+		t.Fatalf("no coverage")
+	}
+	for line, expected := range map[int]int{1: 3, 5: 1, 9: 0, 10: 0, 13: 3} {
+		if hits := result.Coverage.Lines[line]; hits != expected {
+			t.Errorf("wrong hits of line %d. want=%d, got=%d", line, expected, hits)
+		}
+	}
+
+	if result := tester.RunFile(filepath.Join("testdata", "nested", "broken_test.mk"), tester.Options{Cover: true}); result.Coverage != nil {
+		t.Errorf("coverage of a file which can't be run")
+	}
+}
+
+func TestRunFileErrors(t *testing.T) {
+	result := tester.RunFile(filepath.Join("testdata", "nested", "broken_test.mk"), tester.Options{})
+	if !strings.HasPrefix(result.Error, "parser errors:") || len(result.Tests) != 0 || !result.Failed() {
+		t.Errorf("wrong result of a file with parse errors. got=%+v", result)
+	}
+	if result := tester.RunFile(filepath.Join("testdata", "missing_test.mk"), tester.Options{}); result.Error == "" {
+		t.Errorf("no error for a missing file")
+	}
+}
+
+func results() []tester.FileResult {
+	return []tester.FileResult{
+		tester.RunFile(filepath.Join("testdata", "math_test.mk"), tester.Options{Filter: regexp.MustCompile("add|wrong")}),
+		tester.RunFile(filepath.Join("testdata", "nested", "broken_test.mk"), tester.Options{}),
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var out bytes.Buffer
+	tester.WriteText(&out, results())
+
+	lines := strings.Split(out.String(), "\n")
+	expected := []string{
+		"--- FAIL: test_wrong (testdata/math_test.mk:8)",
+		"\ttestdata/math_test.mk:10: AssertionError: sum: expected 4, got 3",
+		"\toutput:",
+		"\tadding",
+	}
+	if len(lines) < 7 || strings.Join(lines[:4], "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("wrong report.\nwant=%s\ngot= %s", strings.Join(expected, "\n"), out.String())
+	}
+	if !strings.HasPrefix(lines[4], "FAIL\ttestdata/math_test.mk\t") || !strings.HasSuffix(lines[4], "(1 of 2 failed)") {
+		t.Errorf("wrong summary of the file. got=%q", lines[4])
+	}
+	if lines[5] != "FAIL\ttestdata/nested/broken_test.mk [setup failed]" {
+		t.Errorf("wrong summary of the broken file. got=%q", lines[5])
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := tester.WriteJSON(&out, results()); err != nil {
+		t.Fatalf("WriteJSON failed: %s", err)
+	}
+
+	var decoded []tester.FileResult
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, out.String())
+	}
+	if len(decoded) != 2 || len(decoded[0].Tests) != 2 || decoded[0].Tests[1].Message != "sum: expected 4, got 3" ||
+		decoded[1].Error == "" {
+		t.Errorf("wrong results. got=%+v", decoded)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var out bytes.Buffer
+	if err := tester.WriteJUnit(&out, results()); err != nil {
+		t.Fatalf("WriteJUnit failed: %s", err)
+	}
+
+	var decoded struct {
+		Suites []struct {
+			Name     string `xml:"name,attr"`
+			Tests    int    `xml:"tests,attr"`
+			Failures int    `xml:"failures,attr"`
+			Errors   int    `xml:"errors,attr"`
+			Cases    []struct {
+				Name    string `xml:"name,attr"`
+				Failure *struct {
+					Type string `xml:"type,attr"`
+					Text string `xml:",chardata"`
+				} `xml:"failure"`
+				Output string `xml:"system-out"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid XML: %s\n%s", err, out.String())
+	}
+
+	if len(decoded.Suites) != 2 {
+		t.Fatalf("wrong number of suites. got=%d", len(decoded.Suites))
+	}
+	suite := decoded.Suites[0]
+	if suite.Tests != 2 || suite.Failures != 1 || suite.Cases[0].Failure != nil || suite.Cases[1].Failure == nil {
+		t.Fatalf("wrong suite. got=%+v", suite)
+	}
+	failure := suite.Cases[1].Failure
+	if failure.Type != "AssertionError" || failure.Text != "testdata/math_test.mk:10: AssertionError: sum: expected 4, got 3" ||
+		suite.Cases[1].Output != "adding\n" {
+		t.Errorf("wrong failure. got=%+v, output=%q", failure, suite.Cases[1].Output)
+	}
+	if broken := decoded.Suites[1]; broken.Errors != 1 || broken.Tests != 1 {
+		t.Errorf("wrong suite for the broken file. got=%+v", broken)
+	}
+}
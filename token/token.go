@@ -12,6 +12,10 @@ type Token struct {
 	Type TokenType
 	// the literal value of the type e.g. 5
 	Literal string
+	// the line of the source the token starts on and the column (in bytes) within it, both
+	// counting from 1
+	Line   int
+	Column int
 }
 
 // ================ specify the different token types ================
@@ -41,6 +45,8 @@ const (
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	ARROW = "->" // of the return type of a function
+
 	// delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -62,6 +68,10 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
 )
 
 var keywords = map[string]TokenType{
@@ -72,6 +82,11 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+
+	"throw":   THROW,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
 }
 
 // LookupIdent matches the specified identifier to it's character representation
@@ -0,0 +1,576 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/resolver"
+	"github.com/andy9775/monkey/token"
+)
+
+/*
+	Check infers the type of every expression and reports the operations which can't succeed
+	on values of the types they're given, the way the engines would at runtime:
+
+	- the operands of + (ints or strings), - * / (ints), < > <= >= (ints or strings, both the
+	  same) and prefix - (an int)
+	- calls of values which aren't functions, with the wrong number of arguments or with
+	  arguments of the wrong types
+	- indexing (arrays by ints, hashes by their keys, not strings), slicing (arrays and strings)
+	  and index assignment (of arrays, by ints, to their elements)
+	- values which aren't of the types they're annotated with: let values, arguments and the
+	  results of functions
+
+	Parameters without an annotation are of the type any, as is anything derived from them, the
+	builtins, caught errors and names defined more than once; results without one are inferred.
+	The elements of arrays and hashes are all of one type, which is any when they differ: [1, 2]
+	is an [int] and putting a string into it is an error, while [1, "a"] is an [any].
+*/
+
+// Error is a type error
+type Error struct {
+	Token   token.Token // where the error is
+	Message string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Token.Line, e.Token.Column, e.Message)
+}
+
+// fnContext is a function being checked
+type fnContext struct {
+	lit      *ast.FunctionLiteral
+	result   typ
+	inferred bool // whether the result is inferred from the returns, rather than annotated
+	returns  []typ
+}
+
+type checker struct {
+	unifier
+	errors []Error
+
+	bindings  map[*ast.Identifier]*resolver.Binding
+	redefined map[*resolver.Binding]bool
+	schemes   map[*resolver.Binding]*scheme
+	fn        *fnContext
+}
+
+// Check type checks program, which must have no parse errors, and returns the type errors in
+// source order
+func Check(program *ast.Program) []Error {
+	resolution := resolver.Resolve(program)
+	c := &checker{
+		bindings:  map[*ast.Identifier]*resolver.Binding{},
+		redefined: map[*resolver.Binding]bool{},
+		schemes:   map[*resolver.Binding]*scheme{},
+	}
+	for _, occurrence := range resolution.Occurrences {
+		c.bindings[occurrence.Ident] = occurrence.Binding
+	}
+	for _, b := range resolution.Bindings {
+		definitions := 0
+		for _, ref := range b.Refs {
+			if resolution.Definitions[ref] {
+				definitions++
+			}
+		}
+		c.redefined[b] = definitions > 1
+	}
+
+	for _, s := range program.Statements {
+		c.statement(s)
+	}
+
+	sort.SliceStable(c.errors, func(i, j int) bool { return resolver.Before(c.errors[i].Token, c.errors[j].Token) })
+	return c.errors
+}
+
+func (c *checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, Error{Token: tok, Message: fmt.Sprintf(format, a...)})
+}
+
+// annotation returns the type of an annotation, reporting the unknown type names in it
+func (c *checker) annotation(a ast.Type) typ {
+	return fromAnnotation(a, func(name *ast.NamedType) {
+		c.errorf(name.Token, "unknown type %s", name.Name)
+	})
+}
+
+// assign checks that a value of the type actual can be used as one of the type expected
+func (c *checker) assign(tok token.Token, expected, actual typ, context string) {
+	if !c.unify(expected, actual) {
+		c.errorf(tok, "cannot use %s as %s in %s", actual, expected, context)
+	}
+}
+
+// define sets the type of the binding ident defines
+func (c *checker) define(ident *ast.Identifier, s *scheme) {
+	b := c.bindings[ident]
+	if b == nil {
+		return
+	}
+	if c.redefined[b] {
+		s = &scheme{t: anyType}
+	}
+	if _, ok := c.schemes[b]; !ok {
+		c.schemes[b] = s
+	}
+}
+
+// ---------------- statements ----------------
+
+// statement checks s and returns the type of its value, nil if it doesn't complete (it returns
+// or throws)
+func (c *checker) statement(s ast.Statement) typ {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		c.let(s)
+		return nullType
+	case *ast.ReturnStatement:
+		t := c.expression(s.ReturnValue)
+		if c.fn != nil {
+			if c.fn.inferred {
+				c.fn.returns = append(c.fn.returns, t)
+			} else {
+				c.assign(s.Token, c.fn.result, t, "the result of "+c.name(c.fn.lit))
+			}
+		}
+		return nil
+	case *ast.ThrowStatement:
+		c.expression(s.Value)
+		return nil
+	case *ast.ExpressionStatement:
+		return c.expression(s.Expression)
+	case *ast.BlockStatement:
+		return c.block(s)
+	}
+	return nullType
+}
+
+// block checks b and returns the type of its value: that of the last statement, nil if it
+// doesn't complete
+func (c *checker) block(b *ast.BlockStatement) typ {
+	if b == nil {
+		return nullType
+	}
+	var t typ = nullType
+	for _, s := range b.Statements {
+		t = c.statement(s)
+		if t == nil {
+			return nil
+		}
+	}
+	return t
+}
+
+func (c *checker) let(s *ast.LetStatement) {
+	if s.Name == nil {
+		return
+	}
+
+	var annotated typ
+	if s.Type != nil {
+		annotated = c.annotation(s.Type)
+	}
+
+	lit, isFunction := s.Value.(*ast.FunctionLiteral)
+	if !isFunction {
+		t := c.expression(s.Value)
+		if annotated != nil {
+			c.assign(s.Token, annotated, t, "let "+s.Name.Value)
+			t = annotated
+		}
+		c.define(s.Name, &scheme{t: t})
+		return
+	}
+
+	// the function can call itself, by a type which is generalized once its body is checked
+	c.level++
+	self := c.fresh()
+	c.define(s.Name, &scheme{t: self})
+	t := c.function(lit, self)
+	if annotated != nil {
+		c.assign(s.Token, annotated, t, "let "+s.Name.Value)
+	}
+	c.level--
+
+	if b := c.bindings[s.Name]; b != nil && !c.redefined[b] {
+		if annotated != nil {
+			c.schemes[b] = &scheme{t: annotated}
+		} else {
+			c.schemes[b] = c.generalize(t)
+		}
+	}
+}
+
+// ---------------- expressions ----------------
+
+// expression checks e and returns its type
+func (c *checker) expression(e ast.Expression) typ {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return intType
+	case *ast.StringLiteral:
+		return stringType
+	case *ast.Boolean:
+		return boolType
+	case *ast.Identifier:
+		b := c.bindings[e]
+		if b == nil || c.schemes[b] == nil {
+			return anyType // builtins, and undefined names, which the compiler reports
+		}
+		return c.instantiate(c.schemes[b])
+	case *ast.PrefixExpression:
+		t := c.expression(e.Right)
+		if e.Operator == "-" {
+			if !c.unify(intType, t) {
+				c.errorf(e.Token, "unsupported type for negation: %s", t)
+			}
+			return intType
+		}
+		return boolType
+	case *ast.InfixExpression:
+		return c.infix(e)
+	case *ast.IfExpression:
+		c.expression(e.Condition)
+		if t := c.joinBranches(c.block(e.Consequence), c.block(e.Alternative)); t != nil {
+			return t
+		}
+		return c.fresh() // neither branch completes, the value can be of any type
+
+	case *ast.TryExpression:
+		t := c.block(e.Block)
+		if e.CatchName != nil {
+			c.define(e.CatchName, &scheme{t: anyType})
+		}
+		if e.Catch != nil {
+			t = c.joinBranches(t, c.block(e.Catch))
+		}
+		c.block(e.Finally)
+		if t == nil {
+			return c.fresh()
+		}
+		return t
+	case *ast.FunctionLiteral:
+		return c.function(e, nil)
+	case *ast.CallExpression:
+		return c.call(e)
+	case *ast.ArrayLiteral:
+		var element typ
+		for _, el := range e.Elements {
+			element = c.joinElements(element, c.expression(el))
+		}
+		if element == nil {
+			element = c.fresh()
+		}
+		return &array{element}
+	case *ast.HashLiteral:
+		var key, value typ
+		for _, k := range e.Keys {
+			kt := c.expression(k)
+			switch prune(kt).(type) {
+			case *hash, *function:
+				c.errorf(expressionToken(k), "unusable as hash key: %s", kt)
+			}
+			key = c.joinElements(key, kt)
+			value = c.joinElements(value, c.expression(e.Pairs[k]))
+		}
+		if key == nil {
+			key, value = c.fresh(), c.fresh()
+		}
+		return &hash{key, value}
+	case *ast.IndexExpression:
+		return c.index(e)
+	case *ast.SliceExpression:
+		return c.slice(e)
+	case *ast.AssignExpression:
+		return c.assignIndex(e)
+	}
+	return anyType
+}
+
+// joinBranches joins the types of two branches, either of which may not complete (nil)
+func (c *checker) joinBranches(a, b typ) typ {
+	switch {
+	case a == nil && b == nil:
+		return nil
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return c.join(a, b)
+}
+
+// joinElements joins the type of an element to that of the elements before it (nil if there
+// are none)
+func (c *checker) joinElements(elements, t typ) typ {
+	if elements == nil {
+		return t
+	}
+	return c.join(elements, t)
+}
+
+// name returns how errors refer to a function
+func (c *checker) name(lit *ast.FunctionLiteral) string {
+	if lit.Name != "" {
+		return lit.Name
+	}
+	return "the function"
+}
+
+// function checks a function literal and returns its type. self is the type the function
+// refers to itself by, if it's bound by a let.
+func (c *checker) function(lit *ast.FunctionLiteral, self typ) typ {
+	t := &function{}
+	for i, param := range lit.Parameters {
+		var pt typ = anyType
+		if i < len(lit.ParameterTypes) && lit.ParameterTypes[i] != nil {
+			pt = c.annotation(lit.ParameterTypes[i])
+		}
+		t.params = append(t.params, pt)
+		c.define(param, &scheme{t: pt})
+	}
+
+	fn := &fnContext{lit: lit, inferred: lit.ReturnType == nil}
+	if fn.inferred {
+		fn.result = c.fresh()
+	} else {
+		fn.result = c.annotation(lit.ReturnType)
+	}
+	t.result = fn.result
+	if self != nil {
+		c.unify(self, t)
+	}
+
+	outer := c.fn
+	c.fn = fn
+	body := c.block(lit.Body)
+	c.fn = outer
+
+	if !fn.inferred {
+		if body != nil {
+			c.assign(lit.Token, fn.result, body, "the result of "+c.name(lit))
+		}
+		return t
+	}
+
+	// the result is whatever the body and the returns are, any when they differ
+	if body != nil {
+		fn.returns = append(fn.returns, body)
+	}
+	var result typ
+	for _, r := range fn.returns {
+		result = c.joinElements(result, r)
+	}
+	if v, ok := prune(fn.result).(*variable); ok && result != nil && prune(result) == anyType {
+		v.bound = anyType
+	} else if result != nil {
+		c.unify(fn.result, result)
+	}
+	return t
+}
+
+func (c *checker) call(e *ast.CallExpression) typ {
+	ft := c.expression(e.Function)
+	args := []typ{}
+	for _, a := range e.Arguments {
+		args = append(args, c.expression(a))
+	}
+
+	switch f := prune(ft).(type) {
+	case *function:
+		if len(f.params) != len(args) {
+			c.errorf(e.Token, "wrong number of arguments: want=%d, got=%d", len(f.params), len(args))
+			return f.result
+		}
+		for i, arg := range args {
+			c.assign(expressionToken(e.Arguments[i]), f.params[i], arg,
+				fmt.Sprintf("argument %d of %s", i+1, e.Function))
+		}
+		return f.result
+	case *variable:
+		// a function not known yet, such as a function calling itself
+		result := c.fresh()
+		c.unify(f, &function{params: args, result: result})
+		return result
+	case dynamic:
+		return anyType
+	default:
+		c.errorf(e.Token, "cannot call %s, a value of the type %s", e.Function, ft)
+		return anyType
+	}
+}
+
+// infix checks an infix expression. Both operands of an arithmetic operator or a comparison
+// other than == and != must be of the same type, one the operator supports.
+func (c *checker) infix(e *ast.InfixExpression) typ {
+	left, right := c.expression(e.Left), c.expression(e.Right)
+
+	var supported []typ
+	result := typ(boolType)
+	switch e.Operator {
+	case "==", "!=":
+		return boolType
+	case "+":
+		supported, result = []typ{intType, stringType}, nil
+	case "-", "*", "/":
+		supported, result = []typ{intType}, nil
+	case "<", ">", "<=", ">=":
+		supported = []typ{intType, stringType}
+	default:
+		return anyType
+	}
+
+	if !c.unify(left, right) {
+		c.errorf(e.Token, "unsupported types for binary operation: %s %s %s", left, e.Operator, right)
+		return c.or(result, anyType)
+	}
+	t := prune(left)
+	if t == anyType {
+		t = prune(right)
+	}
+
+	switch t := t.(type) {
+	case dynamic:
+		return c.or(result, anyType)
+	case *variable:
+		if len(supported) == 1 {
+			c.unify(t, supported[0])
+		}
+		return c.or(result, t)
+	}
+	for _, s := range supported {
+		if t == s {
+			return c.or(result, t)
+		}
+	}
+	c.errorf(e.Token, "unsupported types for binary operation: %s %s %s", left, e.Operator, right)
+	return c.or(result, anyType)
+}
+
+// or returns t, or otherwise if t is nil
+func (c *checker) or(t, otherwise typ) typ {
+	if t == nil {
+		return otherwise
+	}
+	return t
+}
+
+func (c *checker) index(e *ast.IndexExpression) typ {
+	left, index := c.expression(e.Left), c.expression(e.Index)
+
+	switch l := prune(left).(type) {
+	case *array:
+		if !c.unify(intType, index) {
+			c.errorf(e.Token, "array index must be int, got %s", index)
+		}
+		return l.element
+	case *hash:
+		if !c.unify(l.key, index) {
+			c.errorf(e.Token, "cannot use %s as %s in the key of a hash", index, l.key)
+		}
+		return l.value
+	case dynamic, *variable:
+		return anyType
+	}
+	if prune(left) == stringType {
+		if !c.unify(intType, index) {
+			c.errorf(e.Token, "string index must be int, got %s", index)
+		}
+		return stringType
+	}
+	if prune(left) == errorType {
+		if !c.unify(stringType, index) {
+			c.errorf(e.Token, "error fields are named by strings, got %s", index)
+		}
+		return anyType
+	}
+	c.errorf(e.Token, "index operator not supported: %s", left)
+	return anyType
+}
+
+func (c *checker) slice(e *ast.SliceExpression) typ {
+	left := c.expression(e.Left)
+	for _, bound := range []ast.Expression{e.Start, e.End} {
+		if bound == nil {
+			continue
+		}
+		if t := c.expression(bound); !c.unify(intType, t) && prune(t) != nullType {
+			c.errorf(e.Token, "slice bounds must be int, got %s", t)
+		}
+	}
+
+	switch l := prune(left).(type) {
+	case *array:
+		return l
+	case dynamic, *variable:
+		return anyType
+	}
+	if prune(left) == stringType {
+		return stringType
+	}
+	c.errorf(e.Token, "slice operator not supported: %s", left)
+	return anyType
+}
+
+func (c *checker) assignIndex(e *ast.AssignExpression) typ {
+	value := c.expression(e.Value)
+	if e.Target == nil {
+		return value
+	}
+	left, index := c.expression(e.Target.Left), c.expression(e.Target.Index)
+
+	switch l := prune(left).(type) {
+	case *array:
+		if !c.unify(intType, index) {
+			c.errorf(e.Target.Token, "array index must be int, got %s", index)
+		}
+		c.assign(e.Token, l.element, value, "an assignment to an element of "+l.String())
+	case dynamic, *variable:
+	default:
+		c.errorf(e.Token, "index assignment not supported: %s", left)
+	}
+	return value
+}
+
+// expressionToken returns the first token of e
+func expressionToken(e ast.Expression) token.Token {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		return e.Token
+	case *ast.IntegerLiteral:
+		return e.Token
+	case *ast.StringLiteral:
+		return e.Token
+	case *ast.Boolean:
+		return e.Token
+	case *ast.PrefixExpression:
+		return e.Token
+	case *ast.InfixExpression:
+		return expressionToken(e.Left)
+	case *ast.IfExpression:
+		return e.Token
+	case *ast.TryExpression:
+		return e.Token
+	case *ast.FunctionLiteral:
+		return e.Token
+	case *ast.CallExpression:
+		return expressionToken(e.Function)
+	case *ast.ArrayLiteral:
+		return e.Token
+	case *ast.HashLiteral:
+		return e.Token
+	case *ast.IndexExpression:
+		return expressionToken(e.Left)
+	case *ast.SliceExpression:
+		return expressionToken(e.Left)
+	case *ast.AssignExpression:
+		if e.Target != nil {
+			return expressionToken(e.Target)
+		}
+		return e.Token
+	}
+	return token.Token{}
+}
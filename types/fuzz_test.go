@@ -0,0 +1,39 @@
+package types_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/internal/corpus"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
+	"github.com/andy9775/monkey/types"
+)
+
+// FuzzCheck checks that checking and instrumenting any program finishes, and that instrumenting
+// leaves programs without annotations as they are
+func FuzzCheck(f *testing.F) {
+	f.Add("let add = fn(a: int, b: [string]) -> {int: fn(int) -> bool} { a + b }; add(1, 2)[1:] = -true")
+	f.Add("let f = fn(n) { if (n) { f(n)(1) } else { [f, {}] } }; let x: fn() = f; try { x() } catch (e) { e[1] }")
+	corpus.AddSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+		types.Check(program)
+
+		before := program.String()
+		if err := types.Instrument(program); err != nil {
+			if program.String() != before {
+				t.Fatalf("a program which wasn't instrumented changed.\nbefore=%s\nafter= %s", before, program.String())
+			}
+			return
+		}
+		if program.String() != before && !strings.Contains(input, ":") && !strings.Contains(input, "->") {
+			t.Fatalf("a program without annotations was instrumented.\nbefore=%s\nafter= %s", before, program.String())
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package types
+
+import (
+	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/token"
+)
+
+/*
+	Instrument adds runtime checks to the boundaries of typed functions, for the values the
+	checker can't know the types of (those of the type any): the body of a function with
+	annotated parameters starts by checking them, and the values it returns (by return or as
+	the value of its body) are checked against its annotated result.
+
+	A check is a call of the builtin check_type, which returns the value it's given unless it's
+	of the wrong type. Checks are shallow, see object/types.go, and a return which is checked
+	is no longer a tail call. Programs which bind the name check_type themselves (by a let, a
+	parameter or a catch) would change what the checks call, so they aren't instrumented.
+*/
+
+// checkName is the name of the builtin the checks call
+const checkName = "check_type"
+
+// Instrument adds the runtime checks of the annotations of the functions of program to it. It
+// returns an error, leaving program as it is, if program binds check_type.
+func Instrument(program *ast.Program) error {
+	if name := bindingOf(program, checkName); name != nil {
+		return Error{Token: name.Token, Message: "can't instrument a program which defines " + checkName}
+	}
+
+	ast.Inspect(program, func(node ast.Node) bool {
+		if lit, ok := node.(*ast.FunctionLiteral); ok && lit.Body != nil {
+			instrumentFunction(lit)
+		}
+		return true
+	})
+	return nil
+}
+
+// bindingOf returns the first identifier which binds name in program, nil if there's none
+func bindingOf(program *ast.Program, name string) *ast.Identifier {
+	var binding *ast.Identifier
+	ast.Inspect(program, func(node ast.Node) bool {
+		names := []*ast.Identifier{}
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			names = append(names, node.Name)
+		case *ast.FunctionLiteral:
+			names = append(names, node.Parameters...)
+		case *ast.TryExpression:
+			names = append(names, node.CatchName)
+		}
+		for _, id := range names {
+			if id != nil && id.Value == name && binding == nil {
+				binding = id
+			}
+		}
+		return binding == nil
+	})
+	return binding
+}
+
+func instrumentFunction(lit *ast.FunctionLiteral) {
+	name := "the function"
+	if lit.Name != "" {
+		name = lit.Name
+	}
+
+	if lit.ReturnType != nil {
+		what := "the result of " + name
+		ast.Inspect(lit.Body, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.FunctionLiteral:
+				return false // the returns of nested functions are theirs
+			case *ast.ReturnStatement:
+				if node.ReturnValue != nil {
+					node.ReturnValue = checkCall(node.Token, node.ReturnValue, lit.ReturnType, what)
+				}
+			}
+			return true
+		})
+		if n := len(lit.Body.Statements); n > 0 {
+			if s, ok := lit.Body.Statements[n-1].(*ast.ExpressionStatement); ok && s.Expression != nil {
+				s.Expression = checkCall(s.Token, s.Expression, lit.ReturnType, what)
+			}
+		}
+	}
+
+	checks := []ast.Statement{}
+	for i, param := range lit.Parameters {
+		if i >= len(lit.ParameterTypes) || lit.ParameterTypes[i] == nil {
+			continue
+		}
+		checks = append(checks, &ast.ExpressionStatement{
+			Token:      param.Token,
+			Expression: checkCall(param.Token, param, lit.ParameterTypes[i], "argument "+param.Value+" of "+name),
+		})
+	}
+	if len(checks) > 0 {
+		lit.Body.Statements = append(checks, lit.Body.Statements...)
+	}
+}
+
+// checkCall returns the call check_type(value, "t", "what"), its tokens at the position of tok
+func checkCall(tok token.Token, value ast.Expression, t ast.Type, what string) *ast.CallExpression {
+	at := func(typ token.TokenType, literal string) token.Token {
+		return token.Token{Type: typ, Literal: literal, Line: tok.Line, Column: tok.Column}
+	}
+	return &ast.CallExpression{
+		Token:    at(token.LPAREN, "("),
+		Function: &ast.Identifier{Token: at(token.IDENT, checkName), Value: checkName},
+		Arguments: []ast.Expression{
+			value,
+			&ast.StringLiteral{Token: at(token.STRING, t.String()), Value: t.String()},
+			&ast.StringLiteral{Token: at(token.STRING, what), Value: what},
+		},
+	}
+}
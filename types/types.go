@@ -0,0 +1,312 @@
+// Package types checks the type annotations of programs ahead of time. Annotations are optional
+// and code without them is dynamic: the checker infers the types it can, Hindley-Milner style,
+// and leaves the values it can't know the types of alone.
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andy9775/monkey/ast"
+)
+
+/*
+	The types are the basic ones (int, string, ...), arrays, hashes and functions, any (the type
+	of dynamic values) and variables, which stand for a type not known yet. Unifying two types
+	binds the variables in them so that they're the same type, where any is the same as any
+	other type: a dynamic value may be anything. Functions bound by let are polymorphic, the
+	variables they're left with after their bodies are checked are generalized (by level, the
+	depth of lets a variable was made in) and replaced by new ones wherever the function is used.
+*/
+
+type typ interface {
+	String() string
+}
+
+// basic is a type named by a name: int, float, string, bool, null or error
+type basic struct {
+	name string
+}
+
+func (b *basic) String() string { return b.name }
+
+var (
+	intType    = &basic{"int"}
+	floatType  = &basic{"float"}
+	stringType = &basic{"string"}
+	boolType   = &basic{"bool"}
+	nullType   = &basic{"null"}
+	errorType  = &basic{"error"}
+)
+
+var basics = map[string]*basic{
+	"int": intType, "float": floatType, "string": stringType, "bool": boolType, "null": nullType, "error": errorType,
+}
+
+// dynamic is the type of the values the checker knows nothing about: any
+type dynamic struct{}
+
+func (dynamic) String() string { return "any" }
+
+var anyType = dynamic{}
+
+type array struct {
+	element typ
+}
+
+func (a *array) String() string { return "[" + a.element.String() + "]" }
+
+type hash struct {
+	key, value typ
+}
+
+func (h *hash) String() string { return "{" + h.key.String() + ": " + h.value.String() + "}" }
+
+type function struct {
+	params []typ
+	result typ
+}
+
+func (f *function) String() string {
+	params := []string{}
+	for _, p := range f.params {
+		params = append(params, p.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + ") -> " + f.result.String()
+}
+
+// variable is a type not known yet, or the one it's bound to
+type variable struct {
+	id    int
+	level int
+	bound typ
+}
+
+func (v *variable) String() string {
+	if v.bound != nil {
+		return v.bound.String()
+	}
+	return fmt.Sprintf("t%d", v.id)
+}
+
+// prune returns the type t is bound to
+func prune(t typ) typ {
+	for {
+		v, ok := t.(*variable)
+		if !ok || v.bound == nil {
+			return t
+		}
+		t = v.bound
+	}
+}
+
+// fromAnnotation returns the type annotation a names, and the names of unknown types in it
+func fromAnnotation(a ast.Type, unknown func(*ast.NamedType)) typ {
+	switch a := a.(type) {
+	case *ast.NamedType:
+		if a.Name == "any" {
+			return anyType
+		}
+		if b, ok := basics[a.Name]; ok {
+			return b
+		}
+		unknown(a)
+		return anyType
+	case *ast.ArrayType:
+		return &array{fromAnnotation(a.Element, unknown)}
+	case *ast.HashType:
+		return &hash{fromAnnotation(a.Key, unknown), fromAnnotation(a.Value, unknown)}
+	case *ast.FunctionType:
+		f := &function{result: anyType}
+		for _, p := range a.Parameters {
+			f.params = append(f.params, fromAnnotation(p, unknown))
+		}
+		if a.Return != nil {
+			f.result = fromAnnotation(a.Return, unknown)
+		}
+		return f
+	}
+	return anyType
+}
+
+// ---------------- unification ----------------
+
+// binding is the state of a variable before unify changed it, for undoing failed unifications
+type binding struct {
+	v     *variable
+	level int
+	bound typ
+}
+
+type unifier struct {
+	vars  int
+	level int
+	trail []binding
+}
+
+func (u *unifier) fresh() *variable {
+	u.vars++
+	return &variable{id: u.vars, level: u.level}
+}
+
+// unify makes a and b the same type, and reports whether they can be. Nothing is changed when
+// they can't.
+func (u *unifier) unify(a, b typ) bool {
+	mark := len(u.trail)
+	if u.unifyTypes(a, b) {
+		u.trail = u.trail[:mark]
+		return true
+	}
+	for i := len(u.trail) - 1; i >= mark; i-- {
+		u.trail[i].v.level, u.trail[i].v.bound = u.trail[i].level, u.trail[i].bound
+	}
+	u.trail = u.trail[:mark]
+	return false
+}
+
+func (u *unifier) unifyTypes(a, b typ) bool {
+	a, b = prune(a), prune(b)
+	if a == b || a == anyType || b == anyType {
+		return true // without binding variables to any, which would lose what's known about them
+	}
+	if v, ok := a.(*variable); ok {
+		return u.bind(v, b)
+	}
+	if v, ok := b.(*variable); ok {
+		return u.bind(v, a)
+	}
+
+	switch a := a.(type) {
+	case *array:
+		b, ok := b.(*array)
+		return ok && u.unifyTypes(a.element, b.element)
+	case *hash:
+		b, ok := b.(*hash)
+		return ok && u.unifyTypes(a.key, b.key) && u.unifyTypes(a.value, b.value)
+	case *function:
+		b, ok := b.(*function)
+		if !ok || len(a.params) != len(b.params) {
+			return false
+		}
+		for i := range a.params {
+			if !u.unifyTypes(a.params[i], b.params[i]) {
+				return false
+			}
+		}
+		return u.unifyTypes(a.result, b.result)
+	}
+	return false
+}
+
+// bind binds v to t, unless t contains v. The variables of t move to the level of v if it's
+// lower, as they can no longer be generalized before v is.
+func (u *unifier) bind(v *variable, t typ) bool {
+	if !u.adjust(v, t) {
+		return false
+	}
+	u.trail = append(u.trail, binding{v, v.level, nil})
+	v.bound = t
+	return true
+}
+
+func (u *unifier) adjust(v *variable, t typ) bool {
+	switch t := prune(t).(type) {
+	case *variable:
+		if t == v {
+			return false
+		}
+		if t.level > v.level {
+			u.trail = append(u.trail, binding{t, t.level, nil})
+			t.level = v.level
+		}
+	case *array:
+		return u.adjust(v, t.element)
+	case *hash:
+		return u.adjust(v, t.key) && u.adjust(v, t.value)
+	case *function:
+		for _, p := range t.params {
+			if !u.adjust(v, p) {
+				return false
+			}
+		}
+		return u.adjust(v, t.result)
+	}
+	return true
+}
+
+// join returns the type of values which are either of the type a or b: the type both are when
+// they unify, any otherwise
+func (u *unifier) join(a, b typ) typ {
+	if prune(a) == anyType || prune(b) == anyType || !u.unify(a, b) {
+		return anyType
+	}
+	return prune(a)
+}
+
+// ---------------- polymorphism ----------------
+
+// scheme is the type of a binding, generic in the variables of generic
+type scheme struct {
+	t       typ
+	generic map[*variable]bool
+}
+
+// generalize returns the scheme of t, generic in the variables of levels above the current one
+func (u *unifier) generalize(t typ) *scheme {
+	s := &scheme{t: t, generic: map[*variable]bool{}}
+	var walk func(typ)
+	walk = func(t typ) {
+		switch t := prune(t).(type) {
+		case *variable:
+			if t.level > u.level {
+				s.generic[t] = true
+			}
+		case *array:
+			walk(t.element)
+		case *hash:
+			walk(t.key)
+			walk(t.value)
+		case *function:
+			for _, p := range t.params {
+				walk(p)
+			}
+			walk(t.result)
+		}
+	}
+	walk(t)
+	return s
+}
+
+// instantiate returns the type of s with new variables for its generic ones
+func (u *unifier) instantiate(s *scheme) typ {
+	if len(s.generic) == 0 {
+		return s.t
+	}
+	fresh := map[*variable]*variable{}
+	var copyType func(typ) typ
+	copyType = func(t typ) typ {
+		switch t := prune(t).(type) {
+		case *variable:
+			if !s.generic[t] {
+				return t
+			}
+			if fresh[t] == nil {
+				fresh[t] = u.fresh()
+			}
+			return fresh[t]
+		case *array:
+			return &array{copyType(t.element)}
+		case *hash:
+			return &hash{copyType(t.key), copyType(t.value)}
+		case *function:
+			f := &function{result: copyType(t.result)}
+			for _, p := range t.params {
+				f.params = append(f.params, copyType(p))
+			}
+			return f
+		default:
+			return t
+		}
+	}
+	return copyType(s.t)
+}
@@ -0,0 +1,190 @@
+package types_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andy9775/monkey/conformance"
+	"github.com/andy9775/monkey/internal/corpus"
+	"github.com/andy9775/monkey/types"
+)
+
+func check(t *testing.T, input string) []string {
+	t.Helper()
+	program, err := conformance.Parse(input)
+	if err != nil {
+		t.Fatalf("%q: %s", input, err)
+	}
+	errors := []string{}
+	for _, err := range types.Check(program) {
+		errors = append(errors, err.Error())
+	}
+	return errors
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// annotations
+		{`let x: int = "five"; x`, []string{"1:1: cannot use string as int in let x"}},
+		{`let x: [int] = [1, 2]; let y: {string: [int]} = {"a": x}; y`, []string{}},
+		{`let x: [string] = [1, 2]; x`, []string{"1:1: cannot use [int] as [string] in let x"}},
+		{`let x: any = 1; x + "a"`, []string{}},
+		{`let x: number = 1; x`, []string{"1:8: unknown type number"}},
+		{`let f: fn(int) -> int = fn(a: string) { a }; f`, []string{
+			"1:1: cannot use fn(string) -> string as fn(int) -> int in let f",
+		}},
+
+		// calls
+		{`let add = fn(a: int, b: int) -> int { a + b }; add(1, "2")`, []string{
+			"1:55: cannot use string as int in argument 2 of add",
+		}},
+		{`let add = fn(a: int, b: int) -> int { a + b }; add(1)`, []string{
+			"1:51: wrong number of arguments: want=2, got=1",
+		}},
+		{`let add = fn(a: int, b: int) -> int { a + b }; add(1, 2) + "a"`, []string{
+			"1:58: unsupported types for binary operation: int + string",
+		}},
+		{`5(1)`, []string{"1:2: cannot call 5, a value of the type int"}},
+		{`let apply = fn(f: fn(int) -> int) { f(1) }; apply(fn(x) { x }); apply(fn(s: string) { s })`, []string{
+			"1:71: cannot use fn(string) -> string as fn(int) -> int in argument 1 of apply",
+		}},
+
+		// results
+		{`let f = fn() -> string { return 1; }; f`, []string{"1:26: cannot use int as string in the result of f"}},
+		{`let f = fn(n: int) -> string { if (n > 1) { "big" } else { n } }; f`, []string{}}, // either branch may run
+		{`let f = fn(n) -> int { if (n) { return 1; } throw "no" }; f(1)`, []string{}},
+		{`let f = fn() { 1 }; f() + "a"`, []string{"1:25: unsupported types for binary operation: int + string"}},
+
+		// operators
+		{`-"a"`, []string{"1:1: unsupported type for negation: string"}},
+		{`true + 1`, []string{"1:6: unsupported types for binary operation: bool + int"}},
+		{`true + true`, []string{"1:6: unsupported types for binary operation: bool + bool"}},
+		{`"a" - "b"`, []string{"1:5: unsupported types for binary operation: string - string"}},
+		{`"a" < 1`, []string{"1:5: unsupported types for binary operation: string < int"}},
+		{`"a" < "b"; "a" + "b"; 1 == "a"; !1`, []string{}},
+		{`let f = fn(x) { x }; f(true) * 2`, []string{}},
+
+		// indexing
+		{`[1, 2]["a"]`, []string{"1:7: array index must be int, got string"}},
+		{`"abc"[0] + "d"; "abc"[-1]`, []string{}},
+		{`"abc"["a"]`, []string{"1:6: string index must be int, got string"}},
+		{`true[0]`, []string{"1:5: index operator not supported: bool"}},
+		{`{"a": 1}[1]`, []string{"1:9: cannot use int as string in the key of a hash"}},
+		{`{[1]: 1, {}: 2}`, []string{"1:10: unusable as hash key: {t1: t2}"}},
+		{`"abc"[1:]; [1, 2][:1]; true[1:]`, []string{"1:28: slice operator not supported: bool"}},
+		{`let xs = [1]; xs[0] = "a"`, []string{"1:21: cannot use string as int in an assignment to an element of [int]"}},
+		{`let h = {}; h[1] = 2`, []string{"1:18: index assignment not supported: {t1: t2}"}},
+		{`try { throw 1 } catch (e) { e["message"] + 1 }`, []string{}},
+
+		// inference and polymorphism
+		{`let id = fn(x) { x }; id(1) + 1; id("a") + "b"`, []string{}},
+		{`let fact = fn(n) { if (n < 1) { 1 } else { n * fact(n - 1) } }; fact(5) + "a"`, []string{
+			"1:73: unsupported types for binary operation: int + string",
+		}},
+		{`let f = fn(n: int) -> int { f("a") }; f`, []string{"1:31: cannot use string as int in argument 1 of f"}},
+		{`let xs = []; xs[0] = 1; xs[1] = "a"`, []string{
+			"1:31: cannot use string as int in an assignment to an element of [int]",
+		}},
+		{`let pick = fn(c) { if (c) { 1 } else { "a" } }; pick(true) + 1`, []string{}},
+
+		// dynamic code
+		{`let f = fn(a, b) { a + b }; f(1, "a"); len(1) + 1`, []string{}},
+		{`let x = 1; let x = "a"; x + "b"`, []string{}},
+	}
+
+	for _, tt := range tests {
+		errors := check(t, tt.input)
+		if strings.Join(errors, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong errors for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+// The checker finds the errors of the conformance programs which fail on purpose, and nothing
+// in the others
+func TestCheckConformancePrograms(t *testing.T) {
+	failing := map[string]bool{
+		"arity": true, "assertions": true, "catch": true, "comparison_types": true, "frozen_arrays": true,
+		"negative_indexes": true, "not_a_function": true, "slices": true, "type_error": true, "type_error_strings": true,
+		"unhashable": true,
+	}
+	programs, err := corpus.Programs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, program := range programs {
+		errors := check(t, program.Source)
+		if failing[program.Name] != (len(errors) != 0) {
+			t.Errorf("wrong type errors for %s. got=%q", program.Name, errors)
+		}
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected conformance.Result
+	}{
+		{`let add = fn(a: int, b: int) -> int { a + b }; add(1, 2)`, "3"},
+		{`let add = fn(a: int, b: int) -> int { a + b }; let dyn = fn(x) { x }; add(1, dyn("2"))`, "uncaught TypeError"},
+		{`let f = fn(a: int, b: string) { b }; let dyn = fn(x) { x };
+		  try { f(dyn("1"), "b") } catch (e) { e["message"] }`, "argument a of f must be int, got STRING"},
+		{`let dyn = fn(x) { x };
+		  let f = fn(n: int) -> string { if (n > 10) { return dyn(n); } "small" };
+		  let g = fn(n: int) -> string { dyn(n) };
+		  [f(1), try { f(11) } catch (e) { e["message"] }, try { g(1) } catch (e) { e["message"] }]`,
+			"[small, the result of f must be string, got INTEGER, the result of g must be string, got INTEGER]"},
+		{`let f = fn(xs: [int], h: {string: int}, g: fn(int) -> int, x: any) -> [int] { xs }; f([1], {}, fn(x) { x }, 1)`, "[1]"},
+		{`let f = fn(g: fn(int)) { g }; let dyn = fn(x) { x }; try { f(dyn(1)) } catch (e) { e["message"] }`,
+			"argument g of f must be fn(int), got INTEGER"},
+		{`let fib = fn(n: int) -> int { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)`, "610"},
+		{`fn(x: string) { x }("a")`, "a"},
+		{`let f = fn(x) { x }; f(1)`, "1"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range conformance.Engines() {
+			program, err := conformance.Parse(tt.input)
+			if err != nil {
+				t.Fatalf("%q: %s", tt.input, err)
+			}
+			if err := types.Instrument(program); err != nil {
+				t.Fatalf("%q: %s", tt.input, err)
+			}
+			if result := engine.Run(program); result != tt.expected {
+				t.Errorf("wrong result of %q on the %s. want=%s, got=%s", tt.input, engine.Name, tt.expected, result)
+			}
+		}
+	}
+}
+
+// Programs binding check_type aren't instrumented, their own check_type would be called instead
+func TestInstrumentCheckTypeBound(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let check_type = fn(v, t, w) { v }; let f = fn(x: int) { x }; f("a")`, "1:5: can't instrument a program which defines check_type"},
+		{`let f = fn(x: int, check_type) { x }; f(1, 2)`, "1:20: can't instrument a program which defines check_type"},
+		{`let f = fn(x: int) { x };
+		  try { f(1) } catch (check_type) { 0 }`, "2:25: can't instrument a program which defines check_type"},
+	}
+
+	for _, tt := range tests {
+		program, err := conformance.Parse(tt.input)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.input, err)
+		}
+		before := program.String()
+		err = types.Instrument(program)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%s, got=%v", tt.input, tt.expected, err)
+		}
+		if program.String() != before {
+			t.Errorf("%q was changed", tt.input)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"github.com/andy9775/monkey/object"
+)
+
+/*
+	Coverage, for finding out which code a program ran (see `monkey test -cover` and the cover
+	package). While coverage is on the vm counts how often it runs each instruction, and for each
+	OpJumpNotTruthy how often the condition was truthy and how often it jumped. Coverage slows
+	the vm down so it's off unless EnableCoverage turns it on, and the register vm has none.
+*/
+
+// Outcomes counts the outcomes of a conditional jump: Truthy the times it went on to the next
+// instruction, Falsy the times it jumped
+type Outcomes struct {
+	Truthy, Falsy int
+}
+
+// Coverage is what a program ran, by function. The functions which never ran have no counts.
+type Coverage struct {
+	Main *object.CompiledFunction // the main program
+
+	// Counts are by offset the number of times the instruction there ran, Branches the outcomes
+	// of the conditional jumps by their offset
+	Counts   map[*object.CompiledFunction][]int
+	Branches map[*object.CompiledFunction]map[int]*Outcomes
+}
+
+// EnableCoverage makes the vm record what the program runs from then on, see Coverage
+func (vm *VM) EnableCoverage() {
+	vm.coverage = &Coverage{
+		Main:     vm.frames[0].cl.Fn,
+		Counts:   map[*object.CompiledFunction][]int{},
+		Branches: map[*object.CompiledFunction]map[int]*Outcomes{},
+	}
+}
+
+// Coverage returns what the program ran, nil unless EnableCoverage was called
+func (vm *VM) Coverage() *Coverage {
+	return vm.coverage
+}
+
+// counts returns the instruction counts of fn, which the run loop adds to
+func (c *Coverage) counts(fn *object.CompiledFunction) []int {
+	counts, ok := c.Counts[fn]
+	if !ok {
+		counts = make([]int, len(fn.Instructions))
+		c.Counts[fn] = counts
+	}
+	return counts
+}
+
+// branch records an outcome of the conditional jump at ip in fn
+func (c *Coverage) branch(fn *object.CompiledFunction, ip int, truthy bool) {
+	branches, ok := c.Branches[fn]
+	if !ok {
+		branches = map[int]*Outcomes{}
+		c.Branches[fn] = branches
+	}
+	outcomes, ok := branches[ip]
+	if !ok {
+		outcomes = &Outcomes{}
+		branches[ip] = outcomes
+	}
+	if truthy {
+		outcomes.Truthy++
+	} else {
+		outcomes.Falsy++
+	}
+}
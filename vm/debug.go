@@ -0,0 +1,84 @@
+package vm
+
+import (
+	"github.com/andy9775/monkey/object"
+)
+
+/*
+	Support for debuggers (see the debugger package). A hook set with SetHook is called before
+	each instruction the vm runs, and can look at the state of the program through Frames,
+	Globals and Stack. An error the hook returns stops the program: Run returns it as is, without
+	exception handlers getting to see it.
+*/
+
+// Hook is called before each instruction, see SetHook
+type Hook func() error
+
+// SetHook sets the hook called before each instruction, nil removes it
+func (vm *VM) SetHook(hook Hook) {
+	vm.hook = hook
+}
+
+// FrameInfo describes a frame of the program, for debuggers
+type FrameInfo struct {
+	Fn   *object.CompiledFunction
+	Free []object.Object
+
+	// IP is the position of the instruction the frame runs next, for the frames below the
+	// current one a position within the call they're making
+	IP int
+
+	// Locals are the values of the function's locals. While a hook is set, those not set yet are
+	// nil.
+	Locals []object.Object
+}
+
+// Frames returns the frames of the program, the current one last
+func (vm *VM) Frames() []FrameInfo {
+	frames := make([]FrameInfo, vm.framesIndex)
+	for i := 0; i < vm.framesIndex; i++ {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+
+		info := FrameInfo{Fn: fn, Free: frame.cl.Free, IP: frame.ip}
+		if i == vm.framesIndex-1 {
+			info.IP++ // the hook runs before ip moves on to the next instruction
+		}
+		info.Locals = make([]object.Object, fn.NumLocals)
+		copy(info.Locals, vm.stack[frame.basePointer:frame.basePointer+fn.NumLocals])
+
+		frames[i] = info
+	}
+	return frames
+}
+
+// Globals returns the globals of the program by index, nil for those not set
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// Stack returns the operands the current frame has on the stack, the top last
+func (vm *VM) Stack() []object.Object {
+	frame := vm.currentFrame()
+	bottom := frame.basePointer + frame.cl.Fn.NumLocals
+
+	stack := make([]object.Object, vm.sp-bottom)
+	copy(stack, vm.stack[bottom:vm.sp])
+	return stack
+}
+
+// Position returns the function of the current frame, the position of the instruction it runs
+// next and the number of frames. Unlike Frames it doesn't allocate, so hooks can call it for
+// every instruction.
+func (vm *VM) Position() (*object.CompiledFunction, int, int) {
+	frame := vm.currentFrame()
+	return frame.cl.Fn, frame.ip + 1, vm.framesIndex
+}
+
+// clearLocals clears what a previous call left in the locals of frame past its numArgs
+// parameters, so debuggers can tell the locals which are set
+func (vm *VM) clearLocals(frame *Frame, numArgs int) {
+	for i := frame.basePointer + numArgs; i < frame.basePointer+frame.cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+}
@@ -0,0 +1,34 @@
+package vm
+
+import (
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/object"
+)
+
+/*
+	Both vms run a program in an inner loop which returns on the first error, thrown by an
+	OpThrow or raised by the vm its self. The error is then handled like this: the handler table
+	of the current frame's function (see compiler/exceptions.go) is searched for a handler
+	covering the instruction being executed. Frames without one are popped, adding their
+	function to the error's trace, until a handler is found and execution continues at its
+	target with the error. When no frame handles the error, Run returns it.
+*/
+
+// toError converts an error raised by the vm into the error the program sees. The vm raises
+// *object.Errors for the errors a program can cause, anything else is a RuntimeError.
+func toError(err error) *object.Error {
+	if e, ok := err.(*object.Error); ok {
+		return e
+	}
+	return object.NewError(object.RuntimeErrorKind, "%s", err.Error())
+}
+
+// findHandler returns the first of handlers which covers the instruction at ip
+func findHandler(handlers []code.Handler, ip int) (code.Handler, bool) {
+	for _, h := range handlers {
+		if h.Start <= ip && ip < h.End {
+			return h, true
+		}
+	}
+	return code.Handler{}, false
+}
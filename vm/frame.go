@@ -22,3 +22,18 @@ func NewFrame(cl *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// reuseFrame sets up frames[index] for a call of cl. The Frame a previous call left in the slot
+// is reused rather than allocating a new one for every call.
+func reuseFrame(frames []*Frame, index int, cl *object.Closure, basePointer int) *Frame {
+	f := frames[index]
+	if f == nil {
+		f = &Frame{}
+		frames[index] = f
+	}
+
+	f.cl = cl
+	f.ip = -1
+	f.basePointer = basePointer
+	return f
+}
@@ -0,0 +1,48 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/internal/corpus"
+	"github.com/andy9775/monkey/lexer"
+	"github.com/andy9775/monkey/parser"
+)
+
+// FuzzRun checks that running any program on either vm ends in a result or an error. The
+// number of calls is limited, as programs can recurse forever.
+func FuzzRun(f *testing.F) {
+	// inputs of the vm tests
+	f.Add("let fibonacci = fn(x) { if (x == 0) { 0 } else { if (x == 1) { return 1; } else { fibonacci(x - 1) + fibonacci(x - 2) } } }; fibonacci(15);")
+	f.Add("let sum = fn(n, acc) { if (n == 0) { return acc; } sum(n - 1, acc + n) }; sum(10000, 0)")
+	f.Add("let newClosure = fn(a, b) { let c = a + b; fn(d) { let e = d + c; fn(f) { e + f; }; }; }; newClosure(9, 90)(8)(7)")
+	f.Add(`[1, 2, 3][1]; [[1, 1, 1]][0][0]; [][0]; {1: 1, 2: 2}[1]; {}[0]; len("four"); push([], 1); rest([1, 2, 3])`)
+	f.Add(`let f = fn() { throw error("boom", "MyError") }; try { f() } catch (e) { e["kind"] } finally { 0 }`)
+	f.Add("let f = fn() { f() + 1 }; f()")
+	f.Add("let f = fn() { f() }; f()")
+	f.Add(`1 / 0; [1, 2]["a"]; {[1]: 2}[fn() {}]; 1(2); fn(a) { a }()`)
+	corpus.AddSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		comp := compiler.New()
+		comp.EnableOptimizations()
+		if err := comp.Compile(program); err != nil {
+			return
+		}
+
+		for _, m := range machines(t, comp.Bytecode()) {
+			machine := m.new()
+			machine.LimitCalls(100000)
+
+			if err := machine.Run(); err == nil {
+				_ = machine.LastPoppedStackElem()
+			}
+		}
+	})
+}
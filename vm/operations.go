@@ -0,0 +1,315 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/object"
+)
+
+// The operations shared by the stack and the register vm. They work on values rather than the
+// stack or registers, and the opcodes name the operation (OpAdd, OpEqual, ...).
+
+func binaryOperation(op code.Opcode, left, right object.Object) (object.Object, error) {
+	leftType := left.Type()
+	rightType := right.Type()
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+		return binaryIntegerOperation(op, left, right)
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return binaryStringOperation(op, left, right)
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "unsupported types for binary operation: %s %s", leftType, rightType)
+	}
+}
+
+func binaryStringOperation(op code.Opcode, left, right object.Object) (object.Object, error) {
+	if op != code.OpAdd {
+		return nil, object.NewError(object.TypeErrorKind, "unknown string operator: %d", op)
+	}
+
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	return &object.String{Value: leftValue + rightValue}, nil
+}
+
+func binaryIntegerOperation(op code.Opcode, left, right object.Object) (object.Object, error) {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	var result int64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		if rightValue == 0 {
+			return nil, object.NewError(object.RuntimeErrorKind, "division by zero")
+		}
+		result = leftValue / rightValue
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "unknown integer operator: %d", op)
+	}
+
+	return object.NewInteger(result), nil
+}
+
+func comparison(op code.Opcode, left, right object.Object) (object.Object, error) {
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return integerComparison(op, left, right)
+	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return stringComparison(op, left, right)
+	}
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(right == left), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(right != left), nil
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "unknown operator: %d (%s %s)",
+			op, left.Type(), right.Type())
+	}
+}
+
+func integerComparison(op code.Opcode, left, right object.Object) (object.Object, error) {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(rightValue == leftValue), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(rightValue != leftValue), nil
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(leftValue > rightValue), nil
+	case code.OpLessThan:
+		return nativeBoolToBooleanObject(leftValue < rightValue), nil
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "unknown operator: %d", op)
+	}
+}
+
+// stringComparison compares strings by value, unlike other objects which are compared by
+// identity. Strings are ordered byte by byte.
+func stringComparison(op code.Opcode, left, right object.Object) (object.Object, error) {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(leftValue == rightValue), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(leftValue != rightValue), nil
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(leftValue > rightValue), nil
+	case code.OpLessThan:
+		return nativeBoolToBooleanObject(leftValue < rightValue), nil
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "unknown operator: %d (%s %s)",
+			op, left.Type(), right.Type())
+	}
+}
+
+// compare returns the result of a comparison as a native bool
+func compare(op code.Opcode, left, right object.Object) (bool, error) {
+	// fast path for the common integer case
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			if op == code.OpEqual {
+				return l.Value == r.Value, nil
+			}
+			return l.Value > r.Value, nil
+		}
+	}
+
+	result, err := comparison(op, left, right)
+	if err != nil {
+		return false, err
+	}
+	return isTruthy(result), nil
+}
+
+func minusOperator(operand object.Object) (object.Object, error) {
+	if operand.Type() != object.INTEGER_OBJ {
+		return nil, object.NewError(object.TypeErrorKind, "unsupported type for negation: %s", operand.Type())
+	}
+
+	value := operand.(*object.Integer).Value
+	return object.NewInteger(-value), nil
+}
+
+func bangOperator(operand object.Object) object.Object {
+	switch operand {
+	case True:
+		return False
+	case False:
+		return True
+	case Null: // !Null is True
+		return True
+	default:
+		return False
+	}
+}
+
+func indexExpression(left, index object.Object) (object.Object, error) {
+	switch {
+	case (left.Type() == object.ARRAY_OBJ || left.Type() == object.STRING_OBJ) && index.Type() == object.INTEGER_OBJ:
+		if element, ok := object.Index(left, index.(*object.Integer).Value); ok {
+			return element, nil
+		}
+		return Null, nil
+	case left.Type() == object.HASH_OBJ:
+		return hashIndex(left, index)
+	case left.Type() == object.ERROR_OBJ && index.Type() == object.STRING_OBJ:
+		if field := left.(*object.Error).Field(index.(*object.String).Value); field != nil {
+			return field, nil
+		}
+		return Null, nil
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "index operator not supported: %s", left.Type())
+	}
+}
+
+func slice(left, start, end object.Object) (object.Object, error) {
+	result, err := object.Slice(left, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// setIndex sets the element of an array, the only data structure which can be changed
+func setIndex(left, index, value object.Object) error {
+	array, ok := left.(*object.Array)
+	if !ok {
+		return object.NewError(object.TypeErrorKind, "index assignment not supported: %s", left.Type())
+	}
+	i, ok := index.(*object.Integer)
+	if !ok {
+		return object.NewError(object.TypeErrorKind, "array index must be INTEGER, got %s", index.Type())
+	}
+
+	if err := array.SetIndex(i.Value, value); err != nil {
+		return err
+	}
+	return nil
+}
+
+func hashIndex(hash, index object.Object) (object.Object, error) {
+	hashObject := hash.(*object.Hash)
+
+	if !object.IsHashable(index) {
+		return nil, object.NewError(object.TypeErrorKind, "unusable as hash key: %s", index.Type())
+	}
+
+	value, ok := hashObject.Get(index)
+	if !ok {
+		return Null, nil
+	}
+
+	return value, nil
+}
+
+// newHash builds a hash from alternating keys and values
+func newHash(elements []object.Object) (object.Object, error) {
+	hash := object.NewHash()
+
+	for i := 0; i < len(elements); i += 2 {
+		key := elements[i]
+		value := elements[i+1]
+
+		if !hash.Set(key, value) {
+			return nil, object.NewError(object.TypeErrorKind, "unusable as hash key: %s", key.Type())
+		}
+	}
+
+	return hash, nil
+}
+
+// newArray builds an array from a copy of elements
+func newArray(elements []object.Object) object.Object {
+	copied := make([]object.Object, len(elements))
+	copy(copied, elements)
+
+	return &object.Array{Elements: copied}
+}
+
+// newClosure wraps the compiled function constant and its free variables in a closure
+func newClosure(constant object.Object, free []object.Object) (object.Object, error) {
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return nil, fmt.Errorf("not a function: %+v", constant)
+	}
+
+	captured := make([]object.Object, len(free))
+	copy(captured, free)
+
+	return &object.Closure{Fn: function, Free: captured}, nil
+}
+
+// callLimit counts the calls of a program, stopping it once it makes more than max (0 doesn't
+// limit the calls). Monkey has no loops, so a program only runs for long by making calls.
+type callLimit struct {
+	calls, max int
+}
+
+// LimitCalls stops the program with a RuntimeError once it makes more than max calls, 0 removes
+// the limit
+func (l *callLimit) LimitCalls(max int) {
+	l.max = max
+}
+
+func (l *callLimit) count() error {
+	l.calls++
+	if l.max > 0 && l.calls > l.max {
+		return object.NewError(object.RuntimeErrorKind, "call limit of %d exceeded", l.max)
+	}
+	return nil
+}
+
+// programIO holds the streams of a program (see object.IO), the standard streams unless others
+// are set
+type programIO struct {
+	io *object.IO
+}
+
+// SetIO sets the streams of the program
+func (p *programIO) SetIO(io *object.IO) {
+	p.io = io
+}
+
+// IO returns the streams of the program
+func (p *programIO) IO() *object.IO {
+	if p.io == nil {
+		return object.StdIO()
+	}
+	return p.io
+}
+
+func checkArity(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return object.NewError(object.ArgumentErrorKind, "wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+	return nil
+}
+
+// callBuiltin calls builtin with args; builtins without a result return Null. An error the
+// builtin throws is returned as the error.
+func callBuiltin(rt object.Runtime, builtin *object.Builtin, args []object.Object) (object.Object, error) {
+	switch result := builtin.Fn(rt, args...).(type) {
+	case nil:
+		return Null, nil
+	case *object.Exception:
+		return nil, result.Err
+	default:
+		return result, nil
+	}
+}
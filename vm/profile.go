@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andy9775/monkey/object"
+)
+
+/*
+	Profiling, for finding out what a program spends its time on (see `monkey run -profile` and
+	the profile package). A profile is recorded in two ways at once.
+
+	Instrumenting: the vm times the calls of closures, from the call (callClosure) to the return
+	(OpReturnValue and OpReturn, or an exception unwinding the frame), and counts the
+	instructions it runs by opcode. A tail call ends the call it replaces. The time builtins take
+	is that of the functions calling them. The call counts are exact, but reading the clock twice
+	a call costs about as much as a small function does, so the times of programs making many
+	short calls are inflated (around twice for a recursive fib).
+
+	Sampling: every SamplePeriod the vm takes a sample by walking its frames, recording the
+	function and line each of them is at. It reads the clock every sampleCheck instructions to
+	find out whether a sample is due, and a sample taken late (after a long builtin, say) counts
+	for each of the periods which went by. This costs next to nothing, and the samples show the
+	lines time goes to, though only statistically: a function running for less than the period
+	may not be sampled at all.
+
+	Calls make a tree: a function called from two places has a node for each, so that profiles
+	keep the call stacks flame graphs show.
+*/
+
+// SamplePeriod is the time between the samples of a profile
+const SamplePeriod = time.Millisecond
+
+// sampleCheck is the number of instructions between the times the vm checks if a sample is due,
+// far fewer than it runs in a SamplePeriod
+const sampleCheck = 1024
+
+// Call is a node of the call tree of a profile: the calls of a function from the calls of the
+// nodes above it
+type Call struct {
+	Fn       *object.CompiledFunction
+	Calls    int
+	Total    time.Duration // the time the calls took
+	Self     time.Duration // the time the calls took outside of the calls they made
+	Children []*Call       // in the order of their first call
+
+	children map[*object.CompiledFunction]*Call
+}
+
+// child returns the node of the calls of fn from c
+func (c *Call) child(fn *object.CompiledFunction) *Call {
+	child, ok := c.children[fn]
+	if !ok {
+		child = &Call{Fn: fn, children: map[*object.CompiledFunction]*Call{}}
+		c.children[fn] = child
+		c.Children = append(c.Children, child)
+	}
+	return child
+}
+
+// Location is where a frame was when a sample was taken
+type Location struct {
+	Fn   *object.CompiledFunction
+	Line int
+}
+
+// Sample is a call stack the samples of a profile found the program in
+type Sample struct {
+	Stack []Location // the innermost frame first
+	Count int        // the number of samples
+}
+
+// Profile is what a program spent its time on
+type Profile struct {
+	Start   time.Time // when profiling started
+	Root    *Call     // the main program, called once
+	Opcodes [256]int  // by opcode the number of instructions run
+	Samples []*Sample // in the order of their first sample
+}
+
+// profiler records a profile. Its stack has an activation for each frame of the vm.
+type profiler struct {
+	profile *Profile
+	stack   []activation
+
+	countdown int                // the instructions until the next check for a sample
+	next      time.Time          // when the next sample is due
+	samples   map[string]*Sample // by the key of their stack
+}
+
+type activation struct {
+	call     *Call
+	start    time.Time
+	children time.Duration // the time of the calls it made which returned
+}
+
+// EnableProfiling makes the vm profile the program from then on, see Profile
+func (vm *VM) EnableProfiling() {
+	root := &Call{Fn: vm.frames[0].cl.Fn, Calls: 1, children: map[*object.CompiledFunction]*Call{}}
+	start := time.Now()
+	p := &profiler{
+		profile:   &Profile{Start: start, Root: root},
+		countdown: sampleCheck,
+		next:      start.Add(SamplePeriod),
+		samples:   map[string]*Sample{},
+	}
+	p.stack = append(p.stack, activation{call: root, start: start})
+	for i := 1; i < vm.framesIndex; i++ {
+		p.enter(vm.frames[i].cl.Fn)
+	}
+	vm.profiler = p
+}
+
+// Profile ends profiling, ending the calls still running at the time, and returns the profile.
+// It returns nil unless profiling is on.
+func (vm *VM) Profile() *Profile {
+	if vm.profiler == nil {
+		return nil
+	}
+	p := vm.profiler
+	vm.profiler = nil
+	p.leave(0)
+	return p.profile
+}
+
+// sample records where frames are if a sample is due, once per period since the last sample
+func (p *profiler) sample(frames []*Frame) {
+	if p.countdown--; p.countdown > 0 {
+		return
+	}
+	p.countdown = sampleCheck
+	now := time.Now()
+	if now.Before(p.next) {
+		return
+	}
+	count := 1 + int(now.Sub(p.next)/SamplePeriod)
+	p.next = p.next.Add(time.Duration(count) * SamplePeriod)
+
+	var key strings.Builder
+	stack := make([]Location, 0, len(frames))
+	for i := len(frames) - 1; i >= 0; i-- {
+		fn := frames[i].cl.Fn
+		line := fn.Lines.LineAt(frames[i].ip)
+		stack = append(stack, Location{Fn: fn, Line: line})
+		fmt.Fprintf(&key, "%p:%d ", fn, line)
+	}
+
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &Sample{Stack: stack}
+		p.samples[key.String()] = s
+		p.profile.Samples = append(p.profile.Samples, s)
+	}
+	s.Count += count
+}
+
+// enter records a call of fn from the function of the current activation
+func (p *profiler) enter(fn *object.CompiledFunction) {
+	call := p.stack[len(p.stack)-1].call.child(fn)
+	call.Calls++
+	p.stack = append(p.stack, activation{call: call, start: time.Now()})
+}
+
+// leave records the returns of the calls above the first depth activations
+func (p *profiler) leave(depth int) {
+	now := time.Now()
+	for len(p.stack) > depth {
+		a := p.stack[len(p.stack)-1]
+		p.stack = p.stack[:len(p.stack)-1]
+
+		elapsed := now.Sub(a.start)
+		a.call.Total += elapsed
+		a.call.Self += elapsed - a.children
+		if len(p.stack) > 0 {
+			p.stack[len(p.stack)-1].children += elapsed
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/andy9775/monkey/code"
+	"github.com/andy9775/monkey/compiler"
+	"github.com/andy9775/monkey/object"
+)
+
+// RegistersSize is the number of registers shared by all frames of the RegisterVM
+const RegistersSize = 65536
+
+// Machine is implemented by both the stack based VM and the RegisterVM
+type Machine interface {
+	Run() error
+	LastPoppedStackElem() object.Object
+	LimitCalls(max int)
+	SetIO(io *object.IO)
+}
+
+// RegisterVM runs register code (see code/register.go and compiler.AllocateRegisters). Frames
+// are windows into one register file: a frame's registers start at its basePointer, the
+// closure being called sits in the register right below it.
+type RegisterVM struct {
+	constants []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	registers []object.Object
+
+	globals []object.Object
+
+	lastPopped object.Object
+
+	// floor is the number of frames below the function a builtin is calling, 0 outside of such
+	// calls (see Call)
+	floor int
+
+	callLimit
+	programIO
+}
+
+// NewRegister returns a new instance of the RegisterVM for register bytecode
+func NewRegister(bytecode *compiler.Bytecode) *RegisterVM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		NumRegisters: bytecode.NumRegisters,
+		Handlers:     bytecode.Handlers,
+	}
+	mainFrame := NewFrame(&object.Closure{Fn: mainFn}, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &RegisterVM{
+		constants: bytecode.Constants,
+
+		registers: make([]object.Object, RegistersSize),
+
+		globals: make([]object.Object, GlobalsSize),
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+func NewRegisterWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *RegisterVM {
+	vm := NewRegister(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// LastPoppedStackElem returns the value of the last expression statement of the main program
+func (vm *RegisterVM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+// Run executes the register code. An error no exception handler catches (see exceptions.go)
+// stops the program and is returned as an *object.Error.
+func (vm *RegisterVM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		exception, handled := vm.unwind(err)
+		if !handled {
+			return exception
+		}
+	}
+}
+
+// unwind looks for the handler of err, popping the frames without one. If a handler is found
+// the error is stored in the handler's register and execution continues at the handler.
+func (vm *RegisterVM) unwind(err error) (*object.Error, bool) {
+	exception := toError(err)
+
+	for {
+		frame := vm.frames[vm.framesIndex-1]
+		fn := frame.cl.Fn
+
+		if h, ok := findHandler(fn.Handlers, frame.ip); ok {
+			vm.registers[frame.basePointer+fn.NumLocals+h.Depth] = exception
+			frame.ip = h.Target - 1
+			return exception, true
+		}
+
+		if vm.framesIndex == vm.floor+1 {
+			return exception, false
+		}
+
+		exception.AddTrace(fn.Name)
+		vm.framesIndex--
+	}
+}
+
+// run executes the register code until the program ends or an error is thrown. The current
+// frame's instructions and registers are kept in locals and only reloaded when the frame
+// changes.
+func (vm *RegisterVM) run() error {
+	frame := vm.frames[vm.framesIndex-1]
+	ins := frame.Instructions()
+	regs := vm.registers[frame.basePointer:]
+
+	// load reads an RK operand
+	load := func(operand uint16) object.Object {
+		if operand&code.ConstantBit != 0 {
+			return vm.constants[operand&^code.ConstantBit]
+		}
+		return regs[operand]
+	}
+
+	// switchFrame makes the frame on top of the frame stack the current one
+	switchFrame := func() {
+		frame = vm.frames[vm.framesIndex-1]
+		ins = frame.Instructions()
+		regs = vm.registers[frame.basePointer:]
+	}
+
+	for frame.ip < len(ins)-1 {
+		frame.ip++
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			regs[code.ReadUint16(ins[ip+1:])] = vm.constants[code.ReadUint16(ins[ip+3:])]
+			frame.ip += 4
+		case code.OpMove:
+			regs[code.ReadUint16(ins[ip+1:])] = load(code.ReadUint16(ins[ip+3:]))
+			frame.ip += 4
+		case code.OpTrue:
+			regs[code.ReadUint16(ins[ip+1:])] = True
+			frame.ip += 2
+		case code.OpFalse:
+			regs[code.ReadUint16(ins[ip+1:])] = False
+			frame.ip += 2
+		case code.OpNull:
+			regs[code.ReadUint16(ins[ip+1:])] = Null
+			frame.ip += 2
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+			left := load(code.ReadUint16(ins[ip+3:]))
+			right := load(code.ReadUint16(ins[ip+5:]))
+
+			var result object.Object
+			l, lok := left.(*object.Integer)
+			r, rok := right.(*object.Integer)
+			switch {
+			case lok && rok && op == code.OpAdd: // fast path for the common integer cases
+				result = object.NewInteger(l.Value + r.Value)
+			case lok && rok && op == code.OpSub:
+				result = object.NewInteger(l.Value - r.Value)
+			default:
+				var err error
+				result, err = binaryOperation(op, left, right)
+				if err != nil {
+					return err
+				}
+			}
+
+			regs[code.ReadUint16(ins[ip+1:])] = result
+			frame.ip += 6
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			result, err := comparison(op, load(code.ReadUint16(ins[ip+3:])), load(code.ReadUint16(ins[ip+5:])))
+			if err != nil {
+				return err
+			}
+			regs[code.ReadUint16(ins[ip+1:])] = result
+			frame.ip += 6
+		case code.OpMinus:
+			result, err := minusOperator(load(code.ReadUint16(ins[ip+3:])))
+			if err != nil {
+				return err
+			}
+			regs[code.ReadUint16(ins[ip+1:])] = result
+			frame.ip += 4
+		case code.OpBang:
+			regs[code.ReadUint16(ins[ip+1:])] = bangOperator(load(code.ReadUint16(ins[ip+3:])))
+			frame.ip += 4
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+		case code.OpJumpNotTruthy:
+			frame.ip += 4
+			if !isTruthy(load(code.ReadUint16(ins[ip+1:]))) {
+				frame.ip = int(code.ReadUint16(ins[ip+3:])) - 1
+			}
+		case code.OpJumpIfNotGreater, code.OpJumpIfNotEqual:
+			comparisonOp := code.OpGreaterThan
+			if op == code.OpJumpIfNotEqual {
+				comparisonOp = code.OpEqual
+			}
+
+			condition, err := compare(comparisonOp, load(code.ReadUint16(ins[ip+1:])), load(code.ReadUint16(ins[ip+3:])))
+			if err != nil {
+				return err
+			}
+			frame.ip += 6
+			if !condition {
+				frame.ip = int(code.ReadUint16(ins[ip+5:])) - 1
+			}
+
+		case code.OpGetGlobal:
+			regs[code.ReadUint16(ins[ip+1:])] = vm.globals[code.ReadUint16(ins[ip+3:])]
+			frame.ip += 4
+		case code.OpSetGlobal:
+			vm.globals[code.ReadUint16(ins[ip+1:])] = load(code.ReadUint16(ins[ip+3:]))
+			frame.ip += 4
+		case code.OpGetFree:
+			regs[code.ReadUint16(ins[ip+1:])] = frame.cl.Free[code.ReadUint8(ins[ip+3:])]
+			frame.ip += 3
+		case code.OpGetBuiltin:
+			regs[code.ReadUint16(ins[ip+1:])] = object.Builtins[code.ReadUint8(ins[ip+3:])].Builtin
+			frame.ip += 3
+		case code.OpCurrentClosure:
+			regs[code.ReadUint16(ins[ip+1:])] = frame.cl
+			frame.ip += 2
+
+		case code.OpArray:
+			start := code.ReadUint16(ins[ip+1:])
+			numElements := code.ReadUint16(ins[ip+3:])
+			frame.ip += 4
+
+			regs[start] = newArray(regs[start : start+numElements])
+		case code.OpHash:
+			start := code.ReadUint16(ins[ip+1:])
+			numElements := code.ReadUint16(ins[ip+3:])
+			frame.ip += 4
+
+			hash, err := newHash(regs[start : start+numElements])
+			if err != nil {
+				return err
+			}
+			regs[start] = hash
+		case code.OpIndex:
+			result, err := indexExpression(load(code.ReadUint16(ins[ip+3:])), load(code.ReadUint16(ins[ip+5:])))
+			if err != nil {
+				return err
+			}
+			regs[code.ReadUint16(ins[ip+1:])] = result
+			frame.ip += 6
+		case code.OpSlice:
+			result, err := slice(load(code.ReadUint16(ins[ip+3:])), load(code.ReadUint16(ins[ip+5:])), load(code.ReadUint16(ins[ip+7:])))
+			if err != nil {
+				return err
+			}
+			regs[code.ReadUint16(ins[ip+1:])] = result
+			frame.ip += 8
+		case code.OpSetIndex:
+			value := load(code.ReadUint16(ins[ip+7:]))
+			err := setIndex(load(code.ReadUint16(ins[ip+3:])), load(code.ReadUint16(ins[ip+5:])), value)
+			if err != nil {
+				return err
+			}
+			regs[code.ReadUint16(ins[ip+1:])] = value
+			frame.ip += 8
+
+		case code.OpCall, code.OpTailCall:
+			base := int(code.ReadUint16(ins[ip+1:]))
+			numArgs := int(code.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+
+			switch callee := regs[base].(type) {
+			case *object.Closure:
+				var err error
+				if op == code.OpCall {
+					err = vm.callClosure(callee, frame.basePointer+base+1, numArgs)
+				} else {
+					err = vm.tailCallClosure(callee, frame, base, numArgs)
+				}
+				if err != nil {
+					return err
+				}
+				switchFrame()
+			case *object.Builtin:
+				// builtins don't use a frame; for tail calls the following OpReturnValue
+				// returns the result
+				result, err := callBuiltin(vm, callee, regs[base+1:base+1+numArgs])
+				if err != nil {
+					return err
+				}
+				regs[base] = result
+			default:
+				return object.NewError(object.TypeErrorKind, "calling non-function and non-builtin")
+			}
+
+		case code.OpReturnValue, code.OpReturn:
+			var returnValue object.Object = Null
+			if op == code.OpReturnValue {
+				returnValue = load(code.ReadUint16(ins[ip+1:]))
+			}
+
+			if vm.framesIndex == 1 { // returning from the main program ends it, with the value
+				vm.lastPopped = returnValue
+				return nil
+			}
+			vm.framesIndex--
+			vm.registers[frame.basePointer-1] = returnValue
+			if vm.framesIndex == vm.floor { // returned to the builtin which called the function
+				return nil
+			}
+			switchFrame()
+
+		case code.OpPop:
+			vm.lastPopped = load(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+		case code.OpThrow:
+			return object.ToError(load(code.ReadUint16(ins[ip+1:])))
+
+		case code.OpClosure:
+			start := code.ReadUint16(ins[ip+1:])
+			constIndex := code.ReadUint16(ins[ip+3:])
+			numFree := uint16(code.ReadUint8(ins[ip+5:]))
+			frame.ip += 5
+
+			closure, err := newClosure(vm.constants[constIndex], regs[start:start+numFree])
+			if err != nil {
+				return err
+			}
+			regs[start] = closure
+
+		default:
+			return fmt.Errorf("unknown register opcode: %d", op)
+		}
+	}
+	return nil
+}
+
+// Call calls fn on behalf of a builtin (see object.Runtime). The callee and its arguments are
+// placed in the registers after the current frame's, and the vm runs until the callee's frame
+// returns. An error the callee doesn't handle ends the call, rather than unwinding the frames
+// below it.
+func (vm *RegisterVM) Call(fn object.Object, args ...object.Object) (object.Object, *object.Error) {
+	switch fn := fn.(type) {
+	case *object.Builtin:
+		result, err := callBuiltin(vm, fn, args)
+		if err != nil {
+			return nil, toError(err)
+		}
+		return result, nil
+	case *object.Closure:
+		frame := vm.frames[vm.framesIndex-1]
+		base := frame.basePointer + frame.cl.Fn.NumRegisters
+		if base+1+len(args) > len(vm.registers) {
+			return nil, object.NewError(object.RuntimeErrorKind, "stack overflow")
+		}
+
+		vm.registers[base] = fn
+		copy(vm.registers[base+1:], args)
+
+		err := vm.callClosure(fn, base+1, len(args))
+		if err != nil {
+			return nil, toError(err)
+		}
+
+		floor := vm.floor
+		vm.floor = vm.framesIndex - 1
+		err = vm.Run()
+		if err != nil {
+			// unwind stopped at the callee's frame
+			exception := toError(err)
+			exception.AddTrace(vm.frames[vm.framesIndex-1].cl.Fn.Name)
+			vm.framesIndex, vm.floor = vm.floor, floor
+			return nil, exception
+		}
+		vm.floor = floor
+
+		return vm.registers[base], nil
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "calling non-function and non-builtin")
+	}
+}
+
+// callClosure pushes a frame for cl whose registers start at basePointer
+func (vm *RegisterVM) callClosure(cl *object.Closure, basePointer, numArgs int) error {
+	if err := checkArity(cl, numArgs); err != nil {
+		return err
+	}
+	if err := vm.count(); err != nil {
+		return err
+	}
+	if vm.framesIndex >= MaxFrames || basePointer+cl.Fn.NumRegisters > len(vm.registers) {
+		return object.NewError(object.RuntimeErrorKind, "stack overflow")
+	}
+
+	reuseFrame(vm.frames, vm.framesIndex, cl, basePointer)
+	vm.framesIndex++
+
+	return nil
+}
+
+// tailCallClosure reuses frame for cl. The callee and its arguments are moved down to where
+// the frame's closure and its arguments sit, and execution restarts at the beginning of cl.
+func (vm *RegisterVM) tailCallClosure(cl *object.Closure, frame *Frame, base, numArgs int) error {
+	if err := checkArity(cl, numArgs); err != nil {
+		return err
+	}
+	if err := vm.count(); err != nil {
+		return err
+	}
+	if frame.basePointer+cl.Fn.NumRegisters > len(vm.registers) {
+		return object.NewError(object.RuntimeErrorKind, "stack overflow")
+	}
+
+	from := frame.basePointer + base
+	copy(vm.registers[frame.basePointer-1:], vm.registers[from:from+1+numArgs])
+
+	frame.cl = cl
+	frame.ip = -1
+
+	return nil
+}
@@ -1,8 +1,6 @@
 package vm
 
 import (
-	"fmt"
-
 	"github.com/andy9775/monkey/code"
 	"github.com/andy9775/monkey/compiler"
 	"github.com/andy9775/monkey/object"
@@ -12,9 +10,9 @@ const StackSize = 2048
 const GlobalsSize = 65536
 const MaxFrames = 1024 // number of call frames
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+var True = object.True
+var False = object.False
+var Null = object.NullValue
 
 type VM struct {
 	constants []object.Object
@@ -26,12 +24,31 @@ type VM struct {
 	sp    int // alwayspoints to the next value. Top of stack is stack[sp - 1]
 
 	globals []object.Object // track globally defined variables (slice for performence)
+
+	// floor is the number of frames below the function a builtin is calling, 0 outside of such
+	// calls (see Call)
+	floor int
+
+	// hook is called before each instruction, and stopped is the error it stopped the program
+	// with (see debug.go)
+	hook    Hook
+	stopped error
+
+	coverage *Coverage // what the program ran, nil unless coverage is on (see coverage.go)
+	profiler *profiler // nil unless profiling is on (see profile.go)
+
+	callLimit
+	programIO
 }
 
 // New returns a new instance of the VM configured to the Bytecode
 func New(bytecode *compiler.Bytecode) *VM {
 	// treat the main program as a function
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+		Lines:        bytecode.Lines,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	// mainFrame doesn't have local bindings and is never popped
 	mainFrame := NewFrame(mainClosure, 0) // start the main program at 0
@@ -72,18 +89,102 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
-// Run executes the fetch-decode-execute cycle of the vm
+// Run executes the program. An error no exception handler catches (see exceptions.go) stops the
+// program and is returned as an *object.Error.
 func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if vm.stopped != nil {
+			return vm.stopped
+		}
+
+		exception, handled := vm.unwind(err)
+		if !handled {
+			return exception
+		}
+	}
+}
+
+// unwind looks for the handler of err, popping the frames without one. If a handler is found
+// the stack is set up for it and it's where execution continues.
+func (vm *VM) unwind(err error) (*object.Error, bool) {
+	exception := toError(err)
+
+	for {
+		frame := vm.currentFrame()
+		fn := frame.cl.Fn
+
+		// frame.ip is within the instruction being executed (the call, for the frames below
+		// the current one)
+		if h, ok := findHandler(fn.Handlers, frame.ip); ok {
+			vm.sp = frame.basePointer + fn.NumLocals + h.Depth
+			frame.ip = h.Target - 1
+			return exception, vm.push(exception) == nil
+		}
+
+		if vm.framesIndex == vm.floor+1 {
+			return exception, false
+		}
+
+		exception.AddTrace(fn.Name)
+		returned := vm.popFrame()
+		vm.sp = returned.basePointer - 1
+		if vm.profiler != nil {
+			vm.profiler.leave(vm.framesIndex)
+		}
+	}
+}
+
+// run executes the fetch-decode-execute cycle of the vm until the program ends or an error
+// is thrown
+func (vm *VM) run() error {
+	// the current frame and its instructions only change on calls and returns, so rather than
+	// looking them up for every instruction they're kept around and reloaded by switchFrame
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+
+	// the instruction counts of the frame's function while coverage is on, nil otherwise
+	var counts []int
+	if vm.coverage != nil {
+		counts = vm.coverage.counts(frame.cl.Fn)
+	}
+
+	// the profiler while profiling is on, nil otherwise
+	profiler := vm.profiler
+
+	switchFrame := func() {
+		frame = vm.currentFrame()
+		ins = frame.Instructions()
+		if vm.coverage != nil {
+			counts = vm.coverage.counts(frame.cl.Fn)
+		}
+	}
+
 	// ip == instruction pointer
 	var ip int
-	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
+	for frame.ip < len(ins)-1 {
+		if vm.hook != nil {
+			if err := vm.hook(); err != nil {
+				vm.stopped = err
+				return err
+			}
+		}
+
+		frame.ip++
+		ip = frame.ip
 		op = code.Opcode(ins[ip])
+		if counts != nil {
+			counts[ip]++
+		}
+		if profiler != nil {
+			profiler.profile.Opcodes[op]++
+			profiler.sample(vm.frames[:vm.framesIndex])
+		}
 
 		switch op { // decode
 		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
@@ -94,7 +195,7 @@ func (vm *VM) Run() error {
 			}
 		case code.OpConstant:
 			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			// put the constant value onto the vm stack
 			err := vm.push(vm.constants[constIndex])
@@ -111,7 +212,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -130,14 +231,18 @@ func (vm *VM) Run() error {
 			vm.pop()
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
+			frame.ip = pos - 1
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			condition := vm.pop()
-			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
+			truthy := isTruthy(condition)
+			if !truthy {
+				frame.ip = pos - 1
+			}
+			if vm.coverage != nil {
+				vm.coverage.branch(frame.cl.Fn, ip, truthy)
 			}
 
 		case code.OpNull:
@@ -148,11 +253,11 @@ func (vm *VM) Run() error {
 
 		case code.OpSetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
@@ -161,7 +266,7 @@ func (vm *VM) Run() error {
 
 		case code.OpArray:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			array := vm.buildArray(vm.sp-numElements, vm.sp) // object.Array
 
@@ -175,7 +280,7 @@ func (vm *VM) Run() error {
 			}
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
@@ -195,6 +300,34 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			result, err := slice(left, start, end)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(result)
+			if err != nil {
+				return err
+			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := setIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(value)
+			if err != nil {
+				return err
+			}
 		case code.OpCall:
 			// a function call means setting aside space on the stack for the necessary variables
 			// used inside the function, but first we create a new stack frame for the function
@@ -202,12 +335,24 @@ func (vm *VM) Run() error {
 			// runs the functions instructions.
 
 			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip++
+			frame.ip++
 
 			err := vm.executeCall(int(numArgs))
 			if err != nil {
 				return err
 			}
+			switchFrame()
+		case code.OpTailCall:
+			// same as OpCall except the callee replaces the current frame rather than
+			// getting a new one, so tail recursive functions run in constant space
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+			switchFrame()
 
 		case code.OpSetLocal:
 			// setting a local variable places it in the call stack, but inside the
@@ -215,16 +360,12 @@ func (vm *VM) Run() error {
 
 			// localIndex is the offset within the function to place the variable
 			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip++ // increment the instruction
-
-			frame := vm.currentFrame()
+			frame.ip++ // increment the instruction
 
 			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip++
-
-			frame := vm.currentFrame()
+			frame.ip++
 
 			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
 			if err != nil {
@@ -233,25 +374,48 @@ func (vm *VM) Run() error {
 
 		case code.OpReturnValue:
 			returnValue := vm.pop() // return value sits on top of the stack
+			if vm.framesIndex == 1 {
+				// returning from the main program ends it
+				return vm.end(returnValue)
+			}
 
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1 // reset the call stack
+			returned := vm.popFrame()
+			vm.sp = returned.basePointer - 1 // reset the call stack
+			if vm.profiler != nil {
+				vm.profiler.leave(vm.framesIndex)
+			}
+			switchFrame()
 
 			err := vm.push(returnValue)
 			if err != nil {
 				return err
 			}
+			if vm.framesIndex == vm.floor { // returned to the builtin which called the function
+				return nil
+			}
 		case code.OpReturn:
-			frame := vm.popFrame()        // remove the functions call frame
-			vm.sp = frame.basePointer - 1 // reset the call stack
+			if vm.framesIndex == 1 {
+				return vm.end(Null)
+			}
+			returned := vm.popFrame()        // remove the functions call frame
+			vm.sp = returned.basePointer - 1 // reset the call stack
+			if vm.profiler != nil {
+				vm.profiler.leave(vm.framesIndex)
+			}
+			switchFrame()
 
 			err := vm.push(Null) // blank return/no return should just put null on the stack
 			if err != nil {
+				return err
+			}
+			if vm.framesIndex == vm.floor {
 				return nil
 			}
+		case code.OpThrow:
+			return object.ToError(vm.pop())
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip++
+			frame.ip++
 
 			definition := object.Builtins[builtinIndex]
 
@@ -262,7 +426,7 @@ func (vm *VM) Run() error {
 		case code.OpClosure:
 			constIndex := code.ReadUint16(ins[ip+1:])
 			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
+			frame.ip += 3
 
 			err := vm.pushClosure(int(constIndex), int(numFree))
 			if err != nil {
@@ -271,41 +435,82 @@ func (vm *VM) Run() error {
 		case code.OpGetFree:
 			// pull the free variable off of the current closure (order matters)
 			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip++
+			frame.ip++
 
-			currentClosure := vm.currentFrame().cl
+			currentClosure := frame.cl
 			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
 				return err
 			}
 		case code.OpCurrentClosure:
-			currentClosure := vm.currentFrame().cl
+			currentClosure := frame.cl
 			err := vm.push(currentClosure)
 			if err != nil {
 				return err
 			}
+
+		// superinstructions
+		case code.OpAddLocals:
+			left := vm.stack[frame.basePointer+int(code.ReadUint8(ins[ip+1:]))]
+			right := vm.stack[frame.basePointer+int(code.ReadUint8(ins[ip+2:]))]
+			frame.ip += 2
+
+			err := vm.executeFusedBinaryOperation(code.OpAdd, left, right)
+			if err != nil {
+				return err
+			}
+		case code.OpAddConst, code.OpSubConst:
+			right := vm.constants[code.ReadUint16(ins[ip+1:])]
+			frame.ip += 2
+
+			binaryOp := code.OpAdd
+			if op == code.OpSubConst {
+				binaryOp = code.OpSub
+			}
+
+			err := vm.executeFusedBinaryOperation(binaryOp, vm.pop(), right)
+			if err != nil {
+				return err
+			}
+		case code.OpJumpIfNotGreater, code.OpJumpIfNotEqual:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			comparisonOp := code.OpGreaterThan
+			if op == code.OpJumpIfNotEqual {
+				comparisonOp = code.OpEqual
+			}
+
+			condition, err := vm.compare(comparisonOp)
+			if err != nil {
+				return err
+			}
+			if !condition {
+				frame.ip = pos - 1
+			}
 		}
 	}
 	return nil
 }
 
 func (vm *VM) pushClosure(constIndex, numFree int) error {
-	constant := vm.constants[constIndex]
-	function, ok := constant.(*object.CompiledFunction)
-	if !ok {
-		return fmt.Errorf("not a function: %+v", constant)
-	}
-
-	free := make([]object.Object, numFree)
-	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+	closure, err := newClosure(vm.constants[constIndex], vm.stack[vm.sp-numFree:vm.sp])
+	if err != nil {
+		return err
 	}
 	vm.sp = vm.sp - numFree
 
-	closure := &object.Closure{Fn: function, Free: free}
 	return vm.push(closure)
 }
 
+// end ends the program with result, for a return from the main program
+func (vm *VM) end(result object.Object) error {
+	frame := vm.currentFrame()
+	frame.ip = len(frame.Instructions()) - 1
+	vm.stack[vm.sp] = result // see LastPoppedStackElem
+	return nil
+}
+
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -314,227 +519,223 @@ func (vm *VM) executeCall(numArgs int) error {
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
 	default:
-		return fmt.Errorf("calling non-function and non-builtin")
+		return object.NewError(object.TypeErrorKind, "calling non-function and non-builtin")
+	}
+}
+
+func (vm *VM) executeTailCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.tailCallClosure(callee, numArgs)
+	case *object.Builtin:
+		// builtins don't use a frame; the following OpReturnValue returns the result
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return object.NewError(object.TypeErrorKind, "calling non-function and non-builtin")
 	}
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
-	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
-			cl.Fn.NumParameters, numArgs)
+	if err := checkArity(cl, numArgs); err != nil {
+		return err
+	}
+	if err := vm.count(); err != nil {
+		return err
+	}
+	if vm.framesIndex >= MaxFrames || vm.sp-numArgs+cl.Fn.NumLocals > StackSize {
+		return object.NewError(object.RuntimeErrorKind, "stack overflow")
 	}
 
-	frame := NewFrame(cl, vm.sp-numArgs) // where the new frames stack pointer starts (account for args)
-	vm.pushFrame(frame)
+	frame := vm.pushFrame(cl, vm.sp-numArgs) // where the new frames stack pointer starts (account for args)
 
 	// set aside space on the stack for local variables
 	// the function call adds variables from vm.sp up to
 	// (vm.sp + fn.NumLocals) when executing
 	// normal usage of the stack won't affect this space
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	if vm.hook != nil {
+		vm.clearLocals(frame, numArgs)
+	}
+	if vm.profiler != nil {
+		vm.profiler.enter(cl.Fn)
+	}
 
 	return nil
 }
 
-func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
-	args := vm.stack[vm.sp-numArgs : vm.sp] // arguments are up to sp
+// tailCallClosure reuses the current frame for cl. The callee and its arguments are moved
+// down to where the current closure and its arguments sit, and execution restarts at the
+// beginning of cl.
+func (vm *VM) tailCallClosure(cl *object.Closure, numArgs int) error {
+	if err := checkArity(cl, numArgs); err != nil {
+		return err
+	}
+	if err := vm.count(); err != nil {
+		return err
+	}
 
-	// take the arguments off of the stack and pass them to the defined builtin function
-	result := builtin.Fn(args...)
-	vm.sp = vm.sp - numArgs - 1
+	frame := vm.currentFrame()
+	if frame.basePointer+cl.Fn.NumLocals > StackSize {
+		return object.NewError(object.RuntimeErrorKind, "stack overflow")
+	}
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
 
-	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
+	frame.cl = cl
+	frame.ip = -1
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	if vm.hook != nil {
+		vm.clearLocals(frame, numArgs)
+	}
+	if vm.profiler != nil {
+		vm.profiler.leave(vm.framesIndex - 1)
+		vm.profiler.enter(cl.Fn)
 	}
 
 	return nil
 }
 
-func (vm *VM) executeIndexExpression(left, index object.Object) error {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return vm.executeArrayIndex(left, index)
-	case left.Type() == object.HASH_OBJ:
-		return vm.executeHashIndex(left, index)
-	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp] // arguments are up to sp
+
+	// take the arguments off of the stack and pass them to the defined builtin function
+	result, err := callBuiltin(vm, builtin, args)
+	if err != nil {
+		return err
 	}
+	vm.sp = vm.sp - numArgs - 1
+
+	return vm.push(result)
 }
 
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	arrayObject := array.(*object.Array)
-	i := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+// Call calls fn on behalf of a builtin (see object.Runtime). A closure runs in a frame on top of
+// the frames of the program, and the vm runs until that frame returns. An error the closure
+// doesn't handle ends the call, rather than unwinding the frames below it.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, *object.Error) {
+	switch fn := fn.(type) {
+	case *object.Builtin:
+		result, err := callBuiltin(vm, fn, args)
+		if err != nil {
+			return nil, toError(err)
+		}
+		return result, nil
+	case *object.Closure:
+		sp, floor := vm.sp, vm.floor
+		if sp+1+len(args) > StackSize {
+			return nil, object.NewError(object.RuntimeErrorKind, "stack overflow")
+		}
 
-	if i < 0 || i > max {
-		return vm.push(Null)
-	}
+		vm.stack[sp] = fn
+		copy(vm.stack[sp+1:], args)
+		vm.sp = sp + 1 + len(args)
 
-	return vm.push(arrayObject.Elements[i])
-}
+		err := vm.callClosure(fn, len(args))
+		if err != nil {
+			vm.sp = sp
+			return nil, toError(err)
+		}
 
-func (vm *VM) executeHashIndex(hash, index object.Object) error {
-	hashObject := hash.(*object.Hash)
+		vm.floor = vm.framesIndex - 1
+		err = vm.Run()
+		if err != nil {
+			// unwind stopped at the closure's frame
+			exception := toError(err)
+			exception.AddTrace(vm.currentFrame().cl.Fn.Name)
+			vm.framesIndex, vm.sp, vm.floor = vm.floor, sp, floor
+			if vm.profiler != nil {
+				vm.profiler.leave(vm.framesIndex)
+			}
+			return nil, exception
+		}
+		vm.floor = floor
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+		return vm.pop(), nil
+	default:
+		return nil, object.NewError(object.TypeErrorKind, "calling non-function and non-builtin")
 	}
+}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
-	if !ok {
-		return vm.push(Null)
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	result, err := indexExpression(left, index)
+	if err != nil {
+		return err
 	}
-
-	return vm.push(pair.Value)
+	return vm.push(result)
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
-
-	for i := startIndex; i < endIndex; i += 2 {
-		key := vm.stack[i]
-		value := vm.stack[i+1]
-		pair := object.HashPair{Key: key, Value: value}
-
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
-		}
-
-		hashedPairs[hashKey.HashKey()] = pair
-	}
-
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return newHash(vm.stack[startIndex:endIndex])
 }
 
-// buildArray iterates through the stack elements associated with the array and adds them
-// to a new object.Array object.
+// buildArray copies the stack elements associated with the array to a new object.Array
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
-	elements := make([]object.Object, endIndex-startIndex)
-
-	for i := startIndex; i < endIndex; i++ {
-		elements[i-startIndex] = vm.stack[i]
-	}
-
-	return &object.Array{Elements: elements}
+	return newArray(vm.stack[startIndex:endIndex])
 }
 
 func (vm *VM) executeMinusOperator() error {
-	operand := vm.pop()
-
-	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	result, err := minusOperator(vm.pop())
+	if err != nil {
+		return err
 	}
-
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.push(result)
 }
 
 func (vm *VM) executeBangOperator() error {
-	operand := vm.pop()
-	switch operand {
-	case True:
-		return vm.push(False)
-	case False:
-		return vm.push(True)
-	case Null: // !Null is True
-		return vm.push(True)
-	default:
-		return vm.push(False)
-	}
+	return vm.push(bangOperator(vm.pop()))
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return vm.executeIntegerComparison(op, left, right)
-	}
-
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
-	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)",
-			op, left.Type(), right.Type())
+	result, err := comparison(op, left, right)
+	if err != nil {
+		return err
 	}
+	return vm.push(result)
 }
 
-func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
-	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
-	default:
-		return fmt.Errorf("unknown operator: %d", op)
-	}
-}
-
-func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+// compare pops the two operands of a comparison and returns the result as a native bool
+func (vm *VM) compare(op code.Opcode) (bool, error) {
 	right := vm.pop()
 	left := vm.pop()
 
-	leftType := left.Type()
-	rightType := right.Type()
-	switch {
-	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
-		return vm.executeBinaryIntegerOperation(op, left, right)
-	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
-		return vm.executeBinaryStringOperation(op, left, right)
-	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
-	}
-
+	return compare(op, left, right)
 }
 
-func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
-	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+// executeFusedBinaryOperation is used by the superinstructions. Integers are handled without
+// going through the generic type checks, everything else falls back to executeBinaryOperands.
+func (vm *VM) executeFusedBinaryOperation(op code.Opcode, left, right object.Object) error {
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			if op == code.OpSub {
+				return vm.push(object.NewInteger(l.Value - r.Value))
+			}
+			return vm.push(object.NewInteger(l.Value + r.Value))
+		}
 	}
 
-	leftValue := left.(*object.String).Value
-	rightValue := right.(*object.String).Value
-
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	return vm.executeBinaryOperands(op, left, right)
 }
 
-func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
 
-	var result int64
+	return vm.executeBinaryOperands(op, left, right)
+}
 
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
-	case code.OpSub:
-		result = leftValue - rightValue
-	case code.OpMul:
-		result = leftValue * rightValue
-	case code.OpDiv:
-		result = leftValue / rightValue
-	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+func (vm *VM) executeBinaryOperands(op code.Opcode, left, right object.Object) error {
+	result, err := binaryOperation(op, left, right)
+	if err != nil {
+		return err
 	}
-
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(result)
 }
 
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+		return object.NewError(object.RuntimeErrorKind, "stack overflow")
 	}
 
 	vm.stack[vm.sp] = o
@@ -553,9 +754,10 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+func (vm *VM) pushFrame(cl *object.Closure, basePointer int) *Frame {
+	f := reuseFrame(vm.frames, vm.framesIndex, cl, basePointer)
 	vm.framesIndex++
+	return f
 }
 
 func (vm *VM) popFrame() *Frame {
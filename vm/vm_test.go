@@ -1,10 +1,17 @@
 package vm_test
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/andy9775/monkey/ast"
+	"github.com/andy9775/monkey/code"
 	"github.com/andy9775/monkey/compiler"
 	"github.com/andy9775/monkey/lexer"
 	"github.com/andy9775/monkey/object"
@@ -60,6 +67,48 @@ func TestRecursiveFunctions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			// far deeper than MaxFrames
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(100000);`,
+			expected: 0,
+		},
+		{
+			input: `
+			let sum = fn(x, acc) {
+				if (x == 0) {
+					return acc;
+				}
+				return sum(x - 1, acc + x);
+			};
+			sum(100000, 0);`,
+			expected: 5000050000,
+		},
+		{
+			// tail calls from one closure into another
+			input: `
+			let loop = fn(x) { if (x == 0) { true } else { loop(x - 1) } };
+			let wrapper = fn() { let inner = fn(x) { loop(x) }; inner(100001) };
+			wrapper();`,
+			expected: true,
+		},
+		{
+			input:    `let f = fn(arr) { len(arr) }; f([1, 2, 3]) + 1`,
+			expected: 4,
+		},
+	}
+	runVmTests(t, tests)
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -138,14 +187,11 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("hello world")`, 11},
 		{
 			`len(1)`,
-			&object.Error{
-				Message: "argument to `len` not supported, got INTEGER"},
+			thrown{&object.Error{Message: "argument to `len` not supported, got INTEGER"}},
 		},
 		{
 			`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
+			thrown{&object.Error{Message: "wrong number of arguments. got=2, want=1"}},
 		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
@@ -153,28 +199,424 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, vm.Null},
 		{`first(1)`,
-			&object.Error{
-				Message: "argument to `first` must be ARRAY, got INTEGER",
-			},
+			thrown{&object.Error{Message: "argument to `first` must be ARRAY, got INTEGER"}},
 		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, vm.Null},
 		{`last(1)`,
-			&object.Error{
-				Message: "argument to `last` must be ARRAY, got INTEGER",
-			},
+			thrown{&object.Error{Message: "argument to `last` must be ARRAY, got INTEGER"}},
 		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, vm.Null},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`,
-			&object.Error{
-				Message: "argument to `push` must be ARRAY, got INTEGER",
-			},
+			thrown{&object.Error{Message: "argument to `push` must be ARRAY, got INTEGER"}},
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestArrayChanges(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2]; a[0] = 3; a[0] + a[1]", 5},
+		{"let a = [1]; a[0] = 2", 2},
+		{"let a = [1]; a[0] = a[0] = 2; a", []int{2}},
+		{"let a = [1]; let b = a; append!(b, 2); a", []int{1, 2}},
+		{"let a = [1, 2, 3]; pop!(a) + len(a)", 5},
+		{"pop!([])", vm.Null},
+		{"let a = [1, 3]; insert(a, 1, 2); a", []int{1, 2, 3}},
+		{"let a = [1, 2, 3]; remove(a, 0) + a[0]", 3},
+		{"let f = fn(x) { let a = [x]; a[0] = a[0] * 2; a }; f(2)", []int{4}},
+		// closures share the array rather than a copy of it
+		{"let a = [0]; let inc = fn() { a[0] = a[0] + 1 }; inc(); inc(); a[0]", 2},
+		{
+			`let counter = fn() { let a = [0]; [fn() { a[0] = a[0] + 1 }, fn() { a[0] }] };
+			let c = counter(); c[0](); c[0](); c[1]()`,
+			2,
+		},
+		{"let a = [1, 2, 3]; a[-1] = 7; a", []int{1, 2, 7}},
+		{
+			"let a = [1]; a[1] = 2",
+			thrown{&object.Error{Message: "index out of range: 1 (length 1)", Kind: "RuntimeError"}},
+		},
+		{
+			"let a = [1]; a[-2] = 2",
+			thrown{&object.Error{Message: "index out of range: -2 (length 1)", Kind: "RuntimeError"}},
+		},
+		{
+			"let a = [1]; a[0] = [a]",
+			thrown{&object.Error{Message: "cannot put an array into itself", Kind: "RuntimeError"}},
+		},
+		{
+			"let a = freeze([1]); a[0] = 2",
+			thrown{&object.Error{Message: "cannot modify a frozen array", Kind: "TypeError"}},
+		},
+		{
+			"let a = freeze([1]); append!(a, 2)",
+			thrown{&object.Error{Message: "cannot modify a frozen array", Kind: "TypeError"}},
+		},
+		{
+			"{}[1] = 2",
+			thrown{&object.Error{Message: "index assignment not supported: HASH", Kind: "TypeError"}},
+		},
+		{
+			`[1]["a"] = 2`,
+			thrown{&object.Error{Message: "array index must be INTEGER, got STRING", Kind: "TypeError"}},
 		},
 	}
 	runVmTests(t, tests)
 }
+
+func TestCollectionFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"map([1, 2, 3], fn(x) { x * 2 })", []int{2, 4, 6}},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", []int{3, 4}},
+		{"reduce([1, 2, 3], 10, fn(acc, x) { acc + x })", 16},
+		{"sort([3, 1, 2])", []int{1, 2, 3}},
+		{"sort([1, 3, 2], fn(a, b) { b - a })", []int{3, 2, 1}},
+		{"range(3)", []int{0, 1, 2}},
+		{"range(1, 7, 3)", []int{1, 4}},
+		{"len(zip([1, 2], [3, 4, 5]))", 2},
+		{"any([1, 2], fn(x) { x == 2 })", true},
+		{"all([1, 2], fn(x) { x == 2 })", false},
+		{"find([1, 2, 3], fn(x) { x > 1 })", 2},
+		{"find([1], fn(x) { false })", vm.Null},
+		{"flatten([[1], 2, [3, 4]])", []int{1, 2, 3, 4}},
+		{"unique([1, 1, 2, 1])", []int{1, 2}},
+		// builtins can be passed too
+		{"map([[1], [1, 2]], len)", []int{1, 2}},
+		// closures see their free variables, and the callbacks run in frames of their own
+		{"let n = 10; let add = fn(x) { x + n }; fn() { map([1, 2], add) }()", []int{11, 12}},
+		{"map([1, 2], fn(x) { map([x], fn(y) { y * 10 })[0] })", []int{10, 20}},
+		{"let f = fn(x) { if (x == 0) { 0 } else { x + f(x - 1) } }; map([3], f)", []int{6}},
+		// a recursive version would run out of frames
+		{"len(map(range(5000), fn(x) { x }))", 5000},
+		{"reduce(range(5000), 0, fn(acc, x) { acc + 1 })", 5000},
+		// errors thrown by a callback come out of the builtin
+		{
+			"let f = fn(x) { throw error(\"bad\", \"Bad\") }; try { map([1], f) } catch (e) { e[\"kind\"] }",
+			"Bad",
+		},
+		{
+			"let f = fn(x) { throw \"bad\" }; map([1], f)",
+			thrown{&object.Error{Message: "bad", Trace: []string{"f"}}},
+		},
+		{
+			// handlers inside the callback still catch its errors
+			"map([1, 2], fn(x) { try { throw x } catch (e) { x * 10 } })",
+			[]int{10, 20},
+		},
+		{
+			"let g = fn() { map([1], fn(x) { x(1) }) }; try { g() } catch (e) { len(e[\"trace\"]) }",
+			2,
+		},
+		{
+			"map([1], fn() { 1 })",
+			thrown{&object.Error{Message: "wrong number of arguments: want=0, got=1", Kind: "ArgumentError"}},
+		},
+		{
+			"sort([1, \"a\"])",
+			thrown{&object.Error{Message: "cannot compare STRING and INTEGER", Kind: "TypeError"}},
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestJSON(t *testing.T) {
+	tests := []vmTestCase{
+		{`json_stringify({"a": [1, true, "x"], "b": {}})`, `{"a":[1,true,"x"],"b":{}}`},
+		{`json_parse(json_stringify({"a": [1, 2]}))["a"][1]`, 2},
+		{`json_stringify(json_parse("[1, 2.5, 1e2, null]"))`, "[1,2.5,100.0,null]"},
+		{`len(json_stringify([1], 2))`, 7},
+		// decoded nulls are the null of the vm
+		{`json_parse("[null]")[0]`, vm.Null},
+		{`let a = json_parse("[null]"); a[0] == [][0]`, true},
+		{`let q = json_stringify("")[:1]; json_parse(q + "monkey" + q)`, "monkey"},
+		{
+			`json_parse("[1,")`,
+			thrown{&object.Error{Message: "invalid JSON at offset 3: unexpected end of JSON input", Kind: "ArgumentError"}},
+		},
+		{
+			`json_stringify({"f": fn() { 1 }})`,
+			thrown{&object.Error{Message: `cannot encode a function as JSON (at ["f"])`, Kind: "TypeError"}},
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw 1 } catch { 2 }`, 2},
+		{`try { throw [1] } catch (e) { e["message"] }`, "[1]"},
+		{`try { throw "boom" } catch (e) { e["kind"] }`, "Error"},
+		{`try { throw "boom" } catch (e) { e["unknown"] }`, vm.Null},
+		{`try { 1 + "a" } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { fn(a) { a }() } catch (e) { e["kind"] }`, "ArgumentError"},
+		{`try { len(1) } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { len() } catch (e) { e["message"] }`, "wrong number of arguments. got=0, want=1"},
+		{`try { } catch (e) { 1 }`, vm.Null},
+		{`try { let a = 1; } catch (e) { 1 }`, vm.Null},
+		{`let e = error("bad", "ValueError"); e["kind"]`, "ValueError"},
+		{`error("bad")`, &object.Error{Message: "bad", Kind: "Error"}},
+		{
+			`let f = fn() { throw error("bad", "ValueError") }; try { f() } catch (e) { e["kind"] }`,
+			"ValueError",
+		},
+		{
+			// the values below the try expression survive the unwinding
+			`let f = fn(n) { if (n == 0) { throw "bottom" } 1 + f(n - 1) };
+			let r = [1, 2 * try { f(10) } catch (e) { 3 }];
+			r[0] + r[1]`,
+			7,
+		},
+		{
+			`let f = fn() { try { throw "x" } catch (err) { err["message"] + "!" } }; f()`,
+			"x!",
+		},
+		{
+			`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`,
+			"ab",
+		},
+		{
+			// calls inside a try block aren't tail calls
+			`let g = fn() { throw "g" }; let f = fn() { try { return g() } catch (e) { "caught" } }; f()`,
+			"caught",
+		},
+		{
+			`let g = fn() { throw "g" }; let f = fn() { try { g() } catch (e) { "caught" } }; f()`,
+			"caught",
+		},
+		// traces
+		{
+			`let inner = fn() { throw "x" }; let outer = fn() { inner() + 1 };
+			try { outer() } catch (e) { e["trace"][0] + " " + e["trace"][1] }`,
+			"inner outer",
+		},
+		{
+			// tail calls don't leave a frame behind
+			`let inner = fn() { throw "x" }; let outer = fn() { inner() };
+			try { outer() } catch (e) { len(e["trace"]) }`,
+			1,
+		},
+		{
+			`let e = try { fn() { throw "x" }() } catch (e) { e }; e["trace"][0]`,
+			"<anonymous>",
+		},
+		// finally
+		{`try { 5 } finally { 6 }`, 5},
+		{`try { throw "a" } catch (e) { 1 } finally { let done = true; }; done`, true},
+		{`try { 1 } finally { let done = true; }; done`, true},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn() { try { throw "a" } finally { return "finally" } }; f()`, "finally"},
+		{`let f = fn() { try { throw "x" } catch (e) { return 1 } finally { 2 } }; f()`, 1},
+		{
+			`try { try { throw "a" } finally { throw "b" } } catch (e) { e["message"] }`,
+			"b",
+		},
+		{
+			`let f = fn() { try { try { return 1 } finally { throw "inner" } } catch (e) { e["message"] } };
+			f()`,
+			"inner",
+		},
+		{
+			// the finally block isn't protected by its own catch
+			`let f = fn() { try { return 1 } catch (e) { 2 } finally { throw "f" } };
+			try { f() } catch (e) { e["message"] }`,
+			"f",
+		},
+		{
+			`let f = fn(x) { try { if (x > 1) { return x } 0 } finally { let y = 1; } }; f(2) + f(1)`,
+			2,
+		},
+		// uncaught errors
+		{`throw "oops"`, thrown{&object.Error{Message: "oops", Kind: "Error"}}},
+		{
+			`let f = fn() { throw error("x") }; let g = fn() { f() + 1 }; g()`,
+			thrown{&object.Error{Message: "x", Trace: []string{"f", "g"}}},
+		},
+		{
+			`try { throw "a" } finally { 1 }`,
+			thrown{&object.Error{Message: "a"}},
+		},
+		{
+			`let f = fn() { f() + 1 }; try { f() } catch (e) { e["message"] }`,
+			"stack overflow",
+		},
+		{`1 / 0`, thrown{&object.Error{Message: "division by zero", Kind: "RuntimeError"}}},
+		{`try { [1]["a"] } catch (e) { e["kind"] }`, "TypeError"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestGoFunctions(t *testing.T) {
+	host := map[string]interface{}{
+		"each": func(xs []int, f func(int) int) []int {
+			for i, x := range xs {
+				xs[i] = f(x)
+			}
+			return xs
+		},
+		"checked": func(f func() (int, error)) string {
+			if _, err := f(); err != nil {
+				return "go got " + err.Error()
+			}
+			return "ok"
+		},
+		"divide": func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"user": func(name string) struct{ Name, Home string } {
+			return struct{ Name, Home string }{name, "/home/" + name}
+		},
+	}
+
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result, or the error
+	}{
+		{`each([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`try { each([1, 2], fn(x) { throw "boom" }) } catch (e) { e["message"] }`, "boom"},
+		{`checked(fn() { throw "boom" })`, "go got boom"},
+		{`checked(fn() { 1 })`, "ok"},
+		{`divide(7, 2)`, "3"},
+		{`try { divide(1, 0) } catch (e) { [e["kind"], e["message"]] }`, "[Error, division by zero]"},
+		{`user("monkey")["Home"]`, "/home/monkey"},
+		{`each([1], fn(x) { "a" })`, "cannot convert STRING to int"},
+		{`each(["a"], fn(x) { x })`, "cannot convert STRING to int (at argument 1[0])"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			symbolTable := compiler.NewSymbolTable()
+			for i, v := range object.Builtins {
+				symbolTable.DefineBuiltin(i, v.Name)
+			}
+			globals := make([]object.Object, vm.GlobalsSize)
+			for name, fn := range host {
+				obj, err := object.FromGo(fn)
+				if err != nil {
+					t.Fatalf("converting %s failed: %s", name, err)
+				}
+				globals[symbolTable.Define(name).Index] = obj
+			}
+
+			comp := compiler.NewWithState(symbolTable, []object.Object{})
+			if optimize {
+				comp.EnableOptimizations()
+			}
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+			registerBytecode, err := compiler.AllocateRegisters(bytecode)
+			if err != nil {
+				t.Fatalf("register allocation error: %s", err)
+			}
+
+			for _, machine := range []vm.Machine{
+				vm.NewWithGlobalStore(bytecode, globals),
+				vm.NewRegisterWithGlobalStore(registerBytecode, globals),
+			} {
+				var result string
+				if err := machine.Run(); err != nil {
+					result = err.Error()
+				} else {
+					result = machine.LastPoppedStackElem().Inspect()
+				}
+				if result != tt.expected {
+					t.Errorf("wrong result for %q (%T). want=%s, got=%s", tt.input, machine, tt.expected, result)
+				}
+			}
+		}
+	}
+}
+
+func TestIO(t *testing.T) {
+	input := `puts(read_line(), 1); print(read_line(), "|"); eprint("e", [2]); read_all()`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	for _, m := range machines(t, comp.Bytecode()) {
+		var stdout, stderr strings.Builder
+		machine := m.new()
+		machine.SetIO(object.NewIO(strings.NewReader("first\r\nsecond\nthe rest"), &stdout, &stderr))
+		if err := machine.Run(); err != nil {
+			t.Fatalf("%s vm error: %s", m.name, err)
+		}
+
+		if result := machine.LastPoppedStackElem().Inspect(); result != "the rest" {
+			t.Errorf("%s: wrong result. got=%q", m.name, result)
+		}
+		if stdout.String() != "first\n1\nsecond|" {
+			t.Errorf("%s: wrong stdout. got=%q", m.name, stdout.String())
+		}
+		if stderr.String() != "e[2]" {
+			t.Errorf("%s: wrong stderr. got=%q", m.name, stderr.String())
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	input := `write_file("a.txt", "one"); let files = list_dir(); remove_file("a.txt"); [read_file("b.txt"), files, exists("a.txt")]`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	for _, m := range machines(t, comp.Bytecode()) {
+		root := t.TempDir()
+		os.WriteFile(filepath.Join(root, "b.txt"), []byte("two"), 0o644)
+		fsys, err := object.DirFS(root)
+		if err != nil {
+			t.Fatalf("DirFS failed: %s", err)
+		}
+
+		machine := m.new()
+		machine.SetIO(&object.IO{FS: fsys})
+		if err := machine.Run(); err != nil {
+			t.Fatalf("%s vm error: %s", m.name, err)
+		}
+		if result := machine.LastPoppedStackElem().Inspect(); result != "[two, [a.txt, b.txt], false]" {
+			t.Errorf("%s: wrong result. got=%s", m.name, result)
+		}
+	}
+}
+
+func TestCallLimit(t *testing.T) {
+	inputs := []string{
+		"let f = fn() { f() }; f()",
+		// calls made by builtins count too
+		"map(range(2000), fn(x) { x })",
+	}
+
+	for _, input := range inputs {
+		program := parse(input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		for _, m := range machines(t, comp.Bytecode()) {
+			machine := m.new()
+			machine.LimitCalls(1000)
+
+			err = machine.Run()
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", m.name)
+			}
+			testErrorObject(t, &object.Error{Message: "call limit of 1000 exceeded", Kind: "RuntimeError"}, err)
+		}
+	}
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -199,13 +641,14 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 			t.Fatalf("compiler error: %s", err)
 		}
 
-		vm := vm.New(comp.Bytecode())
-		err = vm.Run()
-		if err == nil {
-			t.Fatalf("expected VM error but resulted in none.")
-		}
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		for _, m := range machines(t, comp.Bytecode()) {
+			err = m.new().Run()
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", m.name)
+			}
+			if err.Error() != tt.expected {
+				t.Fatalf("%s: wrong VM error: want=%q, got=%q", m.name, tt.expected, err)
+			}
 		}
 	}
 }
@@ -302,7 +745,18 @@ func TestIndexExpressions(t *testing.T) {
 		{"[[1, 1, 1]][0][0]", 1},
 		{"[][0]", vm.Null},
 		{"[1, 2, 3][99]", vm.Null},
-		{"[1][-1]", vm.Null},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", vm.Null},
+		{`"abc"[0]`, "a"},
+		{`"abc"[-1]`, "c"},
+		{`"abc"[3]`, vm.Null},
+		{`"abc"[-4]`, vm.Null},
+		{`let s = "abc"; let i = 1; s[i]`, "b"},
+		{
+			`"abc"["a"]`,
+			thrown{&object.Error{Message: "index operator not supported: STRING", Kind: "TypeError"}},
+		},
 		{"{1: 1, 2: 2}[1]", 1},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", vm.Null},
@@ -312,6 +766,37 @@ func TestIndexExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestSliceExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1:2]", []int{2}},
+		{"[1, 2, 3][1:]", []int{2, 3}},
+		{"[1, 2, 3][:2]", []int{1, 2}},
+		{"[1, 2, 3][:]", []int{1, 2, 3}},
+		{"[1, 2, 3][-2:]", []int{2, 3}},
+		{"[1, 2, 3][:-1]", []int{1, 2}},
+		{"[1, 2, 3][-5:5]", []int{1, 2, 3}},
+		{"[1, 2, 3][2:1]", []int{}},
+		{"let a = [1, 2]; let i = 1; a[i - 1:i]", []int{1}},
+		{`"monkey"[3:]`, "key"},
+		{`"monkey"[:-3]`, "mon"},
+		{`"monkey"[10:]`, ""},
+		// slices are copies
+		{"let a = [1, 2]; let b = a[:]; b[0] = 3; a", []int{1, 2}},
+		{"let a = freeze([1, 2]); let b = a[:]; b[0] = 3; b", []int{3, 2}},
+		{"let f = fn(a) { a[1:] }; f([1, 2])", []int{2}},
+		{
+			"1[0:1]",
+			thrown{&object.Error{Message: "slice operator not supported: INTEGER", Kind: "TypeError"}},
+		},
+		{
+			`[1]["a":]`,
+			thrown{&object.Error{Message: "slice bounds must be INTEGER, got STRING", Kind: "TypeError"}},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -357,6 +842,40 @@ func TestFunctionsWithReturnStatement(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// A return at the top level ends the program with its value
+func TestTopLevelReturn(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`return""`, ""},
+		{`let a = 1; return a + 1; 3`, "2"},
+		{`if (true) { return 4; } 5`, "4"},
+		{`let f = fn() { return 1; }; f(); return f() + 1;`, "2"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			comp := compiler.New()
+			if optimize {
+				comp.EnableOptimizations()
+			}
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			for _, m := range machines(t, comp.Bytecode()) {
+				machine := m.new()
+				if err := machine.Run(); err != nil {
+					t.Fatalf("%q: %s vm error: %s", tt.input, m.name, err)
+				}
+				if result := machine.LastPoppedStackElem().Inspect(); result != tt.expected {
+					t.Errorf("wrong result of %q on the %s vm (optimize=%t). want=%q, got=%q", tt.input, m.name, optimize, tt.expected, result)
+				}
+			}
+		}
+	}
+}
+
 func TestFunctionsWithoutReturnValue(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -425,6 +944,31 @@ func TestHashLiterals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the inspected result or the error
+	}{
+		{`{"b": 1, "a": 2, 3: 3}`, "{b: 1, a: 2, 3: 3}"},
+		{`{"b": 1, "a": 2, "b": 3}`, "{b: 3, a: 2}"},
+		{`{[1, 2]: "x"}[[1, 2]]`, "x"},
+		{`{[1, 2]: "x"}[[1, 2, 3]]`, "null"},
+		{`{[[1], ["a"]]: 1, [[1], "a"]: 2}[[[1], "a"]]`, "2"},
+		{`{[1, fn() {}]: 1}`, "unusable as hash key: ARRAY"},
+		{`{[1]: 1}[[fn() {}]]`, "unusable as hash key: ARRAY"},
+	}
+
+	for _, tt := range tests {
+		result, err := run(t, tt.input, false)
+		if err != "" {
+			result = err
+		}
+		if result != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, result)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -438,7 +982,89 @@ func TestStringExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
-		{`"mon" + "key" + "banana`, "monkeybanana"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"monkey" == "monkey"`, true},
+		{`("mon" + "key") == "monkey"`, true},
+		{`"monkey" != "banana"`, true},
+		{`let m = "mon"; if (m + "key" == "monkey") { 1 } else { 2 }`, 1},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"ab" > "a"`, true},
+		{`"a" <= "a"`, true},
+		{`"a" >= "b"`, false},
+		{`let a = "a"; let b = "b"; if (a < b) { 1 } else { 2 }`, 1},
+		{`let f = fn(a, b) { a > b }; f("b", "a")`, true},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`join(split("a,b,,c", ","), "|")`, "a|b||c"},
+		{`len(split("abc", ""))`, 3},
+		{`join([], ", ")`, ""},
+		{`trim("  monkey ")`, "monkey"},
+		{`upper("Monkey")`, "MONKEY"},
+		{`lower("Monkey")`, "monkey"},
+		{`contains("monkey", "nke")`, true},
+		{`contains("monkey", "x")`, false},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`replace("a-b-c", "-", "")`, "abc"},
+		{`index_of("monkey", "key")`, 3},
+		{`index_of("monkey", "x")`, -1},
+		{`substr("monkey", 3)`, "key"},
+		{`substr("monkey", 0, 3)`, "mon"},
+		{`substr("monkey", 6)`, ""},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`join(chars("héllo"), " ")`, "h é l l o"},
+		{`len(chars("héllo"))`, 5},
+		// positions count characters rather than bytes
+		{`len("héllo")`, 5},
+		{`index_of("héllo", "l")`, 2},
+		{`substr("héllo", 1, 2)`, "é"},
+		{`substr("héllo", 2)`, "llo"},
+		{`"héllo"[1:2]`, "é"},
+		{`"héllo"[-4]`, "é"},
+		{`format("%s has %d items: %v", "cart", 2, [1, "a"])`, "cart has 2 items: [1, a]"},
+		{`format("100%%")`, "100%"},
+		{
+			`join(["a", 1], "")`,
+			thrown{&object.Error{Message: "elements joined by `join` must be STRING, got INTEGER", Kind: "TypeError"}},
+		},
+		{
+			`upper(1)`,
+			thrown{&object.Error{Message: "argument to `upper` must be STRING, got INTEGER", Kind: "TypeError"}},
+		},
+		{
+			`substr("abc", 2, 1)`,
+			thrown{&object.Error{Message: "substring out of range: [2:1] (length 3)", Kind: "RuntimeError"}},
+		},
+		{
+			`repeat("a", -1)`,
+			thrown{&object.Error{Message: "count of `repeat` must not be negative, got -1", Kind: "ArgumentError"}},
+		},
+		{
+			`repeat("ab", 10000000000)`,
+			thrown{&object.Error{Message: "string of more than 16777216 bytes is too large", Kind: "RuntimeError"}},
+		},
+		{
+			`format("%d", "a")`,
+			thrown{&object.Error{Message: "%d in format must be given INTEGER, got STRING", Kind: "TypeError"}},
+		},
+		{
+			`format("%s %s", "a")`,
+			thrown{&object.Error{Message: "format needs more arguments than the 1 given", Kind: "ArgumentError"}},
+		},
+		{
+			`format("%s", "a", "b")`,
+			thrown{&object.Error{Message: "format uses 1 of 2 arguments", Kind: "ArgumentError"}},
+		},
+		{
+			`format("%x", 1)`,
+			thrown{&object.Error{Message: "unknown verb %x in format", Kind: "ArgumentError"}},
+		},
 	}
 	runVmTests(t, tests)
 }
@@ -463,6 +1089,10 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 }", vm.Null},
 		{"if (false) { 10 }", vm.Null},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (true) {}", vm.Null},
+		{"if (true) { let x = 1; } else { 20 }", vm.Null},
+		{"let x = 1; if (x) { 10; let y = 2; }", vm.Null},
+		{"let f = fn(x) { if (x) { return 10; } }; f(true)", 10},
 	}
 
 	runVmTests(t, tests)
@@ -500,6 +1130,16 @@ func TestIntegerArithmatic(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 1", false},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{"2 >= 1", true},
+		{"1 >= 1", true},
+		{"let one = fn() { 1 }; one() < one() + 1", true},
+		{"let one = fn() { 1 }; one() + 1 < one()", false},
+		{"let one = fn() { 1 }; one() <= one()", true},
+		{"let one = fn() { 1 }; one() >= one() + 1", false},
 		{"-5", -5},
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
@@ -525,6 +1165,354 @@ func TestBooleanExpression(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestOptimizedBytecodeEquivalence(t *testing.T) {
+	inputs := []string{
+		fibInput + "fib(15)",
+		`let a = 1; let b = 2; let f = fn(x, y) { x + y }; f(a, b) + f(3, 4)`,
+		`let f = fn(s) { s + "!" }; f("hi")`,                          // OpAddConst on strings
+		`let f = fn(a, b) { a + b }; f("mon", "key")`,                 // OpAddLocals on strings
+		`let f = fn(x) { if (x == true) { 1 } else { 2 } }; f(false)`, // OpJumpIfNotEqual on booleans
+		`let s = "a"; if (s == s) { 1 } else { 2 }`,
+		`let f = fn(x) { if (x > 1) { x } }; f(0)`,
+		`let f = fn(x) { x - 1 }; f("a")`, // errors
+		`let f = fn(x) { if (x > 1) { 1 } }; f(true)`,
+		`let f = fn(a, b) { a + b }; f(1, [])`,
+	}
+
+	for _, input := range inputs {
+		unoptimized, unoptimizedErr := run(t, input, false)
+		optimized, optimizedErr := run(t, input, true)
+
+		if unoptimized != optimized || unoptimizedErr != optimizedErr {
+			t.Errorf("optimized bytecode differs for %q.\nunoptimized=%s (%s)\noptimized=%s (%s)",
+				input, unoptimized, unoptimizedErr, optimized, optimizedErr)
+		}
+	}
+}
+
+// run compiles and runs input on every machine, returning the inspected result or the error.
+// The machines have to agree.
+func TestHook(t *testing.T) {
+	input := `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let x = add(1, 2);
+x`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	machine := vm.New(bytecode)
+
+	// the lines of the statements as they start, and the locals of add before it returns
+	var lines []string
+	var locals []object.Object
+	machine.SetHook(func() error {
+		fn, ip, depth := machine.Position()
+		if line, ok := fn.Lines.StatementAt(ip); ok {
+			lines = append(lines, fmt.Sprintf("%d:%d", depth, line))
+		}
+		if line, ok := fn.Lines.StatementAt(ip); ok && line == 3 {
+			frames := machine.Frames()
+			locals = frames[len(frames)-1].Locals
+			if len(machine.Stack()) != 0 {
+				t.Errorf("stack of add not empty. got=%v", machine.Stack())
+			}
+			if bottom := frames[0].Fn.Lines.LineAt(frames[0].IP); bottom != 5 {
+				t.Errorf("main program at wrong line. want=5, got=%d", bottom)
+			}
+		}
+		return nil
+	})
+
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := strings.Join(lines, " "); got != "1:1 1:5 2:2 2:3 1:6" {
+		t.Errorf("statements wrong. want=%q, got=%q", "1:1 1:5 2:2 2:3 1:6", got)
+	}
+	if len(locals) != 3 {
+		t.Fatalf("wrong number of locals. want=3, got=%d", len(locals))
+	}
+	for i, want := range []int64{1, 2, 3} {
+		if err := testIntegerObject(want, locals[i]); err != nil {
+			t.Errorf("local %s wrong: %s", bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction).LocalNames[i], err)
+		}
+	}
+	if err := testIntegerObject(3, machine.Globals()[1]); err != nil {
+		t.Errorf("global x wrong: %s", err)
+	}
+}
+
+func TestHookStops(t *testing.T) {
+	stop := errors.New("stop")
+
+	tests := []struct {
+		input string
+		depth int // the hook stops the program in the first frame this deep
+	}{
+		{"let a = 1; a + 1", 1},
+		{"try { 1 + 1 } catch (e) { e }", 1},                    // exception handlers don't catch it
+		{"map([1, 2], fn(x) { try { x } catch (e) { e } })", 2}, // nor the builtin calling back
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := vm.New(comp.Bytecode())
+
+		instructions, stopped := 0, 0
+		machine.SetHook(func() error {
+			instructions++
+			if _, _, depth := machine.Position(); depth == tt.depth && instructions > 2 {
+				stopped++
+				return stop
+			}
+			return nil
+		})
+
+		if err := machine.Run(); err != stop {
+			t.Errorf("%q: wrong error. want=%v, got=%v", tt.input, stop, err)
+		}
+		if stopped != 1 {
+			t.Errorf("%q: program didn't stop. stopped %d times", tt.input, stopped)
+		}
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	input := `let sign = fn(x) { if (x < 0) { -1 } else { 1 } };
+let never = fn() { 0 };
+[sign(-2), sign(3), sign(4)]`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := vm.New(comp.Bytecode())
+	machine.EnableCoverage()
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	coverage := machine.Coverage()
+
+	var sign, never *object.CompiledFunction
+	for _, c := range comp.Bytecode().Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok && sign == nil {
+			sign = fn
+		} else if ok {
+			never = fn
+		}
+	}
+	if counts := coverage.Counts[sign]; len(counts) != len(sign.Instructions) || counts[0] != 3 {
+		t.Errorf("wrong counts of sign. got=%v", counts)
+	}
+	if counts, ok := coverage.Counts[never]; ok {
+		t.Errorf("counts of a function which never ran. got=%v", counts)
+	}
+	if counts := coverage.Counts[coverage.Main]; counts[0] != 1 {
+		t.Errorf("wrong counts of the main program. got=%v", counts)
+	}
+
+	branches := coverage.Branches[sign]
+	if len(branches) != 1 {
+		t.Fatalf("wrong number of branches. want=1, got=%d", len(branches))
+	}
+	for _, outcomes := range branches {
+		if *outcomes != (vm.Outcomes{Truthy: 1, Falsy: 2}) {
+			t.Errorf("wrong outcomes. want={1 2}, got=%v", *outcomes)
+		}
+	}
+
+	if vm.New(comp.Bytecode()).Coverage() != nil {
+		t.Errorf("coverage recorded without EnableCoverage")
+	}
+}
+
+func TestProfile(t *testing.T) {
+	input := `let inner = fn() { 1 };
+let outer = fn() { inner() + inner() };
+let boom = fn() { throw "boom" };
+outer();
+outer();
+try { boom() } catch (e) { e };
+try { map([1], fn(x) { let y = boom(); y }) } catch (e) { e };`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := vm.New(comp.Bytecode())
+	machine.EnableProfiling()
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	p := machine.Profile()
+	if machine.Profile() != nil {
+		t.Errorf("profiling didn't end")
+	}
+
+	// the calls by the path to them, and the times adding up
+	calls := map[string]int{}
+	var walk func(c *vm.Call, path string)
+	walk = func(c *vm.Call, path string) {
+		name := c.Fn.Name
+		if name == "" {
+			name = "?"
+		}
+		path += "/" + name
+		calls[path] += c.Calls
+
+		var children time.Duration
+		for _, child := range c.Children {
+			children += child.Total
+			walk(child, path)
+		}
+		if c.Self < 0 || c.Self+children != c.Total {
+			t.Errorf("times of %s don't add up. self=%s, children=%s, total=%s", path, c.Self, children, c.Total)
+		}
+	}
+	walk(p.Root, "")
+
+	expected := map[string]int{"/?": 1, "/?/outer": 2, "/?/outer/inner": 4, "/?/boom": 1, "/?/?": 1, "/?/?/boom": 1}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("wrong calls.\nwant=%v\ngot =%v", expected, calls)
+	}
+	if p.Opcodes[code.OpCall] != 9 || p.Opcodes[code.OpThrow] != 2 {
+		t.Errorf("wrong opcode counts. OpCall=%d, OpThrow=%d", p.Opcodes[code.OpCall], p.Opcodes[code.OpThrow])
+	}
+}
+
+func TestProfileSamples(t *testing.T) {
+	input := `let fib = fn(n) {
+  if (n < 2) { return n }
+  fib(n - 1) + fib(n - 2)
+};
+fib(24)`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := vm.New(comp.Bytecode())
+	machine.EnableProfiling()
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	p := machine.Profile()
+
+	// fib runs for far longer than a period, so most samples are of it
+	samples, fib := 0, 0
+	for _, s := range p.Samples {
+		samples += s.Count
+		if bottom := s.Stack[len(s.Stack)-1]; bottom.Fn != p.Root.Fn || bottom.Line != 5 {
+			t.Errorf("sample doesn't start at the call of fib. got=%+v", bottom)
+		}
+		for _, loc := range s.Stack[:len(s.Stack)-1] {
+			if loc.Fn.Name != "fib" || loc.Line < 2 || loc.Line > 3 {
+				t.Errorf("wrong location in fib. got=%s:%d", loc.Fn.Name, loc.Line)
+			}
+		}
+		if len(s.Stack) > 1 {
+			fib += s.Count
+		}
+	}
+	if samples == 0 || fib < samples/2 {
+		t.Errorf("wrong samples. got=%d, of fib %d", samples, fib)
+	}
+}
+
+func run(t *testing.T, input string, optimize bool) (string, string) {
+	t.Helper()
+
+	comp := compiler.New()
+	if optimize {
+		comp.EnableOptimizations()
+	}
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var results [][2]string
+	for _, m := range machines(t, comp.Bytecode()) {
+		result := [2]string{}
+		machine := m.new()
+		if err := machine.Run(); err != nil {
+			result[1] = err.Error()
+		} else {
+			result[0] = machine.LastPoppedStackElem().Inspect()
+		}
+
+		if len(results) > 0 && result != results[0] {
+			t.Errorf("%s differs for %q: got=%v, want=%v", m.name, input, result, results[0])
+		}
+		results = append(results, result)
+	}
+
+	return results[0][0], results[0][1]
+}
+
+const fibInput = `
+let fib = fn(x) {
+	if (x < 2) {
+		return x;
+	}
+	fib(x - 1) + fib(x - 2);
+};
+`
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkMachines(b, fibInput+"fib(25)")
+}
+
+// BenchmarkArithmetic mostly allocates integer results, which are outside of the small
+// integer cache for the larger part
+func BenchmarkArithmetic(b *testing.B) {
+	benchmarkMachines(b, `
+	let sum = fn(n, acc) {
+		if (n == 0) {
+			return acc;
+		}
+		sum(n - 1, acc + n * 3 / 2 - -n);
+	};
+	sum(20000, 0)
+	`)
+}
+
+// benchmarkMachines runs input on every machine, with and without the optimization passes
+func benchmarkMachines(b *testing.B, input string) {
+	for _, optimize := range []bool{false, true} {
+		comp := compiler.New()
+		if optimize {
+			comp.EnableOptimizations()
+		}
+		if err := comp.Compile(parse(input)); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+
+		name := "unoptimized"
+		if optimize {
+			name = "optimized"
+		}
+
+		for _, m := range machines(b, comp.Bytecode()) {
+			b.Run(m.name+"/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := m.new().Run(); err != nil {
+						b.Fatalf("vm error: %s", err)
+					}
+				}
+			})
+		}
+	}
+}
+
 type vmTestCase struct {
 	input    string
 	expected interface{}
@@ -534,38 +1522,81 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
 	for _, tt := range tests {
-		program := parse(tt.input)
+		// every program must behave the same with and without the optimization passes
+		for _, optimize := range []bool{false, true} {
+			runVmTest(t, tt, optimize)
+		}
+	}
+}
 
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
+func runVmTest(t *testing.T, tt vmTestCase, optimize bool) {
+	t.Helper()
+
+	program := parse(tt.input)
+
+	comp := compiler.New()
+	if optimize {
+		comp.EnableOptimizations()
+	}
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	// dump bytecode
+	// for i, constant := range comp.Bytecode().Constants {
+	// 	fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
+	// 	switch constant := constant.(type) {
+	// 	case *object.CompiledFunction:
+	// 		fmt.Printf(" Instructions:\n%s", constant.Instructions)
+	// 	case *object.Integer:
+	// 		fmt.Printf(" Value: %d\n", constant.Value)
+	// 	}
+	// 	fmt.Printf("\n")
+	// }
+
+	for _, m := range machines(t, comp.Bytecode()) {
+		machine := m.new()
+		err = machine.Run()
+
+		// an expected error is expected to be thrown and not caught
+		if expected, ok := tt.expected.(thrown); ok {
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", m.name)
+			}
+			testErrorObject(t, expected.Error, err)
+			continue
 		}
-		// dump bytecode
-		// for i, constant := range comp.Bytecode().Constants {
-		// 	fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
-		// 	switch constant := constant.(type) {
-		// 	case *object.CompiledFunction:
-		// 		fmt.Printf(" Instructions:\n%s", constant.Instructions)
-		// 	case *object.Integer:
-		// 		fmt.Printf(" Value: %d\n", constant.Value)
-		// 	}
-		// 	fmt.Printf("\n")
-		// }
-
-		vm := vm.New(comp.Bytecode())
-		err = vm.Run()
 
 		if err != nil {
-			t.Fatalf("vm error: %s", err)
+			t.Fatalf("%s vm error: %s", m.name, err)
 		}
 
-		stackElem := vm.LastPoppedStackElem()
+		stackElem := machine.LastPoppedStackElem()
 
 		testExpectedObject(t, tt.expected, stackElem)
 	}
 }
 
+type machine struct {
+	name string
+	new  func() vm.Machine // a machine only runs once
+}
+
+// machines returns every vm implementation, set up to run bytecode
+func machines(t testing.TB, bytecode *compiler.Bytecode) []machine {
+	t.Helper()
+
+	registerBytecode, err := compiler.AllocateRegisters(bytecode)
+	if err != nil {
+		t.Fatalf("register allocation error: %s", err)
+	}
+
+	return []machine{
+		{"stack", func() vm.Machine { return vm.New(bytecode) }},
+		{"register", func() vm.Machine { return vm.NewRegister(registerBytecode) }},
+	}
+}
+
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
 	t.Helper()
 
@@ -616,16 +1647,16 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 			if !ok {
-				t.Errorf("no pair for given key in Pairs")
+				t.Errorf("no expected pair for key %s", pair.Key.Inspect())
 			}
 
 			err := testIntegerObject(expectedValue, pair.Value)
@@ -633,17 +1664,35 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
-	case object.Error:
-		errObj, ok := actual.(*object.Error)
-		if !ok {
-			t.Errorf("object is not Error: %T (%+v)", actual, actual)
-			return
-		}
+	case *object.Error:
+		testErrorObject(t, expected, actual)
+	}
+}
 
-		if errObj.Message != expected.Message {
-			t.Errorf("wrong error message. expected=%q, got=%q",
-				expected.Message, errObj.Message)
-		}
+// thrown is the expected result of a program which ends with an uncaught error
+type thrown struct {
+	*object.Error
+}
+
+// testErrorObject compares the message, and the kind and trace if expected has them
+func testErrorObject(t *testing.T, expected *object.Error, actual interface{}) {
+	t.Helper()
+
+	errObj, ok := actual.(*object.Error)
+	if !ok {
+		t.Errorf("object is not Error: %T (%+v)", actual, actual)
+		return
+	}
+
+	if errObj.Message != expected.Message {
+		t.Errorf("wrong error message. expected=%q, got=%q",
+			expected.Message, errObj.Message)
+	}
+	if expected.Kind != "" && errObj.Kind != expected.Kind {
+		t.Errorf("wrong error kind. expected=%q, got=%q", expected.Kind, errObj.Kind)
+	}
+	if expected.Trace != nil && fmt.Sprint(errObj.Trace) != fmt.Sprint(expected.Trace) {
+		t.Errorf("wrong error trace. expected=%v, got=%v", expected.Trace, errObj.Trace)
 	}
 }
 